@@ -2,12 +2,13 @@ package ip_hash
 
 import (
 	"math"
+	"slices"
 	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/consistent"
@@ -18,9 +19,9 @@ import (
 )
 
 type serverMap struct {
-	node        *consistent.Node
-	isHostAlive atomic.Bool
-	statsIdx    int
+	node     *consistent.Node
+	health   types.Health
+	statsIdx int
 }
 
 type IPHash struct {
@@ -31,11 +32,22 @@ type IPHash struct {
 	healthCheckerDone chan struct{}
 	stopOnce          sync.Once
 	servers           *consistent.ConsistentHash
+	degraded          proxy.Degraded
 	len               int
 	healthCheckerTime time.Duration
+	retryPolicy       *proxy.RetryPolicy
+	// pool names the pool the Backends belong to in the events published
+	// about them; empty for the Backends under backends.
+	pool     string
+	clientIP config.ClientIP
 }
 
 func NewIPHash(cfg *config.Config, middlewareExecutor *middleware.Executor, proxyFunc proxy.ProxyFunc) types.IBalancer {
+	// PrepareConfig rejects an empty Backend list; there is nothing to balance.
+	if len(cfg.Backends) == 0 {
+		return nil
+	}
+
 	ipHash := &IPHash{
 		servers: consistent.NewConsistentHash(
 			int(math.Pow(float64(len(cfg.Backends)), float64(2))),
@@ -47,13 +59,18 @@ func NewIPHash(cfg *config.Config, middlewareExecutor *middleware.Executor, prox
 		hashFunc:          cfg.HashFunc,
 		stopHealthChecker: make(chan struct{}),
 		healthCheckerDone: make(chan struct{}),
+		retryPolicy:       proxy.NewRetryPolicy(cfg.Retry, cfg.Hedge, cfg.Queue),
+		pool:              cfg.Pool,
+		clientIP:          cfg.ClientIP,
 	}
 
+	var degraded proxy.DegradedSet
 	for i, b := range cfg.Backends {
 		proxyClient := proxyFunc(&b, cfg.CustomHeaders, middlewareExecutor)
 		node := &consistent.Node{Id: i, Proxy: proxyClient, Addr: b.Url}
-		isHostAlive := ipHash.isHostAlive(b.GetHealthCheckURL())
-		if isHostAlive {
+		state := ipHash.isHostAlive(b.GetHealthCheckURL())
+		degraded = degraded.Apply(proxyClient, types.Down, state, b.HealthCheck.DegradedWeight, cfg.Pool, b.Url)
+		if state.InRotation() {
 			ipHash.servers.AddNode(node)
 			ipHash.len++
 			zap.S().Infof("Server add for load balancing successfully Addr: %s", b.Url)
@@ -61,12 +78,13 @@ func NewIPHash(cfg *config.Config, middlewareExecutor *middleware.Executor, prox
 			zap.S().Warnf("Server is not live, it will be added for load balancing when its health check succeeds, Addr: %s", b.Url)
 		}
 		backendState := &serverMap{node: node, statsIdx: len(ipHash.serversMap)}
-		backendState.isHostAlive.Store(isHostAlive)
+		backendState.health.Store(state)
 		ipHash.serversMap[ipHash.hashFunc(helper.S2B(b.Url+strconv.Itoa(i)))] = backendState
 	}
+	ipHash.degraded.Store(degraded)
 
-	if ipHash.len <= 0 {
-		return nil
+	if ipHash.len == 0 {
+		zap.S().Warn("No backend is alive yet, serving 503 until a backend rejoins")
 	}
 
 	go ipHash.healthChecker(cfg.Backends)
@@ -76,22 +94,34 @@ func NewIPHash(cfg *config.Config, middlewareExecutor *middleware.Executor, prox
 
 func (h *IPHash) Serve() func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
-		hashCode := h.hashFunc(helper.S2B(ctx.RemoteIP().String()))
-		proxyClient := h.get(hashCode)
-		if proxyClient == nil {
-			proxy.NoAliveBackends(ctx)
-			return
-		}
-		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		// Hash the resolved client, not the peer: behind a load balancer
+		// every request would otherwise land on the same Backend.
+		hashCode := h.hashFunc(helper.S2B(proxy.ClientIP(ctx, &h.clientIP).String()))
+		h.retryPolicy.Serve(ctx, func(tried []proxy.IProxyClient) proxy.IProxyClient {
+			return h.get(hashCode, tried)
+		})
 	}
 }
 
-func (h *IPHash) get(hashCode uint32) proxy.IProxyClient {
-	node := h.servers.GetNode(hashCode)
-	if node == nil {
-		return nil
+func (h *IPHash) get(hashCode uint32, tried []proxy.IProxyClient) proxy.IProxyClient {
+	degraded := h.degraded.Load()
+	// A Degraded Backend turns down a fixed slice of the client hashes it
+	// owns; those clients move on clockwise as if it had been tried.
+	excluded := slices.Clip(tried)
+	var fallback proxy.IProxyClient
+	for {
+		node := h.servers.GetNodeExcluding(hashCode, excluded)
+		if node == nil {
+			return fallback
+		}
+		if degraded.AdmitHash(node.Proxy, hashCode) {
+			return node.Proxy
+		}
+		if fallback == nil {
+			fallback = node.Proxy
+		}
+		excluded = append(excluded, node.Proxy)
 	}
-	return node.Proxy
 }
 
 func (h *IPHash) healthChecker(backends []config.Backend) {
@@ -115,38 +145,51 @@ func (h *IPHash) healthChecker(backends []config.Backend) {
 }
 
 func (h *IPHash) healthCheck(backend *config.Backend, index int) {
-	status := h.isHostAlive(backend.GetHealthCheckURL())
+	state := h.isHostAlive(backend.GetHealthCheckURL())
 	backendHash := h.hashFunc(helper.S2B(backend.Url + strconv.Itoa(index)))
 	proxyMap, ok := h.serversMap[backendHash]
+	if !ok {
+		return
+	}
+
+	prev := proxyMap.health.Load()
+	degraded := h.degraded.Load().Apply(proxyMap.node.Proxy, prev, state, backend.HealthCheck.DegradedWeight, h.pool, backend.Url)
+	h.degraded.Store(degraded)
 
-	if ok && (!status && proxyMap.isHostAlive.Load()) {
+	if !state.InRotation() && prev.InRotation() {
 		h.servers.RemoveNode(proxyMap.node)
-		proxyMap.isHostAlive.Store(false)
 		h.len--
 
 		zap.S().Infof("Server is down, removing from load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendDown, Pool: h.pool, Backend: backend.Url})
 		if h.len == 0 {
 			zap.S().Warn("All backends are down, serving 503 until a backend rejoins")
+			events.Publish(events.Event{Type: events.AllBackendsDown, Pool: h.pool})
 		}
-	} else if ok && (status && !proxyMap.isHostAlive.Load()) {
+	} else if state.InRotation() && !prev.InRotation() {
 		h.servers.AddNode(proxyMap.node)
-		proxyMap.isHostAlive.Store(true)
 		h.len++
 		zap.S().Infof("Server is live again, adding back to load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendRejoin, Pool: h.pool, Backend: backend.Url})
 	}
+
+	proxyMap.health.Store(state)
 }
 
 func (h *IPHash) Stats() []types.ProxyStat {
 	stats := make([]types.ProxyStat, len(h.serversMap))
 	for hash, p := range h.serversMap {
 		s := p.node.Proxy.Stat()
+		health := p.health.Load()
 		stats[p.statsIdx] = types.ProxyStat{
 			Addr:          s.Addr,
 			TotalReqCount: s.TotalReqCount,
 			AvgResTime:    s.AvgResTime,
 			LastUseTime:   s.LastUseTime,
 			ConnsCount:    s.ConnsCount,
-			IsHostAlive:   p.isHostAlive.Load(),
+			OpenTunnels:   s.OpenTunnels,
+			IsHostAlive:   health.InRotation(),
+			Health:        health.String(),
 			BackendHash:   hash,
 		}
 	}
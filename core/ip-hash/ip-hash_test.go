@@ -2,12 +2,16 @@ package ip_hash
 
 import (
 	"math"
+	"net"
+	"net/netip"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
@@ -18,12 +22,13 @@ import (
 
 func TestNewIPHash(t *testing.T) {
 	for _, ip := range mocks.TestCases {
-		if ip.ExpectedServerCount == 0 {
+		if len(ip.Config.Backends) == 0 {
 			ipHash := NewIPHash(&ip.Config, nil, ip.ProxyFunc)
 			assert.Nil(t, ipHash)
 		} else {
 			ipHash := NewIPHash(&ip.Config, nil, ip.ProxyFunc).(*IPHash)
-			assert.Equal(t, ip.ExpectedServerCount, len(ipHash.serversMap))
+			assert.Equal(t, len(ip.Config.Backends), len(ipHash.serversMap))
+			assert.Equal(t, ip.ExpectedServerCount, ipHash.len)
 		}
 	}
 }
@@ -34,7 +39,7 @@ func TestGet(t *testing.T) {
 	assert.NotNil(t, balancer)
 
 	ipHash := balancer.(*IPHash)
-	proxy := ipHash.get(caseOne.Config.HashFunc([]byte{1, 2, 3}))
+	proxy := ipHash.get(caseOne.Config.HashFunc([]byte{1, 2, 3}), nil)
 
 	assert.IsType(t, &mocks.MockProxy{}, proxy)
 }
@@ -51,7 +56,7 @@ func TestServer(t *testing.T) {
 		Request: *fasthttp.AcquireRequest(),
 	}
 
-	proxy := ipHash.get(caseOne.Config.HashFunc([]byte{1})).(*mocks.MockProxy)
+	proxy := ipHash.get(caseOne.Config.HashFunc([]byte{1}), nil).(*mocks.MockProxy)
 	assert.False(t, proxy.IsCalled, "expected Server func not be called, but it was called")
 	handlerFunc(&ctx)
 	assert.True(t, proxy.IsCalled, "expected Server func to be called, but it wasn't")
@@ -83,9 +88,9 @@ func TestHealthChecker(t *testing.T) {
 	}
 
 	var stopOnce sync.Once
-	ipHash.isHostAlive = func(s string) bool {
+	ipHash.isHostAlive = func(s string) types.HealthState {
 		stopOnce.Do(func() { close(ipHash.stopHealthChecker) })
-		return false
+		return types.Down
 	}
 	ipHash.hashFunc = func(b []byte) uint32 {
 		return 0
@@ -103,13 +108,13 @@ func TestRemoveOneServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := ipHash.serversMap[ipHash.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		ipHash.isHostAlive = func(s string) bool {
-			return false
+		ipHash.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := ipHash.len
 		ipHash.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, ipHash.len, "expected server to be removed after health check, but it did not.")
 	}
 
@@ -123,27 +128,27 @@ func TestRemoveAndAddServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := ipHash.serversMap[ipHash.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		ipHash.isHostAlive = func(s string) bool {
-			return false
+		ipHash.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := ipHash.len
 		ipHash.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, ipHash.len, "expected server to be removed after health check, but it did not.")
 	}
 
 	// Add one server
 	if b, ok := ipHash.serversMap[ipHash.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		b.isHostAlive.Store(false)
-		ipHash.isHostAlive = func(s string) bool {
-			return true
+		b.health.Store(types.Down)
+		ipHash.isHostAlive = func(s string) types.HealthState {
+			return types.Alive
 		}
 
 		oldServerCount := ipHash.len
 		ipHash.healthCheck(&backend, 0)
 
-		assert.True(t, b.isHostAlive.Load(), "expected isHostAlive equal to true, but got %v", b.isHostAlive.Load())
+		assert.True(t, b.health.Load().InRotation(), "expected isHostAlive equal to true, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, ipHash.len, oldServerCount, "expected server to be added after health check, but it did not.")
 
 	}
@@ -156,8 +161,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	ipHash := NewIPHash(&caseOne.Config, nil, caseOne.ProxyFunc).(*IPHash)
 	assert.Equal(t, caseOne.ExpectedServerCount, len(ipHash.serversMap))
 
-	ipHash.isHostAlive = func(s string) bool {
-		return false
+	ipHash.isHostAlive = func(s string) types.HealthState {
+		return types.Down
 	}
 	for i, backend := range caseOne.Config.Backends {
 		assert.NotPanics(t, func() {
@@ -174,8 +179,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
 
 	// Rejoin after the total outage.
-	ipHash.isHostAlive = func(s string) bool {
-		return true
+	ipHash.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
 	}
 	ipHash.healthCheck(&caseOne.Config.Backends[0], 0)
 	assert.Equal(t, 1, ipHash.len)
@@ -187,6 +192,35 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	// SPEC (1.0): no Backend passing its first Probe must not stop divisor
+	// from starting; requests get 503 until a Probe lets a backend Rejoin.
+	allDown := mocks.TestCases[2]
+	ipHash := NewIPHash(&allDown.Config, nil, allDown.ProxyFunc).(*IPHash)
+	assert.Equal(t, len(allDown.Config.Backends), len(ipHash.serversMap))
+	assert.Equal(t, 0, ipHash.len)
+
+	handler := ipHash.Serve()
+	ctx := fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	// The first successful Probe lets a Backend Rejoin.
+	ipHash.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
+	}
+	ipHash.healthCheck(&allDown.Config.Backends[0], 0)
+	assert.Equal(t, 1, ipHash.len)
+
+	ctx = fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+}
+
 func TestShutdown(t *testing.T) {
 	t.Run("shutdown calls close on all proxies", func(t *testing.T) {
 		caseOne := mocks.TestCases[0]
@@ -250,8 +284,8 @@ func TestStatsWhenBackendDownAtStartup(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -276,8 +310,8 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -287,12 +321,12 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 	ipHash := NewIPHash(&cfg, nil, mocks.CreateNewMockProxy).(*IPHash)
 	assert.Equal(t, 1, ipHash.len)
 
-	ipHash.isHostAlive = func(string) bool { return true }
+	ipHash.isHostAlive = func(string) types.HealthState { return types.Alive }
 	ipHash.healthCheck(&cfg.Backends[0], 0)
 
 	assert.Equal(t, 2, ipHash.len)
 	sm := ipHash.serversMap[ipHash.hashFunc([]byte("localhost:8080"+"0"))]
-	assert.True(t, sm.isHostAlive.Load())
+	assert.True(t, sm.health.Load().InRotation())
 }
 
 func BenchmarkNext(b *testing.B) {
@@ -301,7 +335,7 @@ func BenchmarkNext(b *testing.B) {
 	hashCode := ipHash.hashFunc([]byte("192.168.1.1"))
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			ipHash.get(hashCode)
+			ipHash.get(hashCode, nil)
 		}
 	})
 }
@@ -311,9 +345,9 @@ func TestShutdownStopsHealthChecker(t *testing.T) {
 	caseOne.Config.HealthCheckerTime = 5 * time.Millisecond
 
 	var checks atomic.Int64
-	caseOne.Config.HealthCheckerFunc = func(string) bool {
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState {
 		checks.Add(1)
-		return true
+		return types.Alive
 	}
 
 	ipHash := NewIPHash(&caseOne.Config, nil, caseOne.ProxyFunc).(*IPHash)
@@ -335,7 +369,7 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 	caseOne := mocks.TestCases[0]
 	var alive atomic.Bool
 	alive.Store(true)
-	caseOne.Config.HealthCheckerFunc = func(string) bool { return alive.Load() }
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState { return mocks.AliveIf(alive.Load()) }
 	balancer := NewIPHash(&caseOne.Config, nil, caseOne.ProxyFunc).(*IPHash)
 	defer balancer.Shutdown() //nolint:errcheck
 
@@ -370,7 +404,7 @@ func TestDuplicateAddressTwinKeepsItsVirtualNodes(t *testing.T) {
 
 	const samples = 1000
 	const sampleStride = math.MaxUint32 / samples
-	routeOf := func(i int) proxy.IProxyClient { return ipHash.get(uint32(i * sampleStride)) }
+	routeOf := func(i int) proxy.IProxyClient { return ipHash.get(uint32(i*sampleStride), nil) }
 	before := make([]proxy.IProxyClient, samples)
 	for i := range before {
 		before[i] = routeOf(i)
@@ -379,18 +413,135 @@ func TestDuplicateAddressTwinKeepsItsVirtualNodes(t *testing.T) {
 	first := cfg.Backends[0]
 	twin := ipHash.serversMap[ipHash.hashFunc([]byte("localhost:8080"+strconv.Itoa(1)))]
 
-	ipHash.isHostAlive = func(string) bool { return false }
+	ipHash.isHostAlive = func(string) types.HealthState { return types.Down }
 	ipHash.healthCheck(&first, 0)
 	assert.Equal(t, 1, ipHash.len)
-	assert.True(t, twin.isHostAlive.Load())
+	assert.True(t, twin.health.Load().InRotation())
 	for i := 0; i < samples; i++ {
 		assert.Same(t, twin.node.Proxy, routeOf(i), "hash %d must fail over to the twin", i)
 	}
 
-	ipHash.isHostAlive = func(string) bool { return true }
+	ipHash.isHostAlive = func(string) types.HealthState { return types.Alive }
 	ipHash.healthCheck(&first, 0)
 	assert.Equal(t, 2, ipHash.len)
 	for i := 0; i < samples; i++ {
 		assert.Same(t, before[i], routeOf(i), "hash %d must route as it did before the flap", i)
 	}
 }
+
+func TestGetSkipsTriedBackends(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	caseOne.Config.HashFunc = helper.HashFunc
+	ipHash := NewIPHash(&caseOne.Config, nil, caseOne.ProxyFunc).(*IPHash)
+	defer ipHash.Shutdown() //nolint:errcheck
+
+	for i := range 10 {
+		hashCode := uint32(i * (math.MaxUint32 / 10))
+		first := ipHash.get(hashCode, nil)
+		second := ipHash.get(hashCode, []proxy.IProxyClient{first})
+		assert.NotNil(t, second)
+		assert.NotSame(t, first, second, "a retry must not get the Backend it already tried")
+		assert.Nil(t, ipHash.get(hashCode, []proxy.IProxyClient{first, second}), "every Backend tried")
+	}
+}
+
+func TestDegradedBackend(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	degradedURL := caseOne.Config.Backends[0].GetHealthCheckURL()
+	caseOne.Config.HealthCheckerFunc = func(url string) types.HealthState {
+		if url == degradedURL {
+			return types.Degraded
+		}
+		return types.Alive
+	}
+	caseOne.Config.HashFunc = helper.HashFunc
+	ipHash := NewIPHash(&caseOne.Config, nil, caseOne.ProxyFunc).(*IPHash)
+	defer ipHash.Shutdown() //nolint:errcheck
+
+	sm := ipHash.serversMap[ipHash.hashFunc([]byte(caseOne.Config.Backends[0].Url+"0"))]
+	degradedProxy := sm.node.Proxy
+	assert.Equal(t, 2, ipHash.len, "a Degraded Backend stays on the ring")
+
+	// degraded_weight 0 (below what PrepareConfig allows) turns every
+	// request down, so the Degraded Backend only serves as a last resort.
+	var other proxy.IProxyClient
+	for i := range 50 {
+		other = ipHash.get(uint32(i*(math.MaxUint32/50)), nil)
+		assert.NotSame(t, degradedProxy, other)
+	}
+	assert.Same(t, degradedProxy, ipHash.get(0, []proxy.IProxyClient{other}))
+
+	stats := ipHash.Stats()
+	assert.Equal(t, "degraded", stats[0].Health)
+	assert.True(t, stats[0].IsHostAlive)
+
+	t.Run("recovers to Alive", func(t *testing.T) {
+		ipHash.isHostAlive = func(string) types.HealthState { return types.Alive }
+		ipHash.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Empty(t, ipHash.degraded.Load())
+		assert.Equal(t, types.Alive, sm.health.Load())
+	})
+
+	t.Run("goes Down and comes back Degraded", func(t *testing.T) {
+		ipHash.isHostAlive = func(string) types.HealthState { return types.Down }
+		ipHash.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Equal(t, 1, ipHash.len)
+
+		ipHash.isHostAlive = func(string) types.HealthState { return types.Degraded }
+		ipHash.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Equal(t, 2, ipHash.len)
+		assert.Contains(t, ipHash.degraded.Load(), degradedProxy)
+		assert.Equal(t, types.Degraded, sm.health.Load())
+	})
+}
+
+func TestHealthCheckPublishesEvents(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	ipHash := NewIPHash(&caseTwo.Config, nil, caseTwo.ProxyFunc).(*IPHash)
+	defer ipHash.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	ipHash.isHostAlive = func(string) types.HealthState { return types.Down }
+	ipHash.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	down := <-ch
+	assert.Equal(t, events.BackendDown, down.Type)
+	assert.Equal(t, "localhost:8080", down.Backend)
+	assert.Equal(t, events.AllBackendsDown, (<-ch).Type)
+
+	ipHash.isHostAlive = func(string) types.HealthState { return types.Alive }
+	ipHash.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 1)
+	rejoin := <-ch
+	assert.Equal(t, events.BackendRejoin, rejoin.Type)
+	assert.Equal(t, "localhost:8080", rejoin.Backend)
+}
+
+func TestServeHashesResolvedClientIP(t *testing.T) {
+	var hashed []string
+	cfg := mocks.TestCases[0].Config
+	cfg.HashFunc = func(b []byte) uint32 {
+		hashed = append(hashed, string(b))
+		return helper.HashFunc(b)
+	}
+	cfg.ClientIP = config.ClientIP{
+		Source:  config.ClientIPXRealIP,
+		Trusted: config.IPRanges{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	ipHash := NewIPHash(&cfg, nil, mocks.CreateNewMockProxy).(*IPHash)
+	defer ipHash.Shutdown() //nolint:errcheck
+
+	serve := func(peer string) string {
+		hashed = nil
+		ctx := fasthttp.RequestCtx{}
+		ctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(peer)})
+		ctx.Request.Header.Set("X-Real-Ip", "203.0.113.9")
+		ipHash.Serve()(&ctx)
+		return hashed[0]
+	}
+
+	assert.Equal(t, "203.0.113.9", serve("10.0.0.1"), "behind a trusted proxy the client it names is hashed")
+	assert.Equal(t, "192.0.2.1", serve("192.0.2.1"), "anyone else's header is ignored")
+}
@@ -1,12 +1,14 @@
 package least_algorithm
 
 import (
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/helper"
@@ -16,9 +18,9 @@ import (
 )
 
 type serverMap struct {
-	proxy       proxy.IProxyClient
-	isHostAlive atomic.Bool
-	statsIdx    int
+	proxy    proxy.IProxyClient
+	health   types.Health
+	statsIdx int
 }
 
 type LeastAlgorithm struct {
@@ -29,12 +31,22 @@ type LeastAlgorithm struct {
 	healthCheckerDone chan struct{}
 	stopOnce          sync.Once
 	servers           atomic.Pointer[[]proxy.IProxyClient]
+	degraded          proxy.Degraded
 	healthCheckerTime time.Duration
-	cursor            atomic.Uint64
-	nextFunc          func() proxy.IProxyClient
+	retryPolicy       *proxy.RetryPolicy
+	// pool names the pool the Backends belong to in the events published
+	// about them; empty for the Backends under backends.
+	pool     string
+	cursor   atomic.Uint64
+	nextFunc proxy.NextFunc
 }
 
 func NewLeastAlgorithm(cfg *config.Config, middlewareExecutor *middleware.Executor, proxyFunc proxy.ProxyFunc) types.IBalancer {
+	// PrepareConfig rejects an empty Backend list; there is nothing to balance.
+	if len(cfg.Backends) == 0 {
+		return nil
+	}
+
 	leastAlgorithm := &LeastAlgorithm{
 		serversMap:        make(map[uint32]*serverMap),
 		isHostAlive:       cfg.HealthCheckerFunc,
@@ -42,25 +54,30 @@ func NewLeastAlgorithm(cfg *config.Config, middlewareExecutor *middleware.Execut
 		hashFunc:          cfg.HashFunc,
 		stopHealthChecker: make(chan struct{}),
 		healthCheckerDone: make(chan struct{}),
+		retryPolicy:       proxy.NewRetryPolicy(cfg.Retry, cfg.Hedge, cfg.Queue),
+		pool:              cfg.Pool,
 	}
 
 	servers := make([]proxy.IProxyClient, 0, len(cfg.Backends))
+	var degraded proxy.DegradedSet
 	for i, b := range cfg.Backends {
 		proxyClient := proxyFunc(&b, cfg.CustomHeaders, middlewareExecutor)
-		isHostAlive := leastAlgorithm.isHostAlive(b.GetHealthCheckURL())
-		if isHostAlive {
+		state := leastAlgorithm.isHostAlive(b.GetHealthCheckURL())
+		degraded = degraded.Apply(proxyClient, types.Down, state, b.HealthCheck.DegradedWeight, cfg.Pool, b.Url)
+		if state.InRotation() {
 			servers = append(servers, proxyClient)
 			zap.S().Infof("Server add for load balancing successfully Addr: %s", b.Url)
 		} else {
 			zap.S().Warnf("Server is not live, it will be added for load balancing when its health check succeeds, Addr: %s", b.Url)
 		}
 		backendState := &serverMap{proxy: proxyClient, statsIdx: len(leastAlgorithm.serversMap)}
-		backendState.isHostAlive.Store(isHostAlive)
+		backendState.health.Store(state)
 		leastAlgorithm.serversMap[leastAlgorithm.hashFunc(helper.S2B(b.Url+strconv.Itoa(i)))] = backendState
 	}
+	leastAlgorithm.degraded.Store(degraded)
 
 	if len(servers) == 0 {
-		return nil
+		zap.S().Warn("No backend is alive yet, serving 503 until a backend rejoins")
 	}
 	leastAlgorithm.servers.Store(&servers)
 
@@ -81,16 +98,11 @@ func NewLeastAlgorithm(cfg *config.Config, middlewareExecutor *middleware.Execut
 
 func (l *LeastAlgorithm) Serve() func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
-		proxyClient := l.nextFunc()
-		if proxyClient == nil {
-			proxy.NoAliveBackends(ctx)
-			return
-		}
-		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		l.retryPolicy.Serve(ctx, l.nextFunc)
 	}
 }
 
-func (l *LeastAlgorithm) leastConnectionNext() proxy.IProxyClient {
+func (l *LeastAlgorithm) leastConnectionNext(tried []proxy.IProxyClient) proxy.IProxyClient {
 	servers := *l.servers.Load()
 	if len(servers) == 0 {
 		return nil
@@ -98,26 +110,50 @@ func (l *LeastAlgorithm) leastConnectionNext() proxy.IProxyClient {
 	// The scan starts at a rotating offset so equally loaded Backends
 	// (an idle pool) take turns instead of the lowest index winning every tie.
 	offset := int(l.cursor.Add(1) % uint64(len(servers)))
-	proxyClient := servers[offset]
-	leastPending := proxyClient.PendingRequests()
-	for i := 1; i < len(servers); i++ {
+	degraded := l.degraded.Load()
+	var proxyClient, fallback proxy.IProxyClient
+	leastPending := 0
+	for i := range len(servers) {
 		server := servers[(offset+i)%len(servers)]
-		if pending := server.PendingRequests(); pending < leastPending {
+		if slices.Contains(tried, server) {
+			continue
+		}
+		// A Degraded Backend sits out part of the requests whatever its load.
+		if !degraded.Admit(server) {
+			if fallback == nil {
+				fallback = server
+			}
+			continue
+		}
+		if pending := server.PendingRequests(); proxyClient == nil || pending < leastPending {
 			proxyClient = server
 			leastPending = pending
 		}
 	}
+	if proxyClient == nil {
+		return fallback
+	}
 	return proxyClient
 }
 
-func (l *LeastAlgorithm) leastResponseTimeNext() proxy.IProxyClient {
+func (l *LeastAlgorithm) leastResponseTimeNext(tried []proxy.IProxyClient) proxy.IProxyClient {
 	servers := *l.servers.Load()
 	if len(servers) == 0 {
 		return nil
 	}
-	proxyClient := servers[0]
-	leastResTime := proxyClient.RecentResponseTime()
+	degraded := l.degraded.Load()
+	var proxyClient, fallback proxy.IProxyClient
+	leastResTime := 0.0
 	for _, server := range servers {
+		if slices.Contains(tried, server) {
+			continue
+		}
+		if !degraded.Admit(server) {
+			if fallback == nil {
+				fallback = server
+			}
+			continue
+		}
 		resTime := server.RecentResponseTime()
 		// 0 means the Backend is unmeasured — never answered, or just
 		// Rejoined: it wins outright so it gets its first sample.
@@ -125,12 +161,15 @@ func (l *LeastAlgorithm) leastResponseTimeNext() proxy.IProxyClient {
 			return server
 		}
 
-		if resTime < leastResTime {
+		if proxyClient == nil || resTime < leastResTime {
 			proxyClient = server
 			leastResTime = resTime
 		}
 	}
 
+	if proxyClient == nil {
+		return fallback
+	}
 	return proxyClient
 }
 
@@ -155,19 +194,28 @@ func (l *LeastAlgorithm) healthChecker(backends []config.Backend) {
 }
 
 func (l *LeastAlgorithm) healthCheck(backend *config.Backend, index int) {
-	status := l.isHostAlive(backend.GetHealthCheckURL())
+	state := l.isHostAlive(backend.GetHealthCheckURL())
 	backendHash := l.hashFunc(helper.S2B(backend.Url + strconv.Itoa(index)))
 	proxyMap, ok := l.serversMap[backendHash]
-	if ok && (!status && proxyMap.isHostAlive.Load()) {
+	if !ok {
+		return
+	}
+
+	prev := proxyMap.health.Load()
+	degraded := l.degraded.Load().Apply(proxyMap.proxy, prev, state, backend.HealthCheck.DegradedWeight, l.pool, backend.Url)
+	l.degraded.Store(degraded)
+
+	if !state.InRotation() && prev.InRotation() {
 		newServers := helper.RemoveByValue(*l.servers.Load(), proxyMap.proxy)
 		l.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(false)
 
 		zap.S().Infof("Server is down, removing from load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendDown, Pool: l.pool, Backend: backend.Url})
 		if len(newServers) == 0 {
 			zap.S().Warn("All backends are down, serving 503 until a backend rejoins")
+			events.Publish(events.Event{Type: events.AllBackendsDown, Pool: l.pool})
 		}
-	} else if ok && (status && !proxyMap.isHostAlive.Load()) {
+	} else if state.InRotation() && !prev.InRotation() {
 		// A Rejoining Backend starts unmeasured: its score from before it
 		// went Down — possibly a failure penalty — says nothing about it now.
 		proxyMap.proxy.ResetRecentResponseTime()
@@ -176,22 +224,27 @@ func (l *LeastAlgorithm) healthCheck(backend *config.Backend, index int) {
 		newServers = append(newServers, oldServers...)
 		newServers = append(newServers, proxyMap.proxy)
 		l.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(true)
 		zap.S().Infof("Server is live again, adding back to load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendRejoin, Pool: l.pool, Backend: backend.Url})
 	}
+
+	proxyMap.health.Store(state)
 }
 
 func (l *LeastAlgorithm) Stats() []types.ProxyStat {
 	stats := make([]types.ProxyStat, len(l.serversMap))
 	for hash, p := range l.serversMap {
 		s := p.proxy.Stat()
+		health := p.health.Load()
 		stats[p.statsIdx] = types.ProxyStat{
 			Addr:          s.Addr,
 			TotalReqCount: s.TotalReqCount,
 			AvgResTime:    s.AvgResTime,
 			LastUseTime:   s.LastUseTime,
 			ConnsCount:    s.ConnsCount,
-			IsHostAlive:   p.isHostAlive.Load(),
+			OpenTunnels:   s.OpenTunnels,
+			IsHostAlive:   health.InRotation(),
+			Health:        health.String(),
 			BackendHash:   hash,
 		}
 	}
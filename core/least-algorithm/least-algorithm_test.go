@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
@@ -17,7 +19,7 @@ import (
 
 func TestNewLeastAlgorithm(t *testing.T) {
 	for i, l := range mocks.TestCases {
-		if l.ExpectedServerCount == 0 {
+		if len(l.Config.Backends) == 0 {
 			testConfig := l.Config
 			testConfig.Type = "least-connection"
 			if i%2 == 0 {
@@ -34,7 +36,7 @@ func TestNewLeastAlgorithm(t *testing.T) {
 			}
 
 			leastAlgorithm := NewLeastAlgorithm(&testConfig, nil, l.ProxyFunc).(*LeastAlgorithm)
-			assert.Equal(t, l.ExpectedServerCount, len(leastAlgorithm.serversMap))
+			assert.Equal(t, len(l.Config.Backends), len(leastAlgorithm.serversMap))
 			assert.Equal(t, l.ExpectedServerCount, len(*leastAlgorithm.servers.Load()))
 		}
 	}
@@ -58,7 +60,7 @@ func TestNext(t *testing.T) {
 			leastConnection := balancer.(*LeastAlgorithm)
 			seen := map[string]int{}
 			for range caseFour.Config.Backends {
-				proxy := leastConnection.nextFunc()
+				proxy := leastConnection.nextFunc(nil)
 				assert.IsType(t, &mocks.MockProxy{}, proxy)
 				seen[proxy.(*mocks.MockProxy).Addr]++
 			}
@@ -74,7 +76,7 @@ func TestNext(t *testing.T) {
 			assert.NotNil(t, balancer)
 
 			leastConnection := balancer.(*LeastAlgorithm)
-			proxy := leastConnection.nextFunc()
+			proxy := leastConnection.nextFunc(nil)
 
 			assert.IsType(t, &mocks.MockProxy{}, proxy)
 			mProxy := proxy.(*mocks.MockProxy)
@@ -90,7 +92,7 @@ func TestNext(t *testing.T) {
 			assert.NotNil(t, balancer)
 
 			leastResponseTime := balancer.(*LeastAlgorithm)
-			proxy := leastResponseTime.nextFunc()
+			proxy := leastResponseTime.nextFunc(nil)
 
 			assert.IsType(t, &mocks.MockProxy{}, proxy)
 			mProxy := proxy.(*mocks.MockProxy)
@@ -104,7 +106,7 @@ func TestNext(t *testing.T) {
 			assert.NotNil(t, balancer)
 
 			leastResponseTime := balancer.(*LeastAlgorithm)
-			proxy := leastResponseTime.nextFunc()
+			proxy := leastResponseTime.nextFunc(nil)
 
 			assert.IsType(t, &mocks.MockProxy{}, proxy)
 			mProxy := proxy.(*mocks.MockProxy)
@@ -127,7 +129,7 @@ func TestServe(t *testing.T) {
 		Request: *fasthttp.AcquireRequest(),
 	}
 
-	proxy := leastAlgorithm.nextFunc().(*mocks.MockProxy)
+	proxy := leastAlgorithm.nextFunc(nil).(*mocks.MockProxy)
 	assert.False(t, proxy.IsCalled, "expected Server func not be called, but it was called")
 	handlerFunc(&ctx)
 	assert.True(t, proxy.IsCalled, "expected Server func to be called, but it wasn't")
@@ -142,9 +144,9 @@ func TestHealthChecker(t *testing.T) {
 	}
 
 	var stopOnce sync.Once
-	leastAlgorithm.isHostAlive = func(s string) bool {
+	leastAlgorithm.isHostAlive = func(s string) types.HealthState {
 		stopOnce.Do(func() { close(leastAlgorithm.stopHealthChecker) })
-		return false
+		return types.Down
 	}
 	leastAlgorithm.hashFunc = func(b []byte) uint32 {
 		return 0
@@ -168,7 +170,7 @@ func TestStats(t *testing.T) {
 		s := leastAlgorithm.serversMap[hash]
 		p := s.proxy.(*mocks.MockProxy)
 
-		assert.Equal(t, s.isHostAlive.Load(), stats[i].IsHostAlive)
+		assert.Equal(t, s.health.Load().InRotation(), stats[i].IsHostAlive)
 		assert.Equal(t, hash, stats[i].BackendHash)
 		assert.Equal(t, backend.Url, p.Addr)
 	}
@@ -183,13 +185,13 @@ func TestRemoveOneServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := leastAlgorithm.serversMap[leastAlgorithm.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		leastAlgorithm.isHostAlive = func(s string) bool {
-			return false
+		leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*leastAlgorithm.servers.Load())
 		leastAlgorithm.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*leastAlgorithm.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 }
@@ -203,27 +205,27 @@ func TestRemoveAndAddServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := leastAlgorithm.serversMap[leastAlgorithm.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		leastAlgorithm.isHostAlive = func(s string) bool {
-			return false
+		leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*leastAlgorithm.servers.Load())
 		leastAlgorithm.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*leastAlgorithm.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 
 	// Add one server
 	if b, ok := leastAlgorithm.serversMap[leastAlgorithm.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		b.isHostAlive.Store(false)
-		leastAlgorithm.isHostAlive = func(s string) bool {
-			return true
+		b.health.Store(types.Down)
+		leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+			return types.Alive
 		}
 
 		oldServerCount := len(*leastAlgorithm.servers.Load())
 		leastAlgorithm.healthCheck(&backend, 0)
 
-		assert.True(t, b.isHostAlive.Load(), "expected isHostAlive equal to true, but got %v", b.isHostAlive.Load())
+		assert.True(t, b.health.Load().InRotation(), "expected isHostAlive equal to true, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, len(*leastAlgorithm.servers.Load()), oldServerCount, "expected server to be added after health check, but it did not.")
 
 	}
@@ -237,8 +239,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
 	assert.Equal(t, caseOne.ExpectedServerCount, len(leastAlgorithm.serversMap))
 
-	leastAlgorithm.isHostAlive = func(s string) bool {
-		return false
+	leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+		return types.Down
 	}
 	for i, backend := range caseOne.Config.Backends {
 		assert.NotPanics(t, func() {
@@ -255,8 +257,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
 
 	// Rejoin after the total outage.
-	leastAlgorithm.isHostAlive = func(s string) bool {
-		return true
+	leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
 	}
 	leastAlgorithm.healthCheck(&caseOne.Config.Backends[0], 0)
 	assert.Len(t, *leastAlgorithm.servers.Load(), 1)
@@ -268,6 +270,36 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	// SPEC (1.0): no Backend passing its first Probe must not stop divisor
+	// from starting; requests get 503 until a Probe lets a backend Rejoin.
+	allDown := mocks.TestCases[2]
+	allDown.Config.Type = "least-connection"
+	leastAlgorithm := NewLeastAlgorithm(&allDown.Config, nil, allDown.ProxyFunc).(*LeastAlgorithm)
+	assert.Equal(t, len(allDown.Config.Backends), len(leastAlgorithm.serversMap))
+	assert.Empty(t, *leastAlgorithm.servers.Load())
+
+	handler := leastAlgorithm.Serve()
+	ctx := fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	// The first successful Probe lets a Backend Rejoin.
+	leastAlgorithm.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
+	}
+	leastAlgorithm.healthCheck(&allDown.Config.Backends[0], 0)
+	assert.Len(t, *leastAlgorithm.servers.Load(), 1)
+
+	ctx = fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+}
+
 func TestShutdown(t *testing.T) {
 	t.Run("shutdown least-connection calls close on all proxies", func(t *testing.T) {
 		caseOne := mocks.TestCases[0]
@@ -358,8 +390,8 @@ func TestStatsWhenBackendDownAtStartup(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -385,8 +417,8 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -396,12 +428,12 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 	leastAlgorithm := NewLeastAlgorithm(&cfg, nil, mocks.CreateNewMockProxy).(*LeastAlgorithm)
 	assert.Len(t, *leastAlgorithm.servers.Load(), 1)
 
-	leastAlgorithm.isHostAlive = func(string) bool { return true }
+	leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Alive }
 	leastAlgorithm.healthCheck(&cfg.Backends[0], 0)
 
 	assert.Len(t, *leastAlgorithm.servers.Load(), 2)
 	sm := leastAlgorithm.serversMap[leastAlgorithm.hashFunc([]byte("localhost:8080"+"0"))]
-	assert.True(t, sm.isHostAlive.Load())
+	assert.True(t, sm.health.Load().InRotation())
 }
 
 func TestNextConcurrentWithHealthCheck(t *testing.T) {
@@ -412,14 +444,14 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 	var alive atomic.Bool
 	leastAlgorithm := &LeastAlgorithm{
 		hashFunc:    hashFunc,
-		isHostAlive: func(string) bool { return alive.Load() },
+		isHostAlive: func(string) types.HealthState { return mocks.AliveIf(alive.Load()) },
 		serversMap: map[uint32]*serverMap{
 			hashFunc([]byte("localhost:8080" + "0")): {proxy: p1, statsIdx: 0},
 			hashFunc([]byte("localhost:80" + "1")):   {proxy: p2, statsIdx: 1},
 		},
 	}
 	for _, sm := range leastAlgorithm.serversMap {
-		sm.isHostAlive.Store(true)
+		sm.health.Store(types.Alive)
 	}
 	servers := []proxy.IProxyClient{p1, p2}
 	leastAlgorithm.servers.Store(&servers)
@@ -441,8 +473,8 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 		case <-done:
 			return
 		default:
-			leastAlgorithm.leastConnectionNext()
-			leastAlgorithm.leastResponseTimeNext()
+			leastAlgorithm.leastConnectionNext(nil)
+			leastAlgorithm.leastResponseTimeNext(nil)
 		}
 	}
 }
@@ -453,7 +485,7 @@ func BenchmarkLeastConnectionNext(b *testing.B) {
 	leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			leastAlgorithm.nextFunc()
+			leastAlgorithm.nextFunc(nil)
 		}
 	})
 }
@@ -464,7 +496,7 @@ func BenchmarkLeastResponseTimeNext(b *testing.B) {
 	leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			leastAlgorithm.nextFunc()
+			leastAlgorithm.nextFunc(nil)
 		}
 	})
 }
@@ -475,9 +507,9 @@ func TestShutdownStopsHealthChecker(t *testing.T) {
 	caseOne.Config.Type = "least-connection"
 
 	var checks atomic.Int64
-	caseOne.Config.HealthCheckerFunc = func(string) bool {
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState {
 		checks.Add(1)
-		return true
+		return types.Alive
 	}
 
 	leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
@@ -510,7 +542,7 @@ func TestLeastResponseTimeNextPicksLeast(t *testing.T) {
 		fast := &mocks.MockProxy{Addr: "localhost:8082", ResTime: 1}
 
 		leastAlgorithm := newBalancer(slow, medium, fast)
-		assert.Equal(t, fast, leastAlgorithm.leastResponseTimeNext())
+		assert.Equal(t, fast, leastAlgorithm.leastResponseTimeNext(nil))
 	})
 
 	t.Run("distinguishes sub-millisecond backends", func(t *testing.T) {
@@ -518,7 +550,7 @@ func TestLeastResponseTimeNextPicksLeast(t *testing.T) {
 		faster := &mocks.MockProxy{Addr: "localhost:8081", ResTime: 0.2}
 
 		leastAlgorithm := newBalancer(slower, faster)
-		assert.Equal(t, faster, leastAlgorithm.leastResponseTimeNext())
+		assert.Equal(t, faster, leastAlgorithm.leastResponseTimeNext(nil))
 	})
 
 	t.Run("prefers a backend that has not answered yet", func(t *testing.T) {
@@ -526,7 +558,7 @@ func TestLeastResponseTimeNextPicksLeast(t *testing.T) {
 		rejoined := &mocks.MockProxy{Addr: "localhost:8081"}
 
 		leastAlgorithm := newBalancer(measured, rejoined)
-		assert.Equal(t, rejoined, leastAlgorithm.leastResponseTimeNext())
+		assert.Equal(t, rejoined, leastAlgorithm.leastResponseTimeNext(nil))
 	})
 }
 
@@ -540,11 +572,11 @@ func TestRejoinResetsResponseTimeScore(t *testing.T) {
 	mockProxy := leastAlgorithm.serversMap[hash].proxy.(*mocks.MockProxy)
 	mockProxy.ResTime = 42
 
-	leastAlgorithm.isHostAlive = func(string) bool { return false }
+	leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Down }
 	leastAlgorithm.healthCheck(&backend, 0)
 	assert.Equal(t, float64(42), mockProxy.ResTime, "going Down alone must not clear the score")
 
-	leastAlgorithm.isHostAlive = func(string) bool { return true }
+	leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Alive }
 	leastAlgorithm.healthCheck(&backend, 0)
 	assert.Equal(t, float64(0), mockProxy.ResTime, "a Rejoining Backend must start unmeasured")
 }
@@ -567,7 +599,7 @@ func TestLeastConnectionNextPicksLeast(t *testing.T) {
 
 		leastAlgorithm := newBalancer(busy, medium, idle)
 		for i := 0; i < 10; i++ {
-			assert.Equal(t, idle, leastAlgorithm.leastConnectionNext(), "call %d", i)
+			assert.Equal(t, idle, leastAlgorithm.leastConnectionNext(nil), "call %d", i)
 		}
 	})
 
@@ -579,7 +611,7 @@ func TestLeastConnectionNextPicksLeast(t *testing.T) {
 		leastAlgorithm := newBalancer(a, b, c)
 		seen := map[*mocks.MockProxy]int{}
 		for i := 0; i < 9; i++ {
-			seen[leastAlgorithm.leastConnectionNext().(*mocks.MockProxy)]++
+			seen[leastAlgorithm.leastConnectionNext(nil).(*mocks.MockProxy)]++
 		}
 		assert.Equal(t, map[*mocks.MockProxy]int{a: 3, b: 3, c: 3}, seen)
 	})
@@ -588,7 +620,7 @@ func TestLeastConnectionNextPicksLeast(t *testing.T) {
 		a := &mocks.MockProxy{Addr: "localhost:9000", Pending: 2}
 		b := &mocks.MockProxy{Addr: "localhost:9001", Pending: 2}
 		leastAlgorithm := newBalancer(a, b)
-		leastAlgorithm.leastConnectionNext()
+		leastAlgorithm.leastConnectionNext(nil)
 
 		rejoined := &mocks.MockProxy{Addr: "localhost:9002", Pending: 2}
 		servers := append(*leastAlgorithm.servers.Load(), rejoined)
@@ -596,7 +628,7 @@ func TestLeastConnectionNextPicksLeast(t *testing.T) {
 
 		reached := false
 		for i := 0; i < len(servers) && !reached; i++ {
-			reached = leastAlgorithm.leastConnectionNext() == rejoined
+			reached = leastAlgorithm.leastConnectionNext(nil) == rejoined
 		}
 		assert.True(t, reached, "an equally loaded Rejoined Backend must be picked within one rotation")
 	})
@@ -606,14 +638,14 @@ func TestLeastConnectionNextPicksLeast(t *testing.T) {
 		b := &mocks.MockProxy{Addr: "localhost:9001"}
 		c := &mocks.MockProxy{Addr: "localhost:9002"}
 		leastAlgorithm := newBalancer(a, b, c)
-		leastAlgorithm.leastConnectionNext()
-		leastAlgorithm.leastConnectionNext()
+		leastAlgorithm.leastConnectionNext(nil)
+		leastAlgorithm.leastConnectionNext(nil)
 
 		servers := []proxy.IProxyClient{a}
 		leastAlgorithm.servers.Store(&servers)
 
 		for i := 0; i < 5; i++ {
-			assert.Equal(t, a, leastAlgorithm.leastConnectionNext())
+			assert.Equal(t, a, leastAlgorithm.leastConnectionNext(nil))
 		}
 	})
 }
@@ -625,7 +657,7 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 	caseOne.Config.Type = "least-connection"
 	var alive atomic.Bool
 	alive.Store(true)
-	caseOne.Config.HealthCheckerFunc = func(string) bool { return alive.Load() }
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState { return mocks.AliveIf(alive.Load()) }
 	balancer := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
 	defer balancer.Shutdown() //nolint:errcheck
 
@@ -650,3 +682,95 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestNextSkipsTriedBackends(t *testing.T) {
+	for _, balancerType := range []string{"least-connection", "least-response-time"} {
+		t.Run(balancerType, func(t *testing.T) {
+			caseOne := mocks.TestCases[0]
+			caseOne.Config.Type = balancerType
+			leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
+			defer leastAlgorithm.Shutdown() //nolint:errcheck
+
+			first := leastAlgorithm.nextFunc(nil)
+			second := leastAlgorithm.nextFunc([]proxy.IProxyClient{first})
+			assert.NotNil(t, second)
+			assert.NotSame(t, first, second, "a retry must not get the Backend it already tried")
+			assert.Nil(t, leastAlgorithm.nextFunc([]proxy.IProxyClient{first, second}), "every Backend tried")
+		})
+	}
+}
+
+func TestDegradedBackend(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	caseOne.Config.Type = "least-connection"
+	degradedURL := caseOne.Config.Backends[0].GetHealthCheckURL()
+	caseOne.Config.HealthCheckerFunc = func(url string) types.HealthState {
+		if url == degradedURL {
+			return types.Degraded
+		}
+		return types.Alive
+	}
+	leastAlgorithm := NewLeastAlgorithm(&caseOne.Config, nil, caseOne.ProxyFunc).(*LeastAlgorithm)
+	defer leastAlgorithm.Shutdown() //nolint:errcheck
+
+	sm := leastAlgorithm.serversMap[leastAlgorithm.hashFunc([]byte(caseOne.Config.Backends[0].Url+"0"))]
+	degradedProxy := sm.proxy
+	assert.Len(t, *leastAlgorithm.servers.Load(), 2, "a Degraded Backend stays in rotation")
+
+	// degraded_weight 0 (below what PrepareConfig allows) turns every
+	// request down, so the Degraded Backend only serves as a last resort.
+	var other proxy.IProxyClient
+	for range 50 {
+		other = leastAlgorithm.nextFunc(nil)
+		assert.NotSame(t, degradedProxy, other)
+	}
+	assert.Same(t, degradedProxy, leastAlgorithm.nextFunc([]proxy.IProxyClient{other}))
+
+	stats := leastAlgorithm.Stats()
+	assert.Equal(t, "degraded", stats[0].Health)
+	assert.True(t, stats[0].IsHostAlive)
+
+	t.Run("recovers to Alive", func(t *testing.T) {
+		leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Alive }
+		leastAlgorithm.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Empty(t, leastAlgorithm.degraded.Load())
+		assert.Equal(t, types.Alive, sm.health.Load())
+	})
+
+	t.Run("goes Down and comes back Degraded", func(t *testing.T) {
+		leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Down }
+		leastAlgorithm.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *leastAlgorithm.servers.Load(), 1)
+
+		leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Degraded }
+		leastAlgorithm.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *leastAlgorithm.servers.Load(), 2)
+		assert.Contains(t, leastAlgorithm.degraded.Load(), degradedProxy)
+		assert.Equal(t, types.Degraded, sm.health.Load())
+	})
+}
+
+func TestHealthCheckPublishesEvents(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	caseTwo.Config.Type = "least-connection"
+	leastAlgorithm := NewLeastAlgorithm(&caseTwo.Config, nil, caseTwo.ProxyFunc).(*LeastAlgorithm)
+	defer leastAlgorithm.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Down }
+	leastAlgorithm.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	down := <-ch
+	assert.Equal(t, events.BackendDown, down.Type)
+	assert.Equal(t, "localhost:8080", down.Backend)
+	assert.Equal(t, events.AllBackendsDown, (<-ch).Type)
+
+	leastAlgorithm.isHostAlive = func(string) types.HealthState { return types.Alive }
+	leastAlgorithm.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 1)
+	rejoin := <-ch
+	assert.Equal(t, events.BackendRejoin, rejoin.Type)
+	assert.Equal(t, "localhost:8080", rejoin.Backend)
+}
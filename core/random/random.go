@@ -2,12 +2,14 @@ package random
 
 import (
 	rand "math/rand/v2"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	types "github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/helper"
@@ -17,9 +19,9 @@ import (
 )
 
 type serverMap struct {
-	proxy       proxy.IProxyClient
-	isHostAlive atomic.Bool
-	statsIdx    int
+	proxy    proxy.IProxyClient
+	health   types.Health
+	statsIdx int
 }
 
 type Random struct {
@@ -30,10 +32,20 @@ type Random struct {
 	healthCheckerDone chan struct{}
 	stopOnce          sync.Once
 	servers           atomic.Pointer[[]proxy.IProxyClient]
+	degraded          proxy.Degraded
 	healthCheckerTime time.Duration
+	retryPolicy       *proxy.RetryPolicy
+	// pool names the pool the Backends belong to in the events published
+	// about them; empty for the Backends under backends.
+	pool string
 }
 
 func NewRandom(cfg *config.Config, middlewareExecutor *middleware.Executor, proxyFunc proxy.ProxyFunc) types.IBalancer {
+	// PrepareConfig rejects an empty Backend list; there is nothing to balance.
+	if len(cfg.Backends) == 0 {
+		return nil
+	}
+
 	random := &Random{
 		serversMap:        make(map[uint32]*serverMap),
 		isHostAlive:       cfg.HealthCheckerFunc,
@@ -41,25 +53,30 @@ func NewRandom(cfg *config.Config, middlewareExecutor *middleware.Executor, prox
 		hashFunc:          cfg.HashFunc,
 		stopHealthChecker: make(chan struct{}),
 		healthCheckerDone: make(chan struct{}),
+		retryPolicy:       proxy.NewRetryPolicy(cfg.Retry, cfg.Hedge, cfg.Queue),
+		pool:              cfg.Pool,
 	}
 
 	servers := make([]proxy.IProxyClient, 0, len(cfg.Backends))
+	var degraded proxy.DegradedSet
 	for i, b := range cfg.Backends {
 		proxyClient := proxyFunc(&b, cfg.CustomHeaders, middlewareExecutor)
-		isHostAlive := random.isHostAlive(b.GetHealthCheckURL())
-		if isHostAlive {
+		state := random.isHostAlive(b.GetHealthCheckURL())
+		degraded = degraded.Apply(proxyClient, types.Down, state, b.HealthCheck.DegradedWeight, cfg.Pool, b.Url)
+		if state.InRotation() {
 			servers = append(servers, proxyClient)
 			zap.S().Infof("Server add for load balancing successfully Addr: %s", b.Url)
 		} else {
 			zap.S().Warnf("Server is not live, it will be added for load balancing when its health check succeeds, Addr: %s", b.Url)
 		}
 		backendState := &serverMap{proxy: proxyClient, statsIdx: len(random.serversMap)}
-		backendState.isHostAlive.Store(isHostAlive)
+		backendState.health.Store(state)
 		random.serversMap[random.hashFunc(helper.S2B(b.Url+strconv.Itoa(i)))] = backendState
 	}
+	random.degraded.Store(degraded)
 
 	if len(servers) == 0 {
-		return nil
+		zap.S().Warn("No backend is alive yet, serving 503 until a backend rejoins")
 	}
 	random.servers.Store(&servers)
 
@@ -70,21 +87,32 @@ func NewRandom(cfg *config.Config, middlewareExecutor *middleware.Executor, prox
 
 func (r *Random) Serve() func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
-		proxyClient := r.next()
-		if proxyClient == nil {
-			proxy.NoAliveBackends(ctx)
-			return
-		}
-		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		r.retryPolicy.Serve(ctx, r.next)
 	}
 }
 
-func (r *Random) next() proxy.IProxyClient {
+func (r *Random) next(tried []proxy.IProxyClient) proxy.IProxyClient {
 	servers := *r.servers.Load()
 	if len(servers) == 0 {
 		return nil
 	}
-	return servers[rand.IntN(len(servers))] //nolint:gosec
+	degraded := r.degraded.Load()
+	// A retry walks on from the random pick to the first Backend it has not
+	// tried, which keeps the choice among the untried ones random. A Degraded
+	// Backend that turns the request down hands it to a healthy Backend at
+	// random.
+	start := rand.IntN(len(servers)) //nolint:gosec
+	for i := range len(servers) {
+		server := servers[(start+i)%len(servers)]
+		if slices.Contains(tried, server) {
+			continue
+		}
+		if degraded.Admit(server) {
+			return server
+		}
+		return degraded.Repick(server, servers, tried)
+	}
+	return nil
 }
 
 func (r *Random) healthChecker(backends []config.Backend) {
@@ -108,40 +136,54 @@ func (r *Random) healthChecker(backends []config.Backend) {
 }
 
 func (r *Random) healthCheck(backend *config.Backend, index int) {
-	status := r.isHostAlive(backend.GetHealthCheckURL())
+	state := r.isHostAlive(backend.GetHealthCheckURL())
 	backendHash := r.hashFunc(helper.S2B(backend.Url + strconv.Itoa(index)))
 	proxyMap, ok := r.serversMap[backendHash]
-	if ok && (!status && proxyMap.isHostAlive.Load()) {
+	if !ok {
+		return
+	}
+
+	prev := proxyMap.health.Load()
+	degraded := r.degraded.Load().Apply(proxyMap.proxy, prev, state, backend.HealthCheck.DegradedWeight, r.pool, backend.Url)
+	r.degraded.Store(degraded)
+
+	if !state.InRotation() && prev.InRotation() {
 		newServers := helper.RemoveByValue(*r.servers.Load(), proxyMap.proxy)
 		r.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(false)
 
 		zap.S().Infof("Server is down, removing from load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendDown, Pool: r.pool, Backend: backend.Url})
 		if len(newServers) == 0 {
 			zap.S().Warn("All backends are down, serving 503 until a backend rejoins")
+			events.Publish(events.Event{Type: events.AllBackendsDown, Pool: r.pool})
 		}
-	} else if ok && (status && !proxyMap.isHostAlive.Load()) {
+	} else if state.InRotation() && !prev.InRotation() {
 		oldServers := *r.servers.Load()
 		newServers := make([]proxy.IProxyClient, 0, len(oldServers)+1)
 		newServers = append(newServers, oldServers...)
 		newServers = append(newServers, proxyMap.proxy)
 		r.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(true)
 		zap.S().Infof("Server is live again, adding back to load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendRejoin, Pool: r.pool, Backend: backend.Url})
 	}
+
+	proxyMap.health.Store(state)
 }
 
 func (r *Random) Stats() []types.ProxyStat {
 	stats := make([]types.ProxyStat, len(r.serversMap))
 	for hash, p := range r.serversMap {
 		s := p.proxy.Stat()
+		health := p.health.Load()
 		stats[p.statsIdx] = types.ProxyStat{
 			Addr:          s.Addr,
 			TotalReqCount: s.TotalReqCount,
 			AvgResTime:    s.AvgResTime,
 			LastUseTime:   s.LastUseTime,
 			ConnsCount:    s.ConnsCount,
-			IsHostAlive:   p.isHostAlive.Load(),
+			OpenTunnels:   s.OpenTunnels,
+			IsHostAlive:   health.InRotation(),
+			Health:        health.String(),
 			BackendHash:   hash,
 		}
 	}
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
@@ -17,12 +19,13 @@ import (
 
 func TestNewRandom(t *testing.T) {
 	for _, rand := range mocks.TestCases {
-		if rand.ExpectedServerCount == 0 {
+		if len(rand.Config.Backends) == 0 {
 			random := NewRandom(&rand.Config, nil, rand.ProxyFunc)
 			assert.Nil(t, random)
 		} else {
 			random := NewRandom(&rand.Config, nil, rand.ProxyFunc).(*Random)
-			assert.Equal(t, rand.ExpectedServerCount, len(random.serversMap))
+			assert.Equal(t, len(rand.Config.Backends), len(random.serversMap))
+			assert.Equal(t, rand.ExpectedServerCount, len(*random.servers.Load()))
 		}
 	}
 }
@@ -33,7 +36,7 @@ func TestNext(t *testing.T) {
 	assert.NotNil(t, balancer)
 
 	random := balancer.(*Random)
-	proxy := random.next()
+	proxy := random.next(nil)
 
 	assert.IsType(t, &mocks.MockProxy{}, proxy)
 }
@@ -49,7 +52,7 @@ func TestServer(t *testing.T) {
 	ctx := fasthttp.RequestCtx{
 		Request: *fasthttp.AcquireRequest(),
 	}
-	proxy := random.next().(*mocks.MockProxy)
+	proxy := random.next(nil).(*mocks.MockProxy)
 	assert.False(t, proxy.IsCalled, "expected Server func not be called, but it was called")
 	handlerFunc(&ctx)
 	assert.True(t, proxy.IsCalled, "expected Server func to be called, but it wasn't")
@@ -67,7 +70,7 @@ func TestStats(t *testing.T) {
 		hash := random.hashFunc([]byte(backend.Url + strconv.Itoa(i)))
 		s := random.serversMap[hash]
 
-		assert.Equal(t, s.isHostAlive.Load(), stats[i].IsHostAlive)
+		assert.Equal(t, s.health.Load().InRotation(), stats[i].IsHostAlive)
 		assert.Equal(t, hash, stats[i].BackendHash)
 	}
 }
@@ -81,9 +84,9 @@ func TestHealthChecker(t *testing.T) {
 	}
 
 	var stopOnce sync.Once
-	random.isHostAlive = func(s string) bool {
+	random.isHostAlive = func(s string) types.HealthState {
 		stopOnce.Do(func() { close(random.stopHealthChecker) })
-		return false
+		return types.Down
 	}
 	random.hashFunc = func(b []byte) uint32 {
 		return 0
@@ -101,13 +104,13 @@ func TestRemoveOneServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := random.serversMap[random.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		random.isHostAlive = func(s string) bool {
-			return false
+		random.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*random.servers.Load())
 		random.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*random.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 
@@ -121,27 +124,27 @@ func TestRemoveAndAddServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := random.serversMap[random.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		random.isHostAlive = func(s string) bool {
-			return false
+		random.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*random.servers.Load())
 		random.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*random.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 
 	// Add one server
 	if b, ok := random.serversMap[random.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		b.isHostAlive.Store(false)
-		random.isHostAlive = func(s string) bool {
-			return true
+		b.health.Store(types.Down)
+		random.isHostAlive = func(s string) types.HealthState {
+			return types.Alive
 		}
 
 		oldServerCount := len(*random.servers.Load())
 		random.healthCheck(&backend, 0)
 
-		assert.True(t, b.isHostAlive.Load(), "expected isHostAlive equal to true, but got %v", b.isHostAlive.Load())
+		assert.True(t, b.health.Load().InRotation(), "expected isHostAlive equal to true, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, len(*random.servers.Load()), oldServerCount, "expected server to be added after health check, but it did not.")
 
 	}
@@ -154,8 +157,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	random := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
 	assert.Equal(t, caseOne.ExpectedServerCount, len(random.serversMap))
 
-	random.isHostAlive = func(s string) bool {
-		return false
+	random.isHostAlive = func(s string) types.HealthState {
+		return types.Down
 	}
 	for i, backend := range caseOne.Config.Backends {
 		assert.NotPanics(t, func() {
@@ -172,8 +175,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
 
 	// Rejoin after the total outage.
-	random.isHostAlive = func(s string) bool {
-		return true
+	random.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
 	}
 	random.healthCheck(&caseOne.Config.Backends[0], 0)
 	assert.Len(t, *random.servers.Load(), 1)
@@ -185,6 +188,35 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	// SPEC (1.0): no Backend passing its first Probe must not stop divisor
+	// from starting; requests get 503 until a Probe lets a backend Rejoin.
+	allDown := mocks.TestCases[2]
+	random := NewRandom(&allDown.Config, nil, allDown.ProxyFunc).(*Random)
+	assert.Equal(t, len(allDown.Config.Backends), len(random.serversMap))
+	assert.Empty(t, *random.servers.Load())
+
+	handler := random.Serve()
+	ctx := fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	// The first successful Probe lets a Backend Rejoin.
+	random.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
+	}
+	random.healthCheck(&allDown.Config.Backends[0], 0)
+	assert.Len(t, *random.servers.Load(), 1)
+
+	ctx = fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+}
+
 func TestShutdown(t *testing.T) {
 	t.Run("shutdown calls close on all proxies", func(t *testing.T) {
 		caseOne := mocks.TestCases[0]
@@ -248,8 +280,8 @@ func TestStatsWhenBackendDownAtStartup(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -274,8 +306,8 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -285,12 +317,12 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 	random := NewRandom(&cfg, nil, mocks.CreateNewMockProxy).(*Random)
 	assert.Len(t, *random.servers.Load(), 1)
 
-	random.isHostAlive = func(string) bool { return true }
+	random.isHostAlive = func(string) types.HealthState { return types.Alive }
 	random.healthCheck(&cfg.Backends[0], 0)
 
 	assert.Len(t, *random.servers.Load(), 2)
 	sm := random.serversMap[random.hashFunc([]byte("localhost:8080"+"0"))]
-	assert.True(t, sm.isHostAlive.Load())
+	assert.True(t, sm.health.Load().InRotation())
 }
 
 func TestNextConcurrentWithHealthCheck(t *testing.T) {
@@ -301,14 +333,14 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 	var alive atomic.Bool
 	random := &Random{
 		hashFunc:    hashFunc,
-		isHostAlive: func(string) bool { return alive.Load() },
+		isHostAlive: func(string) types.HealthState { return mocks.AliveIf(alive.Load()) },
 		serversMap: map[uint32]*serverMap{
 			hashFunc([]byte("localhost:8080" + "0")): {proxy: p1, statsIdx: 0},
 			hashFunc([]byte("localhost:80" + "1")):   {proxy: p2, statsIdx: 1},
 		},
 	}
 	for _, sm := range random.serversMap {
-		sm.isHostAlive.Store(true)
+		sm.health.Store(types.Alive)
 	}
 	servers := []proxy.IProxyClient{p1, p2}
 	random.servers.Store(&servers)
@@ -330,7 +362,7 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 		case <-done:
 			return
 		default:
-			random.next()
+			random.next(nil)
 		}
 	}
 }
@@ -340,7 +372,7 @@ func BenchmarkNext(b *testing.B) {
 	random := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			random.next()
+			random.next(nil)
 		}
 	})
 }
@@ -350,9 +382,9 @@ func TestShutdownStopsHealthChecker(t *testing.T) {
 	caseOne.Config.HealthCheckerTime = 5 * time.Millisecond
 
 	var checks atomic.Int64
-	caseOne.Config.HealthCheckerFunc = func(string) bool {
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState {
 		checks.Add(1)
-		return true
+		return types.Alive
 	}
 
 	random := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
@@ -374,7 +406,7 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 	caseOne := mocks.TestCases[0]
 	var alive atomic.Bool
 	alive.Store(true)
-	caseOne.Config.HealthCheckerFunc = func(string) bool { return alive.Load() }
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState { return mocks.AliveIf(alive.Load()) }
 	balancer := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
 	defer balancer.Shutdown() //nolint:errcheck
 
@@ -399,3 +431,90 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestNextSkipsTriedBackends(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	random := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
+	defer random.Shutdown() //nolint:errcheck
+
+	for range 10 {
+		first := random.next(nil)
+		second := random.next([]proxy.IProxyClient{first})
+		assert.NotNil(t, second)
+		assert.NotSame(t, first, second, "a retry must not get the Backend it already tried")
+		assert.Nil(t, random.next([]proxy.IProxyClient{first, second}), "every Backend tried")
+	}
+}
+
+func TestDegradedBackend(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	degradedURL := caseOne.Config.Backends[0].GetHealthCheckURL()
+	caseOne.Config.HealthCheckerFunc = func(url string) types.HealthState {
+		if url == degradedURL {
+			return types.Degraded
+		}
+		return types.Alive
+	}
+	random := NewRandom(&caseOne.Config, nil, caseOne.ProxyFunc).(*Random)
+	defer random.Shutdown() //nolint:errcheck
+
+	sm := random.serversMap[random.hashFunc([]byte(caseOne.Config.Backends[0].Url+"0"))]
+	degradedProxy := sm.proxy
+	assert.Len(t, *random.servers.Load(), 2, "a Degraded Backend stays in rotation")
+
+	// degraded_weight 0 (below what PrepareConfig allows) turns every
+	// request down, so the Degraded Backend only serves as a last resort.
+	var other proxy.IProxyClient
+	for range 50 {
+		other = random.next(nil)
+		assert.NotSame(t, degradedProxy, other)
+	}
+	assert.Same(t, degradedProxy, random.next([]proxy.IProxyClient{other}))
+
+	stats := random.Stats()
+	assert.Equal(t, "degraded", stats[0].Health)
+	assert.True(t, stats[0].IsHostAlive)
+
+	t.Run("recovers to Alive", func(t *testing.T) {
+		random.isHostAlive = func(string) types.HealthState { return types.Alive }
+		random.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Empty(t, random.degraded.Load())
+		assert.Equal(t, types.Alive, sm.health.Load())
+	})
+
+	t.Run("goes Down and comes back Degraded", func(t *testing.T) {
+		random.isHostAlive = func(string) types.HealthState { return types.Down }
+		random.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *random.servers.Load(), 1)
+
+		random.isHostAlive = func(string) types.HealthState { return types.Degraded }
+		random.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *random.servers.Load(), 2)
+		assert.Contains(t, random.degraded.Load(), degradedProxy)
+		assert.Equal(t, types.Degraded, sm.health.Load())
+	})
+}
+
+func TestHealthCheckPublishesEvents(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	random := NewRandom(&caseTwo.Config, nil, caseTwo.ProxyFunc).(*Random)
+	defer random.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	random.isHostAlive = func(string) types.HealthState { return types.Down }
+	random.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	down := <-ch
+	assert.Equal(t, events.BackendDown, down.Type)
+	assert.Equal(t, "localhost:8080", down.Backend)
+	assert.Equal(t, events.AllBackendsDown, (<-ch).Type)
+
+	random.isHostAlive = func(string) types.HealthState { return types.Alive }
+	random.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 1)
+	rejoin := <-ch
+	assert.Equal(t, events.BackendRejoin, rejoin.Type)
+	assert.Equal(t, "localhost:8080", rejoin.Backend)
+}
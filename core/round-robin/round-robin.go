@@ -1,12 +1,14 @@
 package round_robin
 
 import (
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	types "github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/helper"
@@ -16,9 +18,9 @@ import (
 )
 
 type serverMap struct {
-	proxy       proxy.IProxyClient
-	isHostAlive atomic.Bool
-	statsIdx    int
+	proxy    proxy.IProxyClient
+	health   types.Health
+	statsIdx int
 }
 
 type RoundRobin struct {
@@ -29,11 +31,21 @@ type RoundRobin struct {
 	healthCheckerDone chan struct{}
 	stopOnce          sync.Once
 	servers           atomic.Pointer[[]proxy.IProxyClient]
+	degraded          proxy.Degraded
 	i                 uint64
 	healthCheckerTime time.Duration
+	retryPolicy       *proxy.RetryPolicy
+	// pool names the pool the Backends belong to in the events published
+	// about them; empty for the Backends under backends.
+	pool string
 }
 
 func NewRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor, proxyFunc proxy.ProxyFunc) types.IBalancer {
+	// PrepareConfig rejects an empty Backend list; there is nothing to balance.
+	if len(cfg.Backends) == 0 {
+		return nil
+	}
+
 	roundRobin := &RoundRobin{
 		serversMap:        make(map[uint32]*serverMap),
 		isHostAlive:       cfg.HealthCheckerFunc,
@@ -41,25 +53,30 @@ func NewRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor,
 		hashFunc:          cfg.HashFunc,
 		stopHealthChecker: make(chan struct{}),
 		healthCheckerDone: make(chan struct{}),
+		retryPolicy:       proxy.NewRetryPolicy(cfg.Retry, cfg.Hedge, cfg.Queue),
+		pool:              cfg.Pool,
 	}
 
 	servers := make([]proxy.IProxyClient, 0, len(cfg.Backends))
+	var degraded proxy.DegradedSet
 	for i, b := range cfg.Backends {
 		proxyClient := proxyFunc(&b, cfg.CustomHeaders, middlewareExecutor)
-		isHostAlive := roundRobin.isHostAlive(b.GetHealthCheckURL())
-		if isHostAlive {
+		state := roundRobin.isHostAlive(b.GetHealthCheckURL())
+		degraded = degraded.Apply(proxyClient, types.Down, state, b.HealthCheck.DegradedWeight, cfg.Pool, b.Url)
+		if state.InRotation() {
 			servers = append(servers, proxyClient)
 			zap.S().Infof("Server add for load balancing successfully Addr: %s", b.Url)
 		} else {
 			zap.S().Warnf("Server is not live, it will be added for load balancing when its health check succeeds, Addr: %s", b.Url)
 		}
 		backendState := &serverMap{proxy: proxyClient, statsIdx: len(roundRobin.serversMap)}
-		backendState.isHostAlive.Store(isHostAlive)
+		backendState.health.Store(state)
 		roundRobin.serversMap[roundRobin.hashFunc(helper.S2B(b.Url+strconv.Itoa(i)))] = backendState
 	}
+	roundRobin.degraded.Store(degraded)
 
 	if len(servers) == 0 {
-		return nil
+		zap.S().Warn("No backend is alive yet, serving 503 until a backend rejoins")
 	}
 	roundRobin.servers.Store(&servers)
 
@@ -70,22 +87,31 @@ func NewRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor,
 
 func (r *RoundRobin) Serve() func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
-		proxyClient := r.next()
-		if proxyClient == nil {
-			proxy.NoAliveBackends(ctx)
-			return
-		}
-		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		r.retryPolicy.Serve(ctx, r.next)
 	}
 }
 
-func (r *RoundRobin) next() proxy.IProxyClient {
+func (r *RoundRobin) next(tried []proxy.IProxyClient) proxy.IProxyClient {
 	servers := *r.servers.Load()
 	if len(servers) == 0 {
 		return nil
 	}
+	degraded := r.degraded.Load()
 	v := atomic.AddUint64(&r.i, 1)
-	return servers[v%uint64(len(servers))]
+	// A retry walks on from its rotation slot to the first Backend it has
+	// not tried; a fresh request takes the slot itself. A Degraded Backend
+	// that turns the request down hands it to a healthy Backend at random.
+	for i := range uint64(len(servers)) {
+		server := servers[(v+i)%uint64(len(servers))]
+		if slices.Contains(tried, server) {
+			continue
+		}
+		if degraded.Admit(server) {
+			return server
+		}
+		return degraded.Repick(server, servers, tried)
+	}
+	return nil
 }
 
 func (r *RoundRobin) healthChecker(backends []config.Backend) {
@@ -109,40 +135,54 @@ func (r *RoundRobin) healthChecker(backends []config.Backend) {
 }
 
 func (r *RoundRobin) healthCheck(backend *config.Backend, index int) {
-	status := r.isHostAlive(backend.GetHealthCheckURL())
+	state := r.isHostAlive(backend.GetHealthCheckURL())
 	backendHash := r.hashFunc(helper.S2B(backend.Url + strconv.Itoa(index)))
 	proxyMap, ok := r.serversMap[backendHash]
-	if ok && (!status && proxyMap.isHostAlive.Load()) {
+	if !ok {
+		return
+	}
+
+	prev := proxyMap.health.Load()
+	degraded := r.degraded.Load().Apply(proxyMap.proxy, prev, state, backend.HealthCheck.DegradedWeight, r.pool, backend.Url)
+	r.degraded.Store(degraded)
+
+	if !state.InRotation() && prev.InRotation() {
 		newServers := helper.RemoveByValue(*r.servers.Load(), proxyMap.proxy)
 		r.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(false)
 
 		zap.S().Infof("Server is down, removing from load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendDown, Pool: r.pool, Backend: backend.Url})
 		if len(newServers) == 0 {
 			zap.S().Warn("All backends are down, serving 503 until a backend rejoins")
+			events.Publish(events.Event{Type: events.AllBackendsDown, Pool: r.pool})
 		}
-	} else if ok && (status && !proxyMap.isHostAlive.Load()) {
+	} else if state.InRotation() && !prev.InRotation() {
 		oldServers := *r.servers.Load()
 		newServers := make([]proxy.IProxyClient, 0, len(oldServers)+1)
 		newServers = append(newServers, oldServers...)
 		newServers = append(newServers, proxyMap.proxy)
 		r.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(true)
 		zap.S().Infof("Server is live again, adding back to load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendRejoin, Pool: r.pool, Backend: backend.Url})
 	}
+
+	proxyMap.health.Store(state)
 }
 
 func (r *RoundRobin) Stats() []types.ProxyStat {
 	stats := make([]types.ProxyStat, len(r.serversMap))
 	for hash, p := range r.serversMap {
 		s := p.proxy.Stat()
+		health := p.health.Load()
 		stats[p.statsIdx] = types.ProxyStat{
 			Addr:          s.Addr,
 			TotalReqCount: s.TotalReqCount,
 			AvgResTime:    s.AvgResTime,
 			LastUseTime:   s.LastUseTime,
 			ConnsCount:    s.ConnsCount,
-			IsHostAlive:   p.isHostAlive.Load(),
+			OpenTunnels:   s.OpenTunnels,
+			IsHostAlive:   health.InRotation(),
+			Health:        health.String(),
 			BackendHash:   hash,
 		}
 	}
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
@@ -17,12 +19,13 @@ import (
 
 func TestNewRoundRobin(t *testing.T) {
 	for _, r := range mocks.TestCases {
-		if r.ExpectedServerCount == 0 {
+		if len(r.Config.Backends) == 0 {
 			round := NewRoundRobin(&r.Config, nil, r.ProxyFunc)
 			assert.Nil(t, round)
 		} else {
 			round := NewRoundRobin(&r.Config, nil, r.ProxyFunc).(*RoundRobin)
-			assert.Equal(t, r.ExpectedServerCount, len(round.serversMap))
+			assert.Equal(t, len(r.Config.Backends), len(round.serversMap))
+			assert.Equal(t, r.ExpectedServerCount, len(*round.servers.Load()))
 		}
 	}
 }
@@ -33,7 +36,7 @@ func TestNext(t *testing.T) {
 	assert.NotNil(t, balancer)
 
 	roundRobin := balancer.(*RoundRobin)
-	proxy := roundRobin.next()
+	proxy := roundRobin.next(nil)
 
 	assert.IsType(t, &mocks.MockProxy{}, proxy)
 }
@@ -49,7 +52,7 @@ func TestServer(t *testing.T) {
 	ctx := fasthttp.RequestCtx{
 		Request: *fasthttp.AcquireRequest(),
 	}
-	proxy := roundRobin.next().(*mocks.MockProxy)
+	proxy := roundRobin.next(nil).(*mocks.MockProxy)
 	assert.False(t, proxy.IsCalled, "expected Server func not be called, but it was called")
 	handlerFunc(&ctx)
 	assert.True(t, proxy.IsCalled, "expected Server func to be called, but it wasn't")
@@ -67,7 +70,7 @@ func TestStats(t *testing.T) {
 		hash := roundRobin.hashFunc([]byte(backend.Url + strconv.Itoa(i)))
 		s := roundRobin.serversMap[hash]
 
-		assert.Equal(t, s.isHostAlive.Load(), stats[i].IsHostAlive)
+		assert.Equal(t, s.health.Load().InRotation(), stats[i].IsHostAlive)
 		assert.Equal(t, hash, stats[i].BackendHash)
 	}
 }
@@ -81,9 +84,9 @@ func TestHealthChecker(t *testing.T) {
 	}
 
 	var stopOnce sync.Once
-	roundRobin.isHostAlive = func(s string) bool {
+	roundRobin.isHostAlive = func(s string) types.HealthState {
 		stopOnce.Do(func() { close(roundRobin.stopHealthChecker) })
-		return false
+		return types.Down
 	}
 	roundRobin.hashFunc = func(b []byte) uint32 {
 		return 0
@@ -101,13 +104,13 @@ func TestRemoveOneServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := roundRobin.serversMap[roundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		roundRobin.isHostAlive = func(s string) bool {
-			return false
+		roundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*roundRobin.servers.Load())
 		roundRobin.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*roundRobin.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 }
@@ -120,27 +123,27 @@ func TestRemoveAndAddServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := roundRobin.serversMap[roundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		roundRobin.isHostAlive = func(s string) bool {
-			return false
+		roundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*roundRobin.servers.Load())
 		roundRobin.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*roundRobin.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 
 	// Add one server
 	if b, ok := roundRobin.serversMap[roundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		b.isHostAlive.Store(false)
-		roundRobin.isHostAlive = func(s string) bool {
-			return true
+		b.health.Store(types.Down)
+		roundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Alive
 		}
 
 		oldServerCount := len(*roundRobin.servers.Load())
 		roundRobin.healthCheck(&backend, 0)
 
-		assert.True(t, b.isHostAlive.Load(), "expected isHostAlive equal to true, but got %v", b.isHostAlive.Load())
+		assert.True(t, b.health.Load().InRotation(), "expected isHostAlive equal to true, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, len(*roundRobin.servers.Load()), oldServerCount, "expected server to be added after health check, but it did not.")
 
 	}
@@ -153,8 +156,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	roundRobin := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
 	assert.Equal(t, caseOne.ExpectedServerCount, len(roundRobin.serversMap))
 
-	roundRobin.isHostAlive = func(s string) bool {
-		return false
+	roundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Down
 	}
 	for i, backend := range caseOne.Config.Backends {
 		assert.NotPanics(t, func() {
@@ -171,8 +174,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
 
 	// Rejoin after the total outage.
-	roundRobin.isHostAlive = func(s string) bool {
-		return true
+	roundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
 	}
 	roundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
 	assert.Len(t, *roundRobin.servers.Load(), 1)
@@ -184,6 +187,35 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	// SPEC (1.0): no Backend passing its first Probe must not stop divisor
+	// from starting; requests get 503 until a Probe lets a backend Rejoin.
+	allDown := mocks.TestCases[2]
+	roundRobin := NewRoundRobin(&allDown.Config, nil, allDown.ProxyFunc).(*RoundRobin)
+	assert.Equal(t, len(allDown.Config.Backends), len(roundRobin.serversMap))
+	assert.Empty(t, *roundRobin.servers.Load())
+
+	handler := roundRobin.Serve()
+	ctx := fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	// The first successful Probe lets a Backend Rejoin.
+	roundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
+	}
+	roundRobin.healthCheck(&allDown.Config.Backends[0], 0)
+	assert.Len(t, *roundRobin.servers.Load(), 1)
+
+	ctx = fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+}
+
 func TestShutdown(t *testing.T) {
 	t.Run("shutdown calls close on all proxies", func(t *testing.T) {
 		caseOne := mocks.TestCases[0]
@@ -276,8 +308,8 @@ func TestStatsWhenBackendDownAtStartup(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -302,8 +334,8 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -313,12 +345,12 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 	roundRobin := NewRoundRobin(&cfg, nil, mocks.CreateNewMockProxy).(*RoundRobin)
 	assert.Len(t, *roundRobin.servers.Load(), 1)
 
-	roundRobin.isHostAlive = func(string) bool { return true }
+	roundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
 	roundRobin.healthCheck(&cfg.Backends[0], 0)
 
 	assert.Len(t, *roundRobin.servers.Load(), 2)
 	sm := roundRobin.serversMap[roundRobin.hashFunc([]byte("localhost:8080"+"0"))]
-	assert.True(t, sm.isHostAlive.Load())
+	assert.True(t, sm.health.Load().InRotation())
 }
 
 func TestNextConcurrentWithHealthCheck(t *testing.T) {
@@ -329,14 +361,14 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 	var alive atomic.Bool
 	roundRobin := &RoundRobin{
 		hashFunc:    hashFunc,
-		isHostAlive: func(string) bool { return alive.Load() },
+		isHostAlive: func(string) types.HealthState { return mocks.AliveIf(alive.Load()) },
 		serversMap: map[uint32]*serverMap{
 			hashFunc([]byte("localhost:8080" + "0")): {proxy: p1, statsIdx: 0},
 			hashFunc([]byte("localhost:80" + "1")):   {proxy: p2, statsIdx: 1},
 		},
 	}
 	for _, sm := range roundRobin.serversMap {
-		sm.isHostAlive.Store(true)
+		sm.health.Store(types.Alive)
 	}
 	servers := []proxy.IProxyClient{p1, p2}
 	roundRobin.servers.Store(&servers)
@@ -358,7 +390,7 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 		case <-done:
 			return
 		default:
-			roundRobin.next()
+			roundRobin.next(nil)
 		}
 	}
 }
@@ -368,7 +400,7 @@ func BenchmarkNext(b *testing.B) {
 	roundRobin := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			roundRobin.next()
+			roundRobin.next(nil)
 		}
 	})
 }
@@ -378,9 +410,9 @@ func TestShutdownStopsHealthChecker(t *testing.T) {
 	caseOne.Config.HealthCheckerTime = 5 * time.Millisecond
 
 	var checks atomic.Int64
-	caseOne.Config.HealthCheckerFunc = func(string) bool {
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState {
 		checks.Add(1)
-		return true
+		return types.Alive
 	}
 
 	roundRobin := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
@@ -402,7 +434,7 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 	caseOne := mocks.TestCases[0]
 	var alive atomic.Bool
 	alive.Store(true)
-	caseOne.Config.HealthCheckerFunc = func(string) bool { return alive.Load() }
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState { return mocks.AliveIf(alive.Load()) }
 	balancer := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
 	defer balancer.Shutdown() //nolint:errcheck
 
@@ -427,3 +459,112 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestNextSkipsTriedBackends(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	roundRobin := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
+	defer roundRobin.Shutdown() //nolint:errcheck
+
+	for range 10 {
+		first := roundRobin.next(nil)
+		second := roundRobin.next([]proxy.IProxyClient{first})
+		assert.NotNil(t, second)
+		assert.NotSame(t, first, second, "a retry must not get the Backend it already tried")
+		assert.Nil(t, roundRobin.next([]proxy.IProxyClient{first, second}), "every Backend tried")
+	}
+}
+
+func TestDegradedBackend(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	degradedURL := caseOne.Config.Backends[0].GetHealthCheckURL()
+	caseOne.Config.HealthCheckerFunc = func(url string) types.HealthState {
+		if url == degradedURL {
+			return types.Degraded
+		}
+		return types.Alive
+	}
+	roundRobin := NewRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*RoundRobin)
+	defer roundRobin.Shutdown() //nolint:errcheck
+
+	sm := roundRobin.serversMap[roundRobin.hashFunc([]byte(caseOne.Config.Backends[0].Url+"0"))]
+	degradedProxy := sm.proxy
+	assert.Len(t, *roundRobin.servers.Load(), 2, "a Degraded Backend stays in rotation")
+
+	// degraded_weight 0 (below what PrepareConfig allows) turns every
+	// request down, so the Degraded Backend only serves as a last resort.
+	var other proxy.IProxyClient
+	for range 50 {
+		other = roundRobin.next(nil)
+		assert.NotSame(t, degradedProxy, other)
+	}
+	assert.Same(t, degradedProxy, roundRobin.next([]proxy.IProxyClient{other}))
+
+	stats := roundRobin.Stats()
+	assert.Equal(t, "degraded", stats[0].Health)
+	assert.True(t, stats[0].IsHostAlive)
+
+	t.Run("recovers to Alive", func(t *testing.T) {
+		roundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
+		roundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Empty(t, roundRobin.degraded.Load())
+		assert.Equal(t, types.Alive, sm.health.Load())
+	})
+
+	t.Run("goes Down and comes back Degraded", func(t *testing.T) {
+		roundRobin.isHostAlive = func(string) types.HealthState { return types.Down }
+		roundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *roundRobin.servers.Load(), 1)
+
+		roundRobin.isHostAlive = func(string) types.HealthState { return types.Degraded }
+		roundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *roundRobin.servers.Load(), 2)
+		assert.Contains(t, roundRobin.degraded.Load(), degradedProxy)
+		assert.Equal(t, types.Degraded, sm.health.Load())
+	})
+}
+
+func TestHealthCheckPublishesEvents(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	roundRobin := NewRoundRobin(&caseTwo.Config, nil, caseTwo.ProxyFunc).(*RoundRobin)
+	defer roundRobin.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	roundRobin.isHostAlive = func(string) types.HealthState { return types.Down }
+	roundRobin.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	down := <-ch
+	assert.Equal(t, events.BackendDown, down.Type)
+	assert.Equal(t, "localhost:8080", down.Backend)
+	assert.Equal(t, events.AllBackendsDown, (<-ch).Type)
+
+	roundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
+	roundRobin.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 1)
+	rejoin := <-ch
+	assert.Equal(t, events.BackendRejoin, rejoin.Type)
+	assert.Equal(t, "localhost:8080", rejoin.Backend)
+}
+
+func TestHealthCheckPublishesPool(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	pool := caseTwo.Config.ForBackends("canary", caseTwo.Config.Backends)
+	roundRobin := NewRoundRobin(pool, nil, caseTwo.ProxyFunc).(*RoundRobin)
+	defer roundRobin.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	// A split or mirror pool going down says which pool it is.
+	roundRobin.isHostAlive = func(string) types.HealthState { return types.Down }
+	roundRobin.healthCheck(&pool.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	assert.Equal(t, events.Event{Type: events.BackendDown, Pool: "canary", Backend: "localhost:8080"}, withoutTime(<-ch))
+	assert.Equal(t, events.Event{Type: events.AllBackendsDown, Pool: "canary"}, withoutTime(<-ch))
+}
+
+func withoutTime(e events.Event) events.Event {
+	e.Time = time.Time{}
+	return e
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -11,7 +12,48 @@ import (
 // budget or deadlock a balancer whose checker never started.
 const HealthCheckerStopTimeout = 5 * time.Second
 
-type IsHostAlive func(string) bool
+// HealthState is what a Probe reports about a Backend. The zero value is
+// Down, so a Backend nobody has probed yet never receives traffic.
+type HealthState int32
+
+const (
+	Down HealthState = iota
+	Alive
+	// Degraded Backends stay in rotation at a reduced share, see
+	// health_check.degraded_weight.
+	Degraded
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Degraded:
+		return "degraded"
+	}
+	return "down"
+}
+
+// InRotation reports whether a Backend in this state receives traffic.
+func (s HealthState) InRotation() bool {
+	return s != Down
+}
+
+// Health holds a Backend's HealthState; written by the health checker and
+// read from Stats without locking.
+type Health struct {
+	v atomic.Int32
+}
+
+func (h *Health) Load() HealthState {
+	return HealthState(h.v.Load())
+}
+
+func (h *Health) Store(s HealthState) {
+	h.v.Store(int32(s))
+}
+
+type IsHostAlive func(string) HealthState
 
 type HashFunc func([]byte) uint32
 
@@ -27,6 +69,8 @@ type ProxyStat struct {
 	AvgResTime    float64   `json:"avg_res_time"`
 	LastUseTime   time.Time `json:"last_use_time"`
 	ConnsCount    int       `json:"conns_count"`
+	OpenTunnels   int64     `json:"open_tunnels"`
 	IsHostAlive   bool      `json:"is_host_alive"`
+	Health        string    `json:"health"`
 	BackendHash   uint32    `json:"backend_hash"`
 }
@@ -2,12 +2,14 @@ package w_round_robin
 
 import (
 	"math/rand"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	types "github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/helper"
@@ -17,10 +19,10 @@ import (
 )
 
 type serverMap struct {
-	proxy       proxy.IProxyClient
-	weight      uint
-	isHostAlive atomic.Bool
-	statsIdx    int
+	proxy    proxy.IProxyClient
+	weight   uint
+	health   types.Health
+	statsIdx int
 }
 
 type WRoundRobin struct {
@@ -31,11 +33,21 @@ type WRoundRobin struct {
 	healthCheckerDone chan struct{}
 	stopOnce          sync.Once
 	servers           atomic.Pointer[[]proxy.IProxyClient]
+	degraded          proxy.Degraded
 	i                 uint64
 	healthCheckerTime time.Duration
+	retryPolicy       *proxy.RetryPolicy
+	// pool names the pool the Backends belong to in the events published
+	// about them; empty for the Backends under backends.
+	pool string
 }
 
 func NewWRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor, proxyFunc proxy.ProxyFunc) types.IBalancer {
+	// PrepareConfig rejects an empty Backend list; there is nothing to balance.
+	if len(cfg.Backends) == 0 {
+		return nil
+	}
+
 	wRoundRobin := &WRoundRobin{
 		isHostAlive:       cfg.HealthCheckerFunc,
 		healthCheckerTime: cfg.HealthCheckerTime,
@@ -43,13 +55,17 @@ func NewWRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor,
 		hashFunc:          cfg.HashFunc,
 		stopHealthChecker: make(chan struct{}),
 		healthCheckerDone: make(chan struct{}),
+		retryPolicy:       proxy.NewRetryPolicy(cfg.Retry, cfg.Hedge, cfg.Queue),
+		pool:              cfg.Pool,
 	}
 
 	servers := make([]proxy.IProxyClient, 0)
+	var degraded proxy.DegradedSet
 	for i, b := range cfg.Backends {
 		proxyClient := proxyFunc(&b, cfg.CustomHeaders, middlewareExecutor)
-		isHostAlive := wRoundRobin.isHostAlive(b.GetHealthCheckURL())
-		if isHostAlive {
+		state := wRoundRobin.isHostAlive(b.GetHealthCheckURL())
+		degraded = degraded.Apply(proxyClient, types.Down, state, b.HealthCheck.DegradedWeight, cfg.Pool, b.Url)
+		if state.InRotation() {
 			for range int(b.Weight) {
 				servers = append(servers, proxyClient)
 			}
@@ -59,12 +75,13 @@ func NewWRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor,
 		}
 
 		backendState := &serverMap{proxy: proxyClient, weight: b.Weight, statsIdx: len(wRoundRobin.serversMap)}
-		backendState.isHostAlive.Store(isHostAlive)
+		backendState.health.Store(state)
 		wRoundRobin.serversMap[wRoundRobin.hashFunc(helper.S2B(b.Url+strconv.Itoa(i)))] = backendState
 	}
+	wRoundRobin.degraded.Store(degraded)
 
 	if len(servers) == 0 {
-		return nil
+		zap.S().Warn("No backend is alive yet, serving 503 until a backend rejoins")
 	}
 
 	rand.New(rand.NewSource(time.Now().UnixNano())).Shuffle(len(servers), func(i, j int) { //nolint:gosec
@@ -79,22 +96,32 @@ func NewWRoundRobin(cfg *config.Config, middlewareExecutor *middleware.Executor,
 
 func (w *WRoundRobin) Serve() func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
-		proxyClient := w.next()
-		if proxyClient == nil {
-			proxy.NoAliveBackends(ctx)
-			return
-		}
-		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		w.retryPolicy.Serve(ctx, w.next)
 	}
 }
 
-func (w *WRoundRobin) next() proxy.IProxyClient {
+func (w *WRoundRobin) next(tried []proxy.IProxyClient) proxy.IProxyClient {
 	servers := *w.servers.Load()
 	if len(servers) == 0 {
 		return nil
 	}
+	degraded := w.degraded.Load()
 	v := atomic.AddUint64(&w.i, 1)
-	return servers[v%uint64(len(servers))]
+	// A Backend fills one slot per weight unit, so a retry may walk past
+	// several slots of the Backend it just tried. A Degraded Backend keeps
+	// its slots but turns down part of the requests landing on them, which
+	// go to a healthy Backend at random, by weight.
+	for i := range uint64(len(servers)) {
+		server := servers[(v+i)%uint64(len(servers))]
+		if slices.Contains(tried, server) {
+			continue
+		}
+		if degraded.Admit(server) {
+			return server
+		}
+		return degraded.Repick(server, servers, tried)
+	}
+	return nil
 }
 
 func (w *WRoundRobin) healthChecker(backends []config.Backend) {
@@ -118,20 +145,28 @@ func (w *WRoundRobin) healthChecker(backends []config.Backend) {
 }
 
 func (w *WRoundRobin) healthCheck(backend *config.Backend, index int) {
-	status := w.isHostAlive(backend.GetHealthCheckURL())
+	state := w.isHostAlive(backend.GetHealthCheckURL())
 	backendHash := w.hashFunc(helper.S2B(backend.Url + strconv.Itoa(index)))
 	proxyMap, ok := w.serversMap[backendHash]
+	if !ok {
+		return
+	}
+
+	prev := proxyMap.health.Load()
+	degraded := w.degraded.Load().Apply(proxyMap.proxy, prev, state, backend.HealthCheck.DegradedWeight, w.pool, backend.Url)
+	w.degraded.Store(degraded)
 
-	if ok && (!status && proxyMap.isHostAlive.Load()) {
+	if !state.InRotation() && prev.InRotation() {
 		newServers := helper.RemoveByValue(*w.servers.Load(), proxyMap.proxy)
 		w.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(false)
 
 		zap.S().Infof("Server is down, removing from load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendDown, Pool: w.pool, Backend: backend.Url})
 		if len(newServers) == 0 {
 			zap.S().Warn("All backends are down, serving 503 until a backend rejoins")
+			events.Publish(events.Event{Type: events.AllBackendsDown, Pool: w.pool})
 		}
-	} else if ok && (status && !proxyMap.isHostAlive.Load()) {
+	} else if state.InRotation() && !prev.InRotation() {
 		oldServers := *w.servers.Load()
 		newServers := make([]proxy.IProxyClient, 0, len(oldServers)+int(proxyMap.weight))
 		newServers = append(newServers, oldServers...)
@@ -144,22 +179,27 @@ func (w *WRoundRobin) healthCheck(backend *config.Backend, index int) {
 		})
 
 		w.servers.Store(&newServers)
-		proxyMap.isHostAlive.Store(true)
 		zap.S().Infof("Server is live again, adding back to load balancer, Addr: %s", backend.Url)
+		events.Publish(events.Event{Type: events.BackendRejoin, Pool: w.pool, Backend: backend.Url})
 	}
+
+	proxyMap.health.Store(state)
 }
 
 func (w *WRoundRobin) Stats() []types.ProxyStat {
 	stats := make([]types.ProxyStat, len(w.serversMap))
 	for hash, p := range w.serversMap {
 		s := p.proxy.Stat()
+		health := p.health.Load()
 		stats[p.statsIdx] = types.ProxyStat{
 			Addr:          s.Addr,
 			TotalReqCount: s.TotalReqCount,
 			AvgResTime:    s.AvgResTime,
 			LastUseTime:   s.LastUseTime,
 			ConnsCount:    s.ConnsCount,
-			IsHostAlive:   p.isHostAlive.Load(),
+			OpenTunnels:   s.OpenTunnels,
+			IsHostAlive:   health.InRotation(),
+			Health:        health.String(),
 			BackendHash:   hash,
 		}
 	}
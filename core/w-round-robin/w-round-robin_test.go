@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
@@ -17,12 +19,15 @@ import (
 
 func TestNewWRoundRobin(t *testing.T) {
 	for _, r := range mocks.TestCases {
-		if r.ExpectedServerCount == 0 {
+		if len(r.Config.Backends) == 0 {
 			wRoundRobin := NewWRoundRobin(&r.Config, nil, r.ProxyFunc)
 			assert.Nil(t, wRoundRobin)
 		} else {
 			wRoundRobin := NewWRoundRobin(&r.Config, nil, r.ProxyFunc).(*WRoundRobin)
-			assert.Equal(t, r.ExpectedServerCount, len(wRoundRobin.serversMap))
+			assert.Equal(t, len(r.Config.Backends), len(wRoundRobin.serversMap))
+			if r.ExpectedServerCount == 0 {
+				assert.Empty(t, *wRoundRobin.servers.Load())
+			}
 		}
 	}
 }
@@ -33,7 +38,7 @@ func TestNext(t *testing.T) {
 	assert.NotNil(t, balancer)
 
 	wRoundRobin := balancer.(*WRoundRobin)
-	proxy := wRoundRobin.next()
+	proxy := wRoundRobin.next(nil)
 
 	assert.IsType(t, &mocks.MockProxy{}, proxy)
 
@@ -53,7 +58,7 @@ func TestServer(t *testing.T) {
 		Request: *fasthttp.AcquireRequest(),
 	}
 
-	proxy := wRoundRobin.next().(*mocks.MockProxy)
+	proxy := wRoundRobin.next(nil).(*mocks.MockProxy)
 	assert.False(t, proxy.IsCalled, "expected Server func not be called, but it was called")
 	handlerFunc(&ctx)
 	assert.True(t, proxy.IsCalled, "expected Server func to be called, but it wasn't")
@@ -72,7 +77,7 @@ func TestStats(t *testing.T) {
 		s := wRoundRobin.serversMap[hash]
 		p := s.proxy.(*mocks.MockProxy)
 
-		assert.Equal(t, s.isHostAlive.Load(), stats[i].IsHostAlive)
+		assert.Equal(t, s.health.Load().InRotation(), stats[i].IsHostAlive)
 		assert.Equal(t, hash, stats[i].BackendHash)
 		assert.Equal(t, backend.Url, p.Addr)
 	}
@@ -87,9 +92,9 @@ func TestHealthChecker(t *testing.T) {
 	}
 
 	var stopOnce sync.Once
-	wRoundRobin.isHostAlive = func(s string) bool {
+	wRoundRobin.isHostAlive = func(s string) types.HealthState {
 		stopOnce.Do(func() { close(wRoundRobin.stopHealthChecker) })
-		return false
+		return types.Down
 	}
 	wRoundRobin.hashFunc = func(b []byte) uint32 {
 		return 0
@@ -107,13 +112,13 @@ func TestRemoveOneServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := wRoundRobin.serversMap[wRoundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		wRoundRobin.isHostAlive = func(s string) bool {
-			return false
+		wRoundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*wRoundRobin.servers.Load())
 		wRoundRobin.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*wRoundRobin.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 }
@@ -126,27 +131,27 @@ func TestRemoveAndAddServer(t *testing.T) {
 	// Remove one server
 	backend := caseOne.Config.Backends[0]
 	if b, ok := wRoundRobin.serversMap[wRoundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		wRoundRobin.isHostAlive = func(s string) bool {
-			return false
+		wRoundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Down
 		}
 		oldServerCount := len(*wRoundRobin.servers.Load())
 		wRoundRobin.healthCheck(&backend, 0)
 
-		assert.False(t, b.isHostAlive.Load(), "expected isHostAlive equal to false, but got %v", b.isHostAlive.Load())
+		assert.False(t, b.health.Load().InRotation(), "expected isHostAlive equal to false, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, oldServerCount, len(*wRoundRobin.servers.Load()), "expected server to be removed after health check, but it did not.")
 	}
 
 	// Add one server
 	if b, ok := wRoundRobin.serversMap[wRoundRobin.hashFunc([]byte(backend.Url+strconv.Itoa(0)))]; ok {
-		b.isHostAlive.Store(false)
-		wRoundRobin.isHostAlive = func(s string) bool {
-			return true
+		b.health.Store(types.Down)
+		wRoundRobin.isHostAlive = func(s string) types.HealthState {
+			return types.Alive
 		}
 
 		oldServerCount := len(*wRoundRobin.servers.Load())
 		wRoundRobin.healthCheck(&backend, 0)
 
-		assert.True(t, b.isHostAlive.Load(), "expected isHostAlive equal to true, but got %v", b.isHostAlive.Load())
+		assert.True(t, b.health.Load().InRotation(), "expected isHostAlive equal to true, but got %v", b.health.Load().InRotation())
 		assert.GreaterOrEqual(t, len(*wRoundRobin.servers.Load()), oldServerCount, "expected server to be added after health check, but it did not.")
 
 	}
@@ -159,8 +164,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	wRoundRobin := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
 	assert.Equal(t, caseOne.ExpectedServerCount, len(wRoundRobin.serversMap))
 
-	wRoundRobin.isHostAlive = func(s string) bool {
-		return false
+	wRoundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Down
 	}
 	for i, backend := range caseOne.Config.Backends {
 		assert.NotPanics(t, func() {
@@ -177,8 +182,8 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
 
 	// Rejoin after the total outage.
-	wRoundRobin.isHostAlive = func(s string) bool {
-		return true
+	wRoundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
 	}
 	wRoundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
 	assert.Len(t, *wRoundRobin.servers.Load(), int(caseOne.Config.Backends[0].Weight))
@@ -190,6 +195,35 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	// SPEC (1.0): no Backend passing its first Probe must not stop divisor
+	// from starting; requests get 503 until a Probe lets a backend Rejoin.
+	allDown := mocks.TestCases[2]
+	wRoundRobin := NewWRoundRobin(&allDown.Config, nil, allDown.ProxyFunc).(*WRoundRobin)
+	assert.Equal(t, len(allDown.Config.Backends), len(wRoundRobin.serversMap))
+	assert.Empty(t, *wRoundRobin.servers.Load())
+
+	handler := wRoundRobin.Serve()
+	ctx := fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	// The first successful Probe lets a Backend Rejoin.
+	wRoundRobin.isHostAlive = func(s string) types.HealthState {
+		return types.Alive
+	}
+	wRoundRobin.healthCheck(&allDown.Config.Backends[0], 0)
+	assert.Len(t, *wRoundRobin.servers.Load(), int(allDown.Config.Backends[0].Weight))
+
+	ctx = fasthttp.RequestCtx{
+		Request: *fasthttp.AcquireRequest(),
+	}
+	handler(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+}
+
 func TestShutdown(t *testing.T) {
 	t.Run("shutdown calls close on all proxies", func(t *testing.T) {
 		caseOne := mocks.TestCases[0]
@@ -253,8 +287,8 @@ func TestStatsWhenBackendDownAtStartup(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -279,8 +313,8 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 			{Url: "localhost:80", Weight: 1},
 		},
 		HealthCheckerTime: time.Second * 5,
-		HealthCheckerFunc: func(url string) bool {
-			return url != "http://localhost:8080"
+		HealthCheckerFunc: func(url string) types.HealthState {
+			return mocks.AliveIf(url != "http://localhost:8080")
 		},
 		HashFunc: func(b []byte) uint32 {
 			return uint32(len(b))
@@ -290,12 +324,12 @@ func TestBackendDownAtStartupCanRejoin(t *testing.T) {
 	wRoundRobin := NewWRoundRobin(&cfg, nil, mocks.CreateNewMockProxy).(*WRoundRobin)
 	assert.Len(t, *wRoundRobin.servers.Load(), 1)
 
-	wRoundRobin.isHostAlive = func(string) bool { return true }
+	wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
 	wRoundRobin.healthCheck(&cfg.Backends[0], 0)
 
 	assert.Len(t, *wRoundRobin.servers.Load(), 3)
 	sm := wRoundRobin.serversMap[wRoundRobin.hashFunc([]byte("localhost:8080"+"0"))]
-	assert.True(t, sm.isHostAlive.Load())
+	assert.True(t, sm.health.Load().InRotation())
 }
 
 func TestNextConcurrentWithHealthCheck(t *testing.T) {
@@ -306,14 +340,14 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 	var alive atomic.Bool
 	wRoundRobin := &WRoundRobin{
 		hashFunc:    hashFunc,
-		isHostAlive: func(string) bool { return alive.Load() },
+		isHostAlive: func(string) types.HealthState { return mocks.AliveIf(alive.Load()) },
 		serversMap: map[uint32]*serverMap{
 			hashFunc([]byte("localhost:8080" + "0")): {proxy: p1, weight: 1, statsIdx: 0},
 			hashFunc([]byte("localhost:80" + "1")):   {proxy: p2, weight: 1, statsIdx: 1},
 		},
 	}
 	for _, sm := range wRoundRobin.serversMap {
-		sm.isHostAlive.Store(true)
+		sm.health.Store(types.Alive)
 	}
 	servers := []proxy.IProxyClient{p1, p2}
 	wRoundRobin.servers.Store(&servers)
@@ -335,7 +369,7 @@ func TestNextConcurrentWithHealthCheck(t *testing.T) {
 		case <-done:
 			return
 		default:
-			wRoundRobin.next()
+			wRoundRobin.next(nil)
 		}
 	}
 }
@@ -345,7 +379,7 @@ func BenchmarkNext(b *testing.B) {
 	wRoundRobin := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			wRoundRobin.next()
+			wRoundRobin.next(nil)
 		}
 	})
 }
@@ -355,9 +389,9 @@ func TestShutdownStopsHealthChecker(t *testing.T) {
 	caseOne.Config.HealthCheckerTime = 5 * time.Millisecond
 
 	var checks atomic.Int64
-	caseOne.Config.HealthCheckerFunc = func(string) bool {
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState {
 		checks.Add(1)
-		return true
+		return types.Alive
 	}
 
 	wRoundRobin := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
@@ -379,7 +413,7 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 	caseOne := mocks.TestCases[0]
 	var alive atomic.Bool
 	alive.Store(true)
-	caseOne.Config.HealthCheckerFunc = func(string) bool { return alive.Load() }
+	caseOne.Config.HealthCheckerFunc = func(string) types.HealthState { return mocks.AliveIf(alive.Load()) }
 	balancer := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
 	defer balancer.Shutdown() //nolint:errcheck
 
@@ -404,3 +438,90 @@ func TestStatsConcurrentWithHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestNextSkipsTriedBackends(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	wRoundRobin := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
+	defer wRoundRobin.Shutdown() //nolint:errcheck
+
+	for range 10 {
+		first := wRoundRobin.next(nil)
+		second := wRoundRobin.next([]proxy.IProxyClient{first})
+		assert.NotNil(t, second)
+		assert.NotSame(t, first, second, "a retry must not get the Backend it already tried")
+		assert.Nil(t, wRoundRobin.next([]proxy.IProxyClient{first, second}), "every Backend tried")
+	}
+}
+
+func TestDegradedBackend(t *testing.T) {
+	caseOne := mocks.TestCases[0]
+	degradedURL := caseOne.Config.Backends[0].GetHealthCheckURL()
+	caseOne.Config.HealthCheckerFunc = func(url string) types.HealthState {
+		if url == degradedURL {
+			return types.Degraded
+		}
+		return types.Alive
+	}
+	wRoundRobin := NewWRoundRobin(&caseOne.Config, nil, caseOne.ProxyFunc).(*WRoundRobin)
+	defer wRoundRobin.Shutdown() //nolint:errcheck
+
+	sm := wRoundRobin.serversMap[wRoundRobin.hashFunc([]byte(caseOne.Config.Backends[0].Url+"0"))]
+	degradedProxy := sm.proxy
+	assert.Len(t, *wRoundRobin.servers.Load(), 2, "a Degraded Backend keeps its weight slots")
+
+	// degraded_weight 0 (below what PrepareConfig allows) turns every
+	// request down, so the Degraded Backend only serves as a last resort.
+	var other proxy.IProxyClient
+	for range 50 {
+		other = wRoundRobin.next(nil)
+		assert.NotSame(t, degradedProxy, other)
+	}
+	assert.Same(t, degradedProxy, wRoundRobin.next([]proxy.IProxyClient{other}))
+
+	stats := wRoundRobin.Stats()
+	assert.Equal(t, "degraded", stats[0].Health)
+	assert.True(t, stats[0].IsHostAlive)
+
+	t.Run("recovers to Alive", func(t *testing.T) {
+		wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
+		wRoundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Empty(t, wRoundRobin.degraded.Load())
+		assert.Equal(t, types.Alive, sm.health.Load())
+	})
+
+	t.Run("goes Down and comes back Degraded", func(t *testing.T) {
+		wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Down }
+		wRoundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *wRoundRobin.servers.Load(), 1)
+
+		wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Degraded }
+		wRoundRobin.healthCheck(&caseOne.Config.Backends[0], 0)
+		assert.Len(t, *wRoundRobin.servers.Load(), 2)
+		assert.Contains(t, wRoundRobin.degraded.Load(), degradedProxy)
+		assert.Equal(t, types.Degraded, sm.health.Load())
+	})
+}
+
+func TestHealthCheckPublishesEvents(t *testing.T) {
+	caseTwo := mocks.TestCases[1]
+	wRoundRobin := NewWRoundRobin(&caseTwo.Config, nil, caseTwo.ProxyFunc).(*WRoundRobin)
+	defer wRoundRobin.Shutdown() //nolint:errcheck
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Down }
+	wRoundRobin.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 2)
+	down := <-ch
+	assert.Equal(t, events.BackendDown, down.Type)
+	assert.Equal(t, "localhost:8080", down.Backend)
+	assert.Equal(t, events.AllBackendsDown, (<-ch).Type)
+
+	wRoundRobin.isHostAlive = func(string) types.HealthState { return types.Alive }
+	wRoundRobin.healthCheck(&caseTwo.Config.Backends[0], 0)
+	assert.Len(t, ch, 1)
+	rejoin := <-ch
+	assert.Equal(t, events.BackendRejoin, rejoin.Type)
+	assert.Equal(t, "localhost:8080", rejoin.Backend)
+}
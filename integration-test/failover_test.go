@@ -198,6 +198,50 @@ func TestAllBackendsDownStaysUp(t *testing.T) {
 	})
 }
 
+func TestZeroAliveBackendsAtBoot(t *testing.T) {
+	t.Parallel()
+	// SPEC (1.0): divisor must boot even when no Backend passes its first
+	// Probe (compose/k8s often start the LB first), serve 503 with the
+	// configured Retry-After, and let Backends Rejoin. Born red: every
+	// balancer constructor returned nil and main.go exited with "No
+	// available servers".
+	s := startScenario(t, ScenarioSpec{
+		Name:                 "fozero",
+		Type:                 "round-robin",
+		HealthCheckerTime:    time.Second,
+		NoBackendsRetryAfter: 5 * time.Second,
+		Backends: []BackendSpec{
+			{ID: "a", StartDown: true},
+			{ID: "b", StartDown: true},
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		res, err := s.Request(http.MethodGet, fmt.Sprintf("/zeroalive?n=%d", i), nil, nil)
+		if err != nil {
+			t.Fatalf("divisor stopped answering with no backend alive since boot: %v", err)
+		}
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("request %d with no backend alive since boot got %d, want 503", i, res.StatusCode)
+		}
+		if got := res.Header.Get("Retry-After"); got != "5" {
+			t.Errorf("request %d: Retry-After = %q, want %q", i, got, "5")
+		}
+	}
+
+	s.Backend("a").SetHealth(t, true)
+	eventually(t, 15*time.Second, "backend rejoined after booting with none alive", func() error {
+		res, err := s.Request(http.MethodGet, "/afterboot", nil, nil)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK || res.Echo == nil {
+			return fmt.Errorf("status %d", res.StatusCode)
+		}
+		return nil
+	})
+}
+
 func TestPausedBackendBoundedFailure(t *testing.T) {
 	t.Parallel()
 	// A paused container hangs instead of refusing connections: clients
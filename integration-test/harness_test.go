@@ -77,6 +77,20 @@ type ScenarioSpec struct {
 	WriteTimeout      time.Duration
 	ProxyTimeout       time.Duration
 	MaxRequestBodySize int
+	// NoBackendsRetryAfter is the Retry-After divisor sends with its
+	// no-backends 503.
+	NoBackendsRetryAfter time.Duration
+}
+
+// allStartDown reports whether no Backend passes divisor's first Probe, in
+// which case divisor boots answering 503 instead of proxying.
+func (spec ScenarioSpec) allStartDown() bool {
+	for _, b := range spec.Backends {
+		if !b.StartDown {
+			return false
+		}
+	}
+	return len(spec.Backends) > 0
 }
 
 type Echo struct {
@@ -228,6 +242,12 @@ func startScenario(t *testing.T, spec ScenarioSpec) *Scenario {
 		if err != nil {
 			return err
 		}
+		if spec.allStartDown() {
+			if result.StatusCode != http.StatusServiceUnavailable {
+				return fmt.Errorf("divisor not answering yet: status %d", result.StatusCode)
+			}
+			return nil
+		}
 		if result.StatusCode != http.StatusOK || result.Header.Get("X-Backend-Id") == "" {
 			return fmt.Errorf("divisor not proxying yet: status %d", result.StatusCode)
 		}
@@ -362,6 +382,9 @@ func renderConfig(t *testing.T, s *Scenario) (string, string) {
 	if s.Spec.MaxRequestBodySize > 0 {
 		server["max_request_body_size"] = s.Spec.MaxRequestBodySize
 	}
+	if s.Spec.NoBackendsRetryAfter > 0 {
+		server["no_backends_retry_after"] = s.Spec.NoBackendsRetryAfter.String()
+	}
 
 	cfg := map[string]any{
 		"host":                "0.0.0.0",
@@ -259,9 +259,10 @@ func TestProxyMatrix(t *testing.T) {
 	})
 
 	t.Run("XForwardedFor", func(t *testing.T) {
-		// Pinned current behavior: divisor OVERWRITES client-supplied
-		// X-Forwarded-For with the direct peer IP (anti-spoofing for an
-		// edge balancer). Revisit for 1.0 if append semantics are wanted.
+		// With no forwarded_headers.trusted_proxies configured divisor is
+		// the edge: it OVERWRITES client-supplied X-Forwarded-For with the
+		// direct peer IP (anti-spoofing). Only a trusted proxy's list is
+		// appended to; see docs/adr/0007-forwarded-headers.md.
 		hdr := http.Header{"X-Forwarded-For": []string{"1.2.3.4"}}
 		res := s.MustEcho(t, http.MethodGet, "/xff", nil, hdr)
 		xff := res.Echo.Header("X-Forwarded-For")
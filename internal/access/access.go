@@ -0,0 +1,140 @@
+// Package access turns requests away by client IP, against allow and deny
+// lists that can be reloaded from their files at runtime.
+package access
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
+	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// GlobalScope names the top-level lists in Counters.
+const GlobalScope = "global"
+
+// Counters is what /stats reports about one scope, the top-level lists or a
+// path prefix's: how many ranges each list holds, and the requests denied.
+type Counters struct {
+	Scope  string `json:"scope"`
+	Allow  int    `json:"allow"`
+	Deny   int    `json:"deny"`
+	Denied uint64 `json:"denied"`
+}
+
+// Access is a balancer that answers a request whose client IP any scope
+// covering it denies, and hands the rest to the balancer it wraps.
+type Access struct {
+	types.IBalancer
+	scopes   []*scope
+	clientIP config.ClientIP
+	status   int
+}
+
+type scope struct {
+	name   string
+	prefix string
+	list   config.AccessList
+	lists  atomic.Pointer[lists]
+	denied atomic.Uint64
+}
+
+type lists struct {
+	allow, deny *prefixTree
+}
+
+// New loads cfg's lists, files included, in front of balancer.
+func New(cfg config.AccessControl, clientIP config.ClientIP, balancer types.IBalancer) (*Access, error) {
+	a := &Access{IBalancer: balancer, clientIP: clientIP, status: cfg.DenyStatus}
+	a.scopes = append(a.scopes, &scope{name: GlobalScope, list: cfg.AccessList})
+	for _, path := range cfg.Paths {
+		a.scopes = append(a.scopes, &scope{name: path.Prefix, prefix: path.Prefix, list: path.AccessList})
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Access) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := a.IBalancer.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		addr, _ := netip.AddrFromSlice(proxy.ClientIP(ctx, &a.clientIP))
+		addr = addr.Unmap()
+		for _, s := range a.scopes {
+			if !strings.HasPrefix(helper.B2S(ctx.Path()), s.prefix) {
+				continue
+			}
+			if !s.lists.Load().admits(addr) {
+				s.denied.Add(1)
+				a.deny(ctx)
+				return
+			}
+		}
+		next(ctx)
+	}
+}
+
+// Reload reads every list's files again, and publishes ConfigReloaded once
+// they are in use. Should any fail to load, every scope keeps the lists it
+// had.
+func (a *Access) Reload() error {
+	if err := a.load(); err != nil {
+		return err
+	}
+	events.Publish(events.Event{Type: events.ConfigReloaded})
+	return nil
+}
+
+func (a *Access) load() error {
+	loaded := make([]*lists, 0, len(a.scopes))
+	for _, s := range a.scopes {
+		allow, deny, err := s.list.Load()
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, &lists{allow: newPrefixTree(allow), deny: newPrefixTree(deny)})
+	}
+	for i, s := range a.scopes {
+		s.lists.Store(loaded[i])
+	}
+	return nil
+}
+
+func (a *Access) Counters() []Counters {
+	counters := make([]Counters, 0, len(a.scopes))
+	for _, s := range a.scopes {
+		l := s.lists.Load()
+		counters = append(counters, Counters{
+			Scope:  s.name,
+			Allow:  l.allow.size,
+			Deny:   l.deny.size,
+			Denied: s.denied.Load(),
+		})
+	}
+	return counters
+}
+
+// admits lets addr through unless a deny range holds it, or allow ranges
+// exist and none holds it. An address that could not be resolved is only
+// let through when there are no allow ranges.
+func (l *lists) admits(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return l.allow.size == 0
+	}
+	if l.deny.contains(addr) {
+		return false
+	}
+	return l.allow.size == 0 || l.allow.contains(addr)
+}
+
+func (a *Access) deny(ctx *fasthttp.RequestCtx) {
+	ctx.Response.SetStatusCode(a.status)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.Response.SetBodyString(`{"message":"access denied"}`)
+}
@@ -0,0 +1,91 @@
+package access
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type stubBalancer struct{}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusOK) }
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return nil }
+func (s *stubBalancer) Shutdown() error          { return nil }
+
+func newTestAccess(t *testing.T, cfg config.AccessControl) *Access {
+	t.Helper()
+	c := config.Config{Type: "round-robin", Port: "8000", Backends: []config.Backend{{Url: "localhost:8080"}}, AccessControl: cfg}
+	assert.NoError(t, c.PrepareConfig())
+	a, err := New(c.AccessControl, c.ClientIP, &stubBalancer{})
+	assert.NoError(t, err)
+	return a
+}
+
+func serve(a *Access, peer, path string) int {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(peer), Port: 40000})
+	ctx.Request.SetRequestURI(path)
+	a.Serve()(ctx)
+	return ctx.Response.StatusCode()
+}
+
+func TestAccess(t *testing.T) {
+	t.Run("deny wins over allow", func(t *testing.T) {
+		a := newTestAccess(t, config.AccessControl{AccessList: config.AccessList{
+			Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+			Deny:  []string{"10.6.6.0/24"},
+		}})
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "10.1.1.1", "/"))
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "2001:db8::1", "/"))
+		assert.Equal(t, fasthttp.StatusForbidden, serve(a, "10.6.6.6", "/"))
+		assert.Equal(t, fasthttp.StatusForbidden, serve(a, "198.51.100.1", "/"), "outside every allow range")
+		assert.Equal(t, []Counters{{Scope: GlobalScope, Allow: 2, Deny: 1, Denied: 2}}, a.Counters())
+	})
+
+	t.Run("path lists apply on top of the global ones", func(t *testing.T) {
+		a := newTestAccess(t, config.AccessControl{
+			AccessList: config.AccessList{Deny: []string{"203.0.113.0/24"}},
+			DenyStatus: fasthttp.StatusNotFound,
+			Paths:      []config.AccessPath{{Prefix: "/admin/", AccessList: config.AccessList{Allow: []string{"10.0.0.0/8"}}}},
+		})
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "198.51.100.1", "/shop"))
+		assert.Equal(t, fasthttp.StatusNotFound, serve(a, "198.51.100.1", "/admin/users"))
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "10.0.0.1", "/admin/users"))
+		assert.Equal(t, fasthttp.StatusNotFound, serve(a, "203.0.113.5", "/shop"))
+
+		counters := a.Counters()
+		assert.Equal(t, uint64(1), counters[0].Denied)
+		assert.Equal(t, Counters{Scope: "/admin/", Allow: 1, Denied: 1}, counters[1])
+	})
+
+	t.Run("reloads lists from their files", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "deny.txt")
+		assert.NoError(t, os.WriteFile(file, []byte("# scanners\n198.51.100.0/24\n"), 0o600))
+		ch, unsubscribe := events.Subscribe()
+		defer unsubscribe()
+		a := newTestAccess(t, config.AccessControl{AccessList: config.AccessList{DenyFiles: []string{file}}})
+		assert.Equal(t, fasthttp.StatusForbidden, serve(a, "198.51.100.1", "/"))
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "203.0.113.1", "/"))
+		assert.Empty(t, ch, "loading the files at startup is no reload")
+
+		assert.NoError(t, os.WriteFile(file, []byte("203.0.113.1 # one more\n"), 0o600))
+		assert.NoError(t, a.Reload())
+		assert.Equal(t, events.ConfigReloaded, (<-ch).Type)
+		assert.Equal(t, fasthttp.StatusOK, serve(a, "198.51.100.1", "/"))
+		assert.Equal(t, fasthttp.StatusForbidden, serve(a, "203.0.113.1", "/"))
+
+		assert.NoError(t, os.WriteFile(file, []byte("not an address\n"), 0o600))
+		assert.ErrorIs(t, a.Reload(), config.ErrAccessFile)
+		assert.Equal(t, fasthttp.StatusForbidden, serve(a, "203.0.113.1", "/"), "a failed reload keeps the lists")
+		assert.Empty(t, ch)
+	})
+}
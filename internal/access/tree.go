@@ -0,0 +1,75 @@
+package access
+
+import (
+	"net/netip"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+)
+
+// prefixTree is a binary trie of networks, one bit of address per level: a
+// lookup visits at most as many nodes as the address has bits, however many
+// networks the tree holds.
+type prefixTree struct {
+	v4, v6 *node
+	size   int
+}
+
+type node struct {
+	children [2]*node
+	// network ends here: every address below it is in the tree.
+	network bool
+}
+
+func newPrefixTree(ranges config.IPRanges) *prefixTree {
+	t := &prefixTree{v4: &node{}, v6: &node{}}
+	for _, prefix := range ranges {
+		t.insert(prefix)
+	}
+	return t
+}
+
+func (t *prefixTree) insert(prefix netip.Prefix) {
+	addr := prefix.Addr()
+	n := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := range prefix.Bits() {
+		if n.network {
+			// A wider network already covers this one.
+			t.size++
+			return
+		}
+		b := bit(bytes, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.network = true
+	n.children = [2]*node{}
+	t.size++
+}
+
+func (t *prefixTree) contains(addr netip.Addr) bool {
+	n := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := range addr.BitLen() {
+		if n.network {
+			return true
+		}
+		if n = n.children[bit(bytes, i)]; n == nil {
+			return false
+		}
+	}
+	return n.network
+}
+
+func (t *prefixTree) root(addr netip.Addr) *node {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func bit(bytes []byte, i int) int {
+	return int(bytes[i/8]>>(7-i%8)) & 1 //nolint:mnd
+}
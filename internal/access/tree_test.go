@@ -0,0 +1,69 @@
+package access
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func prefixes(entries ...string) config.IPRanges {
+	ranges := make(config.IPRanges, 0, len(entries))
+	for _, entry := range entries {
+		ranges = append(ranges, netip.MustParsePrefix(entry).Masked())
+	}
+	return ranges
+}
+
+func TestPrefixTree(t *testing.T) {
+	tree := newPrefixTree(prefixes("10.0.0.0/8", "192.168.1.0/24", "203.0.113.7/32", "2001:db8::/32", "2001:db8:1::/48"))
+	assert.Equal(t, 5, tree.size)
+
+	for addr, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.168.1.200":   true,
+		"192.168.2.1":     false,
+		"203.0.113.7":     true,
+		"203.0.113.8":     false,
+		"2001:db8:ff::1":  true,
+		"2001:db9::1":     false,
+		"::ffff:10.0.0.1": false, // callers unmap addresses first
+	} {
+		assert.Equal(t, want, tree.contains(netip.MustParseAddr(addr)), addr)
+	}
+
+	t.Run("a wider network swallows narrower ones", func(t *testing.T) {
+		tree := newPrefixTree(prefixes("10.1.0.0/16", "10.0.0.0/8"))
+		assert.True(t, tree.contains(netip.MustParseAddr("10.2.0.1")))
+		assert.Equal(t, 9, nodes(tree.v4), "the root and one node per bit of the /8")
+	})
+
+	t.Run("everything", func(t *testing.T) {
+		tree := newPrefixTree(prefixes("0.0.0.0/0"))
+		assert.True(t, tree.contains(netip.MustParseAddr("198.51.100.1")))
+		assert.False(t, tree.contains(netip.MustParseAddr("2001:db8::1")))
+	})
+}
+
+func nodes(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return 1 + nodes(n.children[0]) + nodes(n.children[1])
+}
+
+func BenchmarkPrefixTree(b *testing.B) {
+	ranges := make(config.IPRanges, 0, 10000)
+	for i := range 10000 {
+		ranges = append(ranges, netip.MustParsePrefix(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)))
+	}
+	tree := newPrefixTree(ranges)
+	addr := netip.MustParseAddr("10.39.15.7")
+	b.ResetTimer()
+	for range b.N {
+		tree.contains(addr)
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// apiKey checks the key a request carries in a header or a query parameter
+// against a fixed set of keys.
+type apiKey struct {
+	header, query string
+	// keys holds the SHA-256 of every key: looking one up takes as long
+	// however much of it a guess gets right.
+	keys      map[[sha256.Size]byte]struct{}
+	challenge string
+}
+
+func newAPIKey(realm string, cfg config.APIKeyAuth) (*apiKey, error) {
+	keys := cfg.Keys
+	if cfg.KeysFile != "" {
+		lines, err := readLines(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("%s has no keys", cfg.KeysFile)
+		}
+		keys = append(keys[:len(keys):len(keys)], lines...)
+	}
+	a := &apiKey{
+		header:    cfg.Header,
+		query:     cfg.Query,
+		keys:      make(map[[sha256.Size]byte]struct{}, len(keys)),
+		challenge: challenge("APIKey", "realm", realm),
+	}
+	for _, key := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = struct{}{}
+	}
+	return a, nil
+}
+
+func (a *apiKey) verify(ctx *fasthttp.RequestCtx) *rejection {
+	var key []byte
+	if a.header != "" {
+		key = ctx.Request.Header.Peek(a.header)
+	}
+	if len(key) == 0 && a.query != "" {
+		key = ctx.QueryArgs().Peek(a.query)
+	}
+	if len(key) == 0 {
+		return unauthorized(a.challenge)
+	}
+	if _, ok := a.keys[sha256.Sum256(key)]; !ok {
+		return unauthorized(a.challenge)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package auth answers requests without valid credentials with 401 or 403
+// before the balancer picks a Backend for them: basic auth against an
+// htpasswd file, static API keys, and JWTs signed by the keys of a JWKS file.
+package auth
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// Counters is what /stats reports about one policy: the requests it let
+// through, and those it answered 401 and 403.
+type Counters struct {
+	Name         string `json:"name"`
+	Allowed      uint64 `json:"allowed"`
+	Unauthorized uint64 `json:"unauthorized"`
+	Forbidden    uint64 `json:"forbidden"`
+}
+
+// Auth is a balancer that checks every request against the policies
+// covering its path, and hands the ones all of them let through to the
+// balancer it wraps.
+type Auth struct {
+	types.IBalancer
+	policies []*policy
+}
+
+type policy struct {
+	name     string
+	paths    []string
+	verifier verifier
+	allowed  atomic.Uint64
+	// Rejections by status, 401 and 403.
+	unauthorized atomic.Uint64
+	forbidden    atomic.Uint64
+}
+
+// verifier checks the credentials of a request, returning nil to let it
+// through, or how to answer it.
+type verifier interface {
+	verify(ctx *fasthttp.RequestCtx) *rejection
+}
+
+// rejection is a 401 or a 403, and the WWW-Authenticate challenge it carries.
+type rejection struct {
+	status    int
+	challenge string
+}
+
+// New loads each policy's htpasswd, keys or JWKS file, in front of balancer;
+// policies have been prepared.
+func New(policies []config.AuthPolicy, balancer types.IBalancer) (*Auth, error) {
+	a := &Auth{IBalancer: balancer}
+	for _, cfg := range policies {
+		v, err := newVerifier(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("auth %q: %w", cfg.Name, err)
+		}
+		a.policies = append(a.policies, &policy{name: cfg.Name, paths: cfg.Paths, verifier: v})
+	}
+	return a, nil
+}
+
+func newVerifier(cfg config.AuthPolicy) (verifier, error) {
+	switch cfg.Kind() {
+	case config.AuthBasic:
+		return newBasic(cfg.Realm, *cfg.Basic)
+	case config.AuthAPIKey:
+		return newAPIKey(cfg.Realm, *cfg.APIKey)
+	}
+	return newJWT(cfg.Realm, *cfg.JWT)
+}
+
+func (a *Auth) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := a.IBalancer.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		for _, p := range a.policies {
+			if !p.covers(helper.B2S(ctx.Path())) {
+				continue
+			}
+			if r := p.verifier.verify(ctx); r != nil {
+				if r.status == fasthttp.StatusForbidden {
+					p.forbidden.Add(1)
+				} else {
+					p.unauthorized.Add(1)
+				}
+				reject(ctx, r)
+				return
+			}
+			p.allowed.Add(1)
+			ctx.SetUserValue(coveredKey{}, true)
+		}
+		next(ctx)
+	}
+}
+
+// coveredKey marks a request at least one policy covered.
+type coveredKey struct{}
+
+// Covered reports whether a policy covered ctx's request, which the
+// response may then depend on: the key or the claims it carried.
+func Covered(ctx *fasthttp.RequestCtx) bool {
+	covered, _ := ctx.UserValue(coveredKey{}).(bool)
+	return covered
+}
+
+func (a *Auth) Counters() []Counters {
+	counters := make([]Counters, 0, len(a.policies))
+	for _, p := range a.policies {
+		counters = append(counters, Counters{
+			Name:         p.name,
+			Allowed:      p.allowed.Load(),
+			Unauthorized: p.unauthorized.Load(),
+			Forbidden:    p.forbidden.Load(),
+		})
+	}
+	return counters
+}
+
+func (p *policy) covers(path string) bool {
+	return len(p.paths) == 0 || slices.ContainsFunc(p.paths, func(prefix string) bool {
+		return strings.HasPrefix(path, prefix)
+	})
+}
+
+func unauthorized(challenge string) *rejection {
+	return &rejection{status: fasthttp.StatusUnauthorized, challenge: challenge}
+}
+
+func reject(ctx *fasthttp.RequestCtx, r *rejection) {
+	ctx.Response.SetStatusCode(r.status)
+	ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, r.challenge)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	if r.status == fasthttp.StatusForbidden {
+		ctx.Response.SetBodyString(`{"message":"forbidden"}`)
+	} else {
+		ctx.Response.SetBodyString(`{"message":"unauthorized"}`)
+	}
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// challenge builds a WWW-Authenticate value of scheme, with the params
+// given as name and value pairs.
+func challenge(scheme string, params ...string) string {
+	var b strings.Builder
+	b.WriteString(scheme)
+	for i := 0; i+1 < len(params); i += 2 {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(params[i])
+		b.WriteString(`="`)
+		b.WriteString(quoteEscaper.Replace(params[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// credentials returns the rest of the Authorization header after scheme,
+// which is matched case-insensitively, or "" when it uses another scheme.
+func credentials(ctx *fasthttp.RequestCtx, scheme string) string {
+	value := helper.B2S(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(value) <= len(scheme) || value[len(scheme)] != ' ' || !strings.EqualFold(value[:len(scheme)], scheme) {
+		return ""
+	}
+	return strings.TrimSpace(value[len(scheme)+1:])
+}
+
+// readLines returns file's lines, trimmed, without blank ones and those
+// starting with #.
+func readLines(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for line := range strings.Lines(string(data)) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
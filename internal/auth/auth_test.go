@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type stubBalancer struct {
+	served int
+}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		s.served++
+		ctx.SetBodyString("backend")
+	}
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return nil }
+func (s *stubBalancer) Shutdown() error          { return nil }
+
+func newTestAuth(t *testing.T, policies ...config.AuthPolicy) (*Auth, *stubBalancer) {
+	t.Helper()
+	cfg := config.Config{Port: "8000", Backends: []config.Backend{{Url: "localhost:8080"}}, Auth: policies}
+	assert.NoError(t, cfg.PrepareConfig())
+	balancer := &stubBalancer{}
+	a, err := New(cfg.Auth, balancer)
+	assert.NoError(t, err)
+	return a, balancer
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func serve(a *Auth, uri string, header ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}, nil)
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(header); i += 2 {
+		ctx.Request.Header.Set(header[i], header[i+1])
+	}
+	a.Serve()(ctx)
+	return ctx
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	htpasswd := writeFile(t, "htpasswd", "# admins\nalice:"+string(hash)+"\n")
+	a, balancer := newTestAuth(t, config.AuthPolicy{
+		Realm: "admin",
+		Paths: []string{"/admin/"},
+		Basic: &config.BasicAuth{HtpasswdFile: htpasswd},
+	})
+
+	ctx := serve(a, "/admin/users")
+	assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)))
+	assert.Equal(t, `{"message":"unauthorized"}`, string(ctx.Response.Body()))
+
+	for _, authorization := range []string{basicAuth("alice", "wrong"), basicAuth("bob", "s3cret"), "Basic !!", "Bearer x"} {
+		ctx = serve(a, "/admin/users", fasthttp.HeaderAuthorization, authorization)
+		assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), authorization)
+	}
+
+	// The second time, the credentials are remembered.
+	for range 2 {
+		ctx = serve(a, "/admin/users", fasthttp.HeaderAuthorization, basicAuth("alice", "s3cret"))
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+	ctx = serve(a, "/admin/users", fasthttp.HeaderAuthorization, "basic "+basicAuth("alice", "s3cret")[len("Basic "):])
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// Paths outside the policy need no credentials.
+	ctx = serve(a, "/")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.False(t, Covered(ctx))
+	assert.Equal(t, 4, balancer.served)
+	assert.Equal(t, []Counters{{Name: "basic", Allowed: 3, Unauthorized: 5}}, a.Counters())
+}
+
+func TestBasicRejectsOtherHashes(t *testing.T) {
+	htpasswd := writeFile(t, "htpasswd", "alice:$apr1$salt$hash\n")
+	_, err := New([]config.AuthPolicy{{Name: "basic", Basic: &config.BasicAuth{HtpasswdFile: htpasswd}}}, &stubBalancer{})
+	assert.ErrorContains(t, err, "alice does not have a bcrypt hash")
+}
+
+func TestAPIKey(t *testing.T) {
+	keys := writeFile(t, "keys", "# partners\nkey-from-file\n\n")
+	a, balancer := newTestAuth(t,
+		config.AuthPolicy{APIKey: &config.APIKeyAuth{Keys: []string{"inline-key"}, KeysFile: keys}},
+		config.AuthPolicy{Name: "query", Paths: []string{"/feeds/"}, APIKey: &config.APIKeyAuth{Query: "key", Keys: []string{"feed-key"}}},
+	)
+
+	ctx := serve(a, "/")
+	assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	assert.Equal(t, `APIKey realm="divisor"`, string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)))
+	assert.Equal(t, fasthttp.StatusUnauthorized, serve(a, "/", "X-Api-Key", "guess").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusOK, serve(a, "/", "X-Api-Key", "inline-key").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusOK, serve(a, "/", "X-Api-Key", "key-from-file").Response.StatusCode())
+	assert.True(t, Covered(serve(a, "/", "X-Api-Key", "inline-key")))
+
+	// Every policy covering a request must let it through.
+	assert.Equal(t, fasthttp.StatusUnauthorized, serve(a, "/feeds/?key=feed-key").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusUnauthorized, serve(a, "/feeds/?key=inline-key", "X-Api-Key", "inline-key").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusOK, serve(a, "/feeds/?key=feed-key", "X-Api-Key", "inline-key").Response.StatusCode())
+
+	assert.Equal(t, 4, balancer.served)
+	assert.Equal(t, []Counters{
+		{Name: "api_key", Allowed: 5, Unauthorized: 3},
+		{Name: "query", Allowed: 1, Unauthorized: 1},
+	}, a.Counters())
+}
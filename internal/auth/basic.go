@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxVerified bounds how many credentials basic remembers; past it, it
+// starts over.
+const maxVerified = 10000
+
+// basic checks Basic credentials against the bcrypt hashes of an htpasswd
+// file.
+type basic struct {
+	users     map[string][]byte
+	challenge string
+	// unknown is hashed against for users not in the file, so that they
+	// take as long to turn away as a wrong password.
+	unknown []byte
+
+	// verified remembers the SHA-256 of credentials bcrypt accepted, so a
+	// client sending them on every request pays bcrypt's cost once.
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+func newBasic(realm string, cfg config.BasicAuth) (*basic, error) {
+	lines, err := readLines(cfg.HtpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	b := &basic{
+		users:     make(map[string][]byte, len(lines)),
+		challenge: challenge("Basic", "realm", realm, "charset", "UTF-8"),
+		verified:  make(map[[sha256.Size]byte]struct{}),
+	}
+	for _, line := range lines {
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not a user:hash line", cfg.HtpasswdFile, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s: %s does not have a bcrypt hash (htpasswd -B): %w", cfg.HtpasswdFile, user, err)
+		}
+		b.users[user] = []byte(hash)
+	}
+	if len(b.users) == 0 {
+		return nil, fmt.Errorf("%s has no users", cfg.HtpasswdFile)
+	}
+	if b.unknown, err = bcrypt.GenerateFromPassword([]byte(realm), bcrypt.DefaultCost); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *basic) verify(ctx *fasthttp.RequestCtx) *rejection {
+	decoded, err := base64.StdEncoding.DecodeString(credentials(ctx, "Basic"))
+	if err != nil {
+		return unauthorized(b.challenge)
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return unauthorized(b.challenge)
+	}
+
+	sum := sha256.Sum256(decoded)
+	b.mu.Lock()
+	_, verified := b.verified[sum]
+	b.mu.Unlock()
+	if verified {
+		return nil
+	}
+
+	hash, known := b.users[user]
+	if !known {
+		hash = b.unknown
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !known {
+		return unauthorized(b.challenge)
+	}
+	b.mu.Lock()
+	if len(b.verified) >= maxVerified {
+		clear(b.verified)
+	}
+	b.verified[sum] = struct{}{}
+	b.mu.Unlock()
+	return nil
+}
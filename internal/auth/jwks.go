@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// jwk is one verification key of a JWKS file: a []byte secret for HS
+// algorithms, an *rsa.PublicKey for RS and an *ecdsa.PublicKey for ES.
+type jwk struct {
+	kid string
+	// alg, when the file sets it, is the only algorithm the key verifies.
+	alg string
+	key any
+}
+
+// curves maps each ES algorithm to the curve its keys are on.
+var curves = map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+
+var curveNames = map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+
+// loadJWKS reads the signing keys of a JWKS file (RFC 7517), skipping those
+// meant for encryption and those of other key types.
+func loadJWKS(file string) ([]jwk, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var keys []jwk
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key := jwk{kid: k.Kid, alg: k.Alg}
+		switch k.Kty {
+		case "oct":
+			key.key, err = decodeSegment(k.K)
+			if err == nil && len(key.key.([]byte)) == 0 {
+				err = fmt.Errorf("empty secret")
+			}
+		case "RSA":
+			key.key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key.key, err = ecKey(k.Crv, k.X, k.Y)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d (kid %q): %w", file, i, k.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no oct, RSA or EC signing keys", file)
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := decodeSegment(n)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := decodeSegment(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eBytes)
+	if len(nBytes) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(exponent.Int64())}, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	curve, ok := curveNames[crv]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xBytes, err := decodeSegment(x)
+	if err != nil {
+		return nil, err
+	}
+	yBytes, err := decodeSegment(y)
+	if err != nil {
+		return nil, err
+	}
+	size := (curve.Params().BitSize + 7) / 8 //nolint:mnd
+	if len(xBytes) > size || len(yBytes) > size {
+		return nil, fmt.Errorf("coordinates too long for %s", crv)
+	}
+	point := make([]byte, 1+2*size)
+	point[0] = 4 // uncompressed
+	copy(point[1+size-len(xBytes):], xBytes)
+	copy(point[1+2*size-len(yBytes):], yBytes)
+	return ecdsa.ParseUncompressedPublicKey(curve, point)
+}
+
+// accepts reports whether k may verify a token signed with alg.
+func (k *jwk) accepts(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch key := k.key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS")
+	case *ecdsa.PublicKey:
+		return curves[alg] == key.Curve
+	}
+	return false
+}
+
+// verify checks sig over signed with alg, which k accepts.
+func (k *jwk) verify(alg string, signed, sig []byte) bool {
+	hash := hashes[alg[2:]]
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig) == nil
+	case *ecdsa.PublicKey:
+		// ES signatures are r and s side by side, each as long as the
+		// curve's order, not ASN.1.
+		size := (key.Curve.Params().BitSize + 7) / 8 //nolint:mnd
+		if len(sig) != 2*size {
+			return false
+		}
+		h := hash.New()
+		h.Write(signed)
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, h.Sum(nil), r, s)
+	}
+	return false
+}
+
+// hashes maps the size suffix of an algorithm to its hash.
+var hashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
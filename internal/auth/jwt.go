@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// Each goes out as the error_description of a rejected token.
+var (
+	errTokenMalformed = errors.New("malformed token")
+	errTokenAlgorithm = errors.New("token algorithm not accepted")
+	errTokenSignature = errors.New("token signature does not verify")
+	errTokenNoExpiry  = errors.New("token has no expiry")
+	errTokenExpired   = errors.New("token expired")
+	errTokenEarly     = errors.New("token not valid yet")
+	errTokenIssuer    = errors.New("token issuer not accepted")
+	errTokenAudience  = errors.New("token audience not accepted")
+)
+
+// jwtBearer validates the bearer token of a request, and sends the claims
+// it is configured to as headers.
+type jwtBearer struct {
+	keys         []jwk
+	algorithms   []string
+	issuer       string
+	audience     []string
+	scopes       []string
+	leeway       time.Duration
+	claimHeaders map[string]string
+	realm        string
+	now          func() time.Time
+}
+
+func newJWT(realm string, cfg config.JWTAuth) (*jwtBearer, error) {
+	keys, err := loadJWKS(cfg.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtBearer{
+		keys:         keys,
+		algorithms:   cfg.Algorithms,
+		issuer:       cfg.Issuer,
+		audience:     cfg.Audience,
+		scopes:       cfg.Scopes,
+		leeway:       cfg.Leeway,
+		claimHeaders: cfg.ClaimHeaders,
+		realm:        realm,
+		now:          time.Now,
+	}, nil
+}
+
+func (j *jwtBearer) verify(ctx *fasthttp.RequestCtx) *rejection {
+	// Whatever the client sent under a claim header is not a claim.
+	for _, header := range j.claimHeaders {
+		ctx.Request.Header.Del(header)
+	}
+
+	token := credentials(ctx, "Bearer")
+	if token == "" {
+		return unauthorized(challenge("Bearer", "realm", j.realm))
+	}
+	claims, err := j.parse(token)
+	if err != nil {
+		return unauthorized(challenge("Bearer", "realm", j.realm, "error", "invalid_token", "error_description", err.Error()))
+	}
+	if !hasScopes(claims, j.scopes) {
+		return &rejection{
+			status:    fasthttp.StatusForbidden,
+			challenge: challenge("Bearer", "realm", j.realm, "error", "insufficient_scope", "scope", strings.Join(j.scopes, " ")),
+		}
+	}
+
+	for claim, header := range j.claimHeaders {
+		if value, ok := claimValue(claims[claim]); ok {
+			ctx.Request.Header.Set(header, value)
+		}
+	}
+	return nil
+}
+
+// parse verifies token's signature and time, issuer and audience claims,
+// and returns its claims. exp is required, nbf only checked when present.
+func (j *jwtBearer) parse(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { //nolint:mnd
+		return nil, errTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJSON(parts[0], &header); err != nil {
+		return nil, errTokenMalformed
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	if !slices.Contains(j.algorithms, header.Alg) {
+		return nil, errTokenAlgorithm
+	}
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+	if !slices.ContainsFunc(j.keys, func(k jwk) bool {
+		return (header.Kid == "" || k.kid == header.Kid) && k.accepts(header.Alg) && k.verify(header.Alg, signed, sig)
+	}) {
+		return nil, errTokenSignature
+	}
+
+	var claims map[string]any
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+	// A token without an expiry would be good forever.
+	exp, ok := claimTime(claims["exp"])
+	if !ok {
+		return nil, errTokenNoExpiry
+	}
+	now := j.now()
+	if !now.Before(exp.Add(j.leeway)) {
+		return nil, errTokenExpired
+	}
+	if v, present := claims["nbf"]; present {
+		nbf, ok := claimTime(v)
+		if !ok {
+			return nil, errTokenMalformed
+		}
+		if now.Add(j.leeway).Before(nbf) {
+			return nil, errTokenEarly
+		}
+	}
+	if j.issuer != "" && claims["iss"] != j.issuer {
+		return nil, errTokenIssuer
+	}
+	if len(j.audience) > 0 && !slices.ContainsFunc(claimStrings(claims["aud"]), func(aud string) bool {
+		return slices.Contains(j.audience, aud)
+	}) {
+		return nil, errTokenAudience
+	}
+	return claims, nil
+}
+
+// hasScopes reports whether the scope claim, a space-separated string, or
+// the scp claim, a list, holds every one of scopes.
+func hasScopes(claims map[string]any, scopes []string) bool {
+	granted := claimStrings(claims["scp"])
+	if scope, ok := claims["scope"].(string); ok {
+		granted = append(granted, strings.Fields(scope)...)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeJSON(segment string, v any) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// claimTime reads a NumericDate claim: seconds since the epoch.
+func claimTime(v any) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil || math.IsInf(seconds, 0) {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+}
+
+// claimStrings reads a claim that is a string or a list of them.
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// claimValue renders a claim as a header value: strings as they are, lists
+// joined with commas, and anything else as JSON. A missing claim, or one
+// that would break the header, is not sent.
+func claimValue(v any) (string, bool) {
+	var value string
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		value = v
+	case json.Number:
+		value = v.String()
+	case bool:
+		value = strconv.FormatBool(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := claimValue(item); ok {
+				values = append(values, s)
+			}
+		}
+		value = strings.Join(values, ",")
+	default:
+		by, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		value = string(by)
+	}
+	return value, !strings.ContainsAny(value, "\r\n")
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	rsaTestKey, _ = rsa.GenerateKey(rand.Reader, 2048) //nolint:mnd
+	ecTestKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hmacTestKey   = []byte("0123456789abcdef0123456789abcdef")
+	jwtNow        = time.Unix(1_800_000_000, 0)
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func testJWKS(t *testing.T) string {
+	t.Helper()
+	ecBytes, err := ecTestKey.PublicKey.Bytes()
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaTestKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecBytes[1:33]), "y": b64(ecBytes[33:])},
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": b64(hmacTestKey)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AA"},
+	}})
+	assert.NoError(t, err)
+	return writeFile(t, "jwks.json", string(jwks))
+}
+
+// sign makes a token of claims with alg, signed by the test key of its kind.
+func sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(sha256.New, hmacTestKey)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, rsaTestKey, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case "ES":
+		r, s, err := ecdsa.Sign(rand.Reader, ecTestKey, digest[:])
+		assert.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...) //nolint:mnd
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWT(t *testing.T) {
+	a, balancer := newTestAuth(t, config.AuthPolicy{JWT: &config.JWTAuth{
+		JWKSFile:     testJWKS(t),
+		Algorithms:   []string{"RS256", "ES256", "HS256"},
+		Issuer:       "https://issuer.example",
+		Audience:     []string{"divisor", "api"},
+		Leeway:       time.Minute,
+		ClaimHeaders: map[string]string{"sub": "X-User-Id", "roles": "X-User-Roles", "tenant": "X-Tenant"},
+	}})
+	a.policies[0].verifier.(*jwtBearer).now = func() time.Time { return jwtNow }
+
+	claims := func(overrides ...any) map[string]any {
+		c := map[string]any{
+			"iss":   "https://issuer.example",
+			"aud":   []string{"api"},
+			"sub":   "user-42",
+			"roles": []string{"admin", "billing"},
+			"exp":   jwtNow.Add(time.Hour).Unix(),
+			"nbf":   jwtNow.Add(-time.Hour).Unix(),
+		}
+		for i := 0; i+1 < len(overrides); i += 2 {
+			if overrides[i+1] == nil {
+				delete(c, overrides[i].(string))
+			} else {
+				c[overrides[i].(string)] = overrides[i+1]
+			}
+		}
+		return c
+	}
+	bearer := func(token string) []string {
+		return []string{fasthttp.HeaderAuthorization, "Bearer " + token, "X-Tenant", "forged"}
+	}
+
+	ctx := serve(a, "/")
+	assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	assert.Equal(t, `Bearer realm="divisor"`, string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)))
+
+	for _, token := range []string{
+		sign(t, "RS256", "rsa", claims()),
+		sign(t, "ES256", "ec", claims()),
+		sign(t, "HS256", "hmac", claims("aud", "divisor")),
+		sign(t, "RS256", "", claims()),
+		// Expired, but within the leeway.
+		sign(t, "ES256", "ec", claims("exp", jwtNow.Add(-30*time.Second).Unix())),
+	} {
+		ctx = serve(a, "/", bearer(token)...)
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), token)
+		assert.Equal(t, "user-42", string(ctx.Request.Header.Peek("X-User-Id")))
+		assert.Equal(t, "admin,billing", string(ctx.Request.Header.Peek("X-User-Roles")))
+		assert.Empty(t, ctx.Request.Header.Peek("X-Tenant"))
+	}
+
+	tampered := sign(t, "RS256", "rsa", claims())
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	invalid := []struct {
+		token string
+		err   error
+	}{
+		{"not.a.token", errTokenMalformed},
+		{"abc", errTokenMalformed},
+		{tampered, errTokenSignature},
+		{sign(t, "HS384", "hmac", claims()), errTokenAlgorithm},
+		// The RSA key never verifies an HMAC, nor the HMAC key an ES token.
+		{sign(t, "HS256", "rsa", claims()), errTokenSignature},
+		{sign(t, "ES256", "hmac", claims()), errTokenSignature},
+		{sign(t, "RS256", "ec", claims()), errTokenSignature},
+		{sign(t, "RS256", "rsa", claims("exp", jwtNow.Add(-2*time.Minute).Unix())), errTokenExpired},
+		{sign(t, "RS256", "rsa", claims("exp", nil)), errTokenNoExpiry},
+		{sign(t, "RS256", "rsa", claims("exp", "tomorrow")), errTokenNoExpiry},
+		{sign(t, "RS256", "rsa", claims("nbf", "yesterday")), errTokenMalformed},
+		{sign(t, "RS256", "rsa", claims("nbf", jwtNow.Add(2*time.Minute).Unix())), errTokenEarly},
+		{sign(t, "RS256", "rsa", claims("iss", "https://other.example")), errTokenIssuer},
+		{sign(t, "RS256", "rsa", claims("aud", "other")), errTokenAudience},
+	}
+	for _, tt := range invalid {
+		ctx = serve(a, "/", bearer(tt.token)...)
+		assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), tt.token)
+		assert.Equal(t, fmt.Sprintf(`Bearer realm="divisor", error="invalid_token", error_description="%s"`, tt.err),
+			string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)), tt.token)
+		assert.Empty(t, ctx.Request.Header.Peek("X-Tenant"))
+	}
+
+	assert.Equal(t, 5, balancer.served)
+	assert.Equal(t, []Counters{{Name: "jwt", Allowed: 5, Unauthorized: 15}}, a.Counters())
+}
+
+func TestJWTScopes(t *testing.T) {
+	a, _ := newTestAuth(t, config.AuthPolicy{JWT: &config.JWTAuth{JWKSFile: testJWKS(t), Scopes: []string{"read", "write"}}})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	ctx := serve(a, "/", fasthttp.HeaderAuthorization, "Bearer "+sign(t, "ES256", "ec", map[string]any{"scope": "read", "exp": exp}))
+	assert.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+	assert.Equal(t, `Bearer realm="divisor", error="insufficient_scope", scope="read write"`,
+		string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)))
+	assert.Equal(t, `{"message":"forbidden"}`, string(ctx.Response.Body()))
+
+	for _, claims := range []map[string]any{{"scope": "write openid read", "exp": exp}, {"scp": []string{"read", "write"}, "exp": exp}} {
+		ctx = serve(a, "/", fasthttp.HeaderAuthorization, "Bearer "+sign(t, "ES256", "ec", claims))
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+	assert.Equal(t, []Counters{{Name: "jwt", Allowed: 2, Forbidden: 1}}, a.Counters())
+}
+
+func TestLoadJWKS(t *testing.T) {
+	keys, err := loadJWKS(testJWKS(t))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 3)
+
+	for _, jwks := range []string{
+		`{"keys":[]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"EC","crv":"secp256k1","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"RSA","n":"","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"oct","k":""}]}`,
+		`not json`,
+	} {
+		_, err := loadJWKS(writeFile(t, "jwks.json", jwks))
+		assert.Error(t, err, jwks)
+	}
+}
@@ -0,0 +1,511 @@
+// Package cache keeps responses the Backends mark cacheable in memory, in
+// front of the balancer: a hit is answered without the balancer ever seeing
+// the request.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// Counters is what /stats reports about the cache. A hit is answered from
+// memory, a miss goes to a Backend, revalidations included, and a bypass is
+// a request the cache never considered.
+type Counters struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Bypasses uint64 `json:"bypasses"`
+	Entries  int    `json:"entries"`
+	Bytes    int    `json:"bytes"`
+}
+
+// Cache is a balancer that answers what it can from memory and hands the
+// rest to the balancer it wraps.
+type Cache struct {
+	types.IBalancer
+	maxSize       int
+	maxObjectSize int
+
+	mu sync.Mutex
+	// variants holds, per method, host and URI, the entries told apart by
+	// the request headers the Backend's Vary names.
+	variants map[string]*variants
+	lru      *list.List
+	size     int
+
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	bypasses atomic.Uint64
+
+	// bypass and partition are what the stages around the cache know of a
+	// request: whether it is its client's own, and which pool serves it.
+	bypass    func(ctx *fasthttp.RequestCtx) bool
+	partition func(ctx *fasthttp.RequestCtx) string
+
+	refreshes sync.WaitGroup
+	now       func() time.Time
+}
+
+// request is what the cache needs of a request, taken before the balancer's
+// Backend rewrites its host, path and headers.
+type request struct {
+	primary string
+	host    string
+	path    string
+	header  fasthttp.RequestHeader
+}
+
+type variants struct {
+	vary    []string
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	primary string
+	key     string
+	host    string
+	path    string
+	status  int
+	header  fasthttp.ResponseHeader
+	body    []byte
+	size    int
+	// born is when the response left the Backend, less the Age it came
+	// with.
+	born           time.Time
+	fresh          time.Duration
+	stale          time.Duration
+	etag           string
+	lastModified   string
+	refreshing     atomic.Bool
+	mustRevalidate bool
+}
+
+// Statuses a response may be stored with; RFC 9110 calls them heuristically
+// cacheable, though here they still need explicit freshness or a validator.
+var cacheableStatuses = []int{
+	fasthttp.StatusOK, fasthttp.StatusNonAuthoritativeInfo, fasthttp.StatusNoContent,
+	fasthttp.StatusMultipleChoices, fasthttp.StatusMovedPermanently, fasthttp.StatusPermanentRedirect,
+	fasthttp.StatusNotFound, fasthttp.StatusMethodNotAllowed, fasthttp.StatusGone,
+	fasthttp.StatusRequestURITooLong, fasthttp.StatusNotImplemented,
+}
+
+func New(cfg config.Cache, balancer types.IBalancer) *Cache {
+	return &Cache{
+		IBalancer:     balancer,
+		maxSize:       cfg.MaxSize,
+		maxObjectSize: cfg.MaxObjectSize,
+		variants:      make(map[string]*variants),
+		lru:           list.New(),
+		now:           time.Now,
+	}
+}
+
+// Bypass keeps the requests f reports true for out of the cache, neither
+// answered from nor stored in it.
+func (c *Cache) Bypass(f func(ctx *fasthttp.RequestCtx) bool) {
+	c.bypass = f
+}
+
+// Partition keys each request by what f returns for it as well, so requests
+// f tells apart never share a response.
+func (c *Cache) Partition(f func(ctx *fasthttp.RequestCtx) string) {
+	c.partition = f
+}
+
+func (c *Cache) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := c.IBalancer.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		c.serve(ctx, next)
+	}
+}
+
+// Shutdown lets background revalidations finish before the balancer they
+// go through is shut down.
+func (c *Cache) Shutdown() error {
+	c.refreshes.Wait()
+	return c.IBalancer.Shutdown()
+}
+
+func (c *Cache) Counters() Counters {
+	c.mu.Lock()
+	entries, size := c.lru.Len(), c.size
+	c.mu.Unlock()
+	return Counters{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Bypasses: c.bypasses.Load(),
+		Entries:  entries,
+		Bytes:    size,
+	}
+}
+
+// Purge drops the entries for host, or for every host when it is empty,
+// whose path starts with prefix, and reports how many went.
+func (c *Cache) Purge(host, prefix string) int {
+	host = strings.ToLower(host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := 0
+	for el := c.lru.Front(); el != nil; {
+		e := el.Value.(*entry)
+		el = el.Next()
+		if (host == "" || e.host == host) && strings.HasPrefix(e.path, prefix) {
+			c.remove(e)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (c *Cache) serve(ctx *fasthttp.RequestCtx, next func(*fasthttp.RequestCtx)) {
+	req := &ctx.Request
+	cc := parseCacheControl(req.Header.Peek(fasthttp.HeaderCacheControl))
+	if !cacheableRequest(req) || cc.noStore || c.bypass != nil && c.bypass(ctx) {
+		c.bypasses.Add(1)
+		next(ctx)
+		return
+	}
+	// A client asking for no-cache, or max-age=0, gets a revalidated answer.
+	revalidate := cc.noCache || cc.maxAge == 0 ||
+		(len(req.Header.Peek(fasthttp.HeaderCacheControl)) == 0 && bytes.Contains(req.Header.Peek("Pragma"), []byte("no-cache")))
+
+	r := newRequest(req)
+	if c.partition != nil {
+		r.primary += "\x00" + c.partition(ctx)
+	}
+	e := c.lookup(r)
+	now := c.now()
+	if e != nil && !revalidate {
+		age := now.Sub(e.born)
+		switch {
+		case age < e.fresh:
+			c.hits.Add(1)
+			e.writeTo(ctx, &r.header, now)
+			return
+		case !e.mustRevalidate && age < e.fresh+e.stale:
+			c.hits.Add(1)
+			c.refreshInBackground(ctx, next, r, e)
+			e.writeTo(ctx, &r.header, now)
+			return
+		}
+	}
+
+	c.misses.Add(1)
+	if e != nil && (e.etag != "" || e.lastModified != "") {
+		c.revalidate(ctx, next, r, e)
+		return
+	}
+	next(ctx)
+	c.store(r, &ctx.Response, now)
+}
+
+// cacheableRequest reports whether a request may be answered from, or its
+// response kept in, the cache at all.
+func cacheableRequest(req *fasthttp.Request) bool {
+	if !req.Header.IsGet() && !req.Header.IsHead() {
+		return false
+	}
+	// A credentialed response is the user's own, a ranged one a piece of
+	// one, an upgrade not a response at all.
+	for _, header := range []string{fasthttp.HeaderAuthorization, fasthttp.HeaderRange, fasthttp.HeaderUpgrade} {
+		if len(req.Header.Peek(header)) > 0 {
+			return false
+		}
+	}
+	return req.Header.ContentLength() == 0
+}
+
+// newRequest keys a request by method, host and URI.
+func newRequest(req *fasthttp.Request) *request {
+	r := &request{
+		host: string(bytes.ToLower(req.Host())),
+		path: string(req.URI().Path()),
+	}
+	r.primary = string(req.Header.Method()) + "\x00" + r.host + "\x00" + string(req.RequestURI())
+	req.Header.CopyTo(&r.header)
+	return r
+}
+
+// variantKey adds the values of the request headers the Backend's Vary named
+// to the primary key.
+func (r *request) variantKey(vary []string) string {
+	key := []byte(r.primary)
+	for _, name := range vary {
+		key = append(key, 0)
+		for i, value := range r.header.PeekAll(name) {
+			if i > 0 {
+				key = append(key, ',')
+			}
+			key = append(key, value...)
+		}
+	}
+	return string(key)
+}
+
+func (c *Cache) lookup(r *request) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.variants[r.primary]
+	if !ok {
+		return nil
+	}
+	el, ok := v.entries[r.variantKey(v.vary)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*entry)
+}
+
+// revalidate asks the Backend whether e still stands, in place of the
+// client's own conditions, which are then answered from whatever the cache
+// ends up holding.
+func (c *Cache) revalidate(ctx *fasthttp.RequestCtx, next func(*fasthttp.RequestCtx), r *request, e *entry) {
+	req := &ctx.Request
+	req.Header.Del(fasthttp.HeaderIfNoneMatch)
+	req.Header.Del(fasthttp.HeaderIfModifiedSince)
+	if e.etag != "" {
+		req.Header.Set(fasthttp.HeaderIfNoneMatch, e.etag)
+	}
+	if e.lastModified != "" {
+		req.Header.Set(fasthttp.HeaderIfModifiedSince, e.lastModified)
+	}
+
+	now := c.now()
+	next(ctx)
+
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified {
+		stored := c.store(r, &ctx.Response, now)
+		switch {
+		case stored == nil:
+			c.drop(e)
+		case notModified(&r.header, stored):
+			stored.writeTo(ctx, &r.header, c.now())
+		}
+		return
+	}
+	refreshed := c.refreshed(e, &ctx.Response.Header, now)
+	refreshed.writeTo(ctx, &r.header, c.now())
+}
+
+// refreshInBackground revalidates e off the request that found it stale, at
+// most once at a time.
+func (c *Cache) refreshInBackground(ctx *fasthttp.RequestCtx, next func(*fasthttp.RequestCtx), r *request, e *entry) {
+	if !e.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	// Init copies the request, though not a host only its URI holds: ctx is
+	// recycled once its handler returns.
+	bg := &fasthttp.RequestCtx{}
+	bg.Init(&ctx.Request, ctx.RemoteAddr(), nil)
+	ctx.Request.URI().CopyTo(bg.Request.URI())
+	// Nor its user values, such as the pool a partition chose.
+	ctx.VisitUserValuesAll(bg.SetUserValue)
+	// fasthttp's headers are not safe to share, even for reading.
+	bgR := &request{primary: r.primary, host: r.host, path: r.path}
+	r.header.CopyTo(&bgR.header)
+	c.refreshes.Add(1)
+	go func() {
+		defer c.refreshes.Done()
+		defer e.refreshing.Store(false)
+		c.revalidate(bg, next, bgR, e)
+	}()
+}
+
+// refreshed replaces e with a copy carrying the freshness and validators of
+// the 304 that confirmed it. Should the 304 forbid storing, e is dropped and
+// answers this one last request.
+func (c *Cache) refreshed(e *entry, notModified *fasthttp.ResponseHeader, now time.Time) *entry {
+	header := &fasthttp.ResponseHeader{}
+	e.header.CopyTo(header)
+	for _, name := range []string{fasthttp.HeaderCacheControl, fasthttp.HeaderExpires,
+		fasthttp.HeaderETag, fasthttp.HeaderLastModified, fasthttp.HeaderAge} {
+		if value := notModified.Peek(name); len(value) > 0 {
+			header.Set(name, string(value))
+		}
+	}
+	refreshed := newEntry(e.status, header, e.body, now)
+	if refreshed == nil {
+		c.drop(e)
+		return e
+	}
+	refreshed.primary, refreshed.key, refreshed.host, refreshed.path = e.primary, e.key, e.host, e.path
+	refreshed.size = e.size
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// e may have been evicted, purged or replaced meanwhile.
+	if v, ok := c.variants[e.primary]; ok {
+		if el, ok := v.entries[e.key]; ok && el.Value == e {
+			el.Value = refreshed
+			c.lru.MoveToFront(el)
+		}
+	}
+	return refreshed
+}
+
+// store keeps res if it may be shared, and returns what it stored.
+func (c *Cache) store(r *request, res *fasthttp.Response, now time.Time) *entry {
+	if res.IsBodyStream() || len(res.Header.Peek(fasthttp.HeaderSetCookie)) > 0 {
+		return nil
+	}
+	vary, ok := parseVary(res.Header.PeekAll(fasthttp.HeaderVary))
+	if !ok {
+		return nil
+	}
+	header := &fasthttp.ResponseHeader{}
+	res.Header.CopyTo(header)
+	e := newEntry(res.StatusCode(), header, slices.Clone(res.Body()), now)
+	if e == nil {
+		return nil
+	}
+	e.primary, e.key, e.host, e.path = r.primary, r.variantKey(vary), r.host, r.path
+	e.size = len(e.key) + len(e.header.Header()) + len(e.body)
+	if e.size > c.maxObjectSize {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.variants[r.primary]
+	if ok && !slices.Equal(v.vary, vary) {
+		// Variants told apart by other headers can no longer be found.
+		for _, el := range v.entries {
+			c.remove(el.Value.(*entry))
+		}
+		ok = false
+	}
+	if !ok {
+		v = &variants{vary: vary, entries: make(map[string]*list.Element)}
+		c.variants[r.primary] = v
+	}
+	if el, ok := v.entries[e.key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+	}
+	c.add(v, e)
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back().Value.(*entry))
+	}
+	return e
+}
+
+// newEntry reads a response's status and header for whether, and for how
+// long, it may be kept; nil means not at all.
+func newEntry(status int, header *fasthttp.ResponseHeader, body []byte, now time.Time) *entry {
+	if !slices.Contains(cacheableStatuses, status) {
+		return nil
+	}
+	cc := parseCacheControl(header.Peek(fasthttp.HeaderCacheControl))
+	if cc.noStore || cc.private {
+		return nil
+	}
+	e := &entry{
+		status:         status,
+		body:           body,
+		fresh:          freshness(cc, header, now),
+		stale:          max(cc.staleWhileRevalidate, 0),
+		etag:           string(header.Peek(fasthttp.HeaderETag)),
+		lastModified:   string(header.Peek(fasthttp.HeaderLastModified)),
+		mustRevalidate: cc.mustRevalidate,
+	}
+	if e.fresh == 0 && e.etag == "" && e.lastModified == "" {
+		return nil
+	}
+	age, _ := strconv.Atoi(string(header.Peek(fasthttp.HeaderAge)))
+	e.born = now.Add(-time.Duration(max(age, 0)) * time.Second)
+	header.Del(fasthttp.HeaderAge)
+	header.CopyTo(&e.header)
+	return e
+}
+
+// parseVary returns the request header names a response varies on,
+// canonical and sorted; false means it varies on something no key can hold.
+func parseVary(values [][]byte) ([]string, bool) {
+	var vary []string
+	for _, value := range values {
+		for name := range strings.SplitSeq(helper.B2S(value), ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(vary)
+	return slices.Compact(vary), true
+}
+
+func (c *Cache) drop(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(e)
+}
+
+// add and remove expect c.mu held.
+func (c *Cache) add(v *variants, e *entry) {
+	v.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+}
+
+func (c *Cache) remove(e *entry) {
+	v, ok := c.variants[e.primary]
+	if !ok {
+		return
+	}
+	el, ok := v.entries[e.key]
+	if !ok || el.Value != e {
+		return
+	}
+	c.lru.Remove(el)
+	c.size -= e.size
+	delete(v.entries, e.key)
+	if len(v.entries) == 0 {
+		delete(c.variants, e.primary)
+	}
+}
+
+// writeTo answers ctx from e, with a 304 when the client's own conditions
+// hold.
+func (e *entry) writeTo(ctx *fasthttp.RequestCtx, req *fasthttp.RequestHeader, now time.Time) {
+	res := &ctx.Response
+	e.header.CopyTo(&res.Header)
+	res.Header.Set(fasthttp.HeaderAge, strconv.Itoa(int(now.Sub(e.born)/time.Second)))
+	if notModified(req, e) {
+		res.SetStatusCode(fasthttp.StatusNotModified)
+		res.ResetBody()
+		return
+	}
+	res.SetStatusCode(e.status)
+	res.SetBodyRaw(e.body)
+}
+
+func notModified(req *fasthttp.RequestHeader, e *entry) bool {
+	if ifNoneMatch := req.Peek(fasthttp.HeaderIfNoneMatch); len(ifNoneMatch) > 0 {
+		return e.etag != "" && etagMatches(ifNoneMatch, e.etag)
+	}
+	ifModifiedSince := req.Peek(fasthttp.HeaderIfModifiedSince)
+	if len(ifModifiedSince) == 0 || e.lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(helper.B2S(ifModifiedSince))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(e.lastModified)
+	return err == nil && !modified.After(since)
+}
@@ -0,0 +1,348 @@
+package cache
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type stubBalancer struct {
+	calls   atomic.Int32
+	handler func(ctx *fasthttp.RequestCtx)
+}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		s.calls.Add(1)
+		s.handler(ctx)
+	}
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return nil }
+func (s *stubBalancer) Shutdown() error          { return nil }
+
+// newTestCache serves what handler answers, with a clock the test moves.
+func newTestCache(handler func(ctx *fasthttp.RequestCtx)) (*Cache, *stubBalancer, *time.Time) {
+	balancer := &stubBalancer{handler: handler}
+	cache := New(config.Cache{Enabled: true, MaxSize: 1 << 20, MaxObjectSize: 1 << 20}, balancer)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return cache, balancer, &now
+}
+
+func get(c *Cache, method, uri string, header ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(header); i += 2 {
+		ctx.Request.Header.Add(header[i], header[i+1])
+	}
+	c.Serve()(ctx)
+	return ctx
+}
+
+func respond(cacheControl string) func(ctx *fasthttp.RequestCtx) {
+	n := 0
+	return func(ctx *fasthttp.RequestCtx) {
+		n++
+		if cacheControl != "" {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, cacheControl)
+		}
+		ctx.Response.SetBodyString("response " + strconv.Itoa(n))
+	}
+}
+
+func TestCacheHit(t *testing.T) {
+	cache, balancer, now := newTestCache(respond("max-age=60"))
+
+	ctx := get(cache, "GET", "http://example.com/a?x=1")
+	assert.Equal(t, "response 1", string(ctx.Response.Body()))
+
+	*now = now.Add(30 * time.Second)
+	ctx = get(cache, "GET", "http://EXAMPLE.com/a?x=1")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "response 1", string(ctx.Response.Body()))
+	assert.Equal(t, "30", string(ctx.Response.Header.Peek(fasthttp.HeaderAge)))
+	assert.EqualValues(t, 1, balancer.calls.Load())
+
+	// Method, host and URI all make the key.
+	get(cache, "HEAD", "http://example.com/a?x=1")
+	get(cache, "GET", "http://other.example.com/a?x=1")
+	get(cache, "GET", "http://example.com/a?x=2")
+	assert.EqualValues(t, 4, balancer.calls.Load())
+
+	*now = now.Add(31 * time.Second)
+	ctx = get(cache, "GET", "http://example.com/a?x=1")
+	assert.Equal(t, "response 5", string(ctx.Response.Body()))
+
+	counters := cache.Counters()
+	assert.Equal(t, uint64(1), counters.Hits)
+	assert.Equal(t, uint64(5), counters.Misses)
+	assert.Equal(t, 4, counters.Entries)
+}
+
+func TestCacheKeyTakenBeforeBackendRewrites(t *testing.T) {
+	cache, balancer, _ := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.SetHost("backend:8080")
+		ctx.Request.URI().SetPath("/v1/a")
+		ctx.Request.Header.Del(fasthttp.HeaderAcceptLanguage)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Language")
+	})
+
+	get(cache, "GET", "http://example.com/a", fasthttp.HeaderAcceptLanguage, "de")
+	get(cache, "GET", "http://example.com/a", fasthttp.HeaderAcceptLanguage, "de")
+	assert.EqualValues(t, 1, balancer.calls.Load())
+	assert.Equal(t, 1, cache.Purge("example.com", "/a"))
+}
+
+func TestCacheBypass(t *testing.T) {
+	cache, balancer, _ := newTestCache(respond("max-age=60"))
+
+	get(cache, "POST", "http://example.com/")
+	get(cache, "GET", "http://example.com/", fasthttp.HeaderAuthorization, "Bearer x")
+	get(cache, "GET", "http://example.com/", fasthttp.HeaderRange, "bytes=0-1")
+	get(cache, "GET", "http://example.com/", fasthttp.HeaderCacheControl, "no-store")
+	assert.EqualValues(t, 4, balancer.calls.Load())
+	assert.Equal(t, Counters{Bypasses: 4}, cache.Counters())
+
+	cache.Bypass(func(ctx *fasthttp.RequestCtx) bool { return len(ctx.Request.Header.Peek("X-Api-Key")) > 0 })
+	get(cache, "GET", "http://example.com/", "X-Api-Key", "a")
+	get(cache, "GET", "http://example.com/", "X-Api-Key", "b")
+	assert.EqualValues(t, 6, balancer.calls.Load())
+	assert.Equal(t, Counters{Bypasses: 6}, cache.Counters())
+}
+
+func TestCachePartition(t *testing.T) {
+	cache, balancer, now := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=10, stale-while-revalidate=30")
+		ctx.Response.SetBodyString(ctx.UserValue("pool").(string))
+	})
+	cache.Partition(func(ctx *fasthttp.RequestCtx) string {
+		ctx.SetUserValue("pool", string(ctx.Request.Header.Peek("X-Pool")))
+		return ctx.UserValue("pool").(string)
+	})
+
+	assert.Equal(t, "canary", string(get(cache, "GET", "http://example.com/", "X-Pool", "canary").Response.Body()))
+	assert.Equal(t, "primary", string(get(cache, "GET", "http://example.com/", "X-Pool", "primary").Response.Body()))
+	assert.Equal(t, "canary", string(get(cache, "GET", "http://example.com/", "X-Pool", "canary").Response.Body()))
+	assert.EqualValues(t, 2, balancer.calls.Load())
+
+	// A revalidation in the background goes to the partition's own pool.
+	*now = now.Add(20 * time.Second)
+	get(cache, "GET", "http://example.com/", "X-Pool", "canary")
+	cache.refreshes.Wait()
+	assert.EqualValues(t, 3, balancer.calls.Load())
+	*now = now.Add(5 * time.Second)
+	assert.Equal(t, "canary", string(get(cache, "GET", "http://example.com/", "X-Pool", "canary").Response.Body()))
+	assert.Equal(t, 2, cache.Counters().Entries)
+}
+
+func TestCacheNotStored(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(ctx *fasthttp.RequestCtx)
+	}{
+		{"no-store", respond("no-store, max-age=60")},
+		{"private", respond("private, max-age=60")},
+		{"no freshness or validator", respond("")},
+		{"expired", respond("max-age=0")},
+		{"set-cookie", func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+			ctx.Response.Header.Set(fasthttp.HeaderSetCookie, "a=b")
+		}},
+		{"vary star", func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+			ctx.Response.Header.Set(fasthttp.HeaderVary, "*")
+		}},
+		{"uncacheable status", func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		}},
+		{"streamed", func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+			ctx.Response.SetBodyStreamWriter(func(*bufio.Writer) {})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, balancer, _ := newTestCache(tt.handler)
+			get(cache, "GET", "http://example.com/")
+			get(cache, "GET", "http://example.com/")
+			assert.EqualValues(t, 2, balancer.calls.Load())
+			assert.Equal(t, 0, cache.Counters().Entries)
+		})
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	cache, balancer, now := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderExpires, "Thu, 01 Jan 2026 00:01:00 GMT")
+	})
+
+	get(cache, "GET", "http://example.com/")
+	*now = now.Add(59 * time.Second)
+	get(cache, "GET", "http://example.com/")
+	assert.EqualValues(t, 1, balancer.calls.Load())
+	*now = now.Add(time.Second)
+	get(cache, "GET", "http://example.com/")
+	assert.EqualValues(t, 2, balancer.calls.Load())
+}
+
+func TestCacheVary(t *testing.T) {
+	cache, balancer, _ := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "accept-encoding")
+		ctx.Response.SetBody(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
+	})
+
+	for range 2 {
+		assert.Equal(t, "gzip", string(get(cache, "GET", "http://example.com/", fasthttp.HeaderAcceptEncoding, "gzip").Response.Body()))
+		assert.Equal(t, "br", string(get(cache, "GET", "http://example.com/", fasthttp.HeaderAcceptEncoding, "br").Response.Body()))
+		assert.Empty(t, get(cache, "GET", "http://example.com/").Response.Body())
+	}
+	assert.EqualValues(t, 3, balancer.calls.Load())
+	assert.Equal(t, 3, cache.Counters().Entries)
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	var conditional atomic.Value
+	cache, balancer, now := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		conditional.Store(string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)))
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=10")
+		ctx.Response.Header.Set(fasthttp.HeaderETag, `"v1"`)
+		if string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)) == `"v1"` {
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			return
+		}
+		ctx.Response.SetBodyString("v1")
+	})
+
+	get(cache, "GET", "http://example.com/")
+	*now = now.Add(11 * time.Second)
+
+	ctx := get(cache, "GET", "http://example.com/")
+	assert.Equal(t, `"v1"`, conditional.Load())
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "v1", string(ctx.Response.Body()))
+	assert.EqualValues(t, 2, balancer.calls.Load())
+
+	// The 304 made it fresh again.
+	*now = now.Add(5 * time.Second)
+	get(cache, "GET", "http://example.com/")
+	assert.EqualValues(t, 2, balancer.calls.Load())
+
+	// The client's own condition is answered from the cache.
+	ctx = get(cache, "GET", "http://example.com/", fasthttp.HeaderIfNoneMatch, `W/"v1"`)
+	assert.Equal(t, fasthttp.StatusNotModified, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Body())
+
+	// A client asking for no-cache has the cache revalidate first.
+	ctx = get(cache, "GET", "http://example.com/", fasthttp.HeaderCacheControl, "no-cache")
+	assert.Equal(t, "v1", string(ctx.Response.Body()))
+	assert.EqualValues(t, 3, balancer.calls.Load())
+}
+
+func TestCacheRevalidateLastModified(t *testing.T) {
+	const lastModified = "Wed, 31 Dec 2025 00:00:00 GMT"
+	cache, balancer, _ := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderLastModified, lastModified)
+		if string(ctx.Request.Header.Peek(fasthttp.HeaderIfModifiedSince)) == lastModified {
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			return
+		}
+		ctx.Response.SetBodyString("body")
+	})
+
+	// Stale from the start: every request revalidates.
+	for range 3 {
+		assert.Equal(t, "body", string(get(cache, "GET", "http://example.com/").Response.Body()))
+	}
+	assert.EqualValues(t, 3, balancer.calls.Load())
+
+	ctx := get(cache, "GET", "http://example.com/", fasthttp.HeaderIfModifiedSince, "Thu, 01 Jan 2026 00:00:00 GMT")
+	assert.Equal(t, fasthttp.StatusNotModified, ctx.Response.StatusCode())
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	cache, balancer, now := newTestCache(respond("max-age=10, stale-while-revalidate=30"))
+
+	get(cache, "GET", "http://example.com/")
+	*now = now.Add(20 * time.Second)
+	ctx := get(cache, "GET", "http://example.com/")
+	assert.Equal(t, "response 1", string(ctx.Response.Body()))
+	cache.refreshes.Wait()
+	assert.EqualValues(t, 2, balancer.calls.Load())
+
+	ctx = get(cache, "GET", "http://example.com/")
+	assert.Equal(t, "response 2", string(ctx.Response.Body()))
+	assert.Equal(t, uint64(2), cache.Counters().Hits)
+
+	// must-revalidate forbids serving stale at all.
+	cache, balancer, now = newTestCache(respond("max-age=10, stale-while-revalidate=30, must-revalidate"))
+	get(cache, "GET", "http://example.com/")
+	*now = now.Add(20 * time.Second)
+	ctx = get(cache, "GET", "http://example.com/")
+	assert.Equal(t, "response 2", string(ctx.Response.Body()))
+	assert.EqualValues(t, 2, balancer.calls.Load())
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache, balancer, _ := newTestCache(respond("max-age=60"))
+	get(cache, "GET", "http://example.com/a")
+	size := cache.Counters().Bytes
+	cache.maxSize = 2 * size
+
+	get(cache, "GET", "http://example.com/b")
+	get(cache, "GET", "http://example.com/a")
+	get(cache, "GET", "http://example.com/c")
+	assert.Equal(t, 2, cache.Counters().Entries)
+
+	// /b was least recently used.
+	get(cache, "GET", "http://example.com/a")
+	assert.EqualValues(t, 3, balancer.calls.Load())
+	get(cache, "GET", "http://example.com/b")
+	assert.EqualValues(t, 4, balancer.calls.Load())
+
+	cache.maxObjectSize = size - 1
+	get(cache, "GET", "http://example.com/d")
+	get(cache, "GET", "http://example.com/d")
+	assert.EqualValues(t, 6, balancer.calls.Load())
+}
+
+func TestCachePurge(t *testing.T) {
+	cache, _, _ := newTestCache(respond("max-age=60"))
+	for _, uri := range []string{"http://a.example.com/api/1", "http://a.example.com/api/2", "http://a.example.com/static", "http://b.example.com/api/1"} {
+		get(cache, "GET", uri)
+	}
+
+	assert.Equal(t, 2, cache.Purge("A.example.com", "/api"))
+	assert.Equal(t, 1, cache.Purge("", "/api"))
+	assert.Equal(t, 1, cache.Purge("", ""))
+	assert.Equal(t, Counters{Misses: 4}, cache.Counters())
+}
+
+func TestCacheHead(t *testing.T) {
+	cache, _, _ := newTestCache(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.Response.Header.SetContentLength(5)
+		ctx.Response.SkipBody = true
+	})
+
+	for range 2 {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(http.MethodHead)
+		ctx.Request.SetRequestURI("http://example.com/")
+		ctx.Response.SkipBody = true
+		cache.Serve()(ctx)
+		assert.Equal(t, 5, ctx.Response.Header.ContentLength())
+	}
+	assert.Equal(t, uint64(1), cache.Counters().Hits)
+}
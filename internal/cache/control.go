@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// cacheControl holds the Cache-Control directives the cache acts on. A
+// negative duration is a directive that was not sent.
+type cacheControl struct {
+	noStore              bool
+	noCache              bool
+	private              bool
+	mustRevalidate       bool
+	maxAge               time.Duration
+	sMaxAge              time.Duration
+	staleWhileRevalidate time.Duration
+}
+
+func parseCacheControl(value []byte) cacheControl {
+	cc := cacheControl{maxAge: -1, sMaxAge: -1, staleWhileRevalidate: -1}
+	for directive := range strings.SplitSeq(helper.B2S(value), ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "private":
+			// private="Set-Cookie" names fields a shared cache must not
+			// keep; the whole response is left alone rather than trimmed.
+			cc.private = true
+		case "must-revalidate", "proxy-revalidate":
+			cc.mustRevalidate = true
+		case "max-age":
+			cc.maxAge = parseSeconds(arg)
+		case "s-maxage":
+			cc.sMaxAge = parseSeconds(arg)
+		case "stale-while-revalidate":
+			cc.staleWhileRevalidate = parseSeconds(arg)
+		}
+	}
+	return cc
+}
+
+// parseSeconds reads a delta-seconds argument. One that does not parse is
+// taken as 0, which errs towards going back to the Backend.
+func parseSeconds(arg string) time.Duration {
+	n, err := strconv.ParseInt(strings.Trim(arg, `"`), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(min(n, int64(maxDelta/time.Second))) * time.Second
+}
+
+// maxDelta caps delta-seconds well short of overflowing a time.Duration.
+const maxDelta = 100 * 365 * 24 * time.Hour
+
+// freshness is how long a response stays fresh after the Backend sent it:
+// s-maxage, then max-age, then Expires. A response with
+// none of them, or with no-cache, is stale from the start and only served
+// after revalidation.
+func freshness(cc cacheControl, header *fasthttp.ResponseHeader, now time.Time) time.Duration {
+	switch {
+	case cc.noCache:
+		return 0
+	case cc.sMaxAge >= 0:
+		return cc.sMaxAge
+	case cc.maxAge >= 0:
+		return cc.maxAge
+	}
+	expires := header.Peek(fasthttp.HeaderExpires)
+	if len(expires) == 0 {
+		return 0
+	}
+	// An Expires that does not parse, such as 0, means already expired.
+	at, err := http.ParseTime(helper.B2S(expires))
+	if err != nil {
+		return 0
+	}
+	// fasthttp drops a Backend's Date, so Expires counts from arrival.
+	return max(at.Sub(now), 0)
+}
+
+// etagMatches reports whether an If-None-Match list names etag, compared
+// weakly as RFC 9110 asks of If-None-Match.
+func etagMatches(list []byte, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(helper.B2S(list), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl([]byte(`public, Max-Age=60, s-maxage="120", stale-while-revalidate=30, must-revalidate`))
+	assert.Equal(t, cacheControl{
+		mustRevalidate:       true,
+		maxAge:               time.Minute,
+		sMaxAge:              2 * time.Minute,
+		staleWhileRevalidate: 30 * time.Second,
+	}, cc)
+
+	cc = parseCacheControl([]byte(`no-store, no-cache, private="Set-Cookie", max-age=soon`))
+	assert.Equal(t, cacheControl{noStore: true, noCache: true, private: true, sMaxAge: -1, staleWhileRevalidate: -1}, cc)
+
+	assert.Equal(t, cacheControl{maxAge: -1, sMaxAge: -1, staleWhileRevalidate: -1}, parseCacheControl(nil))
+	assert.Equal(t, maxDelta, parseCacheControl([]byte("max-age=99999999999999999")).maxAge.Truncate(time.Second))
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		cacheControl string
+		expires      string
+		want         time.Duration
+	}{
+		{"s-maxage over max-age", "max-age=10, s-maxage=20", "", 20 * time.Second},
+		{"max-age over expires", "max-age=10", "Thu, 01 Jan 2026 01:00:00 GMT", 10 * time.Second},
+		{"no-cache", "no-cache, max-age=10", "", 0},
+		{"expires", "", "Thu, 01 Jan 2026 00:01:00 GMT", time.Minute},
+		{"expires in the past", "", "Wed, 31 Dec 2025 00:00:00 GMT", 0},
+		{"invalid expires", "", "0", 0},
+		{"nothing", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header fasthttp.ResponseHeader
+			for name, value := range map[string]string{fasthttp.HeaderCacheControl: tt.cacheControl, fasthttp.HeaderExpires: tt.expires} {
+				if value != "" {
+					header.Set(name, value)
+				}
+			}
+			assert.Equal(t, tt.want, freshness(parseCacheControl(header.Peek(fasthttp.HeaderCacheControl)), &header, now))
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches([]byte(`"a"`), `"a"`))
+	assert.True(t, etagMatches([]byte(`"b", W/"a"`), `"a"`))
+	assert.True(t, etagMatches([]byte(`"a"`), `W/"a"`))
+	assert.True(t, etagMatches([]byte(`*`), `"a"`))
+	assert.False(t, etagMatches([]byte(`"b"`), `"a"`))
+}
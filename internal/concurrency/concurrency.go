@@ -0,0 +1,186 @@
+// Package concurrency sheds the requests past an adaptive limit on those in
+// flight to Backends with 503, before they queue behind slow Backends.
+package concurrency
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// cpuSampleInterval is how long each reading of the host's CPU usage
+// averages over, and so how often one is taken.
+const cpuSampleInterval = time.Second
+
+const (
+	low = iota
+	normal
+	high
+)
+
+// priorities names the Shed counters.
+var priorities = [...]string{"low", "normal", "high"}
+
+// shares is how much of the limit each priority may fill: the last tenth is
+// kept for high priority requests, and low ones are shed past half.
+var shares = [...]float64{0.5, 0.9, 1}
+
+// Counters is what /stats reports about the limiter. Overloaded is true
+// while CPU usage is above cpu_percent.
+type Counters struct {
+	Limit      int               `json:"limit"`
+	InFlight   int64             `json:"in_flight"`
+	Admitted   uint64            `json:"admitted"`
+	Shed       map[string]uint64 `json:"shed"`
+	Overloaded bool              `json:"overloaded"`
+}
+
+// Limiter is a balancer that hands a request to the balancer it wraps while
+// fewer than its limit are in flight, and answers 503 otherwise.
+type Limiter struct {
+	types.IBalancer
+	limit      *limit
+	priority   config.ConcurrencyPriority
+	cpuPercent float64
+	now        func() time.Time
+	// stopSampling ends the CPU sampling New starts with cpu_percent set.
+	stopSampling context.CancelFunc
+
+	inFlight   atomic.Int64
+	overloaded atomic.Bool
+	admitted   atomic.Uint64
+	shed       [len(priorities)]atomic.Uint64
+}
+
+// New limits the requests in flight to balancer as cfg says; cfg has been
+// prepared. With cpu_percent set, it samples the host's CPU usage until
+// Shutdown.
+func New(cfg config.Concurrency, balancer types.IBalancer) *Limiter {
+	c := &Limiter{
+		IBalancer:    balancer,
+		limit:        newLimit(cfg),
+		priority:     cfg.Priority,
+		cpuPercent:   cfg.CPUPercent,
+		now:          time.Now,
+		stopSampling: func() {},
+	}
+	if c.cpuPercent > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopSampling = cancel
+		go c.sampleCPU(ctx)
+	}
+	return c
+}
+
+func (c *Limiter) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := c.IBalancer.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		priority := c.priorityOf(ctx)
+		inFlight, ok := c.acquire(priority)
+		if !ok {
+			c.shed[priority].Add(1)
+			overloaded(ctx)
+			return
+		}
+		defer c.inFlight.Add(-1)
+		c.admitted.Add(1)
+
+		start := c.now()
+		next(ctx)
+		now := c.now()
+		// The 503s of the queue and of no Alive Backend say nothing about
+		// how loaded the Backends are.
+		c.limit.sample(now, now.Sub(start), inFlight, proxy.BackendFailed(ctx))
+	}
+}
+
+// Shutdown stops sampling CPU usage, then shuts down the balancer it wraps.
+func (c *Limiter) Shutdown() error {
+	c.stopSampling()
+	return c.IBalancer.Shutdown()
+}
+
+// sampleCPU reads the host's CPU usage over each cpuSampleInterval until ctx
+// is done. It measures on its own, as gopsutil's readings without an interval
+// are relative to whichever call came last.
+func (c *Limiter) sampleCPU(ctx context.Context) {
+	for {
+		percent, err := cpu.PercentWithContext(ctx, cpuSampleInterval, false)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			zap.S().Errorf("Error while getting total cpu usage, cpu_percent is ignored, err: %v", err)
+			return
+		}
+		c.setCPUPercent(percent[0])
+	}
+}
+
+// setCPUPercent tells the limiter the host's current CPU usage; above
+// cpu_percent only high priority requests are let through.
+func (c *Limiter) setCPUPercent(percent float64) {
+	c.overloaded.Store(c.cpuPercent > 0 && percent > c.cpuPercent)
+}
+
+func (c *Limiter) Counters() *Counters {
+	shed := make(map[string]uint64, len(priorities))
+	for i, priority := range priorities {
+		shed[priority] = c.shed[i].Load()
+	}
+	return &Counters{
+		Limit:      c.limit.get(),
+		InFlight:   c.inFlight.Load(),
+		Admitted:   c.admitted.Load(),
+		Shed:       shed,
+		Overloaded: c.overloaded.Load(),
+	}
+}
+
+// acquire counts a request of priority in flight, returning how many are
+// now, unless its share of the limit is used up.
+func (c *Limiter) acquire(priority int) (int, bool) {
+	if priority < high && c.overloaded.Load() {
+		return 0, false
+	}
+	bound := max(int64(float64(c.limit.get())*shares[priority]), 1)
+	for {
+		n := c.inFlight.Load()
+		if n >= bound {
+			return 0, false
+		}
+		if c.inFlight.CompareAndSwap(n, n+1) {
+			return int(n + 1), true
+		}
+	}
+}
+
+func (c *Limiter) priorityOf(ctx *fasthttp.RequestCtx) int {
+	if c.priority.Header == "" {
+		return normal
+	}
+	value := helper.B2S(ctx.Request.Header.Peek(c.priority.Header))
+	switch {
+	case slices.Contains(c.priority.High, value):
+		return high
+	case slices.Contains(c.priority.Low, value):
+		return low
+	}
+	return normal
+}
+
+// overloaded answers a request shed to keep Backends within the limit.
+func overloaded(ctx *fasthttp.RequestCtx) {
+	ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.Response.SetBodyString(`{"message":"server overloaded"}`)
+}
@@ -0,0 +1,130 @@
+package concurrency
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// blockingBalancer holds every request until release is closed.
+type blockingBalancer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		b.started <- struct{}{}
+		<-b.release
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	}
+}
+func (b *blockingBalancer) Stats() []types.ProxyStat { return nil }
+func (b *blockingBalancer) Shutdown() error          { return nil }
+
+// statusBalancer answers every request with status, as divisor does itself
+// when no Backend is Alive.
+type statusBalancer int
+
+func (s statusBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(int(s))
+	}
+}
+func (s statusBalancer) Stats() []types.ProxyStat { return nil }
+func (s statusBalancer) Shutdown() error          { return nil }
+
+func newTestLimiter(cfg config.Concurrency) (*Limiter, *blockingBalancer) {
+	cfg.Enabled = true
+	cfg.Algorithm = config.ConcurrencyAIMD
+	cfg.InitialLimit, cfg.MinLimit, cfg.MaxLimit = 10, 10, 10
+	backend := &blockingBalancer{started: make(chan struct{}, 100), release: make(chan struct{})}
+	return New(cfg, backend), backend
+}
+
+func serve(c *Limiter, priority string) int {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	if priority != "" {
+		ctx.Request.Header.Set("X-Priority", priority)
+	}
+	c.Serve()(ctx)
+	return ctx.Response.StatusCode()
+}
+
+// fill sends n requests that stay in flight until backend releases them.
+func fill(c *Limiter, backend *blockingBalancer, n int, priority string, wg *sync.WaitGroup) {
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(c, priority)
+		}()
+		<-backend.started
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("sheds past the limit", func(t *testing.T) {
+		c, backend := newTestLimiter(config.Concurrency{})
+		var wg sync.WaitGroup
+		fill(c, backend, 9, "", &wg)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, serve(c, ""), "the last tenth is kept for high priority")
+		close(backend.release)
+		wg.Wait()
+
+		counters := c.Counters()
+		assert.Equal(t, int64(0), counters.InFlight)
+		assert.Equal(t, uint64(9), counters.Admitted)
+		assert.Equal(t, uint64(1), counters.Shed["normal"])
+		assert.Equal(t, fasthttp.StatusOK, serve(c, ""))
+	})
+
+	t.Run("sheds low priority first and high priority last", func(t *testing.T) {
+		c, backend := newTestLimiter(config.Concurrency{Priority: config.ConcurrencyPriority{
+			Header: "X-Priority", High: []string{"health"}, Low: []string{"batch"},
+		}})
+		var wg sync.WaitGroup
+		fill(c, backend, 5, "", &wg)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, serve(c, "batch"))
+		fill(c, backend, 4, "", &wg)
+		fill(c, backend, 1, "health", &wg)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, serve(c, "health"))
+		close(backend.release)
+		wg.Wait()
+
+		shed := c.Counters().Shed
+		assert.Equal(t, map[string]uint64{"low": 1, "normal": 0, "high": 1}, shed)
+	})
+
+	t.Run("divisor's own 503s leave the limit alone", func(t *testing.T) {
+		c := New(config.Concurrency{
+			Enabled: true, Algorithm: config.ConcurrencyAIMD, InitialLimit: 10, MinLimit: 1, MaxLimit: 20, LatencyThreshold: time.Second,
+		}, statusBalancer(fasthttp.StatusServiceUnavailable))
+		for range 5 {
+			assert.Equal(t, fasthttp.StatusServiceUnavailable, serve(c, ""))
+		}
+		assert.Equal(t, 10, c.Counters().Limit)
+	})
+
+	t.Run("sheds all but high priority above cpu_percent", func(t *testing.T) {
+		c, backend := newTestLimiter(config.Concurrency{CPUPercent: 80, Priority: config.ConcurrencyPriority{
+			Header: "X-Priority", High: []string{"health"},
+		}})
+		close(backend.release)
+		// The test feeds the CPU usage instead of the host.
+		assert.NoError(t, c.Shutdown())
+
+		c.setCPUPercent(95)
+		assert.True(t, c.Counters().Overloaded)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, serve(c, ""))
+		assert.Equal(t, fasthttp.StatusOK, serve(c, "health"))
+		c.setCPUPercent(50)
+		assert.Equal(t, fasthttp.StatusOK, serve(c, ""))
+	})
+}
@@ -0,0 +1,119 @@
+package concurrency
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+)
+
+const (
+	// gradient follows Netflix's Gradient2: the limit shrinks as the recent
+	// latency rises above the long-term one, by at most half per window,
+	// and grows by a queue allowance while latency holds.
+	gradientTolerance = 1.5
+	gradientSmoothing = 0.2
+	gradientQueue     = 4
+	// longWindows is how many windows the long-term latency averages over.
+	longWindows = 600
+	// A window closes once it is both this long and this full.
+	windowDuration   = 100 * time.Millisecond
+	windowMinSamples = 10
+
+	// aimd shrinks the limit by this factor on a sign of overload.
+	aimdBackoff = 0.9
+)
+
+// limit is the adaptive concurrency limit, fed with the latency of every
+// response the Limiter admitted.
+type limit struct {
+	mu        sync.Mutex
+	value     float64
+	min, max  float64
+	gradient  bool
+	threshold time.Duration
+
+	// gradient's window and latencies, in nanoseconds.
+	windowStart time.Time
+	samples     int
+	sum         float64
+	maxInFlight int
+	longRtt     float64
+	windows     int
+}
+
+func newLimit(cfg config.Concurrency) *limit {
+	return &limit{
+		value:     float64(cfg.InitialLimit),
+		min:       float64(cfg.MinLimit),
+		max:       float64(cfg.MaxLimit),
+		gradient:  cfg.Algorithm == config.ConcurrencyGradient,
+		threshold: cfg.LatencyThreshold,
+	}
+}
+
+func (l *limit) get() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.value)
+}
+
+// sample records one response: how long it took, how many requests were in
+// flight when it was sent, and whether its Backend failed it.
+func (l *limit) sample(now time.Time, rtt time.Duration, inFlight int, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gradient {
+		l.sampleGradient(now, rtt, inFlight)
+	} else {
+		l.sampleAIMD(rtt, inFlight, dropped)
+	}
+}
+
+// sampleAIMD backs off on a slow or failed response, and otherwise adds one
+// while at least half the limit is in use.
+func (l *limit) sampleAIMD(rtt time.Duration, inFlight int, dropped bool) {
+	switch {
+	case dropped || rtt > l.threshold:
+		l.value = max(l.min, l.value*aimdBackoff)
+	case float64(inFlight)*2 >= l.value:
+		l.value = min(l.max, l.value+1)
+	}
+}
+
+// sampleGradient averages the responses of a window, then compares that
+// short-term latency with the long-term one.
+func (l *limit) sampleGradient(now time.Time, rtt time.Duration, inFlight int) {
+	if l.samples == 0 {
+		l.windowStart = now
+	}
+	l.samples++
+	l.sum += float64(rtt)
+	l.maxInFlight = max(l.maxInFlight, inFlight)
+	if l.samples < windowMinSamples || now.Sub(l.windowStart) < windowDuration {
+		return
+	}
+	shortRtt := max(l.sum/float64(l.samples), 1)
+	inUse := l.maxInFlight
+	l.samples, l.sum, l.maxInFlight = 0, 0, 0
+
+	// The long-term latency is a plain average until it has enough windows,
+	// then an exponential one; when latency drops for good it follows
+	// faster, so the limit is not held down by a past spike.
+	l.windows = min(l.windows+1, longWindows)
+	l.longRtt += (shortRtt - l.longRtt) / float64(l.windows)
+	if l.longRtt/shortRtt > 2 { //nolint:mnd
+		l.longRtt *= 0.95 //nolint:mnd
+	}
+
+	// A limit the traffic does not come near says nothing about the
+	// Backends; growing it would only let a burst through unchecked.
+	if float64(inUse) < l.value/2 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, gradientTolerance*l.longRtt/shortRtt)) //nolint:mnd
+	next := l.value*gradient + gradientQueue
+	next = l.value*(1-gradientSmoothing) + next*gradientSmoothing
+	l.value = max(l.min, min(l.max, next))
+}
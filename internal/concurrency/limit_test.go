@@ -0,0 +1,74 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimit(algorithm string) *limit {
+	return newLimit(config.Concurrency{
+		Algorithm:        algorithm,
+		InitialLimit:     20,
+		MinLimit:         4,
+		MaxLimit:         100,
+		LatencyThreshold: time.Second,
+	})
+}
+
+// feed samples windows of responses taking rtt each, with inFlight requests
+// in flight, and returns the limit.
+func feed(l *limit, now *time.Time, windows int, rtt time.Duration, inFlight int) int {
+	for range windows {
+		for range windowMinSamples {
+			l.sample(*now, rtt, inFlight, false)
+		}
+		*now = now.Add(windowDuration)
+		l.sample(*now, rtt, inFlight, false)
+	}
+	return l.get()
+}
+
+func TestAIMD(t *testing.T) {
+	l := newTestLimit(config.ConcurrencyAIMD)
+	now := time.Now()
+
+	l.sample(now, time.Millisecond, 5, false)
+	assert.Equal(t, 20, l.get(), "under half the limit in use")
+	l.sample(now, time.Millisecond, 10, false)
+	assert.Equal(t, 21, l.get())
+
+	l.sample(now, 2*time.Second, 10, false)
+	assert.Equal(t, 18, l.get(), "slower than latency_threshold")
+	l.sample(now, time.Millisecond, 10, true)
+	assert.Equal(t, 17, l.get(), "a 502, 503 or 504")
+
+	for range 100 {
+		l.sample(now, time.Millisecond, 100, false)
+	}
+	assert.Equal(t, 100, l.get())
+	for range 100 {
+		l.sample(now, time.Millisecond, 100, true)
+	}
+	assert.Equal(t, 4, l.get())
+}
+
+func TestGradient(t *testing.T) {
+	l := newTestLimit(config.ConcurrencyGradient)
+	now := time.Now()
+
+	assert.Equal(t, 20, feed(l, &now, 50, 10*time.Millisecond, 5), "app limited")
+	grown := feed(l, &now, 50, 10*time.Millisecond, 20)
+	assert.Greater(t, grown, 20, "latency holds while the limit is in use")
+
+	slowed := feed(l, &now, 5, 100*time.Millisecond, grown)
+	assert.Less(t, slowed, grown, "latency rose tenfold")
+	// Shrinking by a tenth per window at most, with the queue allowance added
+	// back, the limit settles at twice the allowance.
+	floor := feed(l, &now, 30, time.Second, 100)
+	assert.Less(t, floor, slowed, "latency rose a hundredfold")
+	assert.LessOrEqual(t, floor, 2*gradientQueue+1)
+	assert.Greater(t, feed(l, &now, 50, 10*time.Millisecond, 100), floor, "latency recovered")
+}
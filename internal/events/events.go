@@ -0,0 +1,96 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Type string
+
+const (
+	// BackendDown: a Probe failed and the Backend left the rotation.
+	BackendDown Type = "BackendDown"
+	// BackendRejoin: a Down Backend passed its Probe and is back in rotation.
+	BackendRejoin Type = "BackendRejoin"
+	// BackendDegraded: a Probe reported Degraded and the Backend's share was reduced.
+	BackendDegraded Type = "BackendDegraded"
+	// AllBackendsDown: the last Backend of a pool left the rotation; requests
+	// for the pool get 503.
+	AllBackendsDown Type = "AllBackendsDown"
+	// ConfigReloaded: the access control files were read again and are in
+	// use; divisor reloads no other configuration.
+	ConfigReloaded Type = "ConfigReloaded"
+)
+
+var Types = []Type{BackendDown, BackendRejoin, BackendDegraded, AllBackendsDown, ConfigReloaded}
+
+// A subscriber that falls this far behind starts losing events rather than
+// stalling the health checker that publishes them.
+const subscriberBuffer = 64
+
+type Event struct {
+	Type Type `json:"type"`
+	// Pool names the split or mirror pool the Backend belongs to; empty for
+	// the Backends under backends.
+	Pool    string    `json:"pool,omitempty"`
+	Backend string    `json:"backend,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Bus fans events out to every subscriber. Publish never blocks.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// defaultBus is the bus the balancers publish health transitions to.
+var defaultBus = NewBus()
+
+func Publish(e Event) {
+	defaultBus.Publish(e)
+}
+
+func Subscribe() (<-chan Event, func()) {
+	return defaultBus.Subscribe()
+}
+
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			zap.S().Warnf("Event subscriber is falling behind, dropping %s event", e.Type)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a func that unsubscribes and closes it.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
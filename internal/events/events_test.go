@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	bus.Publish(Event{Type: BackendDown, Backend: "localhost:8080"}) // nobody listening
+
+	first, unsubscribeFirst := bus.Subscribe()
+	second, unsubscribeSecond := bus.Subscribe()
+	defer unsubscribeSecond()
+
+	bus.Publish(Event{Type: BackendDown, Backend: "localhost:8080"})
+	for _, ch := range []<-chan Event{first, second} {
+		e := <-ch
+		assert.Equal(t, BackendDown, e.Type)
+		assert.Equal(t, "localhost:8080", e.Backend)
+		assert.WithinDuration(t, time.Now(), e.Time, time.Second)
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst() // idempotent
+	_, open := <-first
+	assert.False(t, open, "unsubscribe closes the channel")
+
+	bus.Publish(Event{Type: AllBackendsDown})
+	assert.Equal(t, AllBackendsDown, (<-second).Type)
+}
+
+func TestBusDropsForSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range subscriberBuffer + 10 {
+			bus.Publish(Event{Type: BackendRejoin})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that is not reading")
+	}
+	assert.Len(t, ch, subscriberBuffer)
+}
+
+func TestTypesMatchConfig(t *testing.T) {
+	names := make([]string, 0, len(Types))
+	for _, typ := range Types {
+		names = append(names, string(typ))
+	}
+	assert.Equal(t, config.ValidWebhookEvents, names, "webhooks must be able to subscribe to every event")
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// Idle proxies and load balancers drop silent connections; a comment line
+// keeps the stream open and notices a client that went away.
+const sseKeepalive = 15 * time.Second
+
+// ServeSSE streams the events published on the default bus as Server-Sent
+// Events until the client disconnects.
+func ServeSSE(ctx *fasthttp.RequestCtx) {
+	defaultBus.ServeSSE(ctx)
+}
+
+func (b *Bus) ServeSSE(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	// Subscribe inside the writer: fasthttp may never call it when the
+	// connection is already gone, and the subscription would leak.
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		ch, unsubscribe := b.Subscribe()
+		defer unsubscribe()
+
+		// Flush the headers right away so the client sees the stream open
+		// before the first event.
+		w.WriteString(": connected\n\n") //nolint:errcheck
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(sseKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case e := <-ch:
+				data, err := json.Marshal(e)
+				if err != nil {
+					zap.S().Errorf("Error while encoding %s event, err: %v", e.Type, err)
+					continue
+				}
+				w.WriteString("event: " + string(e.Type) + "\ndata: ") //nolint:errcheck
+				w.Write(data)                                          //nolint:errcheck
+				w.WriteString("\n\n")                                  //nolint:errcheck
+			case <-ticker.C:
+				w.WriteString(": keepalive\n\n") //nolint:errcheck
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
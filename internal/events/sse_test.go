@@ -0,0 +1,63 @@
+package events
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestServeSSE(t *testing.T) {
+	bus := NewBus()
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, bus.ServeSSE) //nolint:errcheck
+
+	conn, err := ln.Dial()
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: divisor\r\n\r\n"))
+	assert.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	var resp fasthttp.Response
+	resp.SkipBody = true
+	assert.NoError(t, resp.Header.Read(r))
+	assert.Equal(t, "text/event-stream", string(resp.Header.ContentType()))
+
+	// The stream is open once the connected comment arrives; only then is
+	// the writer subscribed.
+	assert.Contains(t, readFrame(t, r), ": connected")
+
+	bus.Publish(Event{Type: BackendDown, Backend: "localhost:8080"})
+	frame := readFrame(t, r)
+	assert.Contains(t, frame, "event: BackendDown\n")
+	assert.Contains(t, frame, `"backend":"localhost:8080"`)
+}
+
+// readFrame reads one SSE frame out of the chunked body.
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var frame strings.Builder
+	for !strings.HasSuffix(frame.String(), "\n\n") {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("timed out waiting for an SSE frame")
+			}
+			t.Fatal(err)
+		}
+		// Skip the chunk-size lines of the chunked transfer encoding.
+		if strings.HasSuffix(line, "\r\n") {
+			continue
+		}
+		frame.WriteString(line)
+	}
+	return frame.String()
+}
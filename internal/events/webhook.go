@@ -0,0 +1,99 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const webhookFirstBackoff = time.Second
+
+// Webhook POSTs each event as JSON to one url, retrying with exponential
+// backoff. Deliveries are sequential, so a receiver sees events in order.
+type Webhook struct {
+	client      *fasthttp.Client
+	url         string
+	types       []Type
+	maxAttempts int
+	timeout     time.Duration
+	backoff     time.Duration
+}
+
+// NewWebhook returns a sink for cfg.Url; cfg has been prepared. An empty
+// Events list delivers every event.
+func NewWebhook(cfg config.Webhook) *Webhook {
+	types := make([]Type, 0, len(cfg.Events))
+	for _, t := range cfg.Events {
+		types = append(types, Type(t))
+	}
+	return &Webhook{
+		client:      &fasthttp.Client{},
+		url:         cfg.Url,
+		types:       types,
+		maxAttempts: cfg.MaxAttempts,
+		timeout:     cfg.Timeout,
+		backoff:     webhookFirstBackoff,
+	}
+}
+
+// Start subscribes the sink to the default bus and delivers in the background.
+func (w *Webhook) Start() {
+	ch, _ := Subscribe()
+	go w.run(ch)
+}
+
+func (w *Webhook) run(ch <-chan Event) {
+	for e := range ch {
+		if len(w.types) == 0 || slices.Contains(w.types, e.Type) {
+			w.deliver(e)
+		}
+	}
+}
+
+func (w *Webhook) deliver(e Event) bool {
+	body, err := json.Marshal(e)
+	if err != nil {
+		zap.S().Errorf("Error while encoding %s event, err: %v", e.Type, err)
+		return false
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(body)
+		if err == nil {
+			return true
+		}
+		if attempt >= w.maxAttempts {
+			zap.S().Errorf("Giving up on %s event for webhook %s after %d attempts, err: %v", e.Type, w.url, attempt, err)
+			return false
+		}
+		zap.S().Warnf("Webhook %s failed, retrying in %v, err: %v", w.url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(w.url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBodyRaw(body)
+
+	if err := w.client.DoTimeout(req, resp, w.timeout); err != nil {
+		return err
+	}
+	if status := resp.StatusCode(); status < 200 || status >= 300 {
+		return fmt.Errorf("webhook answered %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		var e Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		received <- e
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(config.Webhook{Url: srv.URL, MaxAttempts: 3, Timeout: time.Second})
+	hook.backoff = time.Millisecond
+
+	assert.True(t, hook.deliver(Event{Type: BackendDown, Backend: "localhost:8080", Time: time.Now()}))
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, "localhost:8080", (<-received).Backend)
+
+	calls.Store(0)
+	hook.maxAttempts = 2
+	assert.False(t, hook.deliver(Event{Type: BackendDown}), "gives up after max_attempts")
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestWebhookFiltersTypes(t *testing.T) {
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		json.NewDecoder(r.Body).Decode(&e) //nolint:errcheck
+		received <- string(e.Type)
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(config.Webhook{Url: srv.URL, Events: []string{"AllBackendsDown"}, MaxAttempts: 1, Timeout: time.Second})
+	ch := make(chan Event, 2)
+	ch <- Event{Type: BackendDown}
+	ch <- Event{Type: AllBackendsDown}
+	close(ch)
+	hook.run(ch)
+
+	assert.Equal(t, string(AllBackendsDown), <-received)
+	assert.Empty(t, received)
+}
@@ -0,0 +1,137 @@
+// Package mirror copies a share of the requests the balancer serves to a
+// shadow pool of Backends, whose responses are dropped: the client only
+// ever gets the balancer's answer.
+package mirror
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// maxInFlight bounds the copies waiting on the shadow pool; past it copies
+// are dropped rather than piling up behind a slow pool.
+const maxInFlight = 1024
+
+// statusClasses names the Responses counters.
+var statusClasses = [...]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// Counters is what /stats reports about the mirror. Responses counts the
+// shadow pool's answers by status class; divisor's own 502 and 504 for a
+// shadow Backend that failed are counted as 5xx. Dropped copies were never
+// sent.
+type Counters struct {
+	Sent      uint64            `json:"sent"`
+	Dropped   uint64            `json:"dropped"`
+	Responses map[string]uint64 `json:"responses"`
+	Backends  []types.ProxyStat `json:"backends"`
+}
+
+// Mirror is a balancer that serves every request through the balancer it
+// wraps and sends a copy of the matching ones to the shadow pool.
+type Mirror struct {
+	types.IBalancer
+	shadow  types.IBalancer
+	percent float64
+	methods []string
+	paths   []string
+
+	inFlight  chan struct{}
+	copies    sync.WaitGroup
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	responses [len(statusClasses)]atomic.Uint64
+}
+
+func New(cfg config.Mirror, balancer, shadow types.IBalancer) *Mirror {
+	return &Mirror{
+		IBalancer: balancer,
+		shadow:    shadow,
+		percent:   cfg.Percent,
+		methods:   cfg.Methods,
+		paths:     cfg.Paths,
+		inFlight:  make(chan struct{}, maxInFlight),
+	}
+}
+
+func (m *Mirror) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := m.IBalancer.Serve()
+	shadow := m.shadow.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		if m.matches(ctx) {
+			// Copied before the balancer rewrites the request for its
+			// Backend, and with a body of its own.
+			m.send(proxy.CopyRequestCtx(ctx), shadow)
+		}
+		next(ctx)
+	}
+}
+
+// Shutdown lets copies in flight finish before the shadow pool is shut
+// down, then shuts down the balancer it wraps.
+func (m *Mirror) Shutdown() error {
+	m.copies.Wait()
+	if err := m.shadow.Shutdown(); err != nil {
+		return err
+	}
+	return m.IBalancer.Shutdown()
+}
+
+func (m *Mirror) Counters() Counters {
+	responses := make(map[string]uint64, len(statusClasses))
+	for i, class := range statusClasses {
+		responses[class] = m.responses[i].Load()
+	}
+	return Counters{
+		Sent:      m.sent.Load(),
+		Dropped:   m.dropped.Load(),
+		Responses: responses,
+		Backends:  m.shadow.Stats(),
+	}
+}
+
+// matches reports whether ctx is one of the requests to copy: its method and
+// path match, and it falls within the percentage.
+func (m *Mirror) matches(ctx *fasthttp.RequestCtx) bool {
+	if ctx.Request.IsBodyStream() || proxy.IsWebSocketUpgrade(&ctx.Request) {
+		return false
+	}
+	if len(m.methods) > 0 && !slices.Contains(m.methods, helper.B2S(ctx.Method())) {
+		return false
+	}
+	if len(m.paths) > 0 && !slices.ContainsFunc(m.paths, func(prefix string) bool {
+		return strings.HasPrefix(helper.B2S(ctx.Path()), prefix)
+	}) {
+		return false
+	}
+	return m.percent >= 100 || rand.Float64()*100 < m.percent //nolint:gosec,mnd
+}
+
+// send serves the copy through the shadow pool in the background, or drops
+// it when too many copies are already waiting.
+func (m *Mirror) send(copied *fasthttp.RequestCtx, shadow func(*fasthttp.RequestCtx)) {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		m.dropped.Add(1)
+		return
+	}
+	m.sent.Add(1)
+	m.copies.Add(1)
+	go func() {
+		defer m.copies.Done()
+		defer func() { <-m.inFlight }()
+		shadow(copied)
+		if class := copied.Response.StatusCode()/100 - 1; class >= 0 && class < len(m.responses) { //nolint:mnd
+			m.responses[class].Add(1)
+		}
+	}()
+}
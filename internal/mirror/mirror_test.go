@@ -0,0 +1,137 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type stubBalancer struct {
+	handler func(ctx *fasthttp.RequestCtx)
+}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) { return s.handler }
+func (s *stubBalancer) Stats() []types.ProxyStat {
+	return []types.ProxyStat{{Addr: "shadow:8080"}}
+}
+func (s *stubBalancer) Shutdown() error { return nil }
+
+// seen records the requests a shadow pool received.
+type seen struct {
+	method, uri, body string
+}
+
+func shadowPool(status int) (*stubBalancer, chan seen) {
+	requests := make(chan seen, 16)
+	return &stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+		requests <- seen{string(ctx.Method()), string(ctx.RequestURI()), string(ctx.Request.Body())}
+		ctx.SetStatusCode(status)
+	}}, requests
+}
+
+func serve(m *Mirror, method, uri, body string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBodyString(body)
+	m.Serve()(ctx)
+	return ctx
+}
+
+// primary answers the client and, as a Backend's rewrites would, changes the
+// request under the copy.
+var primary = &stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+	ctx.Request.SetRequestURI("/rewritten")
+	copy(ctx.Request.Body(), "XXXX")
+	ctx.SetBodyString("primary")
+}}
+
+func TestMirror(t *testing.T) {
+	shadow, requests := shadowPool(fasthttp.StatusOK)
+	m := New(config.Mirror{Enabled: true, Percent: 100}, primary, shadow)
+
+	ctx := serve(m, fasthttp.MethodPost, "/orders?id=1", "order body")
+	assert.Equal(t, "primary", string(ctx.Response.Body()))
+
+	select {
+	case r := <-requests:
+		assert.Equal(t, seen{fasthttp.MethodPost, "/orders?id=1", "order body"}, r)
+	case <-time.After(time.Second):
+		t.Fatal("the shadow pool never got the copy")
+	}
+	assert.Nil(t, m.Shutdown())
+
+	counters := m.Counters()
+	assert.Equal(t, uint64(1), counters.Sent)
+	assert.Equal(t, uint64(1), counters.Responses["2xx"])
+	assert.Equal(t, uint64(0), counters.Responses["5xx"])
+	assert.Equal(t, []types.ProxyStat{{Addr: "shadow:8080"}}, counters.Backends)
+}
+
+func TestMirrorDoesNotWait(t *testing.T) {
+	release := make(chan struct{})
+	shadow := &stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+		<-release
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}}
+	m := New(config.Mirror{Enabled: true, Percent: 100}, primary, shadow)
+
+	done := make(chan struct{})
+	go func() {
+		serve(m, fasthttp.MethodGet, "/", "")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the client waited on the mirror")
+	}
+
+	close(release)
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, uint64(1), m.Counters().Responses["5xx"])
+}
+
+func TestMirrorDropsPastInFlight(t *testing.T) {
+	release := make(chan struct{})
+	shadow := &stubBalancer{handler: func(*fasthttp.RequestCtx) { <-release }}
+	m := New(config.Mirror{Enabled: true, Percent: 100}, primary, shadow)
+	m.inFlight = make(chan struct{}, 1)
+
+	serve(m, fasthttp.MethodGet, "/", "")
+	serve(m, fasthttp.MethodGet, "/", "")
+	close(release)
+	assert.Nil(t, m.Shutdown())
+
+	counters := m.Counters()
+	assert.Equal(t, uint64(1), counters.Sent)
+	assert.Equal(t, uint64(1), counters.Dropped)
+}
+
+func TestMirrorMatches(t *testing.T) {
+	m := New(config.Mirror{Enabled: true, Percent: 100, Methods: []string{"GET"}, Paths: []string{"/api/", "/search"}}, primary, primary)
+
+	match := func(method, uri string) bool {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		return m.matches(ctx)
+	}
+	assert.True(t, match(fasthttp.MethodGet, "/api/orders"))
+	assert.True(t, match(fasthttp.MethodGet, "/search?q=x"))
+	assert.False(t, match(fasthttp.MethodPost, "/api/orders"))
+	assert.False(t, match(fasthttp.MethodGet, "/admin"))
+
+	ws := &fasthttp.RequestCtx{}
+	ws.Request.SetRequestURI("/api/live")
+	ws.Request.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	ws.Request.Header.Set(fasthttp.HeaderUpgrade, "websocket")
+	assert.False(t, m.matches(ws))
+
+	m.percent = 0.000001
+	assert.False(t, match(fasthttp.MethodGet, "/api/orders"))
+}
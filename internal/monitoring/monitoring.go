@@ -1,13 +1,26 @@
 package monitoring
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/access"
+	"github.com/aaydin-tr/divisor/internal/auth"
+	"github.com/aaydin-tr/divisor/internal/cache"
+	"github.com/aaydin-tr/divisor/internal/concurrency"
+	"github.com/aaydin-tr/divisor/internal/events"
+	"github.com/aaydin-tr/divisor/internal/mirror"
+	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/internal/ratelimit"
+	"github.com/aaydin-tr/divisor/internal/split"
 	"github.com/fasthttp/router"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shirou/gopsutil/v4/cpu"
@@ -20,11 +33,20 @@ import (
 )
 
 type Monitoring struct {
-	Backends            []types.ProxyStat `json:"backends"`
-	Memory              MemStats          `json:"memory"`
-	Cpu                 CPUStats          `json:"cpu"`
-	TotalGoroutine      int               `json:"total_goroutine"`
-	OpenConnectionCount int32             `json:"open_conn_count"`
+	Backends            []types.ProxyStat     `json:"backends"`
+	Memory              MemStats              `json:"memory"`
+	Cpu                 CPUStats              `json:"cpu"`
+	TotalGoroutine      int                   `json:"total_goroutine"`
+	OpenConnectionCount int32                 `json:"open_conn_count"`
+	Cache               *cache.Counters       `json:"cache,omitempty"`
+	Hedges              proxy.HedgeStats      `json:"hedges"`
+	Queue               proxy.QueueStats      `json:"queue"`
+	Mirror              *mirror.Counters      `json:"mirror,omitempty"`
+	Split               []split.PoolStats     `json:"split,omitempty"`
+	RateLimits          []ratelimit.Counters  `json:"rate_limits,omitempty"`
+	Concurrency         *concurrency.Counters `json:"concurrency,omitempty"`
+	AccessControl       []access.Counters     `json:"access_control,omitempty"`
+	Auth                []auth.Counters       `json:"auth,omitempty"`
 }
 
 type CPUStats struct {
@@ -88,17 +110,54 @@ func getServerStats(server OpenConnectionsCounter, proxiesStats []types.ProxySta
 
 	monitoring.OpenConnectionCount = server.OpenConnectionsCount()
 	monitoring.Backends = proxiesStats
+	monitoring.Hedges = proxy.Hedges()
+	monitoring.Queue = proxy.Queued()
 
 	return monitoring
 }
 
-func StartMonitoringServer(server OpenConnectionsCounter, proxies types.IBalancer, addr string) {
+// Options is what the monitoring server reports on and acts on. Cache,
+// Mirror, Split, RateLimiter, Concurrency, Access and Auth are nil unless
+// their feature is configured.
+type Options struct {
+	Server      OpenConnectionsCounter
+	Balancer    types.IBalancer
+	Cache       *cache.Cache
+	Mirror      *mirror.Mirror
+	Split       *split.Split
+	RateLimiter *ratelimit.RateLimiter
+	Concurrency *concurrency.Limiter
+	Access      *access.Access
+	Auth        *auth.Auth
+	Addr        string
+	// AdminToken is the bearer token POST requests must carry; without one
+	// they are refused.
+	AdminToken string
+}
+
+// stats takes what /stats reports and Prometheus is fed.
+func (o *Options) stats() Monitoring {
+	m := getServerStats(o.Server, o.Balancer.Stats())
+	m.Cache = cacheCounters(o.Cache)
+	m.Mirror = mirrorCounters(o.Mirror)
+	m.Split = splitPools(o.Split)
+	m.RateLimits = rateLimitCounters(o.RateLimiter)
+	if o.Concurrency != nil {
+		m.Concurrency = o.Concurrency.Counters()
+	}
+	m.AccessControl = accessCounters(o.Access)
+	m.Auth = authCounters(o.Auth)
+	return m
+}
+
+// StartMonitoringServer serves the monitoring endpoints on opts.Addr.
+func StartMonitoringServer(opts Options) {
 	const sleepDuration = 5 * time.Second
 	r := router.New()
 	init_prometheus()
 	go func() {
 		for {
-			stats := getServerStats(server, proxies.Stats())
+			stats := opts.stats()
 			updatePrometheusMetrics(&stats)
 			time.Sleep(sleepDuration)
 		}
@@ -111,8 +170,7 @@ func StartMonitoringServer(server OpenConnectionsCounter, proxies types.IBalance
 
 	r.GET("/stats", func(ctx *fasthttp.RequestCtx) {
 		ctx.Response.Header.Set("Content-Type", "application/json")
-		m := getServerStats(server, proxies.Stats())
-		by, err := json.Marshal(m)
+		by, err := json.Marshal(opts.stats())
 		if err != nil {
 			zap.S().Errorf("Error while parsing json, err: %v", err)
 			return
@@ -121,8 +179,30 @@ func StartMonitoringServer(server OpenConnectionsCounter, proxies types.IBalance
 		ctx.Response.SetBodyRaw(by)
 	})
 
+	r.GET("/ready", func(ctx *fasthttp.RequestCtx) {
+		ready(ctx, opts.pools())
+	})
+
 	r.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler()))
 
+	r.GET("/events", events.ServeSSE)
+
+	r.POST("/cache/purge", admin(opts.AdminToken, func(ctx *fasthttp.RequestCtx) {
+		purge(ctx, opts.Cache)
+	}))
+
+	r.POST("/access/reload", admin(opts.AdminToken, func(ctx *fasthttp.RequestCtx) {
+		reloadAccess(ctx, opts.Access)
+	}))
+
+	r.GET("/split", func(ctx *fasthttp.RequestCtx) {
+		setSplitWeights(ctx, opts.Split, false)
+	})
+
+	r.POST("/split", admin(opts.AdminToken, func(ctx *fasthttp.RequestCtx) {
+		setSplitWeights(ctx, opts.Split, true)
+	}))
+
 	monitoringServer := fasthttp.Server{
 		Handler:               r.Handler,
 		MaxIdleWorkerDuration: 15 * time.Second,
@@ -131,19 +211,195 @@ func StartMonitoringServer(server OpenConnectionsCounter, proxies types.IBalance
 		NoDefaultServerHeader: true,
 	}
 
-	ln, err := reuseport.Listen("tcp4", addr)
+	ln, err := reuseport.Listen("tcp4", opts.Addr)
 	if err != nil {
 		zap.S().Errorf("Error while starting monitoring server %s", err)
 		return
 	}
 
-	zap.S().Infof("Monitoring server is running on http://%s", addr)
+	zap.S().Infof("Monitoring server is running on http://%s", opts.Addr)
 	if err := monitoringServer.Serve(ln); err != nil {
 		zap.S().Errorf("Error while starting monitoring server %s", err)
 		return
 	}
 }
 
+// admin guards an endpoint that changes what divisor does: the request must
+// carry token as a bearer token, and with no token set nothing may. The
+// monitoring server is otherwise open to whoever can reach it.
+func admin(token string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if token == "" {
+			ctx.Response.Header.Set("Content-Type", "application/json")
+			ctx.Response.SetStatusCode(fasthttp.StatusForbidden)
+			ctx.Response.SetBodyString(`{"error":"monitoring.admin_token is not set"}`)
+			return
+		}
+		bearer, ok := bytes.CutPrefix(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization), []byte("Bearer "))
+		if !ok || subtle.ConstantTimeCompare(bearer, []byte(token)) != 1 {
+			ctx.Response.Header.Set("Content-Type", "application/json")
+			ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="divisor monitoring"`)
+			ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
+			ctx.Response.SetBodyString(`{"error":"unauthorized"}`)
+			return
+		}
+		handler(ctx)
+	}
+}
+
+// pools returns every pool's Backends: the split's pools when split is
+// enabled, the balancer's own Backends otherwise.
+func (o *Options) pools() [][]types.ProxyStat {
+	if o.Split == nil {
+		return [][]types.ProxyStat{o.Balancer.Stats()}
+	}
+	var pools [][]types.ProxyStat
+	for _, pool := range o.Split.Pools() {
+		pools = append(pools, pool.Backends)
+	}
+	return pools
+}
+
+// ready answers readiness probes: 200 once every pool has a Backend in
+// rotation, 503 while divisor is up but some pool has nothing to proxy to.
+// Liveness stays with the process itself, so a Backend outage never gets
+// divisor restarted.
+func ready(ctx *fasthttp.RequestCtx, pools [][]types.ProxyStat) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	for _, proxiesStats := range pools {
+		if !slices.ContainsFunc(proxiesStats, func(stat types.ProxyStat) bool { return stat.IsHostAlive }) {
+			ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			ctx.Response.SetBodyString(`{"status":"no backends"}`)
+			return
+		}
+	}
+	ctx.Response.SetBodyString(`{"status":"ready"}`)
+}
+
+func cacheCounters(responseCache *cache.Cache) *cache.Counters {
+	if responseCache == nil {
+		return nil
+	}
+	counters := responseCache.Counters()
+	return &counters
+}
+
+func mirrorCounters(trafficMirror *mirror.Mirror) *mirror.Counters {
+	if trafficMirror == nil {
+		return nil
+	}
+	counters := trafficMirror.Counters()
+	return &counters
+}
+
+func splitPools(trafficSplit *split.Split) []split.PoolStats {
+	if trafficSplit == nil {
+		return nil
+	}
+	return trafficSplit.Pools()
+}
+
+func rateLimitCounters(rateLimiter *ratelimit.RateLimiter) []ratelimit.Counters {
+	if rateLimiter == nil {
+		return nil
+	}
+	return rateLimiter.Counters()
+}
+
+func accessCounters(accessControl *access.Access) []access.Counters {
+	if accessControl == nil {
+		return nil
+	}
+	return accessControl.Counters()
+}
+
+func authCounters(authPolicies *auth.Auth) []auth.Counters {
+	if authPolicies == nil {
+		return nil
+	}
+	return authPolicies.Counters()
+}
+
+// reloadAccess reads the access control files again and answers with every
+// scope's counters, or 500 with the lists unchanged when a file is broken.
+func reloadAccess(ctx *fasthttp.RequestCtx, accessControl *access.Access) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	if accessControl == nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Response.SetBodyString(`{"error":"access control is not enabled"}`)
+		return
+	}
+	if err := accessControl.Reload(); err != nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusInternalServerError)
+		by, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ctx.Response.SetBodyRaw(by)
+		return
+	}
+	zap.S().Info("Access control lists reloaded")
+	by, err := json.Marshal(accessControl.Counters())
+	if err != nil {
+		zap.S().Errorf("Error while parsing json, err: %v", err)
+		return
+	}
+	ctx.Response.SetBodyRaw(by)
+}
+
+// setSplitWeights answers with the split's pools, after setting the weights
+// the query names by pool (?canary=20) when set is true.
+func setSplitWeights(ctx *fasthttp.RequestCtx, trafficSplit *split.Split, set bool) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	if trafficSplit == nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Response.SetBodyString(`{"error":"split is not enabled"}`)
+		return
+	}
+	if set {
+		weights, err := queryWeights(ctx.QueryArgs())
+		if err == nil {
+			err = trafficSplit.SetWeights(weights)
+		}
+		if err != nil {
+			ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
+			by, _ := json.Marshal(map[string]string{"error": err.Error()})
+			ctx.Response.SetBodyRaw(by)
+			return
+		}
+		zap.S().Infof("Split weights set to %v", weights)
+	}
+	by, err := json.Marshal(trafficSplit.Pools())
+	if err != nil {
+		zap.S().Errorf("Error while parsing json, err: %v", err)
+		return
+	}
+	ctx.Response.SetBodyRaw(by)
+}
+
+func queryWeights(args *fasthttp.Args) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for name, value := range args.All() {
+		weight, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return nil, split.ErrWeights
+		}
+		weights[string(name)] = weight
+	}
+	return weights, nil
+}
+
+// purge drops the cached responses for the host and path prefix the query
+// names; with neither, everything goes.
+func purge(ctx *fasthttp.RequestCtx, responseCache *cache.Cache) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	if responseCache == nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Response.SetBodyString(`{"error":"cache is not enabled"}`)
+		return
+	}
+	args := ctx.QueryArgs()
+	purged := responseCache.Purge(string(args.Peek("host")), string(args.Peek("prefix")))
+	ctx.Response.SetBodyString(`{"purged":` + strconv.Itoa(purged) + `}`)
+}
+
 func ByteToMB(b uint64) uint64 {
 	return b / 1024 / 1024 //nolint:mnd
 }
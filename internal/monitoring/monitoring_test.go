@@ -0,0 +1,144 @@
+package monitoring
+
+import (
+	"testing"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/cache"
+	"github.com/aaydin-tr/divisor/internal/split"
+	"github.com/aaydin-tr/divisor/mocks"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type openConnections int32
+
+func (o openConnections) OpenConnectionsCount() int32 { return int32(o) }
+
+func TestStats(t *testing.T) {
+	opts := Options{
+		Server:   openConnections(3),
+		Balancer: &mocks.MockBalancer{},
+		Cache:    cache.New(config.Cache{Enabled: true, MaxSize: 1 << 20, MaxObjectSize: 1 << 20}, &mocks.MockBalancer{}),
+	}
+	m := opts.stats()
+
+	assert.Equal(t, int32(3), m.OpenConnectionCount)
+	assert.NotNil(t, m.Cache)
+	assert.Nil(t, m.Mirror, "features left out report nothing")
+	assert.Nil(t, m.Concurrency)
+}
+
+func TestAdmin(t *testing.T) {
+	served := 0
+	handler := func(ctx *fasthttp.RequestCtx) { served++ }
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer ")
+	admin("", handler)(&ctx)
+	assert.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode(), "no token, no admin endpoints")
+
+	for _, authorization := range []string{"", "Bearer wrong", "Basic s3cret", "s3cret"} {
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, authorization)
+		admin("s3cret", handler)(&ctx)
+		assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), authorization)
+		assert.Equal(t, `{"error":"unauthorized"}`, string(ctx.Response.Body()))
+	}
+	assert.Equal(t, 0, served)
+
+	ctx = fasthttp.RequestCtx{}
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer s3cret")
+	admin("s3cret", handler)(&ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, 1, served)
+}
+
+func TestReady(t *testing.T) {
+	t.Run("ready once a backend is in rotation", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		ready(&ctx, [][]types.ProxyStat{{{IsHostAlive: false}, {IsHostAlive: true}}})
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, `{"status":"ready"}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("no backends while every backend is down", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		ready(&ctx, [][]types.ProxyStat{{{IsHostAlive: false}, {IsHostAlive: false}}})
+
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, `{"status":"no backends"}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("no backends while any split pool is down", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		ready(&ctx, [][]types.ProxyStat{{{IsHostAlive: true}}, {{IsHostAlive: false}}})
+
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, `{"status":"no backends"}`, string(ctx.Response.Body()))
+	})
+}
+
+func TestPurge(t *testing.T) {
+	t.Run("not found while the cache is disabled", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		purge(&ctx, nil)
+
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	t.Run("purges what the query names", func(t *testing.T) {
+		responseCache := cache.New(config.Cache{Enabled: true, MaxSize: 1 << 20, MaxObjectSize: 1 << 20}, &mocks.MockBalancer{})
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/cache/purge?host=example.com&prefix=/api")
+		purge(&ctx, responseCache)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, `{"purged":0}`, string(ctx.Response.Body()))
+	})
+}
+
+func TestSetSplitWeights(t *testing.T) {
+	t.Run("not found while the split is disabled", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		setSplitWeights(&ctx, nil, true)
+
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	trafficSplit := split.New(config.Split{Enabled: true, Pools: []config.SplitPool{{Name: "canary", Weight: 10}}},
+		&mocks.MockBalancer{}, []types.IBalancer{&mocks.MockBalancer{}})
+
+	t.Run("sets the weights the query names", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/split?canary=20")
+		setSplitWeights(&ctx, trafficSplit, true)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		pools := trafficSplit.Pools()
+		assert.Equal(t, 80.0, pools[0].Weight)
+		assert.Equal(t, 20.0, pools[1].Weight)
+	})
+
+	t.Run("rejects what does not fit", func(t *testing.T) {
+		for _, query := range []string{"canary=120", "canary=lots", "primary=50"} {
+			ctx := fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI("/split?" + query)
+			setSplitWeights(&ctx, trafficSplit, true)
+
+			assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode(), query)
+		}
+		assert.Equal(t, 20.0, trafficSplit.Pools()[1].Weight)
+	})
+
+	t.Run("only reports without set", func(t *testing.T) {
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/split?canary=50")
+		setSplitWeights(&ctx, trafficSplit, false)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), `"name":"canary","weight":20`)
+	})
+}
@@ -1,6 +1,7 @@
 package monitoring
 
 import (
+	"github.com/aaydin-tr/divisor/core/types"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -46,10 +47,106 @@ var (
 		Name: "backend_connection_count",
 		Help: "Number of connections for each backend",
 	}, []string{"address"})
+	backendOpenTunnels = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backend_open_tunnels",
+		Help: "Number of open WebSocket tunnels for each backend",
+	}, []string{"address"})
 	backendAlive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "backend_alive",
 		Help: "Whether the backend is alive or not",
 	}, []string{"address"})
+	backendDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backend_degraded",
+		Help: "Whether the backend is degraded and receiving a reduced share",
+	}, []string{"address"})
+
+	cacheRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cache_request_count",
+		Help: "Requests the response cache answered (hit), sent on (miss) or never considered (bypass)",
+	}, []string{"result"})
+	cacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_bytes",
+		Help: "Bytes held by the response cache",
+	})
+
+	hedgesSent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "hedges_sent",
+		Help: "Hedged requests sent to a second backend",
+	})
+	hedgesWon = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "hedges_won",
+		Help: "Hedged requests that answered before the backend they hedged",
+	})
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "queue_depth",
+		Help: "Requests waiting in the queue for a backend below max_conn",
+	})
+	queueRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "queue_request_count",
+		Help: "Requests that waited in the queue, and those answered 503 with the queue full or after max_wait",
+	}, []string{"result"})
+	queueWaitSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "queue_wait_seconds_total",
+		Help: "Time queued requests spent waiting for a backend",
+	})
+
+	mirrorRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirror_request_count",
+		Help: "Request copies sent to the mirror pool (sent) or dropped while too many were in flight (dropped)",
+	}, []string{"result"})
+	mirrorResponses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirror_response_count",
+		Help: "Mirror pool responses by status class, divisor's own 502 and 504 included",
+	}, []string{"class"})
+
+	splitWeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "split_weight",
+		Help: "Percentage of unmatched traffic the split sends to the pool",
+	}, []string{"pool"})
+	splitRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "split_request_count",
+		Help: "Requests the split sent to the pool",
+	}, []string{"pool"})
+	splitResponses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "split_response_count",
+		Help: "Split pool responses by status class, divisor's own 502 and 504 included",
+	}, []string{"pool", "class"})
+
+	rateLimitRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rate_limit_request_count",
+		Help: "Requests the rate limit allowed or denied with 429",
+	}, []string{"limit", "result"})
+	rateLimitKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rate_limit_keys",
+		Help: "Keys the rate limit holds state for",
+	}, []string{"limit"})
+
+	concurrencyLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "concurrency_limit",
+		Help: "Adaptive limit on requests in flight to backends",
+	})
+	concurrencyInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "concurrency_in_flight",
+		Help: "Requests in flight to backends under the concurrency limit",
+	})
+	concurrencyShed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "concurrency_shed_count",
+		Help: "Requests shed with 503 by the concurrency limit or CPU usage",
+	}, []string{"priority"})
+
+	accessDenied = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_denied_count",
+		Help: "Requests access control denied, by scope: global or a path prefix",
+	}, []string{"scope"})
+	accessRanges = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_ranges",
+		Help: "IP ranges in each access control list, by scope and list",
+	}, []string{"scope", "list"})
+	authRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auth_request_count",
+		Help: "Requests each auth policy checked, by result: allowed, unauthorized or forbidden",
+	}, []string{"policy", "result"})
 )
 
 func init_prometheus() {
@@ -63,7 +160,29 @@ func init_prometheus() {
 	prometheus.MustRegister(backendTotalReqCount)
 	prometheus.MustRegister(backendAvgResTime)
 	prometheus.MustRegister(backendConnsCount)
+	prometheus.MustRegister(backendOpenTunnels)
 	prometheus.MustRegister(backendAlive)
+	prometheus.MustRegister(backendDegraded)
+	prometheus.MustRegister(cacheRequests)
+	prometheus.MustRegister(cacheBytes)
+	prometheus.MustRegister(hedgesSent)
+	prometheus.MustRegister(hedgesWon)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(queueRequests)
+	prometheus.MustRegister(queueWaitSeconds)
+	prometheus.MustRegister(mirrorRequests)
+	prometheus.MustRegister(mirrorResponses)
+	prometheus.MustRegister(splitWeight)
+	prometheus.MustRegister(splitRequests)
+	prometheus.MustRegister(splitResponses)
+	prometheus.MustRegister(rateLimitRequests)
+	prometheus.MustRegister(rateLimitKeys)
+	prometheus.MustRegister(concurrencyLimit)
+	prometheus.MustRegister(concurrencyInFlight)
+	prometheus.MustRegister(concurrencyShed)
+	prometheus.MustRegister(accessDenied)
+	prometheus.MustRegister(accessRanges)
+	prometheus.MustRegister(authRequests)
 }
 
 func updatePrometheusMetrics(m *Monitoring) {
@@ -79,11 +198,75 @@ func updatePrometheusMetrics(m *Monitoring) {
 		backendTotalReqCount.WithLabelValues(backend.Addr).Set(float64(backend.TotalReqCount))
 		backendAvgResTime.WithLabelValues(backend.Addr).Set(backend.AvgResTime)
 		backendConnsCount.WithLabelValues(backend.Addr).Set(float64(backend.ConnsCount))
+		backendOpenTunnels.WithLabelValues(backend.Addr).Set(float64(backend.OpenTunnels))
 		backendAlive.WithLabelValues(backend.Addr).Set(func() float64 {
 			if backend.IsHostAlive {
 				return 1
 			}
 			return 0
 		}())
+		backendDegraded.WithLabelValues(backend.Addr).Set(func() float64 {
+			if backend.Health == types.Degraded.String() {
+				return 1
+			}
+			return 0
+		}())
+	}
+
+	if m.Cache != nil {
+		cacheRequests.WithLabelValues("hit").Set(float64(m.Cache.Hits))
+		cacheRequests.WithLabelValues("miss").Set(float64(m.Cache.Misses))
+		cacheRequests.WithLabelValues("bypass").Set(float64(m.Cache.Bypasses))
+		cacheBytes.Set(float64(m.Cache.Bytes))
+	}
+
+	hedgesSent.Set(float64(m.Hedges.Sent))
+	hedgesWon.Set(float64(m.Hedges.Won))
+	queueDepth.Set(float64(m.Queue.Depth))
+	queueRequests.WithLabelValues("queued").Set(float64(m.Queue.Queued))
+	queueRequests.WithLabelValues("overflowed").Set(float64(m.Queue.Overflowed))
+	queueRequests.WithLabelValues("timed_out").Set(float64(m.Queue.TimedOut))
+	queueWaitSeconds.Set(m.Queue.WaitSeconds)
+
+	if m.Mirror != nil {
+		mirrorRequests.WithLabelValues("sent").Set(float64(m.Mirror.Sent))
+		mirrorRequests.WithLabelValues("dropped").Set(float64(m.Mirror.Dropped))
+		for class, count := range m.Mirror.Responses {
+			mirrorResponses.WithLabelValues(class).Set(float64(count))
+		}
+	}
+
+	for _, pool := range m.Split {
+		splitWeight.WithLabelValues(pool.Name).Set(pool.Weight)
+		splitRequests.WithLabelValues(pool.Name).Set(float64(pool.Requests))
+		for class, count := range pool.Responses {
+			splitResponses.WithLabelValues(pool.Name, class).Set(float64(count))
+		}
+	}
+
+	for _, limit := range m.RateLimits {
+		rateLimitRequests.WithLabelValues(limit.Name, "allowed").Set(float64(limit.Allowed))
+		rateLimitRequests.WithLabelValues(limit.Name, "denied").Set(float64(limit.Denied))
+		rateLimitKeys.WithLabelValues(limit.Name).Set(float64(limit.Keys))
+	}
+
+	if m.Concurrency != nil {
+		concurrencyLimit.Set(float64(m.Concurrency.Limit))
+		concurrencyInFlight.Set(float64(m.Concurrency.InFlight))
+		for priority, count := range m.Concurrency.Shed {
+			concurrencyShed.WithLabelValues(priority).Set(float64(count))
+		}
+	}
+
+	for _, scope := range m.AccessControl {
+		accessDenied.WithLabelValues(scope.Scope).Set(float64(scope.Denied))
+		accessRanges.WithLabelValues(scope.Scope, "allow").Set(float64(scope.Allow))
+		accessRanges.WithLabelValues(scope.Scope, "deny").Set(float64(scope.Deny))
+	}
+
+	for _, policy := range m.Auth {
+		authRequests.WithLabelValues(policy.Name, "allowed").Set(float64(policy.Allowed))
+		authRequests.WithLabelValues(policy.Name, "unauthorized").Set(float64(policy.Unauthorized))
+		authRequests.WithLabelValues(policy.Name, "forbidden").Set(float64(policy.Forbidden))
 	}
 }
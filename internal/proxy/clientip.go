@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// clientIPHeaders maps a client_ip source to the header it reads.
+var clientIPHeaders = map[string]string{
+	config.ClientIPXForwardedFor:  "X-Forwarded-For",
+	config.ClientIPXRealIP:        "X-Real-Ip",
+	config.ClientIPCFConnectingIP: "Cf-Connecting-Ip",
+}
+
+// clientIPKey holds a request's resolved client address: ip-hash resolves it
+// to pick a Backend, and the ProxyClient, its middlewares and its logs must
+// then see the same one, on every Retry attempt.
+type clientIPKey struct{}
+
+// ClientIP returns the address of the client that sent the request, as cfg
+// resolves it. It is worked out once per request.
+func ClientIP(ctx *fasthttp.RequestCtx, cfg *config.ClientIP) net.IP {
+	if ip, ok := ctx.UserValue(clientIPKey{}).(net.IP); ok {
+		return ip
+	}
+	ip := resolveClientIP(ctx, cfg)
+	ctx.SetUserValue(clientIPKey{}, ip)
+	return ip
+}
+
+// resolveClientIP believes a header only from a trusted proxy: from anyone
+// else the header is whatever the client chose to send, and the connecting
+// peer is the client.
+func resolveClientIP(ctx *fasthttp.RequestCtx, cfg *config.ClientIP) net.IP {
+	peer := ctx.RemoteIP()
+	header, ok := clientIPHeaders[cfg.Source]
+	if !ok || !cfg.Trusted.Contains(peer) {
+		return peer
+	}
+
+	if cfg.Source == config.ClientIPXForwardedFor {
+		return rightmostUntrusted(ctx.Request.Header.PeekAll(header), cfg.Trusted, peer)
+	}
+	if ip := net.ParseIP(helper.B2S(bytes.TrimSpace(ctx.Request.Header.Peek(header)))); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// rightmostUntrusted walks X-Forwarded-For from the right, where each trusted
+// proxy appended the address it saw. The first untrusted address is the
+// client; everything left of it is the client's to forge. An entry that is
+// not an address ends the walk at the last one that was.
+func rightmostUntrusted(values [][]byte, trusted config.IPRanges, peer net.IP) net.IP {
+	client := peer
+	for i := len(values) - 1; i >= 0; i-- {
+		entries := bytes.Split(values[i], helper.S2B(","))
+		for j := len(entries) - 1; j >= 0; j-- {
+			ip := net.ParseIP(helper.B2S(bytes.TrimSpace(entries[j])))
+			if ip == nil {
+				return client
+			}
+			client = ip
+			if !trusted.Contains(ip) {
+				return client
+			}
+		}
+	}
+	return client
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/middleware"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+var trustedTestRanges = config.IPRanges{netip.MustParsePrefix("10.0.0.0/8")}
+
+func clientIPRequest(peer string, header ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(peer), Port: 40000})
+	for i := 0; i+1 < len(header); i += 2 {
+		ctx.Request.Header.Add(header[i], header[i+1])
+	}
+	return ctx
+}
+
+func TestClientIP(t *testing.T) {
+	xff := config.ClientIP{Source: config.ClientIPXForwardedFor, Trusted: trustedTestRanges}
+	realIP := config.ClientIP{Source: config.ClientIPXRealIP, Trusted: trustedTestRanges}
+	cf := config.ClientIP{Source: config.ClientIPCFConnectingIP, Trusted: trustedTestRanges}
+
+	tests := []struct {
+		name string
+		cfg  config.ClientIP
+		ctx  *fasthttp.RequestCtx
+		want string
+	}{
+		{"remote_addr ignores headers", config.ClientIP{Source: config.ClientIPRemoteAddr, Trusted: trustedTestRanges},
+			clientIPRequest("10.0.0.1", "X-Forwarded-For", "203.0.113.9"), "10.0.0.1"},
+		{"untrusted peer is the client", xff,
+			clientIPRequest("192.0.2.1", "X-Forwarded-For", "203.0.113.9"), "192.0.2.1"},
+		{"rightmost untrusted entry", xff,
+			clientIPRequest("10.0.0.1", "X-Forwarded-For", "1.1.1.1, 203.0.113.9, 10.0.0.2"), "203.0.113.9"},
+		{"entries across header lines", xff,
+			clientIPRequest("10.0.0.1", "X-Forwarded-For", "203.0.113.9", "X-Forwarded-For", "10.0.0.2"), "203.0.113.9"},
+		{"all trusted gives the leftmost", xff,
+			clientIPRequest("10.0.0.1", "X-Forwarded-For", "10.0.0.3, 10.0.0.2"), "10.0.0.3"},
+		{"garbage stops the walk", xff,
+			clientIPRequest("10.0.0.1", "X-Forwarded-For", "203.0.113.9, unknown, 10.0.0.2"), "10.0.0.2"},
+		{"no header gives the peer", xff, clientIPRequest("10.0.0.1"), "10.0.0.1"},
+		{"X-Real-IP", realIP, clientIPRequest("10.0.0.1", "X-Real-IP", " 2001:db8::9 "), "2001:db8::9"},
+		{"X-Real-IP garbage gives the peer", realIP, clientIPRequest("10.0.0.1", "X-Real-IP", "nope"), "10.0.0.1"},
+		{"CF-Connecting-IP", cf, clientIPRequest("10.0.0.1", "CF-Connecting-IP", "203.0.113.9"), "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientIP(tt.ctx, &tt.cfg).String())
+		})
+	}
+}
+
+func TestClientIPIsResolvedOnce(t *testing.T) {
+	cfg := config.ClientIP{Source: config.ClientIPXRealIP, Trusted: trustedTestRanges}
+	ctx := clientIPRequest("10.0.0.1", "X-Real-IP", "203.0.113.9")
+	assert.Equal(t, "203.0.113.9", ClientIP(ctx, &cfg).String())
+
+	// A later attempt finds the header rewritten, as preReq leaves it.
+	ctx.Request.Header.Set("X-Real-IP", "198.51.100.1")
+	assert.Equal(t, "203.0.113.9", ClientIP(ctx, &cfg).String())
+}
+
+func TestResolvedClientIPReachesBackend(t *testing.T) {
+	var seen http.Header
+	b := config.Backend{
+		Url:          protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, ""),
+		ProxyTimeout: time.Second,
+		ClientIP:     config.ClientIP{Source: config.ClientIPXRealIP, Trusted: trustedTestRanges},
+	}
+	var mwClientIP net.IP
+	mw := &mockMiddleware{onRequestFunc: func(ctx *middleware.Context) error {
+		mwClientIP = ctx.ClientIP
+		return nil
+	}}
+	p := createTestProxyWithMiddlewares(b, map[string]string{"X-Client-Ip": "$remote_addr"}, mw)
+
+	assert.NoError(t, p.ReverseProxyHandler(clientIPRequest("10.0.0.1", "X-Real-IP", "203.0.113.9")))
+	assert.Equal(t, "203.0.113.9", seen.Get("X-Client-Ip"))
+	assert.Equal(t, "203.0.113.9", mwClientIP.String())
+	assert.Equal(t, "10.0.0.1", seen.Get("X-Forwarded-For"), "X-Forwarded-For records the peer")
+}
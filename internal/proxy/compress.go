@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	acceptEncodingHeader = []byte(fasthttp.HeaderAcceptEncoding)
+	noTransform          = []byte("no-transform")
+)
+
+// compress encodes the response body with the best algorithm both the client
+// and the compression settings accept. Responses that are streamed, already
+// encoded, too small, too large or of another type go out as they are.
+func (h *ProxyClient) compress(ctx *fasthttp.RequestCtx) {
+	c := &h.compression
+	client, ok := ctx.UserValue(clientRequestKey{}).(*clientRequest)
+	if !c.Enabled || !ok || !compressible(c, &ctx.Response, client.method) {
+		return
+	}
+	res := &ctx.Response
+	// The response now depends on Accept-Encoding, whether or not this
+	// client gets it compressed.
+	addVary(&res.Header, fasthttp.HeaderAcceptEncoding)
+
+	encoding := negotiateEncoding(client.acceptEncoding, c.Algorithms)
+	if encoding == "" {
+		return
+	}
+	body := res.Body()
+	level := c.Levels[encoding]
+	var out []byte
+	switch encoding {
+	case config.CompressionGzip:
+		out = fasthttp.AppendGzipBytesLevel(nil, body, level)
+	case config.CompressionBrotli:
+		out = fasthttp.AppendBrotliBytesLevel(nil, body, level)
+	case config.CompressionZstd:
+		out = fasthttp.AppendZstdBytesLevel(nil, body, level)
+	}
+	if len(out) >= len(body) {
+		return
+	}
+
+	res.SetBodyRaw(out)
+	res.Header.SetContentEncoding(encoding)
+	// A strong ETag promises these exact bytes, which they no longer are.
+	if etag := res.Header.Peek(fasthttp.HeaderETag); bytes.HasPrefix(etag, helper.S2B(`"`)) {
+		res.Header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+	}
+}
+
+func compressible(c *config.Compression, res *fasthttp.Response, method string) bool {
+	status := res.StatusCode()
+	switch {
+	case method == fasthttp.MethodHead || res.IsBodyStream():
+		return false
+	case status < 200 || status == fasthttp.StatusNoContent || status == fasthttp.StatusPartialContent || status == fasthttp.StatusNotModified:
+		return false
+	case len(res.Header.ContentEncoding()) > 0 || len(res.Body()) < c.MinSize || len(res.Body()) > c.MaxSize:
+		return false
+	case bytes.Contains(bytes.ToLower(res.Header.Peek(fasthttp.HeaderCacheControl)), noTransform):
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(helper.B2S(res.Header.ContentType()), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range c.ContentTypes {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the algorithm with the highest q-value in
+// acceptEncoding; algorithms, in order of preference, breaks ties. It
+// returns "" when the client accepts none of them.
+func negotiateEncoding(acceptEncoding string, algorithms []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := map[string]float64{}
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, algorithm := range algorithms {
+		q, ok := accepted[algorithm]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = algorithm, q
+		}
+	}
+	return best
+}
+
+// addVary adds field to the response's Vary unless it already varies on it,
+// or on everything.
+func addVary(header *fasthttp.ResponseHeader, field string) {
+	for _, value := range header.PeekAll(fasthttp.HeaderVary) {
+		for token := range strings.SplitSeq(helper.B2S(value), ",") {
+			token = strings.TrimSpace(token)
+			if token == "*" || strings.EqualFold(token, field) {
+				return
+			}
+		}
+	}
+	header.Add(fasthttp.HeaderVary, field)
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+var compressibleJSON = `{"items":[` + strings.Repeat(`{"id":1,"name":"widget"},`, 100) + `{}]}`
+
+// newCompressionTestClient proxies to a Backend answering with body and the
+// given headers, compression prepared the way PrepareConfig prepares it.
+func newCompressionTestClient(t *testing.T, compression config.Compression, body string, header ...string) *ProxyClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		io.WriteString(w, body) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+
+	compression.Enabled = true
+	cfg := config.Config{Port: "8000", Compression: compression,
+		Backends: []config.Backend{{Url: protocolRegex.ReplaceAllString(srv.URL, "")}}}
+	assert.NoError(t, cfg.PrepareConfig())
+	b := cfg.Backends[0]
+	b.ProxyTimeout = time.Second
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func compressionRequest(acceptEncoding string) *fasthttp.RequestCtx {
+	ctx := clientIPRequest("203.0.113.9")
+	if acceptEncoding != "" {
+		ctx.Request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return ctx
+}
+
+func TestCompress(t *testing.T) {
+	p := newCompressionTestClient(t, config.Compression{}, compressibleJSON,
+		"Content-Type", "application/json; charset=utf-8", "ETag", `"v1"`, "Vary", "Origin")
+
+	decode := map[string]func([]byte) ([]byte, error){
+		"gzip": func(b []byte) ([]byte, error) { return fasthttp.AppendGunzipBytes(nil, b) },
+		"br":   func(b []byte) ([]byte, error) { return fasthttp.AppendUnbrotliBytes(nil, b) },
+		"zstd": func(b []byte) ([]byte, error) { return fasthttp.AppendUnzstdBytes(nil, b) },
+	}
+	for encoding, decode := range decode {
+		ctx := compressionRequest(encoding)
+		assert.NoError(t, p.ReverseProxyHandler(ctx))
+		res := &ctx.Response
+		assert.Equal(t, encoding, string(res.Header.ContentEncoding()))
+		assert.Less(t, len(res.Body()), len(compressibleJSON))
+		body, err := decode(res.Body())
+		assert.NoError(t, err)
+		assert.Equal(t, compressibleJSON, string(body))
+		assert.Equal(t, `W/"v1"`, string(res.Header.Peek("ETag")))
+		assert.Equal(t, []string{"Origin", "Accept-Encoding"}, peekAll(res, "Vary"))
+	}
+
+	ctx := compressionRequest("")
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	assert.Empty(t, ctx.Response.Header.ContentEncoding())
+	assert.Equal(t, compressibleJSON, string(ctx.Response.Body()))
+	assert.Equal(t, []string{"Origin", "Accept-Encoding"}, peekAll(&ctx.Response, "Vary"), "uncompressed answers vary too")
+	assert.Equal(t, `"v1"`, string(ctx.Response.Header.Peek("ETag")))
+}
+
+func peekAll(res *fasthttp.Response, key string) []string {
+	var values []string
+	for _, v := range res.Header.PeekAll(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+func TestCompressLeavesAlone(t *testing.T) {
+	gzipped := string(fasthttp.AppendGzipBytes(nil, []byte(compressibleJSON)))
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+	}{
+		{"already encoded", gzipped, []string{"Content-Type", "application/json", "Content-Encoding", "gzip"}},
+		{"type not listed", compressibleJSON, []string{"Content-Type", "image/png"}},
+		{"under min_size", `{"ok":true}`, []string{"Content-Type", "application/json"}},
+		{"over max_size", strings.Repeat(compressibleJSON, 2000), []string{"Content-Type", "application/json"}},
+		{"no-transform", compressibleJSON, []string{"Content-Type", "application/json", "Cache-Control", "public, No-Transform"}},
+	}
+	for _, tt := range tests {
+		p := newCompressionTestClient(t, config.Compression{}, tt.body, tt.header...)
+		ctx := compressionRequest("gzip, br")
+		assert.NoError(t, p.ReverseProxyHandler(ctx), tt.name)
+		assert.Equal(t, tt.body, string(ctx.Response.Body()), tt.name)
+		if tt.name != "already encoded" {
+			assert.Empty(t, ctx.Response.Header.ContentEncoding(), tt.name)
+			assert.Empty(t, ctx.Response.Header.Peek("Vary"), tt.name)
+		}
+	}
+}
+
+func TestCompressAcceptEncodingRemovedByHeaderRules(t *testing.T) {
+	p := newCompressionTestClient(t, config.Compression{}, compressibleJSON, "Content-Type", "application/json")
+	p.requestHeaders = []config.HeaderOp{{Action: config.HeaderRemove, Name: "Accept-Encoding"}}
+
+	ctx := compressionRequest("gzip")
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	assert.Equal(t, "gzip", string(ctx.Response.Header.ContentEncoding()), "the client's Accept-Encoding, not the Backend's")
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	all := []string{"zstd", "br", "gzip"}
+	tests := []struct {
+		acceptEncoding string
+		algorithms     []string
+		want           string
+	}{
+		{"", all, ""},
+		{"gzip, deflate, br, zstd", all, "zstd"},
+		{"gzip, deflate, br, zstd", []string{"gzip", "br"}, "gzip"},
+		{"gzip;q=1.0, br;q=0.5", all, "gzip"},
+		{"BR", all, "br"},
+		{"*", all, "zstd"},
+		{"*;q=0.1, gzip;q=0.5", all, "gzip"},
+		{"gzip;q=0, identity", all, ""},
+		{"*, zstd;q=0", all, "br"},
+		{"deflate", all, ""},
+		{"gzip;q=bad, br", all, "br"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding, tt.algorithms), tt.acceptEncoding)
+	}
+}
+
+func TestCompressOverHTTP2(t *testing.T) {
+	p := newCompressionTestClient(t, config.Compression{Algorithms: []string{"gzip"}}, compressibleJSON, "Content-Type", "application/json")
+	frontend, client := h2Frontend(t, p)
+	// Asking explicitly keeps the Transport from decoding the body itself.
+	req, err := http.NewRequest(http.MethodGet, frontend.URL+"/", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	zr, err := gzip.NewReader(res.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, compressibleJSON, string(body))
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/events"
+	"go.uber.org/zap"
+)
+
+// DegradedSet maps each Degraded Backend to the percentage of its normal
+// share it keeps. Balancers swap it copy-on-write alongside their rotation,
+// so it is never mutated once published.
+type DegradedSet map[IProxyClient]int
+
+// Degraded publishes a Balancer's DegradedSet; the zero value holds an
+// empty set.
+type Degraded struct {
+	set atomic.Pointer[DegradedSet]
+}
+
+func (d *Degraded) Load() DegradedSet {
+	if set := d.set.Load(); set != nil {
+		return *set
+	}
+	return nil
+}
+
+func (d *Degraded) Store(set DegradedSet) {
+	d.set.Store(&set)
+}
+
+// With returns a copy of d that also holds p at weight percent.
+func (d DegradedSet) With(p IProxyClient, weight int) DegradedSet {
+	set := make(DegradedSet, len(d)+1)
+	for k, v := range d {
+		set[k] = v
+	}
+	set[p] = weight
+	return set
+}
+
+// Without returns a copy of d that no longer holds p, or d itself when p was
+// not in it.
+func (d DegradedSet) Without(p IProxyClient) DegradedSet {
+	if _, ok := d[p]; !ok {
+		return d
+	}
+	set := make(DegradedSet, len(d))
+	for k, v := range d {
+		if k != p {
+			set[k] = v
+		}
+	}
+	return set
+}
+
+// Admit reports whether the Balancer may hand this request to p. A Backend
+// that is not Degraded is always admitted; a Degraded one is admitted for
+// its weight percent of the requests that pick it.
+func (d DegradedSet) Admit(p IProxyClient) bool {
+	if len(d) == 0 {
+		return true
+	}
+	weight, ok := d[p]
+	return !ok || rand.IntN(100) < weight //nolint:gosec,mnd
+}
+
+// Repick picks the Backend for a request p turned down: one of servers,
+// besides p and tried, at random among those not Degraded, so p's
+// turned-down share spreads evenly over the healthy ones rather than
+// falling to p's neighbour in the rotation. A server listed once per weight
+// unit is picked in proportion. With no healthy one left, p keeps the
+// request.
+func (d DegradedSet) Repick(p IProxyClient, servers, tried []IProxyClient) IProxyClient {
+	picked, n := p, 0
+	for _, server := range servers {
+		if _, ok := d[server]; ok || slices.Contains(tried, server) {
+			continue
+		}
+		n++
+		if rand.IntN(n) == 0 { //nolint:gosec
+			picked = server
+		}
+	}
+	return picked
+}
+
+// AdmitHash is Admit for hash-based Balancers: the same hash is always
+// admitted or always refused, so a client keeps landing on one Backend.
+func (d DegradedSet) AdmitHash(p IProxyClient, hash uint32) bool {
+	if len(d) == 0 {
+		return true
+	}
+	weight, ok := d[p]
+	return !ok || hash%100 < uint32(weight) //nolint:mnd
+}
+
+// Apply returns d updated for p, at addr in pool, moving from prev to state,
+// logging any change in p's share of traffic.
+func (d DegradedSet) Apply(p IProxyClient, prev, state types.HealthState, weight int, pool, addr string) DegradedSet {
+	switch {
+	case state == types.Degraded && prev != types.Degraded:
+		zap.S().Infof("Server is degraded, keeping %d%% of its share, Addr: %s", weight, addr)
+		events.Publish(events.Event{Type: events.BackendDegraded, Pool: pool, Backend: addr})
+		return d.With(p, weight)
+	case state != types.Degraded && prev == types.Degraded:
+		if state == types.Alive {
+			zap.S().Infof("Server is healthy again, restoring its full share, Addr: %s", addr)
+		}
+		return d.Without(p)
+	}
+	return d
+}
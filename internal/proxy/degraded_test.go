@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedSet(t *testing.T) {
+	p1 := NewProxyClient(&config.Backend{Url: "localhost:8080"}, nil, nil)
+	p2 := NewProxyClient(&config.Backend{Url: "localhost:8081"}, nil, nil)
+
+	var empty DegradedSet
+	assert.True(t, empty.Admit(p1))
+	assert.True(t, empty.AdmitHash(p1, 99))
+
+	set := empty.With(p1, 30)
+	assert.Empty(t, empty, "With must not mutate the published set")
+	assert.True(t, set.Admit(p2), "a Backend that is not Degraded is always admitted")
+	assert.True(t, set.AdmitHash(p1, 129))
+	assert.False(t, set.AdmitHash(p1, 130))
+
+	admitted := 0
+	for range 10000 {
+		if set.Admit(p1) {
+			admitted++
+		}
+	}
+	assert.InDelta(t, 3000, admitted, 300)
+
+	assert.Equal(t, set, set.Without(p2), "removing an absent Backend returns the set as is")
+	without := set.Without(p1)
+	assert.Empty(t, without)
+	assert.Contains(t, set, p1, "Without must not mutate the published set")
+}
+
+func TestDegradedSetApply(t *testing.T) {
+	p := NewProxyClient(&config.Backend{Url: "localhost:8080"}, nil, nil)
+
+	var set DegradedSet
+	set = set.Apply(p, types.Alive, types.Degraded, 40, "", "localhost:8080")
+	assert.Equal(t, DegradedSet{p: 40}, set)
+
+	assert.Equal(t, set, set.Apply(p, types.Degraded, types.Degraded, 40, "", "localhost:8080"))
+	assert.Empty(t, set.Apply(p, types.Degraded, types.Alive, 40, "", "localhost:8080"))
+	assert.Empty(t, set.Apply(p, types.Degraded, types.Down, 40, "", "localhost:8080"))
+}
+
+func TestDegradedZeroValue(t *testing.T) {
+	var d Degraded
+	assert.Nil(t, d.Load())
+
+	p := NewProxyClient(&config.Backend{Url: "localhost:8080"}, nil, nil)
+	d.Store(DegradedSet{p: 10})
+	assert.Equal(t, DegradedSet{p: 10}, d.Load())
+}
+
+func TestDegradedSetRepick(t *testing.T) {
+	p1 := NewProxyClient(&config.Backend{Url: "localhost:8080"}, nil, nil)
+	p2 := NewProxyClient(&config.Backend{Url: "localhost:8081"}, nil, nil)
+	p3 := NewProxyClient(&config.Backend{Url: "localhost:8082"}, nil, nil)
+	p4 := NewProxyClient(&config.Backend{Url: "localhost:8083"}, nil, nil)
+	servers := []IProxyClient{p1, p2, p3, p4}
+	set := DegradedSet{p1: 0, p4: 0}
+
+	picks := map[IProxyClient]int{}
+	for range 10000 {
+		picks[set.Repick(p1, servers, nil)]++
+	}
+	assert.Len(t, picks, 2, "only the healthy Backends take the turned-down share")
+	assert.InDelta(t, 5000, picks[p2], 300)
+	assert.InDelta(t, 5000, picks[p3], 300)
+
+	assert.Equal(t, p3, set.Repick(p1, servers, []IProxyClient{p2}))
+	assert.Equal(t, p1, set.Repick(p1, servers, []IProxyClient{p2, p3}), "with no healthy Backend left the Degraded one keeps the request")
+}
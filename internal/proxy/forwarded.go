@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	xForwardedProto = []byte("X-Forwarded-Proto")
+	xForwardedHost  = []byte("X-Forwarded-Host")
+	xForwardedPort  = []byte("X-Forwarded-Port")
+	forwardedHeader = []byte("Forwarded")
+)
+
+// forwardedKey holds a request's forwarded values once worked out: a retry
+// runs preReq again on a request already rewritten for the previous Backend,
+// whose Host and scheme no longer say what the client asked for.
+type forwardedKey struct{}
+
+// forwarded is what divisor tells a Backend about the client side of a
+// request, one value per header.
+type forwarded struct {
+	xff, proto, host, port, rfc7239 []byte
+}
+
+// setForwarded sets the X-Forwarded-* headers, and Forwarded if enabled. It
+// must run before Host and the scheme are rewritten for the Backend.
+func (h *ProxyClient) setForwarded(ctx *fasthttp.RequestCtx, peerIP []byte) {
+	f, ok := ctx.UserValue(forwardedKey{}).(*forwarded)
+	if !ok {
+		f = h.newForwarded(ctx, peerIP)
+		ctx.SetUserValue(forwardedKey{}, f)
+	}
+
+	req := &ctx.Request
+	req.Header.SetBytesKV(XForwardedFor, f.xff)
+	req.Header.SetBytesKV(xForwardedProto, f.proto)
+	req.Header.SetBytesKV(xForwardedHost, f.host)
+	req.Header.SetBytesKV(xForwardedPort, f.port)
+	if f.rfc7239 != nil {
+		req.Header.SetBytesKV(forwardedHeader, f.rfc7239)
+	}
+}
+
+// newForwarded describes the hop from the client to divisor. A trusted peer
+// is a proxy in front of divisor: its X-Forwarded-For and Forwarded lists
+// are extended and its X-Forwarded-Proto/Host/Port kept, since they describe
+// the original client. Anyone else's values are replaced, or a client could
+// pose as any address.
+func (h *ProxyClient) newForwarded(ctx *fasthttp.RequestCtx, peerIP []byte) *forwarded {
+	req := &ctx.Request
+	proto := httpB
+	if ctx.IsTLS() || bytes.Equal(req.URI().Scheme(), httpsB) {
+		proto = httpsB
+	}
+	host := append([]byte(nil), req.Host()...)
+
+	f := &forwarded{
+		xff:   append([]byte(nil), peerIP...),
+		proto: proto,
+		host:  host,
+		port:  hostPort(host, proto),
+	}
+	trusted := h.forwardedHeaders.Trusts(ctx.RemoteIP())
+	if trusted {
+		if prior := joinHeader(req, XForwardedFor); len(prior) > 0 {
+			f.xff = append(append(prior, ", "...), f.xff...)
+		}
+		if prior := req.Header.PeekBytes(xForwardedProto); len(prior) > 0 {
+			f.proto = append([]byte(nil), prior...)
+		}
+		if prior := req.Header.PeekBytes(xForwardedHost); len(prior) > 0 {
+			f.host = append([]byte(nil), prior...)
+		}
+		if prior := req.Header.PeekBytes(xForwardedPort); len(prior) > 0 {
+			f.port = append([]byte(nil), prior...)
+		}
+	}
+
+	if h.forwardedHeaders.Forwarded {
+		// Each proxy's element describes the hop it received, so this one
+		// uses what divisor saw, not what a trusted peer reported.
+		f.rfc7239 = forwardedElement(peerIP, host, proto)
+		if prior := joinHeader(req, forwardedHeader); trusted && len(prior) > 0 {
+			f.rfc7239 = append(append(prior, ", "...), f.rfc7239...)
+		}
+	}
+	return f
+}
+
+// joinHeader returns every value of key as one comma-separated list.
+func joinHeader(req *fasthttp.Request, key []byte) []byte {
+	return bytes.Join(req.Header.PeekAll(helper.B2S(key)), helper.S2B(", "))
+}
+
+// hostPort returns the port in host, or the default port of proto.
+func hostPort(host, proto []byte) []byte {
+	if _, port, err := net.SplitHostPort(helper.B2S(host)); err == nil && port != "" {
+		return []byte(port)
+	}
+	if bytes.Equal(proto, httpsB) {
+		return []byte("443")
+	}
+	return []byte("80")
+}
+
+// forwardedElement formats one RFC 7239 element. IPv6 addresses and hosts,
+// which hold colons, are not valid tokens and go out quoted.
+func forwardedElement(forIP, host, proto []byte) []byte {
+	var b strings.Builder
+	b.WriteString("for=")
+	if bytes.IndexByte(forIP, ':') >= 0 {
+		b.WriteString(`"[` + helper.B2S(forIP) + `]"`)
+	} else {
+		b.Write(forIP)
+	}
+	if len(host) > 0 {
+		b.WriteString(";host=" + quoteForwarded(helper.B2S(host)))
+	}
+	b.WriteString(";proto=")
+	b.Write(proto)
+	return []byte(b.String())
+}
+
+func quoteForwarded(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// headerServer records the headers of the last request it received.
+func headerServer(t *testing.T, seen *http.Header) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = r.Header.Clone()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newForwardedTestClient proxies to addr with Forwarded on, its
+// forwarded_headers prepared the way PrepareConfig copies them to a Backend.
+func newForwardedTestClient(t *testing.T, addr string, trustedProxies ...string) *ProxyClient {
+	t.Helper()
+	cfg := config.Config{
+		Port:             "8000",
+		Backends:         []config.Backend{{Url: addr}},
+		ForwardedHeaders: config.ForwardedHeaders{TrustedProxies: trustedProxies, Forwarded: true},
+	}
+	assert.NoError(t, cfg.PrepareConfig())
+	b := cfg.Backends[0]
+	b.ProxyTimeout = time.Second
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func forwardedRequest(peer string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(peer), Port: 40000})
+	ctx.Request.SetRequestURI("/")
+	ctx.Request.SetHost("shop.example:8080")
+	ctx.Request.Header.Set("X-Forwarded-For", "203.0.113.7")
+	ctx.Request.Header.Set("X-Forwarded-Proto", "https")
+	ctx.Request.Header.Set("X-Forwarded-Host", "shop.example")
+	ctx.Request.Header.Set("X-Forwarded-Port", "443")
+	ctx.Request.Header.Set("Forwarded", "for=203.0.113.7;proto=https")
+	return ctx
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	var seen http.Header
+	addr := protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, "")
+
+	t.Run("untrusted peer has its values replaced", func(t *testing.T) {
+		p := newForwardedTestClient(t, addr, "10.0.0.0/8")
+		assert.NoError(t, p.ReverseProxyHandler(forwardedRequest("192.0.2.1")))
+
+		assert.Equal(t, "192.0.2.1", seen.Get("X-Forwarded-For"))
+		assert.Equal(t, "http", seen.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "shop.example:8080", seen.Get("X-Forwarded-Host"))
+		assert.Equal(t, "8080", seen.Get("X-Forwarded-Port"))
+		assert.Equal(t, `for=192.0.2.1;host="shop.example:8080";proto=http`, seen.Get("Forwarded"))
+	})
+
+	t.Run("trusted peer has its values kept and extended", func(t *testing.T) {
+		p := newForwardedTestClient(t, addr, "10.0.0.0/8")
+		assert.NoError(t, p.ReverseProxyHandler(forwardedRequest("10.1.2.3")))
+
+		assert.Equal(t, "203.0.113.7, 10.1.2.3", seen.Get("X-Forwarded-For"))
+		assert.Equal(t, "https", seen.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "shop.example", seen.Get("X-Forwarded-Host"))
+		assert.Equal(t, "443", seen.Get("X-Forwarded-Port"))
+		assert.Equal(t, `for=203.0.113.7;proto=https, for=10.1.2.3;host="shop.example:8080";proto=http`, seen.Get("Forwarded"))
+	})
+
+	t.Run("bare IP is trusted", func(t *testing.T) {
+		p := newForwardedTestClient(t, addr, "10.1.2.3")
+		assert.NoError(t, p.ReverseProxyHandler(forwardedRequest("10.1.2.3")))
+		assert.Equal(t, "203.0.113.7, 10.1.2.3", seen.Get("X-Forwarded-For"))
+	})
+
+	t.Run("IPv6 peer is quoted in Forwarded", func(t *testing.T) {
+		p := newForwardedTestClient(t, addr)
+		ctx := forwardedRequest("2001:db8::1")
+		ctx.Request.SetHost("shop.example")
+		assert.NoError(t, p.ReverseProxyHandler(ctx))
+
+		assert.Equal(t, "2001:db8::1", seen.Get("X-Forwarded-For"))
+		assert.Equal(t, "80", seen.Get("X-Forwarded-Port"))
+		assert.Equal(t, `for="[2001:db8::1]";host="shop.example";proto=http`, seen.Get("Forwarded"))
+	})
+
+	t.Run("Forwarded is off by default", func(t *testing.T) {
+		b := config.Backend{Url: addr, ProxyTimeout: time.Second}
+		p := NewProxyClient(&b, nil, nil).(*ProxyClient)
+		ctx := forwardedRequest("192.0.2.1")
+		ctx.Request.Header.Del("Forwarded")
+		assert.NoError(t, p.ReverseProxyHandler(ctx))
+		assert.Empty(t, seen.Get("Forwarded"))
+		assert.Equal(t, "192.0.2.1", seen.Get("X-Forwarded-For"))
+	})
+}
+
+func TestForwardedHeadersSurviveRetry(t *testing.T) {
+	var seen http.Header
+	ok := newForwardedTestClient(t, protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, ""), "10.0.0.0/8")
+	refused := newForwardedTestClient(t, refusedAddr(t), "10.0.0.0/8")
+
+	ctx := forwardedRequest("10.1.2.3")
+	NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{}).Serve(ctx, inOrder(refused, ok))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// The second attempt must describe the client's request, not the first
+	// attempt's rewrite of it for the refused Backend.
+	assert.Equal(t, "203.0.113.7, 10.1.2.3", seen.Get("X-Forwarded-For"))
+	assert.Equal(t, `for=203.0.113.7;proto=https, for=10.1.2.3;host="shop.example:8080";proto=http`, seen.Get("Forwarded"))
+}
+
+func TestForwardedProtoOverHTTP2(t *testing.T) {
+	var seen http.Header
+	p := newForwardedTestClient(t, protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, ""))
+	srv := httptest.NewUnstartedServer(NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+		p.ReverseProxyHandler(ctx) //nolint:errcheck
+	}}, 0, false))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "/")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, "https", seen.Get("X-Forwarded-Proto"))
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	assert.Equal(t, port, seen.Get("X-Forwarded-Port"))
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcLikeServer speaks h2c only, the way a gRPC server does: it echoes the
+// request body and the X-Checksum request trailer, and ends every response
+// with a Grpc-Status trailer. Requests that are not HTTP/2 get 505.
+func grpcLikeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if r.Header.Get("Hang") != "" {
+			<-r.Context().Done()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("X-Seen-Te", r.Header.Get("Te"))
+		w.Header().Set("X-Seen-Checksum", r.Trailer.Get("X-Checksum"))
+		// Headers go out first, as gRPC sends them, so no Content-Length is set.
+		w.(http.Flusher).Flush()
+		w.Write(body) //nolint:errcheck
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+	}), &http2.Server{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newH2CTestClient(srv *httptest.Server, streamBodies bool) *ProxyClient {
+	b := config.Backend{
+		Url:          protocolRegex.ReplaceAllString(srv.URL, ""),
+		ProxyTimeout: time.Second,
+		Protocol:     config.BackendProtocolH2C,
+		StreamBodies: streamBodies,
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+// h2Frontend serves p through the HTTP/2 frontend and returns a client for it.
+func h2Frontend(t *testing.T, p *ProxyClient) (*httptest.Server, *http.Client) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+		p.ReverseProxyHandler(ctx) //nolint:errcheck
+	}}, 0, p.streamBodies))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, srv.Client()
+}
+
+func TestH2CBackend(t *testing.T) {
+	p := newH2CTestClient(grpcLikeServer(t), false)
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.Header.Set("Te", "trailers")
+	ctx.Request.SetBodyString("hello")
+	assert.NoError(t, p.ReverseProxyHandler(&ctx))
+
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "trailers", string(ctx.Response.Header.Peek("X-Seen-Te")))
+	assert.Equal(t, "hello", string(ctx.Response.Body()))
+	assert.Equal(t, 0, p.PendingRequests())
+	assert.Equal(t, 1, p.Stat().ConnsCount)
+	assert.False(t, p.Stat().LastUseTime.IsZero())
+}
+
+func TestH2CBackendTrailers(t *testing.T) {
+	for _, streamBodies := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream_bodies=%v", streamBodies), func(t *testing.T) {
+			srv, client := h2Frontend(t, newH2CTestClient(grpcLikeServer(t), streamBodies))
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/pkg.Service/Method", io.NopCloser(strings.NewReader("message")))
+			assert.NoError(t, err)
+			req.Header.Set("Te", "trailers")
+			req.Trailer = http.Header{"X-Checksum": nil}
+			body := req.Body
+			req.Body = readCloser{Reader: io.MultiReader(body, eofHook(func() { req.Trailer.Set("X-Checksum", "abc") })), Closer: body}
+
+			res, err := client.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, 2, res.ProtoMajor)
+			assert.Equal(t, "application/grpc", res.Header.Get("Content-Type"))
+			assert.Equal(t, "abc", res.Header.Get("X-Seen-Checksum"), "request trailers must reach the Backend as trailers")
+
+			got, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "message", string(got))
+			assert.Equal(t, "0", res.Trailer.Get("Grpc-Status"))
+			assert.Equal(t, "ok", res.Trailer.Get("Grpc-Message"))
+			assert.Empty(t, res.Header.Get("Grpc-Status"), "trailers must not be folded into headers")
+		})
+	}
+}
+
+func TestH2CBackendTrailersOverHTTP1(t *testing.T) {
+	p := newH2CTestClient(grpcLikeServer(t), true)
+	conn := serveFasthttp(t, p)()
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: divisor\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "0", res.Trailer.Get("Grpc-Status"))
+}
+
+func TestH2CBackendMultiplexesStreams(t *testing.T) {
+	p := newH2CTestClient(grpcLikeServer(t), false)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := fasthttp.RequestCtx{}
+			assert.NoError(t, p.ReverseProxyHandler(&ctx))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(10), int64(p.Stat().TotalReqCount))
+	assert.Equal(t, 1, p.Stat().ConnsCount, "concurrent requests share one connection as streams")
+}
+
+func TestH2CBackendTimeout(t *testing.T) {
+	b := config.Backend{
+		Url:          protocolRegex.ReplaceAllString(grpcLikeServer(t).URL, ""),
+		ProxyTimeout: 100 * time.Millisecond,
+		Protocol:     config.BackendProtocolH2C,
+	}
+	p := NewProxyClient(&b, nil, nil).(*ProxyClient)
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Hang", "true")
+	assert.ErrorIs(t, p.ReverseProxyHandler(&ctx), fasthttp.ErrTimeout)
+	assert.Equal(t, fasthttp.StatusGatewayTimeout, ctx.Response.StatusCode())
+	assert.Equal(t, 0, p.PendingRequests())
+}
+
+func TestH2CBackendUnreachable(t *testing.T) {
+	b := config.Backend{Url: refusedAddr(t), ProxyTimeout: time.Second, Protocol: config.BackendProtocolH2C}
+	p := NewProxyClient(&b, nil, nil).(*ProxyClient)
+
+	ctx := fasthttp.RequestCtx{}
+	err := p.ReverseProxyHandler(&ctx)
+	assert.True(t, isConnectError(err), "a refused dial must stay retryable: %v", err)
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+}
+
+func TestH2CBackendStreamedBodyOverLimit(t *testing.T) {
+	const limit = 64 * 1024
+	b := config.Backend{
+		Url:                protocolRegex.ReplaceAllString(grpcLikeServer(t).URL, ""),
+		ProxyTimeout:       time.Second,
+		Protocol:           config.BackendProtocolH2C,
+		StreamBodies:       true,
+		MaxRequestBodySize: limit,
+	}
+	p := NewProxyClient(&b, nil, nil).(*ProxyClient)
+	conn := serveFasthttp(t, p)()
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: divisor\r\nTransfer-Encoding: chunked\r\n\r\n")
+	for range 4 {
+		fmt.Fprintf(conn, "%x\r\n%s\r\n", limit/2, strings.Repeat("x", limit/2))
+	}
+	fmt.Fprintf(conn, "0\r\n\r\n")
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// eofHook runs fn when read, which io.MultiReader does right after the
+// reader before it ends.
+type eofHook func()
+
+func (f eofHook) Read([]byte) (int, error) {
+	f()
+	return 0, io.EOF
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+// clientRequestKey holds the request as the client sent it, for header rule
+// variables and rewrites: preReq rewrites Host, the scheme and the path for
+// the Backend, and a retry runs it again on the rewritten request.
+type clientRequestKey struct{}
+
+type clientRequest struct {
+	host, method, path, query, scheme string
+	// Path and query, escaped as they go on the wire.
+	uri string
+	// What compression negotiates with, before header rules could
+	// remove it.
+	acceptEncoding string
+	// One id per request, the same in request and response headers and
+	// on every Retry or hedged attempt; made when a rule first asks for
+	// it, by whichever of two hedged attempts asks first.
+	requestID     string
+	requestIDOnce sync.Once
+}
+
+// tlsStateKey holds the client's TLS state on a ctx the net/http adapter
+// made, which has no connection of its own to ask.
+type tlsStateKey struct{}
+
+// headerEditor is what header rules need of a request or response header.
+type headerEditor interface {
+	Set(key, value string)
+	Add(key, value string)
+	Del(key string)
+}
+
+// captureClientRequest records the request on its first attempt, for header
+// rules, rewrites and a preserved Host. It must run before preReq rewrites
+// the request.
+func captureClientRequest(ctx *fasthttp.RequestCtx) {
+	if _, ok := ctx.UserValue(clientRequestKey{}).(*clientRequest); ok {
+		return
+	}
+
+	req := &ctx.Request
+	scheme := "http"
+	if ctx.IsTLS() || bytes.Equal(req.URI().Scheme(), httpsB) {
+		scheme = "https"
+	}
+	ctx.SetUserValue(clientRequestKey{}, &clientRequest{
+		host:           string(req.Host()),
+		method:         string(req.Header.Method()),
+		path:           string(req.URI().Path()),
+		query:          string(req.URI().QueryString()),
+		scheme:         scheme,
+		uri:            string(req.URI().RequestURI()),
+		acceptEncoding: string(joinHeader(req, acceptEncodingHeader)),
+	})
+}
+
+// applyHeaderRules runs ops against header, in order.
+func (h *ProxyClient) applyHeaderRules(ctx *fasthttp.RequestCtx, header headerEditor, ops []config.HeaderOp) {
+	for _, op := range ops {
+		switch op.Action {
+		case config.HeaderRemove:
+			header.Del(op.Name)
+		case config.HeaderSet:
+			header.Set(op.Name, h.expand(ctx, op.Value))
+		case config.HeaderAdd:
+			header.Add(op.Name, h.expand(ctx, op.Value))
+		}
+	}
+}
+
+func (h *ProxyClient) expand(ctx *fasthttp.RequestCtx, t config.HeaderTemplate) string {
+	if len(t) == 1 && !t[0].Var {
+		return t[0].Text
+	}
+	var b strings.Builder
+	for _, part := range t {
+		if part.Var {
+			b.WriteString(h.headerVariable(ctx, part.Text))
+		} else {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+// headerVariable returns the value of one of config.ValidHeaderVariables,
+// empty when the request has none, such as $tls_version over plaintext.
+func (h *ProxyClient) headerVariable(ctx *fasthttp.RequestCtx, name string) string {
+	vars, _ := ctx.UserValue(clientRequestKey{}).(*clientRequest)
+	if vars == nil {
+		vars = &clientRequest{}
+	}
+	switch name {
+	case "$remote_addr":
+		return ClientIP(ctx, &h.clientIP).String()
+	case "$time":
+		return time.Now().Local().Format("2006-01-02T15:04:05.000Z")
+	case "$uuid":
+		return uuid.NewString()
+	case "$incremental":
+		return strconv.FormatUint(atomic.LoadUint64(h.totalRequestCount), 10)
+	case "$host":
+		return vars.host
+	case "$method":
+		return vars.method
+	case "$path":
+		return vars.path
+	case "$query":
+		return vars.query
+	case "$scheme":
+		return vars.scheme
+	case "$backend_addr":
+		return h.Addr
+	case "$request_id":
+		vars.requestIDOnce.Do(func() { vars.requestID = uuid.NewString() })
+		return vars.requestID
+	case "$tls_version":
+		if state := tlsState(ctx); state != nil {
+			return tls.VersionName(state.Version)
+		}
+	}
+	return ""
+}
+
+// tlsState returns the client connection's TLS state, nil over plaintext.
+func tlsState(ctx *fasthttp.RequestCtx) *tls.ConnectionState {
+	if state, ok := ctx.UserValue(tlsStateKey{}).(*tls.ConnectionState); ok {
+		return state
+	}
+	return ctx.TLSConnectionState()
+}
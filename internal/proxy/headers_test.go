@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// newHeaderRulesTestClient proxies to addr with the rules prepared the way
+// PrepareConfig prepares a Backend's.
+func newHeaderRulesTestClient(t *testing.T, addr string, request, response config.HeaderRules) *ProxyClient {
+	t.Helper()
+	cfg := config.Config{
+		Port:            "8000",
+		Backends:        []config.Backend{{Url: addr}},
+		RequestHeaders:  request,
+		ResponseHeaders: response,
+	}
+	assert.NoError(t, cfg.PrepareConfig())
+	b := cfg.Backends[0]
+	b.ProxyTimeout = time.Second
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func headerRulesRequest() *fasthttp.RequestCtx {
+	ctx := clientIPRequest("203.0.113.9", "Cookie", "session=1", "X-Tag", "client")
+	ctx.Request.SetRequestURI("/orders/7?expand=items")
+	ctx.Request.SetHost("shop.example")
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	return ctx
+}
+
+func TestRequestHeaderRules(t *testing.T) {
+	var seen http.Header
+	srv := headerServer(t, &seen)
+	addr := protocolRegex.ReplaceAllString(srv.URL, "")
+	p := newHeaderRulesTestClient(t, addr, config.HeaderRules{
+		Set: map[string]string{
+			"X-Original-Url": "$scheme://$host$path?$query",
+			"X-Route":        "$method to $backend_addr from $remote_addr",
+			"X-Tls":          "[$tls_version]",
+		},
+		Add:    map[string]string{"X-Tag": "divisor"},
+		Remove: []string{"Cookie"},
+	}, config.HeaderRules{})
+
+	assert.NoError(t, p.ReverseProxyHandler(headerRulesRequest()))
+	assert.Equal(t, "http://shop.example/orders/7?expand=items", seen.Get("X-Original-Url"))
+	assert.Equal(t, "POST to "+addr+" from 203.0.113.9", seen.Get("X-Route"))
+	assert.Equal(t, "[]", seen.Get("X-Tls"), "plaintext has no TLS variables")
+	assert.Equal(t, []string{"client", "divisor"}, seen.Values("X-Tag"))
+	assert.Empty(t, seen.Get("Cookie"))
+}
+
+func TestResponseHeaderRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+	}))
+	t.Cleanup(srv.Close)
+	p := newHeaderRulesTestClient(t, protocolRegex.ReplaceAllString(srv.URL, ""),
+		config.HeaderRules{Set: map[string]string{"X-Request-Id": "$request_id"}},
+		config.HeaderRules{
+			Set:    map[string]string{"X-Served-Request-Id": "$request_id", "X-Path": "$path"},
+			Remove: []string{"Server"},
+		})
+
+	ctx := headerRulesRequest()
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	id := string(ctx.Response.Header.Peek("X-Request-Id"))
+	assert.Len(t, id, 36)
+	assert.Equal(t, id, string(ctx.Response.Header.Peek("X-Served-Request-Id")), "one id per request, both ways")
+	assert.Equal(t, "/orders/7", string(ctx.Response.Header.Peek("X-Path")))
+	assert.Empty(t, ctx.Response.Header.Peek("Server"))
+
+	// Error responses divisor makes itself get the rules too.
+	unreachable := newHeaderRulesTestClient(t, refusedAddr(t), config.HeaderRules{},
+		config.HeaderRules{Set: map[string]string{"X-Path": "$path"}})
+	ctx = headerRulesRequest()
+	assert.Error(t, unreachable.ReverseProxyHandler(ctx))
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Equal(t, "/orders/7", string(ctx.Response.Header.Peek("X-Path")))
+}
+
+func TestHeaderRulesSurviveRetry(t *testing.T) {
+	var seen http.Header
+	rules := config.HeaderRules{Set: map[string]string{"X-Original-Host": "$host", "X-Request-Id": "$request_id"}}
+	first := newHeaderRulesTestClient(t, refusedAddr(t), rules, config.HeaderRules{})
+	second := newHeaderRulesTestClient(t, protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, ""), rules, config.HeaderRules{})
+
+	ctx := headerRulesRequest()
+	assert.Error(t, first.ReverseProxyHandler(ctx))
+	id := string(ctx.Request.Header.Peek("X-Request-Id"))
+	ctx.Response.Reset()
+	assert.NoError(t, second.ReverseProxyHandler(ctx))
+	assert.Equal(t, "shop.example", seen.Get("X-Original-Host"), "not the first Backend's address")
+	assert.Equal(t, id, seen.Get("X-Request-Id"))
+}
+
+func TestHeaderRulesTLSVersionOverHTTP2(t *testing.T) {
+	var seen http.Header
+	p := newHeaderRulesTestClient(t, protocolRegex.ReplaceAllString(headerServer(t, &seen).URL, ""),
+		config.HeaderRules{Set: map[string]string{"X-Tls-Version": "$tls_version", "X-Scheme": "$scheme"}}, config.HeaderRules{})
+	frontend, client := h2Frontend(t, p)
+
+	res, err := client.Get(frontend.URL + "/")
+	assert.NoError(t, err)
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	res.Body.Close()
+	assert.Equal(t, tls.VersionName(res.TLS.Version), seen.Get("X-Tls-Version"))
+	assert.Equal(t, "https", seen.Get("X-Scheme"))
+}
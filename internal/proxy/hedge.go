@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// Response times a Backend keeps for hedge delays, and how many it needs
+// before its percentile is believed over the configured delay.
+const (
+	responseTimeWindowSize = 256
+	minResponseTimeSamples = 20
+)
+
+// Hedges sent and won since startup, for /stats.
+var hedgesSent, hedgesWon atomic.Uint64
+
+// HedgeStats is what /stats reports about hedging: Won counts the hedges
+// that answered before the Backend they hedged.
+type HedgeStats struct {
+	Sent uint64 `json:"sent"`
+	Won  uint64 `json:"won"`
+}
+
+func Hedges() HedgeStats {
+	return HedgeStats{Sent: hedgesSent.Load(), Won: hedgesWon.Load()}
+}
+
+// hedgePolicy sends a request to a second Backend when the first has not
+// answered within the delay. Both attempts run on copies of the request, so
+// neither rewrites what the other, or a later Retry, starts from.
+type hedgePolicy struct {
+	budget     *retryBudget
+	delay      time.Duration
+	percentile float64
+	// losers counts the attempts still running after the other answered
+	// the client. One to an h2c Backend or one taking a PROXY header is
+	// called off at once; fasthttp cannot abort one to any other Backend,
+	// so it keeps loading it, and holds a hedge from the budget, until it
+	// ends.
+	losers atomic.Int64
+	// queue, when there is one, decides whether a hedge may take a
+	// connection, and is woken as each attempt ends.
+	queue *requestQueue
+}
+
+func newHedgePolicy(cfg config.Hedge, queue *requestQueue) *hedgePolicy {
+	if !cfg.Enabled {
+		return nil
+	}
+	policy := &hedgePolicy{
+		budget:     &retryBudget{percent: uint64(cfg.BudgetPercent)},
+		delay:      cfg.Delay,
+		percentile: cfg.Percentile,
+		queue:      queue,
+	}
+	policy.budget.windowStart.Store(time.Now().UnixNano())
+	return policy
+}
+
+// hedgeable reports whether ctx may be sent twice: an idempotent request
+// with nothing streamed that the first attempt would use up.
+func hedgeable(ctx *fasthttp.RequestCtx) bool {
+	req := &ctx.Request
+	return (req.Header.IsGet() || req.Header.IsHead()) && !req.IsBodyStream() && !IsWebSocketUpgrade(req)
+}
+
+type hedgeResult struct {
+	ctx   *fasthttp.RequestCtx
+	err   error
+	hedge bool
+}
+
+// attemptContextKey holds the context that calls off an attempt in flight.
+type attemptContextKey struct{}
+
+// attemptContext returns the context ctx's attempt is called off by, or
+// context.Background() when nothing may call it off.
+func attemptContext(ctx *fasthttp.RequestCtx) context.Context {
+	if attempt, ok := ctx.UserValue(attemptContextKey{}).(context.Context); ok {
+		return attempt
+	}
+	return context.Background()
+}
+
+// serve proxies ctx to first, and to a Backend next picks should first not
+// answer within the delay. The first attempt to succeed answers the client;
+// should both fail, the last to fail does. The other attempt is called off
+// where the Backend's client can abort it, and otherwise runs to the end,
+// as fasthttp cannot abort a request in flight; its response is dropped,
+// and until it ends it counts as a loser. It returns tried with every
+// Backend it sent the request to.
+func (p *hedgePolicy) serve(ctx *fasthttp.RequestCtx, first IProxyClient, next NextFunc, tried []IProxyClient) ([]IProxyClient, error) {
+	p.budget.recordRequest()
+	// Recorded once here, so both attempts share one $request_id.
+	captureClientRequest(ctx)
+
+	results := make(chan hedgeResult, 2)
+	cancels := make(map[*fasthttp.RequestCtx]context.CancelFunc, 2)
+	launch := func(proxyClient IProxyClient, hedge bool) {
+		attempt := CopyRequestCtx(ctx)
+		attemptCtx, cancel := context.WithCancel(context.Background())
+		attempt.SetUserValue(attemptContextKey{}, attemptCtx)
+		cancels[attempt] = cancel
+		go func() {
+			err := proxyClient.ReverseProxyHandler(attempt)
+			p.queue.release()
+			results <- hedgeResult{ctx: attempt, err: err, hedge: hedge}
+		}()
+	}
+	launch(first, false)
+	tried = append(tried, first)
+
+	timer := time.NewTimer(p.delayFor(first))
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err != nil && pending > 0 {
+				continue
+			}
+			if r.hedge && r.err == nil {
+				hedgesWon.Add(1)
+			}
+			r.ctx.Response.CopyTo(&ctx.Response)
+			ctx.SetUserValue(backendFailedKey{}, BackendFailed(r.ctx))
+			// The timer fires once, so at most one attempt is left. The
+			// winner's own context stays open: its body may still stream.
+			if pending > 0 {
+				for attempt, cancel := range cancels {
+					if attempt != r.ctx {
+						cancel()
+					}
+				}
+				p.losers.Add(1)
+				go func() {
+					<-results
+					p.losers.Add(-1)
+				}()
+			}
+			return tried, r.err
+		case <-timer.C:
+			// A hedge never waits, nor takes a connection from a queued
+			// request.
+			second := p.queue.pickNow(next, tried)
+			if second == nil || !p.withdraw() {
+				continue
+			}
+			hedgesSent.Add(1)
+			launch(second, true)
+			tried = append(tried, second)
+			pending++
+		}
+	}
+}
+
+// withdraw spends a hedge from the budget, unless the losers still running
+// already use up what the window allows.
+func (p *hedgePolicy) withdraw() bool {
+	if uint64(p.losers.Load()) >= p.budget.allowed() {
+		return false
+	}
+	return p.budget.withdraw()
+}
+
+func (p *hedgePolicy) delayFor(proxyClient IProxyClient) time.Duration {
+	if p.percentile > 0 {
+		if d, ok := proxyClient.ResponseTimePercentile(p.percentile); ok {
+			return d
+		}
+	}
+	return p.delay
+}
+
+// CopyRequestCtx copies ctx's request, body included, onto a ctx of its own
+// that outlives ctx: with what earlier steps recorded on it and the TLS state
+// a copy has no connection to ask.
+func CopyRequestCtx(ctx *fasthttp.RequestCtx) *fasthttp.RequestCtx {
+	attempt := &fasthttp.RequestCtx{}
+	attempt.Init(&ctx.Request, ctx.RemoteAddr(), nil)
+	// Init copies the request, though not a host only its URI holds.
+	ctx.Request.URI().CopyTo(attempt.Request.URI())
+	ctx.VisitUserValuesAll(func(key, value any) {
+		attempt.SetUserValue(key, value)
+	})
+	if state := tlsState(ctx); state != nil {
+		attempt.SetUserValue(tlsStateKey{}, state)
+	}
+	return attempt
+}
+
+// responseTimeWindow keeps a Backend's last successful response times, in
+// nanoseconds, overwriting the oldest.
+type responseTimeWindow struct {
+	samples [responseTimeWindowSize]atomic.Int64
+	n       atomic.Uint64
+}
+
+func (w *responseTimeWindow) add(d time.Duration) {
+	i := w.n.Add(1) - 1
+	w.samples[i%responseTimeWindowSize].Store(int64(d))
+}
+
+func (w *responseTimeWindow) percentile(p float64) (time.Duration, bool) {
+	n := min(w.n.Load(), responseTimeWindowSize)
+	if n < minResponseTimeSamples {
+		return 0, false
+	}
+	samples := make([]int64, n)
+	for i := range samples {
+		samples[i] = w.samples[i].Load()
+	}
+	slices.Sort(samples)
+	rank := int(math.Ceil(p/100*float64(n))) - 1 //nolint:mnd
+	return time.Duration(samples[max(rank, 0)]), true
+}
+
+func (w *responseTimeWindow) reset() {
+	w.n.Store(0)
+}
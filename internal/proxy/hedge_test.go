@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// delayedServer answers body after delay, recording the X-Request-Id each
+// request carried.
+func delayedServer(t *testing.T, delay time.Duration, body string, ids *sync.Map) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ids != nil {
+			ids.Store(body, r.Header.Get("X-Request-Id"))
+		}
+		time.Sleep(delay)
+		w.Write([]byte(body)) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func enabledHedge() config.Hedge {
+	return config.Hedge{Enabled: true, Delay: 20 * time.Millisecond, BudgetPercent: config.DefaultHedgeBudget}
+}
+
+func hedgeRequest(method string) *fasthttp.RequestCtx {
+	ctx := clientIPRequest("203.0.113.9")
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI("/")
+	ctx.Request.SetHost("shop.example")
+	return ctx
+}
+
+func TestHedge(t *testing.T) {
+	t.Run("a hedge answers for a slow backend", func(t *testing.T) {
+		slow := newRetryTestClient(delayedServer(t, 500*time.Millisecond, "slow", nil).URL)
+		fast := newRetryTestClient(delayedServer(t, 0, "fast", nil).URL)
+		before := Hedges()
+
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		start := time.Now()
+		NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{}).Serve(ctx, inOrder(slow, fast))
+
+		assert.Less(t, time.Since(start), 400*time.Millisecond)
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, "fast", string(ctx.Response.Body()))
+		assert.Equal(t, HedgeStats{Sent: before.Sent + 1, Won: before.Won + 1}, Hedges())
+	})
+
+	t.Run("no hedge for a backend answering within the delay", func(t *testing.T) {
+		first := newRetryTestClient(delayedServer(t, 0, "first", nil).URL)
+		second := newRetryTestClient(delayedServer(t, 0, "second", nil).URL)
+		before := Hedges()
+
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		NewRetryPolicy(config.Retry{}, config.Hedge{Enabled: true, Delay: time.Second, BudgetPercent: 10}, config.Queue{}).Serve(ctx, inOrder(first, second))
+
+		assert.Equal(t, "first", string(ctx.Response.Body()))
+		assert.Equal(t, before, Hedges())
+	})
+
+	t.Run("no hedge for a non-idempotent method", func(t *testing.T) {
+		slow := newRetryTestClient(delayedServer(t, 100*time.Millisecond, "slow", nil).URL)
+		fast := newRetryTestClient(delayedServer(t, 0, "fast", nil).URL)
+		before := Hedges()
+
+		ctx := hedgeRequest(fasthttp.MethodPost)
+		NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{}).Serve(ctx, inOrder(slow, fast))
+
+		assert.Equal(t, "slow", string(ctx.Response.Body()))
+		assert.Equal(t, before, Hedges())
+	})
+
+	t.Run("the first answer wins even when the hedge went out", func(t *testing.T) {
+		first := newRetryTestClient(delayedServer(t, 50*time.Millisecond, "first", nil).URL)
+		slower := newRetryTestClient(delayedServer(t, 500*time.Millisecond, "slower", nil).URL)
+		before := Hedges()
+
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{}).Serve(ctx, inOrder(first, slower))
+
+		assert.Equal(t, "first", string(ctx.Response.Body()))
+		assert.Equal(t, HedgeStats{Sent: before.Sent + 1, Won: before.Won}, Hedges())
+	})
+
+	t.Run("a failed attempt waits for the other", func(t *testing.T) {
+		refused := newRetryTestClient(refusedAddr(t))
+		slow := newRetryTestClient(delayedServer(t, 100*time.Millisecond, "slow", nil).URL)
+		policy := NewRetryPolicy(config.Retry{}, config.Hedge{Enabled: true, Delay: time.Millisecond, BudgetPercent: 10}, config.Queue{})
+
+		// refused fails within the delay: it is the only attempt.
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		policy.Serve(ctx, inOrder(refused, slow))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+		assert.True(t, BackendFailed(ctx))
+
+		// The hedge to refused fails while slow is still answering.
+		ctx = hedgeRequest(fasthttp.MethodGet)
+		policy.Serve(ctx, inOrder(slow, refused))
+		assert.Equal(t, "slow", string(ctx.Response.Body()))
+		assert.False(t, BackendFailed(ctx), "the answer came from slow")
+	})
+
+	t.Run("hedges share the request id", func(t *testing.T) {
+		var ids sync.Map
+		rules := config.HeaderRules{Set: map[string]string{"X-Request-Id": "$request_id"}}
+		slow := newHeaderRulesTestClient(t, strings.TrimPrefix(delayedServer(t, 200*time.Millisecond, "slow", &ids).URL, "http://"), rules, config.HeaderRules{})
+		fast := newHeaderRulesTestClient(t, strings.TrimPrefix(delayedServer(t, 0, "fast", &ids).URL, "http://"), rules, config.HeaderRules{})
+
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{}).Serve(ctx, inOrder(slow, fast))
+
+		assert.Equal(t, "fast", string(ctx.Response.Body()))
+		slowID, _ := ids.Load("slow")
+		fastID, _ := ids.Load("fast")
+		assert.NotEmpty(t, slowID)
+		assert.Equal(t, slowID, fastID)
+	})
+
+	t.Run("the budget caps hedges", func(t *testing.T) {
+		slow := newRetryTestClient(delayedServer(t, 50*time.Millisecond, "slow", nil).URL)
+		fast := newRetryTestClient(delayedServer(t, 0, "fast", nil).URL)
+		policy := NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{})
+		for policy.hedge.budget.withdraw() {
+		}
+		before := Hedges()
+
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		policy.Serve(ctx, inOrder(slow, fast))
+
+		assert.Equal(t, "slow", string(ctx.Response.Body()))
+		assert.Equal(t, before, Hedges())
+	})
+}
+
+func TestHedgeLosers(t *testing.T) {
+	slow := newRetryTestClient(delayedServer(t, 200*time.Millisecond, "slow", nil).URL)
+	fast := newRetryTestClient(delayedServer(t, 0, "fast", nil).URL)
+	policy := NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{})
+
+	ctx := hedgeRequest(fasthttp.MethodGet)
+	policy.Serve(ctx, inOrder(slow, fast))
+	assert.Equal(t, "fast", string(ctx.Response.Body()))
+	assert.Equal(t, int64(1), policy.hedge.losers.Load(), "slow is still answering")
+	assert.Eventually(t, func() bool { return policy.hedge.losers.Load() == 0 }, time.Second, 10*time.Millisecond)
+
+	// Losers still running use up the budget as hedges do.
+	policy.hedge.losers.Store(minRetriesPerWindow)
+	before := Hedges()
+	ctx = hedgeRequest(fasthttp.MethodGet)
+	policy.Serve(ctx, inOrder(slow, fast))
+	assert.Equal(t, "slow", string(ctx.Response.Body()))
+	assert.Equal(t, before, Hedges())
+}
+
+func TestHedgeCallsOffLoser(t *testing.T) {
+	calledOff := make(chan struct{}, 1)
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			calledOff <- struct{}{}
+		case <-time.After(5 * time.Second):
+		}
+	}), &http2.Server{}))
+	t.Cleanup(srv.Close)
+	slow := newH2CTestClient(srv, false)
+	fast := newRetryTestClient(delayedServer(t, 0, "fast", nil).URL)
+	policy := NewRetryPolicy(config.Retry{}, enabledHedge(), config.Queue{})
+
+	// An h2c Backend's attempt is called off once the hedge wins, and not
+	// counted against it.
+	ctx := hedgeRequest(fasthttp.MethodGet)
+	policy.Serve(ctx, inOrder(slow, fast))
+	assert.Equal(t, "fast", string(ctx.Response.Body()))
+	select {
+	case <-calledOff:
+	case <-time.After(time.Second):
+		t.Fatal("the losing attempt was not called off")
+	}
+	assert.Eventually(t, func() bool { return policy.hedge.losers.Load() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Zero(t, slow.RecentResponseTime())
+}
+
+func TestHedgeThenRetry(t *testing.T) {
+	unavailable := newRetryTestClient(statusServer(t, fasthttp.StatusServiceUnavailable).URL)
+	ok := newRetryTestClient(delayedServer(t, 0, "ok", nil).URL)
+
+	// The hedge never fires; the retry moves on from the Backend the attempt used.
+	ctx := hedgeRequest(fasthttp.MethodGet)
+	NewRetryPolicy(enabledRetry("503"), config.Hedge{Enabled: true, Delay: time.Second, BudgetPercent: 10}, config.Queue{}).
+		Serve(ctx, inOrder(unavailable, ok))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+}
+
+func TestResponseTimePercentile(t *testing.T) {
+	var w responseTimeWindow
+	for i := 1; i < minResponseTimeSamples; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := w.percentile(95)
+	assert.False(t, ok)
+
+	w.add(20 * time.Millisecond)
+	d, ok := w.percentile(95)
+	assert.True(t, ok)
+	assert.Equal(t, 19*time.Millisecond, d)
+	d, _ = w.percentile(50)
+	assert.Equal(t, 10*time.Millisecond, d)
+
+	// The oldest samples are overwritten.
+	for range responseTimeWindowSize {
+		w.add(time.Second)
+	}
+	d, _ = w.percentile(1)
+	assert.Equal(t, time.Second, d)
+
+	w.reset()
+	_, ok = w.percentile(95)
+	assert.False(t, ok)
+}
@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"io"
 	"math"
@@ -9,6 +11,7 @@ import (
 	"net/http"
 	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/aaydin-tr/divisor/core/types"
 	"github.com/aaydin-tr/divisor/pkg/helper"
@@ -16,24 +19,43 @@ import (
 )
 
 type NetHttpAdapter struct {
-	Balancer           types.IBalancer
+	Balancer types.IBalancer
+	// serve is Balancer's handler, built once as the fasthttp server builds
+	// it: wrapped balancers put their whole chain together in Serve.
+	serve              func(ctx *fasthttp.RequestCtx)
 	maxRequestBodySize int
+	streamBodies       bool
 }
 
-func NewNetHttpAdapter(balancer types.IBalancer, maxRequestBodySize int) *NetHttpAdapter {
-	return &NetHttpAdapter{Balancer: balancer, maxRequestBodySize: maxRequestBodySize}
+func NewNetHttpAdapter(balancer types.IBalancer, maxRequestBodySize int, streamBodies bool) *NetHttpAdapter {
+	return &NetHttpAdapter{Balancer: balancer, serve: balancer.Serve(), maxRequestBodySize: maxRequestBodySize, streamBodies: streamBodies}
 }
 
 func (a *NetHttpAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isExtendedConnectWebSocket(r) {
+		a.serveWebSocket(w, r)
+		return
+	}
+
 	if a.maxRequestBodySize > 0 && r.ContentLength > int64(a.maxRequestBodySize) {
 		writeBodyTooLarge(w)
 		return
 	}
 
-	if a.maxRequestBodySize > 0 && r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
-		// Mirror fasthttp's chunked-body handling: buffer a length-less body
-		// up to the cap so an oversized payload never reaches a Backend.
-		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(a.maxRequestBodySize)))
+	// Without stream_bodies, buffer the body as fasthttp's own server does,
+	// up to the cap, so an oversized payload never reaches a Backend and
+	// retries, hedges and the mirror, which send it more than once, see it
+	// whole. A body ending in trailers still streams, to carry them. In
+	// streaming mode ProxyClient enforces the cap as the body goes out.
+	var body []byte
+	buffered := !a.streamBodies && r.Body != nil && r.Body != http.NoBody && r.Trailer == nil
+	if buffered {
+		reader := r.Body
+		if a.maxRequestBodySize > 0 {
+			reader = http.MaxBytesReader(w, r.Body, int64(a.maxRequestBodySize))
+		}
+		var err error
+		body, err = io.ReadAll(reader)
 		var maxBytesErr *http.MaxBytesError
 		switch {
 		case errors.As(err, &maxBytesErr):
@@ -44,16 +66,48 @@ func (a *NetHttpAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "error reading request body", http.StatusBadRequest)
 			return
 		}
-		r.Body = io.NopCloser(bytes.NewReader(body))
 	}
 
 	var ctx fasthttp.RequestCtx
 	ctx.Init(&fasthttp.Request{}, nil, nil)
 	ConvertNetHTTPRequestToFastHTTPRequest(r, &ctx)
+	if buffered {
+		ctx.Request.SetBody(body)
+	}
+
+	a.serve(&ctx)
+
+	writeResponse(w, &ctx.Response)
+}
 
-	a.Balancer.Serve()(&ctx)
+func writeResponse(w http.ResponseWriter, res *fasthttp.Response) {
+	copyResponseHeader(w, res)
+	w.WriteHeader(res.StatusCode())
+	if isOpenEndedStream(res) {
+		if fw := newFlushWriter(w); fw.rc.Flush() == nil {
+			res.BodyWriteTo(fw) //nolint:errcheck
+			writeTrailers(w, res)
+			return
+		}
+	}
+	res.BodyWriteTo(w) //nolint:errcheck
+	writeTrailers(w, res)
+}
+
+// writeTrailers sends the trailers an h2c Backend ended its body with, such
+// as gRPC's grpc-status. They are only known once the body has been written,
+// so they go out undeclared, under http.TrailerPrefix.
+func writeTrailers(w http.ResponseWriter, res *fasthttp.Response) {
+	for k := range res.Header.Trailers() {
+		key := string(k)
+		for _, v := range res.Header.PeekAll(key) {
+			w.Header().Add(http.TrailerPrefix+key, string(v))
+		}
+	}
+}
 
-	ctx.Response.Header.All()(func(k []byte, v []byte) bool {
+func copyResponseHeader(w http.ResponseWriter, res *fasthttp.Response) {
+	res.Header.All()(func(k []byte, v []byte) bool {
 		// Content-Length is skipped because fasthttp's Response.SetBody (what
 		// an OnResponse middleware uses to rewrite a body) does not update the
 		// stored value; net/http derives framing from the bytes written.
@@ -63,11 +117,92 @@ func (a *NetHttpAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return true
 	})
 	w.Header().Set("Server", "divisor")
+}
 
-	w.WriteHeader(ctx.Response.StatusCode())
-	ctx.Response.BodyWriteTo(w) //nolint:errcheck
+// isExtendedConnectWebSocket reports whether r bootstraps a WebSocket over an
+// HTTP/2 stream (RFC 8441).
+func isExtendedConnectWebSocket(r *http.Request) bool {
+	return r.Method == http.MethodConnect && strings.EqualFold(r.Header.Get(":protocol"), "websocket")
 }
 
+// serveWebSocket translates an extended CONNECT into the HTTP/1.1 Upgrade
+// handshake Backends speak, then tunnels the stream once the Backend
+// switches protocols.
+func (a *NetHttpAdapter) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	handshake := *r
+	handshake.Method = http.MethodGet
+	handshake.Header = r.Header.Clone()
+	handshake.Header.Del(":protocol")
+	// RFC 8441 drops the key from the HTTP/2 side; an HTTP/1.1 Backend still
+	// requires one, and its Accept answer is never relayed.
+	handshake.Header.Set("Sec-Websocket-Key", newWebSocketKey())
+	handshake.Body = nil
+	handshake.ContentLength = 0
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&fasthttp.Request{}, nil, nil)
+	ConvertNetHTTPRequestToFastHTTPRequest(&handshake, &ctx)
+	ctx.Request.Header.Set(fasthttp.HeaderUpgrade, "websocket")
+	ctx.Request.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+
+	var tunnel fasthttp.HijackHandler
+	ctx.SetUserValue(hijackKey{}, hijackFunc(func(h fasthttp.HijackHandler) { tunnel = h }))
+
+	a.serve(&ctx)
+
+	if tunnel == nil {
+		writeResponse(w, &ctx.Response)
+		return
+	}
+
+	ctx.Response.Header.Del("Sec-Websocket-Accept")
+	copyResponseHeader(w, &ctx.Response)
+	w.WriteHeader(http.StatusOK)
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		return
+	}
+	tunnel(newStreamConn(w, r))
+}
+
+func newWebSocketKey() string {
+	key := make([]byte, 16) //nolint:mnd
+	rand.Read(key)          //nolint:errcheck
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// streamConn presents an extended CONNECT stream as the net.Conn a tunnel
+// splices: reads come from the request body, writes go out flushed.
+type streamConn struct {
+	flushWriter
+	body   io.ReadCloser
+	local  net.Addr
+	remote net.Addr
+}
+
+func newStreamConn(w http.ResponseWriter, r *http.Request) *streamConn {
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return &streamConn{
+		flushWriter: newFlushWriter(w),
+		body:        r.Body,
+		local:       local,
+		remote:      parseRemoteAddr(r.RemoteAddr),
+	}
+}
+
+func (c *streamConn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+// Close ends the stream's reads; returning from ServeHTTP ends the rest.
+func (c *streamConn) Close() error { return c.body.Close() }
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+// An HTTP/2 read deadline resets the whole stream rather than failing one
+// Read, so the tunnel's idle timeout runs off the Backend side alone.
+func (c *streamConn) SetDeadline(time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(time.Time) error { return nil }
+
 func writeBodyTooLarge(w http.ResponseWriter) {
 	w.Header().Set("Server", "divisor")
 	w.Header().Set("Content-Type", "application/json")
@@ -93,6 +228,8 @@ func ConvertNetHTTPRequestToFastHTTPRequest(r *http.Request, ctx *fasthttp.Reque
 
 	if r.Proto != "" {
 		proto := r.Proto
+		// fasthttp only parses HTTP/1.x; an h2c Backend still gets HTTP/2,
+		// from the h2c client.
 		if r.ProtoAtLeast(2, 0) {
 			proto = "HTTP/1.1"
 		}
@@ -112,6 +249,12 @@ func ConvertNetHTTPRequestToFastHTTPRequest(r *http.Request, ctx *fasthttp.Reque
 	} else if r.URL != nil {
 		ctx.Request.SetRequestURI(r.URL.RequestURI())
 	}
+	// The ctx has no TLS connection to report; X-Forwarded-Proto reads the
+	// scheme, header rules the state.
+	if r.TLS != nil {
+		ctx.Request.URI().SetSchemeBytes(httpsB)
+		ctx.SetUserValue(tlsStateKey{}, r.TLS)
+	}
 
 	for k, values := range r.Header {
 		if strings.EqualFold(k, fasthttp.HeaderHost) {
@@ -122,15 +265,6 @@ func ConvertNetHTTPRequestToFastHTTPRequest(r *http.Request, ctx *fasthttp.Reque
 		}
 	}
 
-	for k, values := range r.Trailer {
-		if ctx.Request.Header.AddTrailer(k) != nil {
-			continue
-		}
-		if len(values) > 0 {
-			ctx.Request.Header.Set(k, strings.Join(values, ", "))
-		}
-	}
-
 	if r.Close {
 		ctx.Request.Header.Del(fasthttp.HeaderConnection)
 		ctx.Request.SetConnectionClose()
@@ -141,7 +275,14 @@ func ConvertNetHTTPRequestToFastHTTPRequest(r *http.Request, ctx *fasthttp.Reque
 		if r.ContentLength <= 0 || r.ContentLength >= int64(math.MaxInt) {
 			contentLength = -1
 		}
-		ctx.Request.SetBodyStream(r.Body, contentLength)
+		var body io.Reader = r.Body
+		if r.Trailer != nil {
+			for k := range r.Trailer {
+				ctx.Request.Header.AddTrailer(k) //nolint:errcheck
+			}
+			body = &trailingBody{r: r, req: &ctx.Request}
+		}
+		ctx.Request.SetBodyStream(body, contentLength)
 	}
 
 	if r.RemoteAddr != "" {
@@ -170,6 +311,28 @@ func ConvertNetHTTPRequestToFastHTTPRequest(r *http.Request, ctx *fasthttp.Reque
 	}
 }
 
+// trailingBody passes r's body through and, once it ends, the values of
+// r's declared trailers: net/http only fills them in at that point. An h2c
+// Backend gets them as trailers.
+type trailingBody struct {
+	r   *http.Request
+	req *fasthttp.Request
+}
+
+func (b *trailingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Body.Read(p)
+	if err == io.EOF {
+		for k, values := range b.r.Trailer {
+			for _, v := range values {
+				b.req.Header.Add(k, v)
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *trailingBody) Close() error { return b.r.Body.Close() }
+
 // parseRemoteAddr parses an http.Request.RemoteAddr into a net.Addr. It only
 // parses the string and never resolves host names, so it cannot block.
 // net/http sets RemoteAddr to an "IP:port" pair, but a bare IP without a port
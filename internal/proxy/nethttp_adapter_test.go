@@ -13,11 +13,15 @@ import (
 
 type stubBalancer struct {
 	handler func(ctx *fasthttp.RequestCtx)
+	builds  int
 }
 
-func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) { return s.handler }
-func (s *stubBalancer) Stats() []types.ProxyStat              { return nil }
-func (s *stubBalancer) Shutdown() error                       { return nil }
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	s.builds++
+	return s.handler
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return nil }
+func (s *stubBalancer) Shutdown() error          { return nil }
 
 func TestNetHttpAdapterServeHTTP(t *testing.T) {
 	balancer := &stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
@@ -46,11 +50,15 @@ func TestNetHttpAdapterServeHTTP(t *testing.T) {
 		ctx.Response.SetBodyString("hello")
 	}}
 
-	adapter := NewNetHttpAdapter(balancer, 0)
+	adapter := NewNetHttpAdapter(balancer, 0, false)
 	req := httptest.NewRequest(http.MethodGet, "http://example.com/x", nil)
 	rec := httptest.NewRecorder()
 
 	adapter.ServeHTTP(rec, req)
+	adapter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/x", nil))
+	if balancer.builds != 1 {
+		t.Errorf("expected the handler to be built once, got %d", balancer.builds)
+	}
 
 	res := rec.Result()
 	defer res.Body.Close()
@@ -91,10 +99,9 @@ func TestNetHttpAdapterMaxRequestBodySize(t *testing.T) {
 	newAdapter := func(reached *bool, streamed *int) *NetHttpAdapter {
 		return NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
 			*reached = true
-			n, _ := io.Copy(io.Discard, ctx.Request.BodyStream())
-			*streamed = int(n)
+			*streamed = len(ctx.Request.Body())
 			ctx.Response.SetStatusCode(fasthttp.StatusOK)
-		}}, limit)
+		}}, limit, false)
 	}
 
 	t.Run("DeclaredLengthOverLimit", func(t *testing.T) {
@@ -148,6 +155,28 @@ func TestNetHttpAdapterMaxRequestBodySize(t *testing.T) {
 	})
 }
 
+// Without stream_bodies a body arrives buffered, as over HTTP/1.1, so
+// retries, hedges and the mirror can send it again.
+func TestNetHttpAdapterBuffersBodies(t *testing.T) {
+	for _, streamBodies := range []bool{false, true} {
+		var isStream bool
+		var body string
+		adapter := NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+			isStream = ctx.Request.IsBodyStream()
+			body = string(ctx.Request.Body())
+		}}, 64, streamBodies)
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/x", bytes.NewReader([]byte("payload")))
+		adapter.ServeHTTP(httptest.NewRecorder(), req)
+
+		if isStream != streamBodies {
+			t.Errorf("stream_bodies %v: body stream %v", streamBodies, isStream)
+		}
+		if body != "payload" {
+			t.Errorf("stream_bodies %v: expected body payload, got %q", streamBodies, body)
+		}
+	}
+}
+
 // A middleware rewriting a response body via SetBodyString leaves the parsed
 // backend Content-Length untouched; forwarding it truncated longer rewrites
 // and broke shorter ones. The adapter must let net/http derive the length
@@ -171,7 +200,7 @@ func TestNetHttpAdapterIgnoresStaleContentLength(t *testing.T) {
 				ctx.Response.SetBodyString(tc.body)
 			}}
 
-			server := httptest.NewServer(NewNetHttpAdapter(balancer, 0))
+			server := httptest.NewServer(NewNetHttpAdapter(balancer, 0, false))
 			defer server.Close()
 
 			res, err := http.Get(server.URL)
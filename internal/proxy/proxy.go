@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"math"
@@ -26,8 +29,10 @@ type IProxyClient interface {
 	ReverseProxyHandler(ctx *fasthttp.RequestCtx) error
 	Stat() types.ProxyStat
 	PendingRequests() int
+	Saturated() bool
 	AvgResponseTime() float64
 	RecentResponseTime() float64
+	ResponseTimePercentile(p float64) (time.Duration, bool)
 	ResetRecentResponseTime()
 	Close() error
 }
@@ -49,6 +54,7 @@ var hopHeaders = [][]byte{
 }
 var XForwardedFor = []byte("X-Forwarded-For")
 var httpB = []byte("http")
+var httpsB = []byte("https")
 
 type errorMessage struct {
 	Message string `json:"message"`
@@ -68,16 +74,43 @@ const failureResponseTimePenalty = 10 * time.Second
 const microsPerMilli = float64(1000)
 
 type ProxyClient struct {
-	proxy                *fasthttp.HostClient
+	proxy                upstream
 	totalRequestCount    *uint64
 	totalResTime         *uint64
 	measuredRequestCount *uint64
 	recentResTime        *uint64
+	responseTimes        *responseTimeWindow
 	customHeaders        map[string]string
 	middlewareExecutor   *middlewarePkg.Executor
 	Addr                 string
-	addrB                []byte
+	// The Host the Backend is sent: its address, a host_header literal, or
+	// nil to keep the client's.
+	hostB []byte
+	// http or https, whichever the Backend speaks.
+	schemeB   []byte
+	tlsConfig *tls.Config
+	// The Backend speaks h2c: proxy is a *transportClient.
+	h2c bool
+	// The PROXY header version the Backend takes, 0 for none.
+	proxyProtocol int
+	// transport is the *transportClient proxy holds, for h2c or a PROXY
+	// header; nil for fasthttp's HostClient.
+	transport        *transportClient
+	forwardedHeaders config.ForwardedHeaders
+	clientIP         config.ClientIP
+	compression      config.Compression
+	requestHeaders   []config.HeaderOp
+	responseHeaders  []config.HeaderOp
+	// nil when the Backend has no rewrite settings.
+	rewrite *config.Rewrite
+	// maxConns bounds the connections to an http1 Backend; zero for the
+	// others, which open what they need.
+	maxConns             int
 	proxyTimeout         time.Duration
+	webSocketIdleTimeout time.Duration
+	tunnels              tunnels
+	maxRequestBodySize   int
+	streamBodies         bool
 }
 
 func (h *ProxyClient) ReverseProxyHandler(ctx *fasthttp.RequestCtx) error {
@@ -86,56 +119,81 @@ func (h *ProxyClient) ReverseProxyHandler(ctx *fasthttp.RequestCtx) error {
 
 	req := &ctx.Request
 	res := &ctx.Response
-	clientIP := helper.S2B(ctx.RemoteIP().String())
+	peerIP := helper.S2B(ctx.RemoteIP().String())
+	client := ClientIP(ctx, &h.clientIP)
 	mwCtx := middleware.NewContext(ctx)
+	mwCtx.ClientIP = client
+	upgrade := IsWebSocketUpgrade(req)
 
-	h.preReq(req, clientIP)
+	h.preReq(ctx, peerIP, helper.S2B(client.String()))
 
 	if h.middlewareExecutor != nil {
 		if err := h.middlewareExecutor.RunOnRequest(mwCtx); err != nil {
-			h.postRes(res)
+			h.postRes(ctx)
 			middlewareError(res, err)
 			return err
 		}
 	}
 
-	// fasthttp treats DoTimeout(0) as already expired, not "no deadline".
+	if upgrade {
+		return h.proxyWebSocket(ctx, mwCtx)
+	}
+
 	var serverErr error
-	if h.proxyTimeout > 0 {
-		serverErr = h.proxy.DoTimeout(req, res, h.proxyTimeout)
+	if h.overBodyLimit(req) {
+		serverErr = fasthttp.ErrBodyTooLarge
 	} else {
-		serverErr = h.proxy.Do(req, res)
+		out, release := h.upstreamRequest(req)
+		switch {
+		case h.transport != nil:
+			serverErr = h.transport.do(attemptContext(ctx), out, res, h.proxyTimeout, h.proxyHeader(ctx))
+		// fasthttp treats DoTimeout(0) as already expired, not "no deadline".
+		case h.proxyTimeout > 0:
+			serverErr = h.proxy.DoTimeout(out, res, h.proxyTimeout)
+		default:
+			serverErr = h.proxy.Do(out, res)
+		}
+		release()
 	}
-	if serverErr != nil {
+	// An oversized body is the client's fault, not the Backend's, and an
+	// attempt called off, a hedge's loser, no fault at all.
+	failed := serverErr != nil && !errors.Is(serverErr, fasthttp.ErrBodyTooLarge) && !errors.Is(serverErr, context.Canceled)
+	if failed {
 		h.recordFailure(time.Since(s))
 	}
+	ctx.SetUserValue(backendFailedKey{}, failed || serverErr == nil && isFailureStatus(res.StatusCode()))
 
 	if h.middlewareExecutor != nil {
 		if handledErr := h.middlewareExecutor.RunOnResponse(mwCtx, serverErr); handledErr != nil {
-			h.postRes(res)
+			h.postRes(ctx)
 			middlewareError(res, handledErr)
 			return handledErr
 		}
 	}
 
-	h.postRes(res)
+	h.postRes(ctx)
 	if serverErr != nil {
-		h.serverError(res, serverErr)
+		h.serverError(ctx, serverErr)
 		return serverErr
 	}
+	if isOpenEndedStream(res) {
+		res.ImmediateHeaderFlush = true
+	}
 
 	h.recordResponseTime(time.Since(s))
 	return nil
 }
 
-// recordResponseTime keeps two measures of a successful request: a lifetime
-// total for the stats endpoint and a moving average for least-response-time.
+// recordResponseTime keeps three measures of a successful request: a lifetime
+// total for the stats endpoint, a moving average for least-response-time and
+// a window of recent samples for hedge delays.
 // Microseconds: sub-millisecond Backends must not all average to zero, or
 // least-response-time cannot tell them apart.
 func (h *ProxyClient) recordResponseTime(elapsed time.Duration) {
 	atomic.AddUint64(h.totalResTime, uint64(elapsed.Microseconds()))
 	atomic.AddUint64(h.measuredRequestCount, 1)
 	h.storeRecentResTime(durationMicros(elapsed))
+	h.responseTimes.add(elapsed)
 }
 
 // recordFailure feeds only the moving average: a failure must push the
@@ -162,25 +220,68 @@ func (h *ProxyClient) storeRecentResTime(micros float64) {
 	}
 }
 
-func (h *ProxyClient) preReq(req *fasthttp.Request, clientIP []byte) {
-	// Nominated headers go first: a client nominating Host or X-Forwarded-For
+// preReq prepares the request for the Backend. peerIP is the connecting
+// peer, which the forwarded headers record; clientIP is the resolved client.
+func (h *ProxyClient) preReq(ctx *fasthttp.RequestCtx, peerIP, clientIP []byte) {
+	req := &ctx.Request
+	captureClientRequest(ctx)
+	h.rewriteRequest(ctx)
+	// gRPC servers require "TE: trailers"; like net/http's ReverseProxy,
+	// keep that one value of the hop-by-hop header for HTTP/2 Backends, and
+	// the trailers the request declared, which HTTP/2 only accepts declared.
+	teTrailers := h.h2c && acceptsTrailers(req)
+	var trailers []string
+	if h.h2c {
+		for k := range req.Header.Trailers() {
+			trailers = append(trailers, string(k))
+		}
+	}
+
+	// Nominated headers go first: a client nominating Host or X-Forwarded-*
 	// only deletes its own values, and divisor's are set below.
 	delConnectionNominated(&req.Header)
 	for _, h := range hopHeaders {
 		req.Header.DelBytes(h)
 	}
+	if teTrailers {
+		req.Header.Set("Te", "trailers")
+	}
+	for _, k := range trailers {
+		req.Header.AddTrailer(k) //nolint:errcheck
+	}
 
-	req.URI().SetSchemeBytes(httpB)
-	req.SetHostBytes(h.addrB)
-	req.Header.SetBytesKV(XForwardedFor, clientIP)
+	h.setForwarded(ctx, peerIP)
+	req.URI().SetSchemeBytes(h.schemeB)
+	if h.hostB != nil {
+		req.SetHostBytes(h.hostB)
+	} else if client, ok := ctx.UserValue(clientRequestKey{}).(*clientRequest); ok {
+		// An earlier attempt may have set another Backend's Host.
+		req.SetHost(client.host)
+	}
 	h.setCustomHeaders(req, clientIP)
+	h.applyHeaderRules(ctx, &req.Header, h.requestHeaders)
 }
 
-func (h *ProxyClient) postRes(res *fasthttp.Response) {
+func acceptsTrailers(req *fasthttp.Request) bool {
+	for _, value := range req.Header.PeekAll("Te") {
+		for token := range bytes.SplitSeq(value, helper.S2B(",")) {
+			if bytes.EqualFold(bytes.TrimSpace(token), helper.S2B("trailers")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *ProxyClient) postRes(ctx *fasthttp.RequestCtx) {
+	res := &ctx.Response
 	delConnectionNominated(&res.Header)
 	for _, h := range hopHeaders {
 		res.Header.DelBytes(h)
 	}
+	h.rewriteResponse(ctx)
+	h.compress(ctx)
+	h.applyHeaderRules(ctx, &res.Header, h.responseHeaders)
 }
 
 type headerSet interface {
@@ -241,11 +342,40 @@ func errorMessageBody(err error) []byte {
 	return body
 }
 
+// backendFailedKey holds whether the last attempt at a request failed at its
+// Backend; see BackendFailed.
+type backendFailedKey struct{}
+
+// BackendFailed reports whether the last attempt at ctx failed at a Backend:
+// it could not be reached, timed out, or answered 502, 503 or 504. The 503s
+// divisor answers itself, for a full queue or no Alive Backend, are not
+// failures of a Backend.
+func BackendFailed(ctx *fasthttp.RequestCtx) bool {
+	failed, _ := ctx.UserValue(backendFailedKey{}).(bool)
+	return failed
+}
+
+func isFailureStatus(status int) bool {
+	return status == fasthttp.StatusBadGateway || status == fasthttp.StatusServiceUnavailable || status == fasthttp.StatusGatewayTimeout
+}
+
+// Retry-After sent with NoAliveBackends, in nanoseconds; zero omits it.
+var noBackendsRetryAfter atomic.Int64
+
+// SetNoBackendsRetryAfter sets the Retry-After NoAliveBackends advertises.
+func SetNoBackendsRetryAfter(d time.Duration) {
+	noBackendsRetryAfter.Store(int64(d))
+}
+
 // NoAliveBackends answers a request that arrived while every Backend is Down:
 // 503 until a Probe lets one Rejoin.
 func NoAliveBackends(ctx *fasthttp.RequestCtx) {
 	ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
 	ctx.Response.SetConnectionClose()
+	if d := time.Duration(noBackendsRetryAfter.Load()); d > 0 {
+		// Retry-After is whole seconds; round up so clients never come back early.
+		ctx.Response.Header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10))
+	}
 	ctx.Response.Header.Set("Content-Type", "application/json")
 	ctx.Response.SetBodyString(`{"message":"no backends available"}`)
 }
@@ -260,9 +390,7 @@ func ErrorHandler(ctx *fasthttp.RequestCtx, err error) {
 	switch {
 	case errors.Is(err, fasthttp.ErrBodyTooLarge):
 		ctx.Response.Reset()
-		ctx.Response.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
-		ctx.Response.Header.Set("Content-Type", "application/json")
-		ctx.Response.SetBodyString(bodyTooLargeMessage)
+		setBodyTooLarge(&ctx.Response)
 	case errors.As(err, &smallBuffer):
 		ctx.Error("Too big request header", fasthttp.StatusRequestHeaderFieldsTooLarge)
 	case errors.As(err, &netErr) && netErr.Timeout():
@@ -272,14 +400,38 @@ func ErrorHandler(ctx *fasthttp.RequestCtx, err error) {
 	}
 }
 
+func setBodyTooLarge(res *fasthttp.Response) {
+	res.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+	res.Header.Set("Content-Type", "application/json")
+	res.SetBodyString(bodyTooLargeMessage)
+}
+
 // 504 means proxy_timeout expired on a hanging Backend, which fasthttp
 // reports as ErrTimeout; 502 covers everything else. Dial timeouts stay 502:
-// an unreachable Backend is Down, not hanging.
-func (h *ProxyClient) serverError(res *fasthttp.Response, err error) {
-	zap.S().Infof("error when proxying the request: %s", err)
+// an unreachable Backend is Down, not hanging. A streamed request body that
+// outgrew max_request_body_size gets the 413 a buffered one would.
+func (h *ProxyClient) serverError(ctx *fasthttp.RequestCtx, err error) {
+	res := &ctx.Response
+	if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+		// The rest of the body is still unread on the client connection.
+		res.SetConnectionClose()
+		setBodyTooLarge(res)
+		return
+	}
+
+	if reason := tlsFailure(err); reason != "" {
+		zap.S().Infof("TLS to the backend failed when proxying the request from %s, %s: %s", ClientIP(ctx, &h.clientIP), reason, err)
+	} else {
+		zap.S().Infof("error when proxying the request from %s: %s", ClientIP(ctx, &h.clientIP), err)
+	}
 	status := fasthttp.StatusBadGateway
-	if errors.Is(err, fasthttp.ErrTimeout) {
+	switch {
+	case errors.Is(err, fasthttp.ErrTimeout):
 		status = fasthttp.StatusGatewayTimeout
+	case errors.Is(err, fasthttp.ErrNoFreeConns):
+		// The Backend is at max_conn, not failing: it is busy, as the
+		// queue says when it gives up.
+		status = fasthttp.StatusServiceUnavailable
 	}
 	res.SetStatusCode(status)
 	res.SetConnectionClose()
@@ -287,6 +439,30 @@ func (h *ProxyClient) serverError(res *fasthttp.Response, err error) {
 	res.SetBody(helper.S2B(`{"message":"` + err.Error() + `"}`))
 }
 
+// tlsFailure names why a handshake with a TLS Backend failed, or returns ""
+// when err is not a TLS failure. All of them answer 502 like any other
+// unreachable Backend; the reason only goes to the log, where a certificate
+// problem would otherwise read like a network one.
+func tlsFailure(err error) string {
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &verifyErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return "backend certificate rejected"
+	case errors.As(err, &recordErr):
+		return "backend does not speak TLS"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// A TLS alert: typically the Backend refusing divisor's client certificate.
+		return "handshake rejected by backend"
+	}
+	return ""
+}
+
 func (h *ProxyClient) setCustomHeaders(req *fasthttp.Request, clientIP []byte) {
 	for k, v := range h.customHeaders {
 		switch v {
@@ -311,6 +487,7 @@ func (h *ProxyClient) Stat() types.ProxyStat {
 		Addr:          h.Addr,
 		LastUseTime:   h.proxy.LastUseTime(),
 		ConnsCount:    h.proxy.ConnsCount(),
+		OpenTunnels:   h.tunnels.Len(),
 	}
 }
 
@@ -318,6 +495,12 @@ func (h *ProxyClient) PendingRequests() int {
 	return h.proxy.PendingRequests()
 }
 
+// Saturated reports whether every connection max_conn allows the Backend is
+// busy, so that a request sent now would wait for one.
+func (h *ProxyClient) Saturated() bool {
+	return h.maxConns > 0 && h.proxy.PendingRequests() >= h.maxConns
+}
+
 // AvgResponseTime returns the lifetime average over successful requests in
 // milliseconds; totalResTime accumulates microseconds. Failed and in-flight
 // requests count toward totalRequestCount but not here — dividing by them
@@ -339,14 +522,22 @@ func (h *ProxyClient) RecentResponseTime() float64 {
 	return math.Float64frombits(atomic.LoadUint64(h.recentResTime)) / microsPerMilli
 }
 
+// ResponseTimePercentile returns the p-th percentile of the Backend's last
+// successful response times, or false while too few were measured to say.
+func (h *ProxyClient) ResponseTimePercentile(p float64) (time.Duration, bool) {
+	return h.responseTimes.percentile(p)
+}
+
 // ResetRecentResponseTime makes the Backend read as unmeasured again. Called
 // on Rejoin: the score from before it went Down — possibly a failure
 // penalty — would otherwise starve it long after it recovered.
 func (h *ProxyClient) ResetRecentResponseTime() {
 	atomic.StoreUint64(h.recentResTime, 0)
+	h.responseTimes.reset()
 }
 
 func (h *ProxyClient) Close() error {
+	h.tunnels.closeAll(tunnelCloseGrace)
 	h.proxy.CloseIdleConnections()
 	return nil
 }
@@ -356,7 +547,8 @@ func NewProxyClient(backend *config.Backend, customHeaders map[string]string, mi
 		return nil
 	}
 
-	proxyClient := &fasthttp.HostClient{
+	var proxyClient upstream
+	hostClient := &fasthttp.HostClient{
 		Addr:                      backend.Url,
 		MaxConns:                  backend.MaxConnection,
 		MaxConnDuration:           backend.MaxConnDuration,
@@ -366,19 +558,75 @@ func NewProxyClient(backend *config.Backend, customHeaders map[string]string, mi
 		// Without a pinned dialer, fasthttp uses proxy_timeout as the
 		// per-dial bound, hanging on unreachable Backends instead of
 		// failing 502 within DefaultDialTimeout (3s).
-		Dial: fasthttp.Dial,
+		Dial:               fasthttp.Dial,
+		StreamResponseBody: backend.StreamBodies,
+		IsTLS:              backend.TLS.Enabled,
+		TLSConfig:          backend.TLS.Config,
+	}
+	if backend.StreamBodies {
+		hostClient.Dial = dialIdleTimeout(backend.ProxyTimeout)
+	}
+	proxyClient = hostClient
+	maxConns := backend.MaxConnection
+	var transport *transportClient
+	h2c := backend.Protocol == config.BackendProtocolH2C
+	if h2c {
+		transport = newH2CClient(backend)
+	}
+	proxyProtocol := backend.ProxyProtocolVersion()
+	if proxyProtocol != 0 {
+		transport = newProxyProtocolClient(backend)
+	}
+	if transport != nil {
+		proxyClient = transport
+		maxConns = 0
+	}
+
+	var rewrite *config.Rewrite
+	if backend.Rewrite.Enabled() {
+		rewrite = &backend.Rewrite
+	}
+
+	hostB := helper.S2B(backend.Url)
+	switch backend.HostHeader {
+	case config.HostHeaderPreserve:
+		hostB = nil
+	case config.HostHeaderBackend, "":
+	default:
+		hostB = []byte(backend.HostHeader)
+	}
+
+	schemeB := httpB
+	if backend.TLS.Enabled {
+		schemeB = httpsB
 	}
 
 	return &ProxyClient{
 		proxy:                proxyClient,
 		Addr:                 backend.Url,
-		addrB:                helper.S2B(backend.Url),
+		hostB:                hostB,
+		schemeB:              schemeB,
+		tlsConfig:            backend.TLS.Config,
+		h2c:                  h2c,
+		proxyProtocol:        proxyProtocol,
+		transport:            transport,
+		forwardedHeaders:     backend.ForwardedHeaders,
+		clientIP:             backend.ClientIP,
+		compression:          backend.Compression,
+		requestHeaders:       backend.RequestHeaders.Ops,
+		responseHeaders:      backend.ResponseHeaders.Ops,
+		rewrite:              rewrite,
 		totalRequestCount:    new(uint64),
 		totalResTime:         new(uint64),
 		measuredRequestCount: new(uint64),
 		recentResTime:        new(uint64),
+		responseTimes:        new(responseTimeWindow),
 		customHeaders:        customHeaders,
 		middlewareExecutor:   middlewareExecutor,
+		maxConns:             maxConns,
 		proxyTimeout:         backend.ProxyTimeout,
+		webSocketIdleTimeout: backend.WebSocketIdleTimeout,
+		maxRequestBodySize:   backend.MaxRequestBodySize,
+		streamBodies:         backend.StreamBodies,
 	}
 }
@@ -199,13 +199,42 @@ func TestServerErrorStatusMapping(t *testing.T) {
 	p := &ProxyClient{}
 
 	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
-	res := fasthttp.AcquireResponse()
-	p.serverError(res, dialTimeout)
-	assert.Equal(t, fasthttp.StatusBadGateway, res.StatusCode())
+	ctx := fasthttp.RequestCtx{}
+	p.serverError(&ctx, dialTimeout)
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
 
-	res = fasthttp.AcquireResponse()
-	p.serverError(res, fasthttp.ErrTimeout)
-	assert.Equal(t, fasthttp.StatusGatewayTimeout, res.StatusCode())
+	ctx = fasthttp.RequestCtx{}
+	p.serverError(&ctx, fasthttp.ErrTimeout)
+	assert.Equal(t, fasthttp.StatusGatewayTimeout, ctx.Response.StatusCode())
+
+	ctx = fasthttp.RequestCtx{}
+	p.serverError(&ctx, fasthttp.ErrNoFreeConns)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+}
+
+func TestBackendFailed(t *testing.T) {
+	bServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		status, _ := strconv.Atoi(req.Header.Get("Status"))
+		res.WriteHeader(status)
+	}))
+	defer bServer.Close()
+	p := NewProxyClient(&config.Backend{Url: protocolRegex.ReplaceAllString(bServer.URL, "")}, nil, nil).(*ProxyClient)
+
+	for status, failed := range map[string]bool{"200": false, "404": false, "500": false, "502": true, "503": true, "504": true} {
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("Status", status)
+		assert.NoError(t, p.ReverseProxyHandler(&ctx))
+		assert.Equal(t, failed, BackendFailed(&ctx), status)
+	}
+
+	ctx := fasthttp.RequestCtx{}
+	assert.Error(t, NewProxyClient(&config.Backend{Url: "invalid-host:99999"}, nil, nil).ReverseProxyHandler(&ctx))
+	assert.True(t, BackendFailed(&ctx))
+
+	// divisor's own 503 reached no Backend.
+	ctx = fasthttp.RequestCtx{}
+	NoAliveBackends(&ctx)
+	assert.False(t, BackendFailed(&ctx))
 }
 
 func TestReverseProxyHandler(t *testing.T) {
@@ -1446,3 +1475,85 @@ func TestConnectionNominatedHeadersStripped(t *testing.T) {
 		assert.Empty(t, res.Header.Peek("X-Two"))
 	})
 }
+
+func TestNoAliveBackendsRetryAfter(t *testing.T) {
+	defer SetNoBackendsRetryAfter(0)
+
+	t.Run("omitted when unset", func(t *testing.T) {
+		SetNoBackendsRetryAfter(0)
+		ctx := fasthttp.RequestCtx{}
+		NoAliveBackends(&ctx)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Nil(t, ctx.Response.Header.Peek("Retry-After"))
+	})
+
+	t.Run("whole seconds, rounded up", func(t *testing.T) {
+		SetNoBackendsRetryAfter(1500 * time.Millisecond)
+		ctx := fasthttp.RequestCtx{}
+		NoAliveBackends(&ctx)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, "2", string(ctx.Response.Header.Peek("Retry-After")))
+	})
+}
+
+// hostServer records the Host of each request it gets.
+func hostServer(t *testing.T, host *string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*host = r.Host
+	}))
+	t.Cleanup(srv.Close)
+	return protocolRegex.ReplaceAllString(srv.URL, "")
+}
+
+func newHostHeaderTestClient(t *testing.T, addr, hostHeader string) *ProxyClient {
+	t.Helper()
+	cfg := config.Config{Port: "8000", Backends: []config.Backend{{Url: addr, HostHeader: hostHeader}}}
+	assert.NoError(t, cfg.PrepareConfig())
+	b := cfg.Backends[0]
+	b.ProxyTimeout = time.Second
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func TestHostHeader(t *testing.T) {
+	var host string
+	addr := hostServer(t, &host)
+	tests := []struct {
+		hostHeader, want string
+	}{
+		{"", addr},
+		{config.HostHeaderBackend, addr},
+		{config.HostHeaderPreserve, "api.example.com"},
+		{"internal.example:8443", "internal.example:8443"},
+	}
+	for _, tt := range tests {
+		p := newHostHeaderTestClient(t, addr, tt.hostHeader)
+		ctx := clientIPRequest("203.0.113.9")
+		ctx.Request.SetHost("api.example.com")
+		assert.NoError(t, p.ReverseProxyHandler(ctx), tt.hostHeader)
+		assert.Equal(t, tt.want, host, tt.hostHeader)
+		assert.Equal(t, "api.example.com", string(ctx.Request.Header.Peek("X-Forwarded-Host")), tt.hostHeader)
+
+		frontend, client := h2Frontend(t, p)
+		req, err := http.NewRequest(http.MethodGet, frontend.URL+"/", nil)
+		assert.NoError(t, err)
+		req.Host = "api.example.com"
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, tt.want, host, "over HTTP/2: "+tt.hostHeader)
+	}
+}
+
+func TestHostHeaderPreservedOnRetry(t *testing.T) {
+	var host string
+	first := newHostHeaderTestClient(t, refusedAddr(t), config.HostHeaderBackend)
+	second := newHostHeaderTestClient(t, hostServer(t, &host), config.HostHeaderPreserve)
+
+	ctx := clientIPRequest("203.0.113.9")
+	ctx.Request.SetHost("api.example.com")
+	assert.Error(t, first.ReverseProxyHandler(ctx))
+	ctx.Response.Reset()
+	assert.NoError(t, second.ReverseProxyHandler(ctx))
+	assert.Equal(t, "api.example.com", host, "not the first Backend's address")
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
+	"github.com/valyala/fasthttp"
+)
+
+// proxyHeader returns the PROXY header the Backend's connection for this
+// request opens with, nil if it takes none. It names the resolved client,
+// with the port it connected from when that client is the connecting peer,
+// and the address the client reached divisor at.
+func (h *ProxyClient) proxyHeader(ctx *fasthttp.RequestCtx) []byte {
+	if h.proxyProtocol == 0 {
+		return nil
+	}
+	client := ClientIP(ctx, &h.clientIP)
+	header := proxyproto.Header{Source: &net.TCPAddr{IP: client}}
+	if peer, ok := ctx.RemoteAddr().(*net.TCPAddr); ok && peer.IP.Equal(client) {
+		header.Source.Port = peer.Port
+	}
+	if local, ok := ctx.LocalAddr().(*net.TCPAddr); ok {
+		header.Destination = local
+	}
+	return header.Format(h.proxyProtocol)
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// proxyProtocolBackend requires a PROXY header on every connection and
+// answers with the client address it named; WebSocket upgrades included,
+// which it turns down.
+func proxyProtocolBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Remote", r.RemoteAddr)
+		if r.Header.Get("Upgrade") != "" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	srv.Listener = proxyproto.NewListener(srv.Listener, true, time.Second)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newProxyProtocolTestClient(srv *httptest.Server, version string, clientIP config.ClientIP) *ProxyClient {
+	b := config.Backend{
+		Url:           protocolRegex.ReplaceAllString(srv.URL, ""),
+		ProxyTimeout:  time.Second,
+		ProxyProtocol: version,
+		ClientIP:      clientIP,
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func TestProxyProtocolToBackend(t *testing.T) {
+	srv := proxyProtocolBackend(t)
+	for _, version := range config.ValidBackendProxyProtocols {
+		t.Run(version, func(t *testing.T) {
+			p := newProxyProtocolTestClient(srv, version, config.ClientIP{})
+
+			// One connection per request: each names its own client.
+			for _, peer := range []string{"203.0.113.9", "2001:db8::9"} {
+				ctx := clientIPRequest(peer)
+				assert.NoError(t, p.ReverseProxyHandler(ctx))
+				assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+				assert.Equal(t, net.JoinHostPort(peer, "40000"), string(ctx.Response.Header.Peek("X-Seen-Remote")))
+			}
+			assert.Eventually(t, func() bool { return p.Stat().ConnsCount == 0 }, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestProxyProtocolNamesResolvedClient(t *testing.T) {
+	p := newProxyProtocolTestClient(proxyProtocolBackend(t), config.ProxyProtocolV2,
+		config.ClientIP{Source: config.ClientIPXRealIP, Trusted: trustedTestRanges})
+
+	ctx := clientIPRequest("10.0.0.1", "X-Real-IP", "203.0.113.9")
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	// The port the client used at the trusted proxy is not known.
+	assert.Equal(t, "203.0.113.9:0", string(ctx.Response.Header.Peek("X-Seen-Remote")))
+}
+
+func TestProxyProtocolWebSocketHandshake(t *testing.T) {
+	p := newProxyProtocolTestClient(proxyProtocolBackend(t), config.ProxyProtocolV1, config.ClientIP{})
+
+	ctx := clientIPRequest("203.0.113.9", "Upgrade", "websocket", "Connection", "Upgrade")
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	assert.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+	assert.Equal(t, "203.0.113.9:40000", string(ctx.Response.Header.Peek("X-Seen-Remote")))
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	errQueueFull    = errors.New("too many requests are waiting for a backend")
+	errQueueTimeout = errors.New("no backend had a free connection in time")
+)
+
+// Requests queued since startup, across balancers, for /stats. queueWait
+// adds up their waits in nanoseconds.
+var (
+	queueDepth                             atomic.Int64
+	queueWait                              atomic.Int64
+	queued, queueOverflowed, queueTimedOut atomic.Uint64
+)
+
+// QueueStats is what /stats reports about the queue: Queued counts the
+// requests that waited for a Backend, Overflowed and TimedOut those answered
+// 503 because the queue was full or max_wait ran out, and WaitSeconds adds up
+// how long queued requests waited.
+type QueueStats struct {
+	Depth       int64   `json:"depth"`
+	Queued      uint64  `json:"queued"`
+	Overflowed  uint64  `json:"overflowed"`
+	TimedOut    uint64  `json:"timed_out"`
+	WaitSeconds float64 `json:"wait_seconds"`
+}
+
+func Queued() QueueStats {
+	return QueueStats{
+		Depth:       queueDepth.Load(),
+		Queued:      queued.Load(),
+		Overflowed:  queueOverflowed.Load(),
+		TimedOut:    queueTimedOut.Load(),
+		WaitSeconds: time.Duration(queueWait.Load()).Seconds(),
+	}
+}
+
+// requestQueue holds requests while every Backend the balancer would hand
+// out is at max_conn. A request it wakes takes whichever Backend is free
+// then, not the one the balancer picked when it arrived.
+type requestQueue struct {
+	mu sync.Mutex
+	// waiting holds one channel per queued request, oldest first.
+	waiting  []chan struct{}
+	maxDepth int
+	maxWait  time.Duration
+	lifo     bool
+}
+
+func newRequestQueue(cfg config.Queue) *requestQueue {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &requestQueue{maxDepth: cfg.MaxDepth, maxWait: cfg.MaxWait, lifo: cfg.Order == config.QueueLIFO}
+}
+
+// pick returns a Backend next hands out with a free connection, waiting
+// for one while every Backend is saturated. It returns nil and no error when
+// next has no Alive Backend.
+func (q *requestQueue) pick(next NextFunc) (IProxyClient, error) {
+	if proxyClient, saturated := freeBackend(next); !saturated {
+		return proxyClient, nil
+	}
+
+	q.mu.Lock()
+	// A connection handed back since the check above woke nobody, as
+	// release found no one waiting; once this request is in the queue,
+	// under the same lock, the next one will wake it.
+	if proxyClient, saturated := freeBackend(next); !saturated {
+		q.mu.Unlock()
+		return proxyClient, nil
+	}
+	if len(q.waiting) >= q.maxDepth {
+		q.mu.Unlock()
+		queueOverflowed.Add(1)
+		return nil, errQueueFull
+	}
+	ready := make(chan struct{}, 1)
+	q.waiting = append(q.waiting, ready)
+	q.mu.Unlock()
+
+	queued.Add(1)
+	queueDepth.Add(1)
+	start := time.Now()
+	defer func() {
+		queueDepth.Add(-1)
+		queueWait.Add(int64(time.Since(start)))
+	}()
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ready:
+			if proxyClient, saturated := freeBackend(next); !saturated {
+				return proxyClient, nil
+			}
+			// A request that just arrived took the connection; wait for
+			// the next one without losing the place.
+			q.requeue(ready)
+		case <-timer.C:
+			if !q.remove(ready) {
+				// Woken as max_wait ran out: the free connection is the
+				// next waiting request's.
+				q.release()
+			}
+			queueTimedOut.Add(1)
+			return nil, errQueueTimeout
+		}
+	}
+}
+
+// pickNow returns a Backend next hands out, besides tried, with a free
+// connection, without waiting: nil while requests are queued, as they come
+// first, or while every such Backend is saturated. A nil queue returns
+// whatever next picks.
+func (q *requestQueue) pickNow(next NextFunc, tried []IProxyClient) IProxyClient {
+	if q == nil {
+		return next(tried)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) > 0 {
+		return nil
+	}
+	proxyClient, _ := freeBackend(func(saturated []IProxyClient) IProxyClient {
+		return next(append(slices.Clip(tried), saturated...))
+	})
+	return proxyClient
+}
+
+// release wakes the request to dispatch next, as an attempt has handed a
+// connection back. A nil queue does nothing.
+func (q *requestQueue) release() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 {
+		return
+	}
+	var ready chan struct{}
+	if q.lifo {
+		ready = q.waiting[len(q.waiting)-1]
+		q.waiting = q.waiting[:len(q.waiting)-1]
+	} else {
+		ready = q.waiting[0]
+		q.waiting = q.waiting[1:]
+	}
+	ready <- struct{}{}
+}
+
+// requeue puts a woken request back where release takes the next one from.
+func (q *requestQueue) requeue(ready chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.lifo {
+		q.waiting = append(q.waiting, ready)
+	} else {
+		q.waiting = append([]chan struct{}{ready}, q.waiting...)
+	}
+}
+
+// remove takes ready out of the queue, and reports false when release
+// already had.
+func (q *requestQueue) remove(ready chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, waiting := range q.waiting {
+		if waiting == ready {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// freeBackend returns the first Backend next hands out that is not
+// saturated, and whether every one it handed out was.
+func freeBackend(next NextFunc) (IProxyClient, bool) {
+	var saturated []IProxyClient
+	for {
+		proxyClient := next(saturated)
+		if proxyClient == nil {
+			return nil, len(saturated) > 0
+		}
+		if !proxyClient.Saturated() {
+			return proxyClient, false
+		}
+		saturated = append(saturated, proxyClient)
+	}
+}
+
+// queueUnavailable answers a request the queue turned away.
+func queueUnavailable(ctx *fasthttp.RequestCtx, err error) {
+	ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.Response.SetBody(errorMessageBody(err))
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// heldServer reports each request's path on arrived as name+path, and holds
+// it until release lets one go.
+func heldServer(t *testing.T, name string, arrived chan<- string) (*ProxyClient, chan<- struct{}) {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- name + r.URL.Path
+		<-release
+		w.Write([]byte(name)) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	b := config.Backend{
+		Url:                strings.TrimPrefix(srv.URL, "http://"),
+		ProxyTimeout:       5 * time.Second,
+		MaxConnection:      1,
+		MaxConnWaitTimeout: 5 * time.Second,
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient), release
+}
+
+func queuePolicy(depth int, wait time.Duration, order string) *RetryPolicy {
+	return NewRetryPolicy(config.Retry{}, config.Hedge{}, config.Queue{Enabled: true, MaxDepth: depth, MaxWait: wait, Order: order})
+}
+
+// serveAsync serves a request for path through policy in the background.
+func serveAsync(policy *RetryPolicy, next NextFunc, path string) <-chan *fasthttp.RequestCtx {
+	done := make(chan *fasthttp.RequestCtx, 1)
+	go func() {
+		ctx := hedgeRequest(fasthttp.MethodGet)
+		ctx.Request.SetRequestURI(path)
+		policy.Serve(ctx, next)
+		done <- ctx
+	}()
+	return done
+}
+
+func waitQueued(t *testing.T, depth int64) {
+	t.Helper()
+	assert.Eventually(t, func() bool { return Queued().Depth == depth }, time.Second, time.Millisecond)
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("dispatches to the first backend to free up", func(t *testing.T) {
+		arrived := make(chan string, 10)
+		a, releaseA := heldServer(t, "a", arrived)
+		b, releaseB := heldServer(t, "b", arrived)
+		policy := queuePolicy(10, 5*time.Second, config.QueueFIFO)
+		next := inOrder(a, b)
+		before := Queued()
+
+		serveAsync(policy, next, "/1")
+		assert.Equal(t, "a/1", <-arrived)
+		second := serveAsync(policy, next, "/2")
+		assert.Equal(t, "b/2", <-arrived)
+		third := serveAsync(policy, next, "/3")
+		waitQueued(t, before.Depth+1)
+
+		releaseB <- struct{}{}
+		assert.Equal(t, "b", string((<-second).Response.Body()))
+		assert.Equal(t, "b/3", <-arrived, "a is still busy")
+		releaseB <- struct{}{}
+		assert.Equal(t, fasthttp.StatusOK, (<-third).Response.StatusCode())
+		releaseA <- struct{}{}
+
+		after := Queued()
+		assert.Equal(t, before.Queued+1, after.Queued)
+		assert.Greater(t, after.WaitSeconds, before.WaitSeconds)
+	})
+
+	t.Run("answers 503 when the queue is full", func(t *testing.T) {
+		arrived := make(chan string, 10)
+		a, releaseA := heldServer(t, "a", arrived)
+		policy := queuePolicy(1, 5*time.Second, config.QueueFIFO)
+		before := Queued()
+
+		first := serveAsync(policy, inOrder(a), "/1")
+		<-arrived
+		second := serveAsync(policy, inOrder(a), "/2")
+		waitQueued(t, before.Depth+1)
+
+		ctx := <-serveAsync(policy, inOrder(a), "/3")
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, `{"message":"too many requests are waiting for a backend"}`, string(ctx.Response.Body()))
+		assert.Equal(t, before.Overflowed+1, Queued().Overflowed)
+
+		releaseA <- struct{}{}
+		<-first
+		<-arrived
+		releaseA <- struct{}{}
+		<-second
+	})
+
+	t.Run("answers 503 after max_wait", func(t *testing.T) {
+		arrived := make(chan string, 10)
+		a, releaseA := heldServer(t, "a", arrived)
+		policy := queuePolicy(10, 50*time.Millisecond, config.QueueFIFO)
+		before := Queued()
+
+		first := serveAsync(policy, inOrder(a), "/1")
+		<-arrived
+		start := time.Now()
+		ctx := <-serveAsync(policy, inOrder(a), "/2")
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, before.TimedOut+1, Queued().TimedOut)
+		assert.Equal(t, before.Depth, Queued().Depth)
+		releaseA <- struct{}{}
+		<-first
+	})
+
+	t.Run("lifo dispatches the newest request first", func(t *testing.T) {
+		arrived := make(chan string, 10)
+		a, releaseA := heldServer(t, "a", arrived)
+		policy := queuePolicy(10, 5*time.Second, config.QueueLIFO)
+		before := Queued()
+
+		serveAsync(policy, inOrder(a), "/1")
+		<-arrived
+		serveAsync(policy, inOrder(a), "/2")
+		waitQueued(t, before.Depth+1)
+		serveAsync(policy, inOrder(a), "/3")
+		waitQueued(t, before.Depth+2)
+
+		releaseA <- struct{}{}
+		assert.Equal(t, "a/3", <-arrived)
+		releaseA <- struct{}{}
+		assert.Equal(t, "a/2", <-arrived)
+		releaseA <- struct{}{}
+	})
+
+	t.Run("no alive backends", func(t *testing.T) {
+		ctx := <-serveAsync(queuePolicy(10, time.Second, config.QueueFIFO), inOrder(), "/")
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, `{"message":"no backends available"}`, string(ctx.Response.Body()))
+	})
+}
+
+// fakeBackend is a Backend that is only ever asked whether it is saturated.
+type fakeBackend struct {
+	IProxyClient
+	saturated atomic.Bool
+}
+
+func (b *fakeBackend) Saturated() bool { return b.saturated.Load() }
+
+func TestQueueFreedBeforeQueueing(t *testing.T) {
+	q := newRequestQueue(config.Queue{Enabled: true, MaxDepth: 10, MaxWait: time.Second})
+	backend := &fakeBackend{}
+	backend.saturated.Store(true)
+	freed := false
+	next := func(tried []IProxyClient) IProxyClient {
+		if len(tried) == 0 {
+			return backend
+		}
+		// The connection comes back once the first check found none, and
+		// release finds nobody to wake.
+		if !freed {
+			freed = true
+			backend.saturated.Store(false)
+			q.release()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	proxyClient, err := q.pick(next)
+	assert.NoError(t, err)
+	assert.Equal(t, IProxyClient(backend), proxyClient)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestQueuePickNow(t *testing.T) {
+	a, b := &fakeBackend{}, &fakeBackend{}
+	a.saturated.Store(true)
+	next := inOrder(a, b)
+
+	var q *requestQueue
+	assert.Equal(t, IProxyClient(a), q.pickNow(next, nil), "without a queue, max_conn is not checked")
+
+	q = newRequestQueue(config.Queue{Enabled: true, MaxDepth: 10, MaxWait: time.Second})
+	assert.Equal(t, IProxyClient(b), q.pickNow(next, nil))
+	assert.Nil(t, q.pickNow(next, []IProxyClient{b}), "a is saturated")
+
+	// A hedge never takes a connection from a queued request.
+	q.waiting = append(q.waiting, make(chan struct{}, 1))
+	assert.Nil(t, q.pickNow(next, nil))
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// Retries are counted against the requests of the current window only, so a
+// burst of failures cannot spend the budget an hour of healthy traffic built.
+const retryBudgetWindow = 10 * time.Second
+
+// Retries allowed per window whatever the percentage, or a low-traffic
+// divisor (20% of 3 requests) could never retry at all.
+const minRetriesPerWindow = 10
+
+// NextFunc picks the Backend for one attempt, skipping the Backends already
+// tried for this request. It returns nil when no untried Backend is Alive.
+type NextFunc func(tried []IProxyClient) IProxyClient
+
+// RetryPolicy decides whether a failed attempt is re-sent to another Backend,
+// whether an attempt is hedged, and whether a request waits in the queue for
+// a free Backend. A nil policy makes exactly one attempt, the behavior ADR
+// 0003 describes.
+type RetryPolicy struct {
+	methods     []string
+	statuses    []int
+	budget      *retryBudget
+	hedge       *hedgePolicy
+	queue       *requestQueue
+	maxAttempts int
+	onConnect   bool
+	onTimeout   bool
+}
+
+func NewRetryPolicy(cfg config.Retry, hedge config.Hedge, queue config.Queue) *RetryPolicy {
+	if !cfg.Enabled() && !hedge.Enabled && !queue.Enabled {
+		return nil
+	}
+
+	// Hedging or queueing alone makes one attempt, that hedging may send to
+	// two Backends.
+	policy := &RetryPolicy{
+		methods:     cfg.Methods,
+		maxAttempts: max(cfg.MaxAttempts, 1),
+		budget:      &retryBudget{percent: uint64(cfg.BudgetPercent)},
+		queue:       newRequestQueue(queue),
+	}
+	policy.hedge = newHedgePolicy(hedge, policy.queue)
+	for _, on := range cfg.On {
+		switch on {
+		case config.RetryOnConnectError:
+			policy.onConnect = true
+		case config.RetryOnTimeout:
+			policy.onTimeout = true
+		default:
+			// PrepareConfig only lets status codes through besides the two above.
+			status, _ := strconv.Atoi(on)
+			policy.statuses = append(policy.statuses, status)
+		}
+	}
+	policy.budget.windowStart.Store(time.Now().UnixNano())
+
+	return policy
+}
+
+// Serve proxies ctx to the Backend next picks. An attempt the policy retries
+// is discarded and the request goes to a Backend next has not handed out yet,
+// until max_attempts, the budget or the Alive Backends run out; the client
+// then gets the last attempt's response. With the queue, the first attempt
+// waits for a Backend below max_conn, and each attempt wakes the next queued
+// request as it ends.
+func (p *RetryPolicy) Serve(ctx *fasthttp.RequestCtx, next NextFunc) {
+	var proxyClient IProxyClient
+	if p != nil && p.queue != nil {
+		var err error
+		if proxyClient, err = p.queue.pick(next); err != nil {
+			queueUnavailable(ctx, err)
+			return
+		}
+	} else {
+		proxyClient = next(nil)
+	}
+	if proxyClient == nil {
+		NoAliveBackends(ctx)
+		return
+	}
+
+	if p == nil {
+		proxyClient.ReverseProxyHandler(ctx) //nolint:errcheck
+		return
+	}
+
+	p.budget.recordRequest()
+	var tried []IProxyClient
+	for attempt := 1; ; attempt++ {
+		var err error
+		tried, err = p.attempt(ctx, proxyClient, next, tried)
+		if attempt >= p.maxAttempts || !p.retryable(ctx, err) {
+			return
+		}
+
+		proxyClient = next(tried)
+		if proxyClient == nil || !p.budget.withdraw() {
+			return
+		}
+
+		zap.S().Infof("Retrying request on another backend after failed attempt %d of %d", attempt, p.maxAttempts)
+		// fasthttp hands a Backend connection back to the pool when its
+		// streamed body is dropped unread; only a closed one is safe.
+		if ctx.Response.IsBodyStream() {
+			ctx.Response.SetConnectionClose()
+		}
+		ctx.Response.Reset()
+	}
+}
+
+// attempt proxies ctx to proxyClient, hedged when the policy and the request
+// allow, and returns tried with every Backend the attempt used. Each request
+// it sends wakes the next queued request as it ends.
+func (p *RetryPolicy) attempt(ctx *fasthttp.RequestCtx, proxyClient IProxyClient, next NextFunc, tried []IProxyClient) ([]IProxyClient, error) {
+	if p.hedge == nil || !hedgeable(ctx) {
+		err := proxyClient.ReverseProxyHandler(ctx)
+		p.queue.release()
+		return append(tried, proxyClient), err
+	}
+	return p.hedge.serve(ctx, proxyClient, next, tried)
+}
+
+func (p *RetryPolicy) retryable(ctx *fasthttp.RequestCtx, err error) bool {
+	if !slices.Contains(p.methods, helper.B2S(ctx.Method())) {
+		return false
+	}
+
+	// The failed attempt consumed a streamed body; there is nothing to resend.
+	if ctx.Request.IsBodyStream() {
+		return false
+	}
+
+	switch {
+	case err == nil:
+		return slices.Contains(p.statuses, ctx.Response.StatusCode())
+	case errors.Is(err, fasthttp.ErrTimeout):
+		return p.onTimeout
+	case isConnectError(err):
+		return p.onConnect
+	}
+
+	return false
+}
+
+// isConnectError reports a failed dial — refused, unreachable, or timed out.
+// The request never reached the Backend, so resending it is safe even when
+// it would not be idempotent.
+func isConnectError(err error) bool {
+	if errors.Is(err, fasthttp.ErrDialTimeout) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryBudget caps retries at a percentage of the requests seen in the
+// current window, so a pool-wide outage cannot multiply the load on the
+// Backends still standing.
+type retryBudget struct {
+	percent     uint64
+	windowStart atomic.Int64
+	requests    atomic.Uint64
+	retries     atomic.Uint64
+}
+
+func (b *retryBudget) recordRequest() {
+	b.roll()
+	b.requests.Add(1)
+}
+
+// withdraw spends one retry and reports whether the budget allowed it.
+func (b *retryBudget) withdraw() bool {
+	b.roll()
+	if b.retries.Add(1) > b.allowed() {
+		b.retries.Add(^uint64(0))
+		return false
+	}
+	return true
+}
+
+// allowed is how many retries the current window allows.
+func (b *retryBudget) allowed() uint64 {
+	return max(b.requests.Load()*b.percent/100, minRetriesPerWindow) //nolint:mnd
+}
+
+func (b *retryBudget) roll() {
+	now := time.Now().UnixNano()
+	start := b.windowStart.Load()
+	if now-start < int64(retryBudgetWindow) {
+		return
+	}
+	if b.windowStart.CompareAndSwap(start, now) {
+		b.requests.Store(0)
+		b.retries.Store(0)
+	}
+}
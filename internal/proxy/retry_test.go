@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// statusServer answers every request with the given status.
+func statusServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// refusedAddr is a local address nothing listens on anymore.
+func refusedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	assert.NoError(t, ln.Close())
+	return addr
+}
+
+func newRetryTestClient(addr string) *ProxyClient {
+	b := config.Backend{Url: strings.TrimPrefix(addr, "http://"), ProxyTimeout: time.Second}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+// inOrder hands out the clients in order, skipping the tried ones.
+func inOrder(clients ...IProxyClient) NextFunc {
+	return func(tried []IProxyClient) IProxyClient {
+		for _, c := range clients {
+			if !slices.Contains(tried, c) {
+				return c
+			}
+		}
+		return nil
+	}
+}
+
+func enabledRetry(on ...string) config.Retry {
+	cfg := config.Retry{MaxAttempts: 3, On: on}
+	cfg.Methods = append([]string(nil), config.DefaultRetryMethods...)
+	cfg.BudgetPercent = config.DefaultRetryBudget
+	return cfg
+}
+
+func TestNewRetryPolicyDisabled(t *testing.T) {
+	assert.Nil(t, NewRetryPolicy(config.Retry{}, config.Hedge{}, config.Queue{}))
+	assert.Nil(t, NewRetryPolicy(config.Retry{MaxAttempts: 1}, config.Hedge{}, config.Queue{}))
+	assert.NotNil(t, NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{}))
+	assert.NotNil(t, NewRetryPolicy(config.Retry{}, config.Hedge{}, config.Queue{Enabled: true, MaxDepth: 1, MaxWait: time.Second}))
+}
+
+func TestRetryPolicyServe(t *testing.T) {
+	ok := newRetryTestClient(statusServer(t, http.StatusOK).URL)
+
+	t.Run("nil policy makes one attempt", func(t *testing.T) {
+		var policy *RetryPolicy
+		refused := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		policy.Serve(&ctx, inOrder(refused, ok))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	})
+
+	t.Run("no alive backends", func(t *testing.T) {
+		policy := NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{})
+		ctx := fasthttp.RequestCtx{}
+		policy.Serve(&ctx, inOrder())
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	})
+
+	t.Run("connect error goes to another backend", func(t *testing.T) {
+		policy := NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{})
+		refused := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		policy.Serve(&ctx, inOrder(refused, ok))
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	})
+
+	t.Run("backend status listed in on", func(t *testing.T) {
+		unavailable := newRetryTestClient(statusServer(t, http.StatusServiceUnavailable).URL)
+
+		ctx := fasthttp.RequestCtx{}
+		NewRetryPolicy(enabledRetry("503"), config.Hedge{}, config.Queue{}).Serve(&ctx, inOrder(unavailable, ok))
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+		ctx = fasthttp.RequestCtx{}
+		NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{}).Serve(&ctx, inOrder(unavailable, ok))
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	})
+
+	t.Run("method not listed is not retried", func(t *testing.T) {
+		policy := NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{})
+		refused := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		policy.Serve(&ctx, inOrder(refused, ok))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	})
+
+	t.Run("streamed body is not retried", func(t *testing.T) {
+		policy := NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{})
+		refused := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPut)
+		ctx.Request.SetBodyStream(strings.NewReader("payload"), -1)
+		policy.Serve(&ctx, inOrder(refused, ok))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	})
+
+	t.Run("max attempts bounds the retries", func(t *testing.T) {
+		cfg := enabledRetry(config.RetryOnConnectError)
+		cfg.MaxAttempts = 2
+		policy := NewRetryPolicy(cfg, config.Hedge{}, config.Queue{})
+		first := newRetryTestClient(refusedAddr(t))
+		second := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		policy.Serve(&ctx, inOrder(first, second, ok))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	})
+
+	t.Run("every untried backend fails", func(t *testing.T) {
+		policy := NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{})
+		refused := newRetryTestClient(refusedAddr(t))
+		ctx := fasthttp.RequestCtx{}
+		policy.Serve(&ctx, inOrder(refused))
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	})
+}
+
+func TestRetryTimeout(t *testing.T) {
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer hanging.Close()
+	slow := NewProxyClient(&config.Backend{
+		Url:          strings.TrimPrefix(hanging.URL, "http://"),
+		ProxyTimeout: 20 * time.Millisecond,
+	}, nil, nil)
+	ok := newRetryTestClient(statusServer(t, http.StatusOK).URL)
+
+	ctx := fasthttp.RequestCtx{}
+	NewRetryPolicy(enabledRetry(config.RetryOnTimeout), config.Hedge{}, config.Queue{}).Serve(&ctx, inOrder(slow, ok))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	ctx = fasthttp.RequestCtx{}
+	NewRetryPolicy(enabledRetry(config.RetryOnConnectError), config.Hedge{}, config.Queue{}).Serve(&ctx, inOrder(slow, ok))
+	assert.Equal(t, fasthttp.StatusGatewayTimeout, ctx.Response.StatusCode())
+}
+
+func TestIsConnectError(t *testing.T) {
+	refused := newRetryTestClient(refusedAddr(t))
+	ctx := fasthttp.RequestCtx{}
+	err := refused.ReverseProxyHandler(&ctx)
+	assert.True(t, isConnectError(err), "refused dial: %v", err)
+
+	assert.True(t, isConnectError(fasthttp.ErrDialTimeout))
+	assert.False(t, isConnectError(fasthttp.ErrTimeout))
+	assert.False(t, isConnectError(fasthttp.ErrConnectionClosed))
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := &retryBudget{percent: 50}
+	budget.windowStart.Store(time.Now().UnixNano())
+
+	for range 100 {
+		budget.recordRequest()
+	}
+	for i := range 50 {
+		assert.True(t, budget.withdraw(), "retry %d is within 50%% of 100 requests", i)
+	}
+	assert.False(t, budget.withdraw(), "the 51st retry exceeds the budget")
+
+	t.Run("floor lets a quiet divisor retry", func(t *testing.T) {
+		quiet := &retryBudget{percent: 20}
+		quiet.windowStart.Store(time.Now().UnixNano())
+		quiet.recordRequest()
+		for range minRetriesPerWindow {
+			assert.True(t, quiet.withdraw())
+		}
+		assert.False(t, quiet.withdraw())
+	})
+
+	t.Run("a new window refills the budget", func(t *testing.T) {
+		budget.windowStart.Store(time.Now().Add(-2 * retryBudgetWindow).UnixNano())
+		assert.True(t, budget.withdraw())
+	})
+}
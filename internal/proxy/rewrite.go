@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// rewriteRequest maps the client's path to the one the Backend serves. A
+// retry runs it on a request an earlier attempt may have rewritten for
+// another Backend, so it always starts from the client's path.
+func (h *ProxyClient) rewriteRequest(ctx *fasthttp.RequestCtx) {
+	client, ok := ctx.UserValue(clientRequestKey{}).(*clientRequest)
+	if !ok {
+		return
+	}
+	uri := ctx.Request.URI()
+	if string(uri.Path()) != client.path {
+		uri.SetPath(client.path)
+	}
+	if h.rewrite == nil {
+		return
+	}
+
+	if h.rewrite.OriginalURIHeader != "" {
+		ctx.Request.Header.Set(h.rewrite.OriginalURIHeader, client.uri)
+	}
+	uri.SetPath(backendPath(h.rewrite, client.path))
+}
+
+// backendPath applies the rewrite to path: StripPrefix, then the first Regex
+// that matches, then AddPrefix.
+func backendPath(r *config.Rewrite, path string) string {
+	if rest, ok := cutPathPrefix(path, r.StripPrefix); ok {
+		path = rest
+	}
+	for _, re := range r.Regex {
+		if re.Pattern.MatchString(path) {
+			path = re.Pattern.ReplaceAllString(path, re.Replace)
+			break
+		}
+	}
+	if r.AddPrefix != "" {
+		path = r.AddPrefix + path
+	}
+	return path
+}
+
+// clientPath undoes the prefixes on a path the Backend sent back. A path
+// outside AddPrefix is not one the rewrite produced and is left alone.
+func clientPath(r *config.Rewrite, path string) string {
+	if r.AddPrefix != "" {
+		rest, ok := cutPathPrefix(path, r.AddPrefix)
+		if !ok {
+			return path
+		}
+		path = rest
+	}
+	if r.StripPrefix != "" {
+		path = r.StripPrefix + path
+	}
+	return path
+}
+
+// cutPathPrefix removes prefix from path on a segment boundary: "/billing"
+// is a prefix of "/billing" and "/billing/x" but not of "/billings". What
+// remains always starts with a slash.
+func cutPathPrefix(path, prefix string) (string, bool) {
+	if prefix == "" {
+		return path, false
+	}
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// rewriteResponse points Location and cookie paths the Backend sent back at
+// the paths the client uses.
+func (h *ProxyClient) rewriteResponse(ctx *fasthttp.RequestCtx) {
+	client, ok := ctx.UserValue(clientRequestKey{}).(*clientRequest)
+	if h.rewrite == nil || !ok {
+		return
+	}
+	res := &ctx.Response
+	if location := res.Header.Peek(fasthttp.HeaderLocation); h.rewrite.RewriteLocation && len(location) > 0 {
+		res.Header.Set(fasthttp.HeaderLocation, h.clientLocation(string(location), client))
+	}
+	if h.rewrite.RewriteCookiePath {
+		rewriteCookiePaths(&res.Header, h.rewrite)
+	}
+}
+
+// clientLocation rewrites a path-absolute Location, or an absolute one naming
+// the Backend itself, which then names the host and scheme the client used.
+// Anything else points elsewhere and is returned as is.
+func (h *ProxyClient) clientLocation(location string, client *clientRequest) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	switch {
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/"):
+	case (u.Scheme == "http" || u.Scheme == "https") && h.isBackendHost(u):
+		u.Scheme, u.Host = client.scheme, client.host
+	default:
+		return location
+	}
+	u.Path = clientPath(h.rewrite, u.Path)
+	u.RawPath = ""
+	return u.String()
+}
+
+func (h *ProxyClient) isBackendHost(u *url.URL) bool {
+	if u.Port() != "" {
+		return u.Host == h.Addr
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port) == h.Addr
+}
+
+// rewriteCookiePaths edits the Path attribute of each Set-Cookie in place,
+// leaving the value's other attributes exactly as the Backend wrote them.
+func rewriteCookiePaths(header *fasthttp.ResponseHeader, r *config.Rewrite) {
+	var cookies []string
+	changed := false
+	for _, value := range header.Cookies() {
+		cookie := string(value)
+		if rewritten := rewriteCookiePath(cookie, r); rewritten != cookie {
+			cookie, changed = rewritten, true
+		}
+		cookies = append(cookies, cookie)
+	}
+	if !changed {
+		return
+	}
+	header.Del(fasthttp.HeaderSetCookie)
+	for _, cookie := range cookies {
+		header.Add(fasthttp.HeaderSetCookie, cookie)
+	}
+}
+
+func rewriteCookiePath(cookie string, r *config.Rewrite) string {
+	attrs := strings.Split(cookie, ";")
+	for i, attr := range attrs[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(attr), "=")
+		if !ok || !strings.EqualFold(name, "path") || !strings.HasPrefix(value, "/") {
+			continue
+		}
+		path := clientPath(r, value)
+		// The Backend's root is the whole mount: Path=/billing covers
+		// /billing itself, Path=/billing/ does not.
+		if r.StripPrefix != "" && path == r.StripPrefix+"/" {
+			path = r.StripPrefix
+		}
+		attrs[i+1] = " " + name + "=" + path
+	}
+	return strings.Join(attrs, ";")
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// newRewriteTestClient proxies to addr with the rewrite prepared the way
+// PrepareConfig prepares a Backend's.
+func newRewriteTestClient(t *testing.T, addr string, rewrite config.Rewrite) *ProxyClient {
+	t.Helper()
+	cfg := config.Config{Port: "8000", Backends: []config.Backend{{Url: addr, Rewrite: rewrite}}}
+	assert.NoError(t, cfg.PrepareConfig())
+	b := cfg.Backends[0]
+	b.ProxyTimeout = time.Second
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+// uriServer records the request URI and headers it was sent and answers
+// with the given response headers.
+func uriServer(t *testing.T, uri *string, seen *http.Header, header ...string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*uri = r.RequestURI
+		*seen = r.Header.Clone()
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Add(header[i], header[i+1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return protocolRegex.ReplaceAllString(srv.URL, "")
+}
+
+func rewriteRequestCtx(uri string) *fasthttp.RequestCtx {
+	ctx := clientIPRequest("203.0.113.9")
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetHost("shop.example")
+	return ctx
+}
+
+func TestBackendPath(t *testing.T) {
+	rewrite := func(r config.Rewrite) *config.Rewrite {
+		cfg := config.Config{Port: "8000", Backends: []config.Backend{{Url: "localhost:8080", Rewrite: r}}}
+		assert.NoError(t, cfg.PrepareConfig())
+		return &cfg.Backends[0].Rewrite
+	}
+	strip := rewrite(config.Rewrite{StripPrefix: "/billing"})
+	add := rewrite(config.Rewrite{AddPrefix: "/api/v2"})
+	both := rewrite(config.Rewrite{StripPrefix: "/billing", AddPrefix: "/api", Regex: []config.RewriteRegex{
+		{Match: `^/invoices/(?P<id>\d+)$`, Replace: "/invoice/${id}"},
+		{Match: `^/invoices/`, Replace: "/never/"},
+	}})
+
+	tests := []struct {
+		rewrite    *config.Rewrite
+		in, out    string
+		clientPath string
+	}{
+		{strip, "/billing/invoices", "/invoices", "/billing/invoices"},
+		{strip, "/billing", "/", "/billing/"},
+		{strip, "/billings", "/billings", "/billing/billings"},
+		{add, "/users", "/api/v2/users", "/users"},
+		{add, "/", "/api/v2/", "/"},
+		{both, "/billing/invoices/7", "/api/invoice/7", "/billing/invoice/7"},
+		{both, "/billing/invoices/x", "/api/never/x", "/billing/never/x"},
+		{both, "/status", "/api/status", "/billing/status"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, backendPath(tt.rewrite, tt.in), tt.in)
+		assert.Equal(t, tt.clientPath, clientPath(tt.rewrite, tt.out), tt.out)
+	}
+	assert.Equal(t, "/elsewhere", clientPath(add, "/elsewhere"), "outside add_prefix is left alone")
+}
+
+func TestRewriteRequest(t *testing.T) {
+	var uri string
+	var seen http.Header
+	addr := uriServer(t, &uri, &seen)
+	p := newRewriteTestClient(t, addr, config.Rewrite{
+		StripPrefix:       "/billing",
+		AddPrefix:         "/v1",
+		OriginalURIHeader: "X-Original-URI",
+	})
+
+	assert.NoError(t, p.ReverseProxyHandler(rewriteRequestCtx("/billing/invoices?page=2")))
+	assert.Equal(t, "/v1/invoices?page=2", uri)
+	assert.Equal(t, "/billing/invoices?page=2", seen.Get("X-Original-URI"))
+}
+
+func TestRewriteRetryStartsFromClientPath(t *testing.T) {
+	var uri string
+	var seen http.Header
+	first := newRewriteTestClient(t, refusedAddr(t), config.Rewrite{StripPrefix: "/billing"})
+	second := newRewriteTestClient(t, uriServer(t, &uri, &seen), config.Rewrite{AddPrefix: "/v1"})
+
+	ctx := rewriteRequestCtx("/billing/invoices")
+	assert.Error(t, first.ReverseProxyHandler(ctx))
+	ctx.Response.Reset()
+	assert.NoError(t, second.ReverseProxyHandler(ctx))
+	assert.Equal(t, "/v1/billing/invoices", uri, "not the first Backend's rewrite rewritten again")
+
+	plain := newRetryTestClient(uriServer(t, &uri, &seen))
+	ctx = rewriteRequestCtx("/billing/invoices")
+	assert.Error(t, first.ReverseProxyHandler(ctx))
+	ctx.Response.Reset()
+	assert.NoError(t, plain.ReverseProxyHandler(ctx))
+	assert.Equal(t, "/billing/invoices", uri, "a Backend without rewrites gets the client's path")
+}
+
+func TestRewriteResponse(t *testing.T) {
+	var uri string
+	var seen http.Header
+	addr := uriServer(t, &uri, &seen,
+		"Location", "/v1/login?next=%2Fv1%2Finvoices",
+		"Set-Cookie", "session=1; Path=/v1; HttpOnly; Priority=High",
+		"Set-Cookie", "theme=dark; path=/v1/settings",
+		"Set-Cookie", "global=1; Path=/elsewhere",
+	)
+	p := newRewriteTestClient(t, addr, config.Rewrite{
+		StripPrefix:       "/billing",
+		AddPrefix:         "/v1",
+		RewriteLocation:   true,
+		RewriteCookiePath: true,
+	})
+
+	ctx := rewriteRequestCtx("/billing/invoices")
+	assert.NoError(t, p.ReverseProxyHandler(ctx))
+	assert.Equal(t, "/billing/login?next=%2Fv1%2Finvoices", string(ctx.Response.Header.Peek("Location")), "the query is not a path")
+	var cookies []string
+	for _, value := range ctx.Response.Header.Cookies() {
+		cookies = append(cookies, string(value))
+	}
+	assert.Equal(t, []string{
+		"session=1; Path=/billing; HttpOnly; Priority=High",
+		"theme=dark; path=/billing/settings",
+		"global=1; Path=/elsewhere",
+	}, cookies)
+}
+
+func TestClientLocation(t *testing.T) {
+	p := newRewriteTestClient(t, "backend.internal:80", config.Rewrite{AddPrefix: "/v1", RewriteLocation: true})
+	client := &clientRequest{scheme: "https", host: "shop.example"}
+
+	tests := []struct{ in, out string }{
+		{"/v1/login", "/login"},
+		{"http://backend.internal/v1/login", "https://shop.example/login"},
+		{"http://backend.internal:80/v1/", "https://shop.example/"},
+		{"https://backend.internal/v1/login", "https://backend.internal/v1/login"},
+		{"https://accounts.example/v1/login", "https://accounts.example/v1/login"},
+		{"login", "login"},
+		{"//cdn.example/v1/a", "//cdn.example/v1/a"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, p.clientLocation(tt.in, client), tt.in)
+	}
+}
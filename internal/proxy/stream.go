@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// upstreamRequest returns the request to send to the Backend. In streaming
+// mode a streamed body goes out through a copy of req whose body stops at
+// max_request_body_size: setting a new stream on req itself would release
+// the one fasthttp is still reading the client's body from. release frees
+// the copy. A transportClient reads the body itself and applies the cap there.
+func (h *ProxyClient) upstreamRequest(req *fasthttp.Request) (out *fasthttp.Request, release func()) {
+	if h.transport != nil || !h.streamBodies || h.maxRequestBodySize <= 0 || !req.IsBodyStream() {
+		return req, func() {}
+	}
+
+	out = fasthttp.AcquireRequest()
+	req.Header.CopyTo(&out.Header)
+	req.URI().CopyTo(out.URI())
+	out.UseHostHeader = req.UseHostHeader
+	out.SetBodyStream(&limitedBody{r: req.BodyStream(), remaining: h.maxRequestBodySize}, req.Header.ContentLength())
+	return out, func() { fasthttp.ReleaseRequest(out) }
+}
+
+// overBodyLimit reports a request body of known length over the limit.
+// fasthttp does not reject these in streaming mode, it streams them.
+func (h *ProxyClient) overBodyLimit(req *fasthttp.Request) bool {
+	return h.streamBodies && h.maxRequestBodySize > 0 && req.Header.ContentLength() > h.maxRequestBodySize
+}
+
+// limitedBody fails the read that takes a streamed body past remaining
+// bytes with ErrBodyTooLarge, which serverError answers with 413.
+type limitedBody struct {
+	r         io.Reader
+	remaining int
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only a read of the end of the body may succeed now.
+		p = p[:min(len(p), 1)]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= n
+	if b.remaining < 0 {
+		return 0, fasthttp.ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// dialIdleTimeout dials connections whose deadlines are reset on every read
+// and write. fasthttp puts proxy_timeout on the whole exchange, which would
+// cut an upload or an event stream that runs longer; this way it only
+// expires on a Backend that goes quiet for that long.
+func dialIdleTimeout(timeout time.Duration) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := fasthttp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// idleTimeoutConn is used by one request at a time, so its fields need no
+// locking.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout    time.Duration
+	readArmed  bool
+	writeArmed bool
+}
+
+func (c *idleTimeoutConn) SetDeadline(t time.Time) error {
+	c.readArmed, c.writeArmed = !t.IsZero(), !t.IsZero()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *idleTimeoutConn) SetReadDeadline(t time.Time) error {
+	c.readArmed = !t.IsZero()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *idleTimeoutConn) SetWriteDeadline(t time.Time) error {
+	c.writeArmed = !t.IsZero()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	if c.readArmed {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	if c.writeArmed {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	}
+	return c.Conn.Write(p)
+}
+
+// flushWriter passes every write on to the client as soon as it is made
+// instead of leaving it in net/http's buffer.
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func newFlushWriter(w http.ResponseWriter) flushWriter {
+	return flushWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+// isOpenEndedStream reports whether res streams a body of unknown length:
+// chunked or read-until-close, the shape of event streams and long polls.
+// Those go out chunk by chunk on both stacks, headers first.
+func isOpenEndedStream(res *fasthttp.Response) bool {
+	return res.IsBodyStream() && res.Header.ContentLength() < 0
+}
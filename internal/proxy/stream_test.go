@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func newStreamingTestClient(srv *httptest.Server, proxyTimeout time.Duration, maxRequestBodySize int) *ProxyClient {
+	b := config.Backend{
+		Url:                protocolRegex.ReplaceAllString(srv.URL, ""),
+		ProxyTimeout:       proxyTimeout,
+		MaxRequestBodySize: maxRequestBodySize,
+		StreamBodies:       true,
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+// eventServer sends one event, then holds the stream open until release is
+// closed and sends a second.
+func eventServer(t *testing.T, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: one\n\n") //nolint:errcheck
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "data: two\n\n") //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// countingServer answers with the number of body bytes it received.
+func countingServer(t *testing.T, reached *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Add(1)
+		n, _ := io.Copy(io.Discard, r.Body)
+		io.WriteString(w, strconv.FormatInt(n, 10)) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func httpClient(dial func() net.Conn) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) { return dial(), nil },
+	}}
+}
+
+// readEvent reads one event off body, failing the test if it does not arrive
+// while the Backend is still holding the stream open.
+func readEvent(t *testing.T, body *bufio.Reader) string {
+	t.Helper()
+	event := make(chan string, 1)
+	go func() {
+		line, _ := body.ReadString('\n')
+		body.ReadString('\n') //nolint:errcheck
+		event <- strings.TrimSpace(line)
+	}()
+	select {
+	case e := <-event:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was held back until the stream ended")
+		return ""
+	}
+}
+
+func TestStreamedResponseIsFlushed(t *testing.T) {
+	release := make(chan struct{})
+	p := newStreamingTestClient(eventServer(t, release), time.Minute, 0)
+	client := httpClient(serveFasthttp(t, p))
+
+	res, err := client.Get("http://divisor/events")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	body := bufio.NewReader(res.Body)
+	assert.Equal(t, "data: one", readEvent(t, body))
+	close(release)
+	assert.Equal(t, "data: two", readEvent(t, body))
+}
+
+func TestStreamedResponseOutlivesProxyTimeout(t *testing.T) {
+	const chunks = 5
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range chunks {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	// The stream runs for 500ms but is never quiet for 300ms.
+	p := newStreamingTestClient(srv, 300*time.Millisecond, 0)
+	client := httpClient(serveFasthttp(t, p))
+
+	res, err := client.Get("http://divisor/")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, chunks, strings.Count(string(body), "chunk"))
+}
+
+func TestStreamedResponseQuietBackend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial") //nolint:errcheck
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	p := newStreamingTestClient(srv, 100*time.Millisecond, 0)
+	client := httpClient(serveFasthttp(t, p))
+
+	res, err := client.Get("http://divisor/")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.Error(t, err, "a Backend quiet for longer than proxy_timeout must cut the stream")
+	assert.Equal(t, "partial", string(body))
+}
+
+func TestStreamedRequestBody(t *testing.T) {
+	const limit = 64 * 1024
+
+	// chunkedPost sends size bytes with no declared length; the fasthttp
+	// server sees them only as they arrive.
+	chunkedPost := func(t *testing.T, dial func() net.Conn, size int) *http.Response {
+		t.Helper()
+		body := io.NopCloser(strings.NewReader(strings.Repeat("x", size)))
+		res, err := httpClient(dial).Post("http://divisor/upload", "application/octet-stream", body)
+		assert.NoError(t, err)
+		return res
+	}
+
+	t.Run("ChunkedWithinLimit", func(t *testing.T) {
+		var reached atomic.Int32
+		p := newStreamingTestClient(countingServer(t, &reached), time.Minute, limit)
+
+		res := chunkedPost(t, serveFasthttp(t, p), limit)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, strconv.Itoa(limit), string(body))
+	})
+
+	t.Run("ChunkedOverLimit", func(t *testing.T) {
+		var reached atomic.Int32
+		p := newStreamingTestClient(countingServer(t, &reached), time.Minute, limit)
+		conn := serveFasthttp(t, p)()
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: divisor\r\nTransfer-Encoding: chunked\r\n\r\n")
+		for range 4 {
+			fmt.Fprintf(conn, "%x\r\n%s\r\n", limit/2, strings.Repeat("x", limit/2))
+		}
+		fmt.Fprintf(conn, "0\r\n\r\n")
+
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		assert.JSONEq(t, bodyTooLargeMessage, string(body))
+		assert.True(t, res.Close, "the unread rest of the body must not be parsed as a request")
+		assert.Equal(t, int64(0), int64(p.RecentResponseTime()), "an oversized body is not the Backend's failure")
+	})
+
+	t.Run("DeclaredLengthOverLimit", func(t *testing.T) {
+		var reached atomic.Int32
+		p := newStreamingTestClient(countingServer(t, &reached), time.Minute, limit)
+		conn := serveFasthttp(t, p)()
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: divisor\r\nContent-Length: %d\r\n\r\n%s",
+			limit+1, strings.Repeat("x", limit+1))
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		assert.Equal(t, int32(0), reached.Load(), "the Backend must not see an oversized body")
+	})
+}
+
+func TestNetHttpAdapterStreamedBodies(t *testing.T) {
+	const limit = 64 * 1024
+
+	newAdapter := func(p *ProxyClient) *httptest.Server {
+		srv := httptest.NewServer(NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+			p.ReverseProxyHandler(ctx) //nolint:errcheck
+		}}, limit, true))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	t.Run("EventStream", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := newAdapter(newStreamingTestClient(eventServer(t, release), time.Minute, limit))
+
+		res, err := http.Get(srv.URL + "/events")
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		body := bufio.NewReader(res.Body)
+		assert.Equal(t, "data: one", readEvent(t, body))
+		close(release)
+		assert.Equal(t, "data: two", readEvent(t, body))
+	})
+
+	t.Run("ChunkedOverLimit", func(t *testing.T) {
+		var reached atomic.Int32
+		srv := newAdapter(newStreamingTestClient(countingServer(t, &reached), time.Minute, limit))
+
+		body := io.NopCloser(strings.NewReader(strings.Repeat("x", 2*limit)))
+		res, err := http.Post(srv.URL+"/upload", "application/octet-stream", body)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	})
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// tlsBackend answers with the subject of the client certificate it was
+// shown, or "plain" if none.
+func tlsBackend(t *testing.T, clientAuth tls.ClientAuthType, maxVersion uint16) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			io.WriteString(w, "plain") //nolint:errcheck
+			return
+		}
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName) //nolint:errcheck
+	}))
+	srv.TLS = &tls.Config{ClientAuth: clientAuth, MaxVersion: maxVersion}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // refused handshakes are the point
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func trusting(srv *httptest.Server) *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	return &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12}
+}
+
+func newTLSTestClient(srv *httptest.Server, tlsConfig *tls.Config) *ProxyClient {
+	b := config.Backend{
+		Url:          strings.TrimPrefix(srv.URL, "https://"),
+		ProxyTimeout: time.Second,
+		TLS:          config.BackendTLS{Enabled: true, Config: tlsConfig},
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+func TestTLSBackend(t *testing.T) {
+	srv := tlsBackend(t, tls.NoClientCert, 0)
+	p := newTLSTestClient(srv, trusting(srv))
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	assert.NoError(t, p.ReverseProxyHandler(&ctx))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "plain", string(ctx.Response.Body()))
+}
+
+func TestTLSBackendUntrustedCertificate(t *testing.T) {
+	srv := tlsBackend(t, tls.NoClientCert, 0)
+	p := newTLSTestClient(srv, &tls.Config{MinVersion: tls.VersionTLS12})
+
+	ctx := fasthttp.RequestCtx{}
+	err := p.ReverseProxyHandler(&ctx)
+	assert.Error(t, err)
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Equal(t, "backend certificate rejected", tlsFailure(err))
+}
+
+func TestTLSBackendNotSpeakingTLS(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	b := config.Backend{
+		Url:          strings.TrimPrefix(srv.URL, "http://"),
+		ProxyTimeout: time.Second,
+		TLS:          config.BackendTLS{Enabled: true, Config: &tls.Config{MinVersion: tls.VersionTLS12}},
+	}
+	p := NewProxyClient(&b, nil, nil).(*ProxyClient)
+
+	ctx := fasthttp.RequestCtx{}
+	err := p.ReverseProxyHandler(&ctx)
+	assert.Error(t, err)
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Equal(t, "backend does not speak TLS", tlsFailure(err))
+}
+
+func TestTLSBackendClientCertificate(t *testing.T) {
+	srv := tlsBackend(t, tls.RequireAnyClientCert, 0)
+
+	t.Run("without a certificate the handshake is refused", func(t *testing.T) {
+		// Under TLS 1.3 the refusal only arrives after the client's side of
+		// the handshake is done, and fasthttp reports it as a closed
+		// connection; TLS 1.2 refuses within the handshake.
+		tls12 := tlsBackend(t, tls.RequireAnyClientCert, tls.VersionTLS12)
+		p := newTLSTestClient(tls12, trusting(tls12))
+
+		ctx := fasthttp.RequestCtx{}
+		err := p.ReverseProxyHandler(&ctx)
+		assert.Error(t, err)
+		assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+		assert.Equal(t, "handshake rejected by backend", tlsFailure(err))
+	})
+
+	t.Run("the client certificate is presented", func(t *testing.T) {
+		cfg := trusting(srv)
+		// The test server's own pair doubles as divisor's client certificate.
+		cfg.Certificates = srv.TLS.Certificates
+
+		p := newTLSTestClient(srv, cfg)
+		ctx := fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/")
+		assert.NoError(t, p.ReverseProxyHandler(&ctx))
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, srv.Certificate().Subject.CommonName, string(ctx.Response.Body()))
+	})
+}
+
+func TestTLSBackendWebSocket(t *testing.T) {
+	plain := echoWebSocketServer(t)
+	srv := httptest.NewUnstartedServer(plain.Config.Handler)
+	srv.StartTLS()
+	defer srv.Close()
+
+	p := newTLSTestClient(srv, trusting(srv))
+	conn := serveFasthttp(t, p)()
+	defer conn.Close()
+
+	res, br := upgrade(t, conn)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	_, err := conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// upstream sends requests to one Backend: a fasthttp.HostClient for
+// HTTP/1.1, a transportClient for protocol h2c or a Backend taking a PROXY
+// header.
+type upstream interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+	LastUseTime() time.Time
+	ConnsCount() int
+	PendingRequests() int
+	CloseIdleConnections()
+}
+
+// roundTripper is the net/http side of a transportClient.
+type roundTripper interface {
+	RoundTrip(*http.Request) (*http.Response, error)
+	CloseIdleConnections()
+}
+
+// transportClient sends requests through a net/http transport, for what
+// fasthttp's HostClient cannot do.
+//
+// For h2c, HTTP/2 without TLS, every request is a stream of its own on a
+// shared connection, so the Balancer picks a Backend per request (per gRPC
+// call) rather than per client connection, and trailers travel as trailers
+// in both directions.
+//
+// For a Backend taking a PROXY header, every request goes out on a
+// connection of its own: the header names one client for the life of the
+// connection, so one kept alive would credit the next client's requests to
+// the first.
+type transportClient struct {
+	transport          roundTripper
+	origin             string
+	maxRequestBodySize int
+	streamBodies       bool
+	pending            atomic.Int64
+	conns              atomic.Int64
+	lastUse            atomic.Int64
+}
+
+func newH2CClient(backend *config.Backend) *transportClient {
+	c := &transportClient{
+		origin:             "http://" + backend.Url,
+		maxRequestBodySize: backend.MaxRequestBodySize,
+		streamBodies:       backend.StreamBodies,
+	}
+	c.transport = &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return c.dial(ctx, network, addr)
+		},
+		IdleConnTimeout: backend.MaxIdleConnDuration,
+	}
+	return c
+}
+
+// newProxyProtocolClient speaks HTTP/1.1, over TLS if the Backend does, and
+// opens every connection with the PROXY header do was given.
+func newProxyProtocolClient(backend *config.Backend) *transportClient {
+	c := &transportClient{
+		origin:             "http://" + backend.Url,
+		maxRequestBodySize: backend.MaxRequestBodySize,
+		streamBodies:       backend.StreamBodies,
+	}
+	if backend.TLS.Enabled {
+		c.origin = "https://" + backend.Url
+	}
+	c.transport = &http.Transport{
+		DialContext:       c.dial,
+		TLSClientConfig:   backend.TLS.Config,
+		DisableKeepAlives: true,
+		// The client's Accept-Encoding goes through as it is, and the
+		// Backend's encoding back.
+		DisableCompression: true,
+		MaxConnsPerHost:    backend.MaxConnection,
+	}
+	return c
+}
+
+// proxyHeaderKey carries the PROXY header a request's connection opens with.
+type proxyHeaderKey struct{}
+
+// dial opens a plain TCP connection, writes the request's PROXY header if it
+// has one, and counts the connection until it closes. For h2c it stands in
+// where http2.Transport expects TLS.
+func (c *transportClient) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: fasthttp.DefaultDialTimeout}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if header, ok := ctx.Value(proxyHeaderKey{}).([]byte); ok {
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.conns.Add(1)
+	return &countedConn{Conn: conn, conns: &c.conns}, nil
+}
+
+func (c *transportClient) Do(req *fasthttp.Request, res *fasthttp.Response) error {
+	return c.do(context.Background(), req, res, 0, nil)
+}
+
+func (c *transportClient) DoTimeout(req *fasthttp.Request, res *fasthttp.Response, timeout time.Duration) error {
+	return c.do(context.Background(), req, res, timeout, nil)
+}
+
+// do sends req and fills res the way HostClient.Do would. A buffered
+// response is read whole before do returns; a streamed one is handed over as
+// res's body stream, and the attempt only ends when that stream is closed.
+// A new connection opens with proxyHeader, if there is one. Cancelling
+// parent calls the attempt off, as a hedge does with the one that lost.
+func (c *transportClient) do(parent context.Context, req *fasthttp.Request, res *fasthttp.Response, timeout time.Duration, proxyHeader []byte) error {
+	c.pending.Add(1)
+	c.lastUse.Store(time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(parent)
+	if proxyHeader != nil {
+		ctx = context.WithValue(ctx, proxyHeaderKey{}, proxyHeader)
+	}
+	deadline := newAttemptDeadline(timeout, c.streamBodies, cancel)
+	finish := func() {
+		deadline.stop()
+		cancel()
+		c.pending.Add(-1)
+	}
+
+	out, err := c.newRequest(ctx, req, deadline)
+	if err != nil {
+		finish()
+		return err
+	}
+
+	resp, err := c.transport.RoundTrip(out)
+	if err != nil {
+		finish()
+		return deadline.err(err)
+	}
+
+	res.Reset()
+	res.SetStatusCode(resp.StatusCode)
+	for k, values := range resp.Header {
+		// Framing is set with the body below.
+		if k == fasthttp.HeaderContentLength {
+			continue
+		}
+		for _, v := range values {
+			res.Header.Add(k, v)
+		}
+	}
+
+	if c.streamBodies && resp.Body != http.NoBody {
+		res.SetBodyStream(&transportResponseBody{
+			r:        resp.Body,
+			resp:     resp,
+			res:      res,
+			deadline: deadline,
+			done: func() {
+				resp.Body.Close()
+				finish()
+			},
+		}, int(resp.ContentLength))
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	finish()
+	if err != nil {
+		return deadline.err(err)
+	}
+	if len(resp.Trailer) == 0 {
+		res.SetBody(body)
+		return nil
+	}
+	// Trailers only go out after a body of unknown length, on either stack.
+	res.SetBodyStream(&transportResponseBody{r: bytes.NewReader(body), resp: resp, res: res}, -1)
+	return nil
+}
+
+// newRequest translates req for the transport. A streamed body is passed
+// through as it arrives, capped at max_request_body_size, and the request's
+// trailers are copied over once it ends.
+func (c *transportClient) newRequest(ctx context.Context, req *fasthttp.Request, deadline *attemptDeadline) (*http.Request, error) {
+	var body io.Reader = http.NoBody
+	var trailer http.Header
+	contentLength := int64(req.Header.ContentLength())
+	switch {
+	case req.IsBodyStream():
+		var r io.Reader = req.BodyStream()
+		if c.streamBodies && c.maxRequestBodySize > 0 {
+			r = &limitedBody{r: r, remaining: c.maxRequestBodySize}
+		}
+		trailer = http.Header{}
+		for k := range req.Header.Trailers() {
+			trailer[string(k)] = nil
+		}
+		body = &transportRequestBody{r: r, req: req, trailer: trailer, deadline: deadline}
+		contentLength = max(contentLength, -1)
+	case len(req.Body()) > 0:
+		// The Transport may still be reading the body after do returns,
+		// when req belongs to the next request on the connection.
+		body = bytes.NewReader(append([]byte(nil), req.Body()...))
+		contentLength = int64(len(req.Body()))
+	default:
+		contentLength = 0
+	}
+
+	out, err := http.NewRequestWithContext(ctx, helper.B2S(req.Header.Method()),
+		c.origin+helper.B2S(req.URI().RequestURI()), body)
+	if err != nil {
+		return nil, err
+	}
+	out.Host = string(req.Host())
+	out.ContentLength = contentLength
+	out.Trailer = trailer
+	req.Header.All()(func(k, v []byte) bool {
+		switch string(k) {
+		case fasthttp.HeaderHost, fasthttp.HeaderContentLength:
+		default:
+			out.Header.Add(string(k), string(v))
+		}
+		return true
+	})
+	return out, nil
+}
+
+// transportRequestBody feeds a request body to the Transport, which sends the
+// declared trailers once the body ends; their values are copied from req at
+// that point, which is when the frontend has them too.
+type transportRequestBody struct {
+	r        io.Reader
+	req      *fasthttp.Request
+	trailer  http.Header
+	deadline *attemptDeadline
+}
+
+func (b *transportRequestBody) Read(p []byte) (int, error) {
+	b.deadline.touch()
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		for key := range b.trailer {
+			for _, v := range b.req.Header.PeekAll(key) {
+				b.trailer.Add(key, string(v))
+			}
+		}
+	}
+	return n, err
+}
+
+// The body belongs to the client request; fasthttp closes it.
+func (b *transportRequestBody) Close() error { return nil }
+
+// transportResponseBody hands the Backend's body to the client, and its trailers
+// once the body ends: only then are they known.
+type transportResponseBody struct {
+	r        io.Reader
+	resp     *http.Response
+	res      *fasthttp.Response
+	deadline *attemptDeadline
+	done     func()
+	once     sync.Once
+}
+
+func (b *transportResponseBody) Read(p []byte) (int, error) {
+	b.deadline.touch()
+	n, err := b.r.Read(p)
+	switch {
+	case err == io.EOF:
+		setTrailers(&b.res.Header, b.resp.Trailer)
+	case err != nil:
+		err = b.deadline.err(err)
+	}
+	return n, err
+}
+
+func (b *transportResponseBody) Close() error {
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+	return nil
+}
+
+func setTrailers(h *fasthttp.ResponseHeader, trailer http.Header) {
+	for k, values := range trailer {
+		// Forbidden trailers (framing, routing, auth) are dropped, as
+		// fasthttp drops them on HTTP/1.1 Backends.
+		if h.AddTrailer(k) != nil {
+			continue
+		}
+		for _, v := range values {
+			h.Add(k, v)
+		}
+	}
+}
+
+func (c *transportClient) LastUseTime() time.Time {
+	if n := c.lastUse.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+func (c *transportClient) ConnsCount() int       { return int(c.conns.Load()) }
+func (c *transportClient) PendingRequests() int  { return int(c.pending.Load()) }
+func (c *transportClient) CloseIdleConnections() { c.transport.CloseIdleConnections() }
+
+// attemptDeadline cancels a transportClient attempt that outlives proxy_timeout: the
+// whole exchange, as fasthttp's DoTimeout bounds it, or in streaming mode
+// only a spell in which no body moved in either direction.
+type attemptDeadline struct {
+	timer   *time.Timer
+	timeout time.Duration
+	idle    bool
+	expired atomic.Bool
+}
+
+func newAttemptDeadline(timeout time.Duration, idle bool, cancel func()) *attemptDeadline {
+	d := &attemptDeadline{timeout: timeout, idle: idle}
+	if timeout > 0 {
+		d.timer = time.AfterFunc(timeout, func() {
+			d.expired.Store(true)
+			cancel()
+		})
+	}
+	return d
+}
+
+func (d *attemptDeadline) touch() {
+	if d != nil && d.idle && d.timer != nil {
+		d.timer.Reset(d.timeout)
+	}
+}
+
+func (d *attemptDeadline) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// err reports a failure caused by the deadline as fasthttp.ErrTimeout, which
+// serverError answers with 504.
+func (d *attemptDeadline) err(err error) error {
+	if d != nil && d.expired.Load() && !errors.Is(err, fasthttp.ErrBodyTooLarge) {
+		return fasthttp.ErrTimeout
+	}
+	return err
+}
+
+// countedConn takes itself off the connection count once, however many
+// times it is closed.
+type countedConn struct {
+	net.Conn
+	conns *atomic.Int64
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.conns.Add(-1) })
+	return c.Conn.Close()
+}
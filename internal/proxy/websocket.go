@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/middleware"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// On shutdown a tunnel's Backend is told the client is gone and gets this
+// long to close its side before the tunnel is torn down.
+const tunnelCloseGrace = 5 * time.Second
+
+const tunnelBufferSize = 32 * 1024
+
+var (
+	upgradeHeader     = []byte(fasthttp.HeaderUpgrade)
+	websocketProtocol = []byte("websocket")
+)
+
+// hijackKey holds the HTTP/2 adapter's stand-in for ctx.Hijack: an extended
+// CONNECT stream has no connection of its own to hijack.
+type hijackKey struct{}
+
+type hijackFunc func(fasthttp.HijackHandler)
+
+// IsWebSocketUpgrade reports whether req asks to switch the connection to
+// the WebSocket protocol.
+func IsWebSocketUpgrade(req *fasthttp.Request) bool {
+	return req.Header.ConnectionUpgrade() && bytes.EqualFold(req.Header.PeekBytes(upgradeHeader), websocketProtocol)
+}
+
+// proxyWebSocket forwards an Upgrade handshake over a connection of its own,
+// then splices the client and Backend connections together once the Backend
+// answers 101. Any other answer is proxied as a plain response.
+func (h *ProxyClient) proxyWebSocket(ctx *fasthttp.RequestCtx, mwCtx *middleware.Context) error {
+	req := &ctx.Request
+	res := &ctx.Response
+
+	// preReq strips Upgrade and Connection as hop-by-hop; the handshake
+	// needs them on this hop too.
+	req.Header.SetBytesV(fasthttp.HeaderUpgrade, websocketProtocol)
+	req.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+
+	backend, br, err := h.handshake(req, res, h.proxyHeader(ctx))
+	if err != nil {
+		h.recordFailure(h.proxyTimeout)
+	}
+
+	if h.middlewareExecutor != nil {
+		if handledErr := h.middlewareExecutor.RunOnResponse(mwCtx, err); handledErr != nil {
+			closeIfOpen(backend)
+			h.postRes(ctx)
+			middlewareError(res, handledErr)
+			return handledErr
+		}
+	}
+
+	if err != nil {
+		h.postRes(ctx)
+		h.serverError(ctx, err)
+		return err
+	}
+
+	if res.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		backend.Close()
+		h.postRes(ctx)
+		return nil
+	}
+
+	h.postRes(ctx)
+	res.Header.SetBytesV(fasthttp.HeaderUpgrade, websocketProtocol)
+	res.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+
+	splice := func(client net.Conn) {
+		h.tunnels.run(client, backend, br, h.webSocketIdleTimeout)
+	}
+	if hijack, ok := ctx.UserValue(hijackKey{}).(hijackFunc); ok {
+		hijack(splice)
+		return nil
+	}
+
+	// fasthttp closes rather than hijacks a connection it wants closed
+	// (disable_keepalive, for one), which would leak the Backend
+	// connection; writing the 101 here makes the hijack unconditional.
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(client net.Conn) {
+		bw := bufio.NewWriter(client)
+		if err := res.Write(bw); err != nil || bw.Flush() != nil {
+			backend.Close()
+			return
+		}
+		splice(client)
+	})
+	return nil
+}
+
+// handshake sends req to the Backend on a fresh connection and reads its
+// answer into res. The connection is returned with its read buffer, which
+// may already hold the first frames the Backend sent after the 101. A
+// proxyHeader goes first, before TLS.
+func (h *ProxyClient) handshake(req *fasthttp.Request, res *fasthttp.Response, proxyHeader []byte) (net.Conn, *bufio.Reader, error) {
+	conn, err := fasthttp.Dial(h.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The handshake is bounded like any request; the tunnel has its own
+	// idle timeout.
+	if h.proxyTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(h.proxyTimeout)) //nolint:errcheck
+	}
+
+	if proxyHeader != nil {
+		if _, err := conn.Write(proxyHeader); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	if h.tlsConfig != nil {
+		tlsConn := tls.Client(conn, h.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, handshakeError(err)
+		}
+		conn = tlsConn
+	}
+
+	bw := bufio.NewWriter(conn)
+	if err := req.Write(bw); err != nil {
+		conn.Close()
+		return nil, nil, handshakeError(err)
+	}
+	if err := bw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, handshakeError(err)
+	}
+
+	br := bufio.NewReaderSize(conn, tunnelBufferSize)
+	if err := res.Read(br); err != nil {
+		conn.Close()
+		return nil, nil, handshakeError(err)
+	}
+
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+	return conn, br, nil
+}
+
+// handshakeError maps a deadline expiry to ErrTimeout, so serverError
+// answers a hanging Backend with 504 as it does for plain requests.
+func handshakeError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fasthttp.ErrTimeout
+	}
+	return err
+}
+
+func closeIfOpen(conn net.Conn) {
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// tunnels tracks a Backend's open WebSocket tunnels so Stat can count them
+// and Close can end them; the zero value is ready to use.
+type tunnels struct {
+	mu      sync.Mutex
+	active  map[*tunnel]struct{}
+	wg      sync.WaitGroup
+	count   atomic.Int64
+	closing bool
+}
+
+// run splices client to backend and returns once the tunnel has closed.
+func (ts *tunnels) run(client, backend net.Conn, br *bufio.Reader, idleTimeout time.Duration) {
+	t := &tunnel{client: client, backend: backend, backendReader: br, idleTimeout: idleTimeout}
+	ts.mu.Lock()
+	if ts.closing {
+		ts.mu.Unlock()
+		t.close()
+		return
+	}
+	if ts.active == nil {
+		ts.active = make(map[*tunnel]struct{})
+	}
+	ts.active[t] = struct{}{}
+	ts.wg.Add(1)
+	ts.mu.Unlock()
+	ts.count.Add(1)
+
+	defer ts.done(t)
+	t.run()
+}
+
+func (ts *tunnels) done(t *tunnel) {
+	ts.mu.Lock()
+	delete(ts.active, t)
+	ts.mu.Unlock()
+	ts.count.Add(-1)
+	ts.wg.Done()
+}
+
+func (ts *tunnels) Len() int64 {
+	return ts.count.Load()
+}
+
+// closeAll ends every open tunnel: Backends first see the client side
+// finish, which lets them close cleanly, and whatever is still open after
+// grace is cut.
+func (ts *tunnels) closeAll(grace time.Duration) {
+	ts.mu.Lock()
+	ts.closing = true
+	active := make([]*tunnel, 0, len(ts.active))
+	for t := range ts.active {
+		active = append(active, t)
+	}
+	ts.mu.Unlock()
+	if len(active) == 0 {
+		return
+	}
+
+	zap.S().Infof("Closing %d open WebSocket tunnels", len(active))
+	for _, t := range active {
+		if !closeWrite(t.backend) {
+			t.close()
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		ts.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(grace):
+		for _, t := range active {
+			t.close()
+		}
+		<-drained
+	}
+}
+
+// tunnel splices a client connection to a Backend connection after a
+// successful Upgrade.
+type tunnel struct {
+	client        net.Conn
+	backend       net.Conn
+	backendReader *bufio.Reader
+	idleTimeout   time.Duration
+	lastActive    atomic.Int64
+	closed        atomic.Bool
+	closeOnce     sync.Once
+}
+
+func (t *tunnel) run() {
+	t.touch()
+
+	upstream := make(chan struct{})
+	go func() {
+		defer close(upstream)
+		t.pipe(t.backend, t.client, t.client)
+	}()
+	t.pipe(t.client, t.backendReader, t.backend)
+	<-upstream
+
+	t.close()
+}
+
+// pipe copies src to dst until src ends, then passes the end on: a clean
+// EOF half-closes dst so the other direction can finish, anything else
+// closes the tunnel.
+func (t *tunnel) pipe(dst net.Conn, src io.Reader, srcConn net.Conn) {
+	buf := make([]byte, tunnelBufferSize)
+	for {
+		if t.idleTimeout > 0 {
+			srcConn.SetReadDeadline(time.Now().Add(t.idleTimeout)) //nolint:errcheck
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				t.close()
+				return
+			}
+		}
+		switch {
+		case err == nil:
+			continue
+		case t.closed.Load():
+			return
+		// Traffic the other way keeps the tunnel open.
+		case errors.Is(err, os.ErrDeadlineExceeded) && t.idle() < t.idleTimeout:
+			continue
+		case errors.Is(err, io.EOF) && closeWrite(dst):
+			return
+		}
+		t.close()
+		return
+	}
+}
+
+func (t *tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - t.lastActive.Load())
+}
+
+func (t *tunnel) close() {
+	t.closeOnce.Do(func() {
+		t.closed.Store(true)
+		t.backend.Close()
+		t.client.Close()
+		// A hijacked fasthttp connection ignores Close until its handler
+		// returns; an expired deadline still wakes the Read blocked on it.
+		t.client.SetReadDeadline(time.Now()) //nolint:errcheck
+	})
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes conn, reporting false when conn cannot be
+// half-closed.
+func closeWrite(conn net.Conn) bool {
+	cw, ok := conn.(closeWriter)
+	return ok && cw.CloseWrite() == nil
+}
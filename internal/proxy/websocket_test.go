@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// echoWebSocketServer completes the Upgrade handshake and then echoes raw
+// bytes; tunnels are byte-level, so no frame parsing is needed. It answers
+// 403 to a request that does not ask to upgrade.
+func echoWebSocketServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-Websocket-Key") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" + //nolint:errcheck
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: accept\r\nX-Seen-Path: " + r.URL.Path + "\r\n\r\n")
+		brw.Flush()               //nolint:errcheck
+		io.Copy(conn, brw.Reader) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newWebSocketTestClient(srv *httptest.Server, idleTimeout time.Duration) *ProxyClient {
+	b := config.Backend{
+		Url:                  protocolRegex.ReplaceAllString(srv.URL, ""),
+		ProxyTimeout:         time.Second,
+		WebSocketIdleTimeout: idleTimeout,
+	}
+	return NewProxyClient(&b, nil, nil).(*ProxyClient)
+}
+
+// serveFasthttp runs p behind a fasthttp server configured the way
+// divisor's HTTP/1.1 stack is, and returns a dialer for client connections. A real TCP listener:
+// fasthttputil's in-memory conns do not wake a blocked Read when its
+// deadline moves, which tunnels rely on to close a hijacked connection.
+func serveFasthttp(t *testing.T, p *ProxyClient) func() net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			p.ReverseProxyHandler(ctx) //nolint:errcheck
+		},
+		MaxRequestBodySize:           p.maxRequestBodySize,
+		StreamRequestBody:            p.streamBodies,
+		DisablePreParseMultipartForm: p.streamBodies,
+	}
+	go srv.Serve(ln) //nolint:errcheck
+	t.Cleanup(func() { ln.Close() })
+
+	return func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.NoError(t, err)
+		return conn
+	}
+}
+
+const upgradeRequest = "GET /chat HTTP/1.1\r\nHost: divisor\r\n" +
+	"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+
+func upgrade(t *testing.T, conn net.Conn) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	_, err := conn.Write([]byte(upgradeRequest))
+	assert.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	assert.NoError(t, err)
+	return res, br
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "WebSocket")
+	assert.True(t, IsWebSocketUpgrade(req))
+
+	req.Header.Set("Upgrade", "h2c")
+	assert.False(t, IsWebSocketUpgrade(req))
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive")
+	assert.False(t, IsWebSocketUpgrade(req))
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	p := newWebSocketTestClient(echoWebSocketServer(t), time.Minute)
+	conn := serveFasthttp(t, p)()
+	defer conn.Close()
+
+	res, br := upgrade(t, conn)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "websocket", res.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", res.Header.Get("Connection"))
+	assert.Equal(t, "/chat", res.Header.Get("X-Seen-Path"))
+
+	_, err := conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	assert.Equal(t, int64(1), p.Stat().OpenTunnels)
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return p.Stat().OpenTunnels == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketUpgradeRefused(t *testing.T) {
+	p := newWebSocketTestClient(echoWebSocketServer(t), time.Minute)
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Upgrade", "websocket")
+	ctx.Request.Header.Set("Connection", "Upgrade")
+	ctx.Request.Header.Set("Sec-WebSocket-Version", "13")
+
+	// No key: the Backend turns the handshake down with a plain response.
+	assert.NoError(t, p.ReverseProxyHandler(&ctx))
+	assert.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+	assert.False(t, ctx.Hijacked())
+	assert.Equal(t, int64(0), p.Stat().OpenTunnels)
+}
+
+func TestWebSocketUnreachableBackend(t *testing.T) {
+	p := newRetryTestClient(refusedAddr(t))
+
+	ctx := fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("Upgrade", "websocket")
+	ctx.Request.Header.Set("Connection", "Upgrade")
+
+	assert.Error(t, p.ReverseProxyHandler(&ctx))
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	assert.False(t, ctx.Hijacked())
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	p := newWebSocketTestClient(echoWebSocketServer(t), 100*time.Millisecond)
+	conn := serveFasthttp(t, p)()
+	defer conn.Close()
+
+	res, br := upgrade(t, conn)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "an idle tunnel must be closed")
+	assert.Eventually(t, func() bool { return p.Stat().OpenTunnels == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestCloseEndsWebSocketTunnels(t *testing.T) {
+	p := newWebSocketTestClient(echoWebSocketServer(t), time.Minute)
+	dial := serveFasthttp(t, p)
+
+	var readers []*bufio.Reader
+	for range 2 {
+		conn := dial()
+		defer conn.Close()
+		res, br := upgrade(t, conn)
+		assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+		readers = append(readers, br)
+	}
+	assert.Eventually(t, func() bool { return p.Stat().OpenTunnels == 2 }, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, p.Close())
+	assert.Equal(t, int64(0), p.Stat().OpenTunnels)
+	for _, br := range readers {
+		_, err := br.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
+
+// flushPipe is an http.ResponseWriter whose body can be read while the
+// handler is still writing it, as an HTTP/2 stream can.
+type flushPipe struct {
+	header http.Header
+	status chan int
+	w      *io.PipeWriter
+}
+
+func (f *flushPipe) Header() http.Header         { return f.header }
+func (f *flushPipe) WriteHeader(status int)      { f.status <- status }
+func (f *flushPipe) Write(p []byte) (int, error) { return f.w.Write(p) }
+func (f *flushPipe) Flush()                      {}
+
+func TestNetHttpAdapterExtendedConnect(t *testing.T) {
+	p := newWebSocketTestClient(echoWebSocketServer(t), time.Minute)
+	adapter := NewNetHttpAdapter(&stubBalancer{handler: func(ctx *fasthttp.RequestCtx) {
+		p.ReverseProxyHandler(ctx) //nolint:errcheck
+	}}, 0, false)
+
+	clientBody, clientWriter := io.Pipe()
+	responseBody, responseWriter := io.Pipe()
+	req := httptest.NewRequest(http.MethodConnect, "https://divisor/chat", clientBody)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-Websocket-Version", "13")
+	w := &flushPipe{header: http.Header{}, status: make(chan int, 1), w: responseWriter}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		adapter.ServeHTTP(w, req)
+	}()
+
+	assert.Equal(t, http.StatusOK, <-w.status)
+	assert.Empty(t, w.Header().Get("Sec-Websocket-Accept"))
+	assert.Empty(t, w.Header().Get("Upgrade"))
+	assert.Equal(t, "/chat", w.Header().Get("X-Seen-Path"))
+
+	_, err := clientWriter.Write([]byte("hello"))
+	assert.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(responseBody, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// The client ending its stream ends the tunnel and the handler.
+	clientWriter.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("extended CONNECT handler did not return after the client closed its stream")
+	}
+	assert.Equal(t, int64(0), p.Stat().OpenTunnels)
+}
@@ -0,0 +1,191 @@
+// Package ratelimit answers requests past a client's rate limit with 429
+// before the balancer picks a Backend for them.
+package ratelimit
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// sweepInterval is how often keys back to a fresh state are dropped.
+const sweepInterval = 10 * time.Second
+
+// Counters is what /stats reports about one limit: the requests it allowed
+// and denied, and the keys it holds state for.
+type Counters struct {
+	Name    string `json:"name"`
+	Allowed uint64 `json:"allowed"`
+	Denied  uint64 `json:"denied"`
+	Keys    int    `json:"keys"`
+}
+
+// RateLimiter is a balancer that checks every request against its limits and
+// hands the ones all of them allow to the balancer it wraps.
+type RateLimiter struct {
+	types.IBalancer
+	limits   []*limit
+	clientIP config.ClientIP
+	stop     chan struct{}
+	stopOnce sync.Once
+	now      func() time.Time
+}
+
+type limit struct {
+	name    string
+	key     string
+	paths   []string
+	store   *store
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+}
+
+// grant is a limit that allowed a request, and the key it allowed it under.
+type grant struct {
+	limit *limit
+	key   string
+}
+
+// New checks requests against limits before handing them to balancer, and
+// starts dropping idle keys until Shutdown.
+func New(limits []config.RateLimit, clientIP config.ClientIP, balancer types.IBalancer) *RateLimiter {
+	r := &RateLimiter{
+		IBalancer: balancer,
+		clientIP:  clientIP,
+		stop:      make(chan struct{}),
+		now:       time.Now,
+	}
+	for _, cfg := range limits {
+		r.limits = append(r.limits, &limit{name: cfg.Name, key: cfg.Key, paths: cfg.Paths, store: newStore(cfg)})
+	}
+	go r.sweep()
+	return r
+}
+
+func (r *RateLimiter) Serve() func(ctx *fasthttp.RequestCtx) {
+	next := r.IBalancer.Serve()
+	return func(ctx *fasthttp.RequestCtx) {
+		now := r.now()
+		var tightest *decision
+		var buf [8]grant
+		granted := buf[:0]
+		for _, l := range r.limits {
+			key, ok := l.keyFor(ctx, &r.clientIP)
+			if !ok {
+				continue
+			}
+			d := l.store.take(key, now)
+			if d.allowed {
+				granted = append(granted, grant{limit: l, key: key})
+			} else {
+				l.denied.Add(1)
+			}
+			if tightest == nil || d.tighter(*tightest) {
+				tightest = &d
+			}
+		}
+		if tightest != nil && !tightest.allowed {
+			// A request that never goes upstream spends none of the
+			// limits that allowed it.
+			for _, g := range granted {
+				g.limit.store.refund(g.key, now)
+			}
+			tooManyRequests(ctx, tightest)
+			return
+		}
+		for _, g := range granted {
+			g.limit.allowed.Add(1)
+		}
+		next(ctx)
+		if tightest != nil {
+			setHeaders(&ctx.Response.Header, tightest)
+		}
+	}
+}
+
+// Shutdown stops dropping idle keys, then shuts down the balancer it wraps.
+func (r *RateLimiter) Shutdown() error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	return r.IBalancer.Shutdown()
+}
+
+func (r *RateLimiter) Counters() []Counters {
+	counters := make([]Counters, 0, len(r.limits))
+	for _, l := range r.limits {
+		counters = append(counters, Counters{
+			Name:    l.name,
+			Allowed: l.allowed.Load(),
+			Denied:  l.denied.Load(),
+			Keys:    l.store.keys(),
+		})
+	}
+	return counters
+}
+
+func (r *RateLimiter) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			now := r.now()
+			for _, l := range r.limits {
+				l.store.sweep(now)
+			}
+		}
+	}
+}
+
+// keyFor returns what l counts ctx by, and false when l does not cover ctx's
+// path. A request missing the header l is keyed by counts against its client
+// IP instead, so leaving the header out does not escape the limit.
+func (l *limit) keyFor(ctx *fasthttp.RequestCtx, clientIP *config.ClientIP) (string, bool) {
+	prefix := ""
+	if len(l.paths) > 0 {
+		i := slices.IndexFunc(l.paths, func(prefix string) bool {
+			return strings.HasPrefix(helper.B2S(ctx.Path()), prefix)
+		})
+		if i < 0 {
+			return "", false
+		}
+		prefix = l.paths[i]
+	}
+
+	switch {
+	case l.key == config.RateLimitKeyPath:
+		return prefix, true
+	case strings.HasPrefix(l.key, config.RateLimitKeyHeader):
+		if value := ctx.Request.Header.Peek(strings.TrimPrefix(l.key, config.RateLimitKeyHeader)); len(value) > 0 {
+			return "header:" + string(value), true
+		}
+	}
+	return "ip:" + proxy.ClientIP(ctx, clientIP).String(), true
+}
+
+// tooManyRequests answers a request a limit denied, telling the client when
+// to come back.
+func tooManyRequests(ctx *fasthttp.RequestCtx, d *decision) {
+	ctx.Response.SetStatusCode(fasthttp.StatusTooManyRequests)
+	ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(max(seconds(d.retryAfter), 1)))
+	setHeaders(&ctx.Response.Header, d)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.Response.SetBodyString(`{"message":"too many requests"}`)
+}
+
+func setHeaders(header *fasthttp.ResponseHeader, d *decision) {
+	header.Set("RateLimit-Limit", strconv.Itoa(d.limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(d.reset)))
+	header.Set("RateLimit-Policy", d.policy)
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type stubBalancer struct {
+	served int
+}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		s.served++
+		ctx.SetBodyString("backend")
+	}
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return nil }
+func (s *stubBalancer) Shutdown() error          { return nil }
+
+func newTestLimiter(t *testing.T, limits ...config.RateLimit) (*RateLimiter, *stubBalancer) {
+	t.Helper()
+	cfg := config.Config{Port: "8000", Backends: []config.Backend{{Url: "localhost:8080"}}, RateLimits: limits}
+	for i := range cfg.RateLimits {
+		cfg.RateLimits[i].Period = time.Minute
+	}
+	assert.NoError(t, cfg.PrepareConfig())
+	balancer := &stubBalancer{}
+	r := New(cfg.RateLimits, cfg.ClientIP, balancer)
+	r.now = func() time.Time { return start }
+	t.Cleanup(func() { r.Shutdown() }) //nolint:errcheck
+	return r, balancer
+}
+
+func request(r *RateLimiter, peer, uri string, header ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP(peer), Port: 40000}, nil)
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(header); i += 2 {
+		ctx.Request.Header.Set(header[i], header[i+1])
+	}
+	r.Serve()(ctx)
+	return ctx
+}
+
+func TestRateLimiter(t *testing.T) {
+	r, balancer := newTestLimiter(t, config.RateLimit{Rate: 2})
+
+	ctx := request(r, "203.0.113.1", "/")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "2", string(ctx.Response.Header.Peek("RateLimit-Limit")))
+	assert.Equal(t, "1", string(ctx.Response.Header.Peek("RateLimit-Remaining")))
+	assert.Equal(t, "30", string(ctx.Response.Header.Peek("RateLimit-Reset")))
+	assert.Equal(t, "2;w=60;burst=2", string(ctx.Response.Header.Peek("RateLimit-Policy")))
+
+	request(r, "203.0.113.1", "/")
+	ctx = request(r, "203.0.113.1", "/")
+	assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	assert.Equal(t, "30", string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)))
+	assert.Equal(t, "0", string(ctx.Response.Header.Peek("RateLimit-Remaining")))
+	assert.Equal(t, `{"message":"too many requests"}`, string(ctx.Response.Body()))
+	assert.Equal(t, 2, balancer.served)
+
+	// Another client has its own budget.
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.2", "/").Response.StatusCode())
+
+	assert.Equal(t, []Counters{{Name: "client_ip", Allowed: 3, Denied: 1, Keys: 2}}, r.Counters())
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	r, _ := newTestLimiter(t,
+		config.RateLimit{Name: "api", Key: "header:X-Api-Key", Rate: 1, Paths: []string{"/api/"}},
+		config.RateLimit{Name: "search", Key: config.RateLimitKeyPath, Rate: 1, Paths: []string{"/search"}},
+	)
+
+	// A header key is counted per value, whoever sends it.
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.1", "/api/a", "X-Api-Key", "k1").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusTooManyRequests, request(r, "203.0.113.2", "/api/a", "X-Api-Key", "k1").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.2", "/api/a", "X-Api-Key", "k2").Response.StatusCode())
+	// Without it, the client IP is the key.
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.3", "/api/a").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusTooManyRequests, request(r, "203.0.113.3", "/api/a").Response.StatusCode())
+
+	// A path key is one budget for every client.
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.1", "/search?q=a").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusTooManyRequests, request(r, "203.0.113.2", "/search?q=b").Response.StatusCode())
+
+	// Paths no limit covers are never limited, nor given RateLimit headers.
+	for range 3 {
+		ctx := request(r, "203.0.113.1", "/static/app.js")
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Empty(t, ctx.Response.Header.Peek("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimiterTightest(t *testing.T) {
+	r, _ := newTestLimiter(t,
+		config.RateLimit{Name: "loose", Rate: 10},
+		config.RateLimit{Name: "tight", Key: config.RateLimitKeyPath, Rate: 3},
+	)
+	ctx := request(r, "203.0.113.1", "/")
+	assert.Equal(t, "3", string(ctx.Response.Header.Peek("RateLimit-Limit")))
+	assert.Equal(t, "2", string(ctx.Response.Header.Peek("RateLimit-Remaining")))
+}
+
+func TestRateLimiterDenialSpendsNoOtherLimit(t *testing.T) {
+	r, balancer := newTestLimiter(t,
+		config.RateLimit{Name: "client", Rate: 2},
+		config.RateLimit{Name: "search", Key: config.RateLimitKeyPath, Rate: 1, Paths: []string{"/search"}},
+	)
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.1", "/search").Response.StatusCode())
+	for range 3 {
+		assert.Equal(t, fasthttp.StatusTooManyRequests, request(r, "203.0.113.1", "/search").Response.StatusCode())
+	}
+	// The denied searches left the client its second request.
+	assert.Equal(t, fasthttp.StatusOK, request(r, "203.0.113.1", "/").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusTooManyRequests, request(r, "203.0.113.1", "/").Response.StatusCode())
+
+	assert.Equal(t, 2, balancer.served)
+	assert.Equal(t, []Counters{
+		{Name: "client", Allowed: 2, Denied: 1, Keys: 1},
+		{Name: "search", Allowed: 1, Denied: 3, Keys: 1},
+	}, r.Counters())
+}
@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+)
+
+// shardCount spreads keys over locks, so requests for different keys seldom
+// wait on each other.
+const shardCount = 64
+
+// decision is what one limit made of one request, and what its RateLimit-*
+// headers say.
+type decision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset is how long until the limit is back to full.
+	reset time.Duration
+	// retryAfter is how long a denied request should wait.
+	retryAfter time.Duration
+	policy     string
+}
+
+// tighter reports whether d says more about the client's limits than other:
+// a denial over an allowance, then the fewest requests remaining.
+func (d decision) tighter(other decision) bool {
+	if d.allowed != other.allowed {
+		return !d.allowed
+	}
+	return d.remaining < other.remaining
+}
+
+// store holds one limit's state per key. An entry is dropped once it is
+// back to the state a new key starts in.
+type store struct {
+	shards        [shardCount]shard
+	slidingWindow bool
+	rate          int
+	burst         int
+	period        time.Duration
+	// policy is the RateLimit-Policy value: the rate, the window in seconds
+	// and, for a token bucket, the burst.
+	policy string
+}
+
+type shard struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is a token bucket (tokens, last) or a sliding window (windowStart,
+// current, previous), as the store's algorithm keeps it.
+type entry struct {
+	tokens      float64
+	last        time.Time
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+func newStore(cfg config.RateLimit) *store {
+	s := &store{
+		slidingWindow: cfg.Algorithm == config.RateLimitSlidingWindow,
+		rate:          cfg.Rate,
+		burst:         cfg.Burst,
+		period:        cfg.Period,
+	}
+	s.policy = strconv.Itoa(s.rate) + ";w=" + strconv.Itoa(max(seconds(s.period), 1))
+	if !s.slidingWindow {
+		s.policy += ";burst=" + strconv.Itoa(s.burst)
+	}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*entry)
+	}
+	return s
+}
+
+func (s *store) shard(key string) *shard {
+	return &s.shards[helper.HashFunc(helper.S2B(key))%shardCount]
+}
+
+func (s *store) take(key string, now time.Time) decision {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e := sh.entries[key]
+	if e == nil {
+		e = &entry{tokens: float64(s.burst), last: now, windowStart: now.Truncate(s.period)}
+		sh.entries[key] = e
+	}
+	if s.slidingWindow {
+		return s.takeWindow(e, now)
+	}
+	return s.takeToken(e, now)
+}
+
+// takeToken refills e at rate per period, up to burst, and spends a token.
+func (s *store) takeToken(e *entry, now time.Time) decision {
+	perToken := float64(s.period) / float64(s.rate)
+	e.tokens = min(float64(s.burst), e.tokens+float64(now.Sub(e.last))/perToken)
+	e.last = now
+	d := decision{limit: s.rate, policy: s.policy}
+	if e.tokens >= 1 {
+		e.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = time.Duration((1 - e.tokens) * perToken)
+	}
+	d.remaining = int(e.tokens)
+	d.reset = time.Duration((float64(s.burst) - e.tokens) * perToken)
+	return d
+}
+
+// takeWindow counts e in fixed windows of period and estimates the sliding
+// one as the current window's count plus the share of the previous window's
+// the sliding one still covers.
+func (s *store) takeWindow(e *entry, now time.Time) decision {
+	start := now.Truncate(s.period)
+	if !start.Equal(e.windowStart) {
+		e.previous = 0
+		if start.Sub(e.windowStart) == s.period {
+			e.previous = e.current
+		}
+		e.current, e.windowStart = 0, start
+	}
+	elapsed := float64(now.Sub(start)) / float64(s.period)
+	estimate := float64(e.previous)*(1-elapsed) + float64(e.current)
+	d := decision{limit: s.rate, policy: s.policy, reset: start.Add(s.period).Sub(now)}
+	rate := float64(s.rate)
+	if estimate+1 <= rate {
+		e.current++
+		d.allowed = true
+		d.remaining = int(rate - estimate - 1)
+		return d
+	}
+
+	// The wait until estimate+1 fits: later in this window while the
+	// previous one's share fades, or else into the next one.
+	if e.current+1 <= s.rate {
+		wait := 1 - (rate-float64(e.current)-1)/float64(e.previous) - elapsed
+		d.retryAfter = time.Duration(wait * float64(s.period))
+	} else {
+		into := 1 - (rate-1)/float64(e.current)
+		d.retryAfter = d.reset + time.Duration(into*float64(s.period))
+	}
+	return d
+}
+
+// refund gives back what take allowed key at now, for a request another
+// limit denied.
+func (s *store) refund(key string, now time.Time) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e := sh.entries[key]
+	switch {
+	case e == nil:
+	case s.slidingWindow:
+		if e.windowStart.Equal(now.Truncate(s.period)) && e.current > 0 {
+			e.current--
+		}
+	default:
+		e.tokens = min(float64(s.burst), e.tokens+1)
+	}
+}
+
+// sweep drops the entries a new key would start out as.
+func (s *store) sweep(now time.Time) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, e := range sh.entries {
+			if s.idle(e, now) {
+				delete(sh.entries, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (s *store) idle(e *entry, now time.Time) bool {
+	if s.slidingWindow {
+		return now.Sub(e.windowStart) >= 2*s.period
+	}
+	perToken := float64(s.period) / float64(s.rate)
+	return e.tokens+float64(now.Sub(e.last))/perToken >= float64(s.burst)
+}
+
+func (s *store) keys() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// seconds rounds d up to whole seconds, as Retry-After and RateLimit-*
+// carry them, so clients never come back early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
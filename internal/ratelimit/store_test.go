@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTokenBucket(t *testing.T) {
+	s := newStore(config.RateLimit{Algorithm: config.RateLimitTokenBucket, Rate: 2, Period: time.Second, Burst: 3})
+	assert.Equal(t, "2;w=1;burst=3", s.policy)
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		d := s.take("a", start)
+		assert.True(t, d.allowed)
+		assert.Equal(t, remaining, d.remaining)
+	}
+	d := s.take("a", start)
+	assert.False(t, d.allowed)
+	assert.Equal(t, 500*time.Millisecond, d.retryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.reset)
+
+	// A refund puts the token back, up to the burst.
+	s.refund("a", start)
+	assert.True(t, s.take("a", start).allowed)
+	s.refund("c", start)
+	assert.Equal(t, 1, s.keys())
+
+	// Other keys have buckets of their own.
+	assert.True(t, s.take("b", start).allowed)
+
+	// Half a second refills one token.
+	assert.True(t, s.take("a", start.Add(500*time.Millisecond)).allowed)
+	assert.False(t, s.take("a", start.Add(500*time.Millisecond)).allowed)
+
+	// A full bucket is what a new key starts with, so it can go.
+	s.sweep(start.Add(time.Second))
+	assert.Equal(t, 1, s.keys())
+	s.sweep(start.Add(2 * time.Second))
+	assert.Equal(t, 0, s.keys())
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := newStore(config.RateLimit{Algorithm: config.RateLimitSlidingWindow, Rate: 4, Period: time.Minute})
+	assert.Equal(t, "4;w=60", s.policy)
+
+	for range 4 {
+		assert.True(t, s.take("a", start).allowed)
+	}
+	s.refund("a", start)
+	assert.True(t, s.take("a", start).allowed)
+	d := s.take("a", start.Add(15*time.Second))
+	assert.False(t, d.allowed)
+	assert.Equal(t, 45*time.Second, d.reset)
+	// The next window still counts 3 of the previous one's 4 at its start.
+	assert.Equal(t, 45*time.Second+15*time.Second, d.retryAfter)
+
+	// A quarter into the next window the previous one still counts 3 of 4.
+	next := start.Add(time.Minute + 15*time.Second)
+	d = s.take("a", next)
+	assert.True(t, d.allowed)
+	assert.Equal(t, 0, d.remaining)
+	d = s.take("a", next)
+	assert.False(t, d.allowed)
+	// Once the previous window's share drops to 2.
+	assert.Equal(t, 15*time.Second, d.retryAfter)
+
+	s.sweep(start.Add(2 * time.Minute))
+	assert.Equal(t, 1, s.keys())
+	s.sweep(start.Add(3 * time.Minute))
+	assert.Equal(t, 0, s.keys())
+}
+
+func TestDecisionTighter(t *testing.T) {
+	allowed := decision{allowed: true, remaining: 5}
+	assert.True(t, decision{remaining: 9}.tighter(allowed))
+	assert.False(t, allowed.tighter(decision{remaining: 0}))
+	assert.True(t, decision{allowed: true, remaining: 1}.tighter(allowed))
+}
@@ -8,10 +8,13 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/aaydin-tr/divisor/core/types"
 	"github.com/aaydin-tr/divisor/internal/proxy"
 	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 	"golang.org/x/net/http2"
@@ -27,6 +30,10 @@ type Server interface {
 // that ended serving, if any; a clean Shutdown delivers nothing. A nil error
 // return guarantees a non-nil Server.
 func Start(cfg *config.Config, balancer types.IBalancer, ln net.Listener) (Server, <-chan error, error) {
+	// Wrapped beneath TLS: a PROXY header comes before the ClientHello.
+	if cfg.Server.ProxyProtocol != "" {
+		ln = proxyproto.NewListener(ln, cfg.Server.ProxyProtocol == config.ProxyProtocolRequire, proxyproto.DefaultHeaderTimeout)
+	}
 	if cfg.Server.HttpVersion == config.Http2 {
 		zap.S().Info("Starting net/http server with HTTP/2")
 		return startNetHttp(cfg, balancer, ln)
@@ -51,8 +58,12 @@ func startFasthttp(cfg *config.Config, balancer types.IBalancer, ln net.Listener
 		IdleTimeout:           cfg.Server.IdleTimeout,
 		DisableKeepalive:      cfg.Server.DisableKeepalive,
 		MaxRequestBodySize:    cfg.Server.MaxRequestBodySize,
-		ErrorHandler:          proxy.ErrorHandler,
-		Name:                  "divisor",
+		StreamRequestBody:     cfg.Server.StreamBodies,
+		// Pre-parsing would spool a streamed multipart upload to memory
+		// and temp files before the Backend sees a byte of it.
+		DisablePreParseMultipartForm: cfg.Server.StreamBodies,
+		ErrorHandler:                 proxy.ErrorHandler,
+		Name:                         "divisor",
 	}
 
 	// fasthttp returns nil from Serve once Shutdown closes the listener.
@@ -75,7 +86,7 @@ func (s netHttpServer) OpenConnectionsCount() int32 { return 0 }
 func startNetHttp(cfg *config.Config, balancer types.IBalancer, ln net.Listener) (Server, <-chan error, error) {
 	srv := &http.Server{
 		Addr:         cfg.GetAddr(),
-		Handler:      proxy.NewNetHttpAdapter(balancer, cfg.Server.MaxRequestBodySize),
+		Handler:      proxy.NewNetHttpAdapter(balancer, cfg.Server.MaxRequestBodySize, cfg.Server.StreamBodies),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
@@ -86,6 +97,12 @@ func startNetHttp(cfg *config.Config, balancer types.IBalancer, ln net.Listener)
 		return nil, nil, fmt.Errorf("configuring HTTP/2 server: %w", err)
 	}
 
+	// x/net/http2 reads this switch once at init, so it can only come from
+	// the environment (the Docker image sets it).
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		zap.S().Warn("WebSocket over HTTP/2 is disabled, set GODEBUG=http2xconnect=1 to accept extended CONNECT")
+	}
+
 	serve := func() error {
 		err := srv.ServeTLS(ln, cfg.Server.CertFile, cfg.Server.KeyFile)
 		if errors.Is(err, http.ErrServerClosed) {
@@ -2,15 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"io"
 	"net"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/aaydin-tr/divisor/internal/testcert"
 	"github.com/aaydin-tr/divisor/mocks"
 	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
 )
 
 // brokenListener fails every Accept permanently, the way a listener whose
@@ -80,3 +86,67 @@ func TestCleanShutdownReportsNothing(t *testing.T) {
 	case <-time.After(200 * time.Millisecond):
 	}
 }
+
+// remoteIPBalancer answers every request with the client address it sees.
+type remoteIPBalancer struct{ mocks.MockBalancer }
+
+func (remoteIPBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) { ctx.SetBodyString(ctx.RemoteIP().String()) }
+}
+
+// proxiedClient opens every connection with a PROXY header naming
+// 203.0.113.9, under TLS when it speaks HTTP/2.
+func proxiedClient(httpVersion string) *http.Client {
+	header := (&proxyproto.Header{
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+	}).Format(2)
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.Write(header)
+		return conn, err
+	}
+	if httpVersion != config.Http2 {
+		return &http.Client{Transport: &http.Transport{DialContext: dial}}
+	}
+	return &http.Client{Transport: &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg.InsecureSkipVerify = true
+			return tls.Client(conn, cfg), nil
+		},
+	}}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	for _, version := range []string{config.Http1, config.Http2} {
+		t.Run(version, func(t *testing.T) {
+			cfg := newConfig(t, version)
+			cfg.Server.ProxyProtocol = config.ProxyProtocolRequire
+			ln := localListener(t)
+			srv, _, err := Start(cfg, &remoteIPBalancer{}, ln)
+			assert.NoError(t, err)
+			defer srv.Shutdown(context.Background()) //nolint:errcheck
+
+			scheme := "http://"
+			if version == config.Http2 {
+				scheme = "https://"
+			}
+			res, err := proxiedClient(version).Get(scheme + ln.Addr().String() + "/")
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, "203.0.113.9", string(body))
+
+			// require turns away a client that sends no header.
+			_, err = (&http.Client{Timeout: time.Second}).Get("http://" + ln.Addr().String() + "/")
+			assert.Error(t, err)
+		})
+	}
+}
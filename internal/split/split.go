@@ -0,0 +1,214 @@
+// Package split divides traffic between the primary pool of Backends and
+// further named pools, such as a canary, each behind a balancer of its own.
+package split
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+// stickyBuckets is how finely a user key's hash places it along the 100%
+// the weights divide: a hundredth of a percent.
+const stickyBuckets = 10000
+
+var (
+	ErrUnknownPool = errors.New("no split pool has that name; the primary pool's weight is what the others leave")
+	ErrWeights     = errors.New("split weights must be between 0 and 100 and add up to at most 100")
+)
+
+// statusClasses names the Responses counters.
+var statusClasses = [...]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// PoolStats is what /stats reports about one pool. Responses counts its
+// answers by status class, divisor's own 502 and 504 included, so error
+// rates compare across pools.
+type PoolStats struct {
+	Name      string            `json:"name"`
+	Weight    float64           `json:"weight"`
+	Requests  uint64            `json:"requests"`
+	Responses map[string]uint64 `json:"responses"`
+	Backends  []types.ProxyStat `json:"backends"`
+}
+
+// Split is a balancer that hands each request to one of its pools' balancers.
+// The primary pool is the one it embeds.
+type Split struct {
+	types.IBalancer
+	// pools holds the primary pool first, then the named ones in config
+	// order.
+	pools []*pool
+	// weights holds the named pools' weights; the primary pool has the rest.
+	weights atomic.Pointer[[]float64]
+	match   []match
+	sticky  config.SplitKey
+}
+
+type pool struct {
+	name      string
+	balancer  types.IBalancer
+	requests  atomic.Uint64
+	responses [len(statusClasses)]atomic.Uint64
+}
+
+type match struct {
+	config.SplitMatch
+	pool int
+}
+
+// New splits traffic between primary and pools, the balancers of cfg.Pools
+// in order.
+func New(cfg config.Split, primary types.IBalancer, pools []types.IBalancer) *Split {
+	s := &Split{IBalancer: primary, sticky: cfg.Sticky}
+	s.pools = append(s.pools, &pool{name: config.SplitPrimaryPool, balancer: primary})
+	weights := make([]float64, 0, len(cfg.Pools))
+	for i, p := range cfg.Pools {
+		s.pools = append(s.pools, &pool{name: p.Name, balancer: pools[i]})
+		weights = append(weights, p.Weight)
+	}
+	s.weights.Store(&weights)
+	for _, m := range cfg.Match {
+		s.match = append(s.match, match{SplitMatch: m, pool: s.index(m.Pool)})
+	}
+	return s
+}
+
+func (s *Split) Serve() func(ctx *fasthttp.RequestCtx) {
+	serve := make([]func(*fasthttp.RequestCtx), len(s.pools))
+	for i, p := range s.pools {
+		serve[i] = p.balancer.Serve()
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		i, ok := ctx.UserValue(chosenKey{}).(int)
+		if !ok {
+			i = s.choose(ctx)
+		}
+		p := s.pools[i]
+		p.requests.Add(1)
+		serve[i](ctx)
+		if class := ctx.Response.StatusCode()/100 - 1; class >= 0 && class < len(p.responses) { //nolint:mnd
+			p.responses[class].Add(1)
+		}
+	}
+}
+
+// Shutdown shuts down the named pools, then the primary one.
+func (s *Split) Shutdown() error {
+	for _, p := range s.pools[1:] {
+		if err := p.balancer.Shutdown(); err != nil {
+			return err
+		}
+	}
+	return s.IBalancer.Shutdown()
+}
+
+// SetWeights changes the named pools' weights, leaving the ones weights does
+// not name as they are. It takes all of them or none.
+func (s *Split) SetWeights(weights map[string]float64) error {
+	next := append([]float64(nil), *s.weights.Load()...)
+	for name, weight := range weights {
+		i := s.index(name)
+		if i <= 0 {
+			return ErrUnknownPool
+		}
+		next[i-1] = weight
+	}
+	if !config.ValidSplitWeights(next) {
+		return ErrWeights
+	}
+	s.weights.Store(&next)
+	return nil
+}
+
+// Pools reports every pool, the primary one first.
+func (s *Split) Pools() []PoolStats {
+	weights := *s.weights.Load()
+	primary := 100.0
+	for _, weight := range weights {
+		primary -= weight
+	}
+	stats := make([]PoolStats, 0, len(s.pools))
+	for i, p := range s.pools {
+		weight := primary
+		if i > 0 {
+			weight = weights[i-1]
+		}
+		responses := make(map[string]uint64, len(statusClasses))
+		for j, class := range statusClasses {
+			responses[class] = p.responses[j].Load()
+		}
+		stats = append(stats, PoolStats{
+			Name:      p.name,
+			Weight:    weight,
+			Requests:  p.requests.Load(),
+			Responses: responses,
+			Backends:  p.balancer.Stats(),
+		})
+	}
+	return stats
+}
+
+// chosenKey holds the index of the pool Choose picked for a request.
+type chosenKey struct{}
+
+// Choose picks the pool for ctx ahead of Serve, which then hands ctx to that
+// pool, and returns its name. The cache keys responses by it, so no pool's
+// response reaches a client another pool serves.
+func (s *Split) Choose(ctx *fasthttp.RequestCtx) string {
+	i, ok := ctx.UserValue(chosenKey{}).(int)
+	if !ok {
+		i = s.choose(ctx)
+		ctx.SetUserValue(chosenKey{}, i)
+	}
+	return s.pools[i].name
+}
+
+// choose picks the index of the pool for ctx: the first match's, else the
+// one the user key's hash falls in, else one at random by weight. Pools are
+// laid out in config order from 0 with the primary pool last, so raising the
+// first pool's weight only moves users onto it; a later pool's range shifts
+// with the weights before it.
+func (s *Split) choose(ctx *fasthttp.RequestCtx) int {
+	for _, m := range s.match {
+		if string(read(ctx, m.Header, m.Cookie)) == m.Value {
+			return m.pool
+		}
+	}
+	point := rand.Float64() * 100 //nolint:gosec,mnd
+	if key := read(ctx, s.sticky.Header, s.sticky.Cookie); len(key) > 0 {
+		point = float64(helper.HashFunc(key)%stickyBuckets) * 100 / stickyBuckets //nolint:mnd
+	}
+	for i, weight := range *s.weights.Load() {
+		if point < weight {
+			return i + 1
+		}
+		point -= weight
+	}
+	return 0
+}
+
+// read returns the request's header, or else its cookie, by that name; nil
+// when both names are empty.
+func read(ctx *fasthttp.RequestCtx, header, cookie string) []byte {
+	switch {
+	case header != "":
+		return ctx.Request.Header.Peek(header)
+	case cookie != "":
+		return ctx.Request.Header.Cookie(cookie)
+	}
+	return nil
+}
+
+func (s *Split) index(name string) int {
+	for i, p := range s.pools {
+		if p.name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package split
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type stubBalancer struct {
+	name   string
+	status int
+}
+
+func (s *stubBalancer) Serve() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(s.status)
+		ctx.SetBodyString(s.name)
+	}
+}
+func (s *stubBalancer) Stats() []types.ProxyStat { return []types.ProxyStat{{Addr: s.name + ":8080"}} }
+func (s *stubBalancer) Shutdown() error          { return nil }
+
+func newTestSplit(cfg config.Split) *Split {
+	cfg.Enabled = true
+	if cfg.Pools == nil {
+		cfg.Pools = []config.SplitPool{{Name: "canary", Weight: 10}}
+	}
+	pools := make([]types.IBalancer, 0, len(cfg.Pools))
+	for _, p := range cfg.Pools {
+		pools = append(pools, &stubBalancer{name: p.Name, status: fasthttp.StatusInternalServerError})
+	}
+	return New(cfg, &stubBalancer{name: "primary", status: fasthttp.StatusOK}, pools)
+}
+
+func serve(s *Split, header ...string) string {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	for i := 0; i+1 < len(header); i += 2 {
+		ctx.Request.Header.Set(header[i], header[i+1])
+	}
+	s.Serve()(ctx)
+	return string(ctx.Response.Body())
+}
+
+func TestSplitWeights(t *testing.T) {
+	s := newTestSplit(config.Split{})
+	served := map[string]int{}
+	for range 2000 {
+		served[serve(s)]++
+	}
+	assert.InDelta(t, 200, served["canary"], 80)
+	assert.Equal(t, 2000, served["primary"]+served["canary"])
+
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 100}))
+	assert.Equal(t, "canary", serve(s))
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 0}))
+	assert.Equal(t, "primary", serve(s))
+
+	assert.ErrorIs(t, s.SetWeights(map[string]float64{"canary": 101}), ErrWeights)
+	assert.ErrorIs(t, s.SetWeights(map[string]float64{"primary": 50}), ErrUnknownPool)
+	assert.ErrorIs(t, s.SetWeights(map[string]float64{"beta": 50}), ErrUnknownPool)
+}
+
+func TestSplitMatch(t *testing.T) {
+	s := newTestSplit(config.Split{Match: []config.SplitMatch{
+		{Header: "X-Canary", Value: "1", Pool: "canary"},
+		{Header: "X-Canary", Value: "0", Pool: config.SplitPrimaryPool},
+		{Cookie: "release", Value: "canary", Pool: "canary"},
+	}})
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 100}))
+	assert.Equal(t, "primary", serve(s, "X-Canary", "0"))
+
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 0}))
+	assert.Equal(t, "canary", serve(s, "X-Canary", "1"))
+	assert.Equal(t, "canary", serve(s, "Cookie", "theme=dark; release=canary"))
+	assert.Equal(t, "primary", serve(s, "X-Canary", "yes"))
+}
+
+func TestSplitChoose(t *testing.T) {
+	s := newTestSplit(config.Split{})
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 100}))
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/")
+	assert.Equal(t, "canary", s.Choose(ctx))
+
+	// Serve keeps to the pool Choose picked, however the weights change.
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 0}))
+	assert.Equal(t, "canary", s.Choose(ctx))
+	s.Serve()(ctx)
+	assert.Equal(t, "canary", string(ctx.Response.Body()))
+}
+
+func TestSplitSticky(t *testing.T) {
+	s := newTestSplit(config.Split{
+		Pools:  []config.SplitPool{{Name: "canary", Weight: 30}, {Name: "beta", Weight: 20}},
+		Sticky: config.SplitKey{Header: "X-User-Id"},
+	})
+	users := map[string]string{}
+	for i := range 200 {
+		id := strconv.Itoa(i)
+		users[id] = serve(s, "X-User-Id", id)
+		for range 3 {
+			assert.Equal(t, users[id], serve(s, "X-User-Id", id))
+		}
+	}
+
+	// Raising the first pool's weight only moves users onto it.
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 40, "beta": 10}))
+	for id, was := range users {
+		if now := serve(s, "X-User-Id", id); now != was {
+			assert.Equal(t, "canary", now)
+		}
+	}
+}
+
+func TestSplitPools(t *testing.T) {
+	s := newTestSplit(config.Split{Match: []config.SplitMatch{{Header: "X-Canary", Value: "1", Pool: "canary"}}})
+	serve(s, "X-Canary", "1")
+	serve(s, "X-Canary", "1")
+	assert.Nil(t, s.SetWeights(map[string]float64{"canary": 25}))
+
+	pools := s.Pools()
+	assert.Len(t, pools, 2)
+	assert.Equal(t, config.SplitPrimaryPool, pools[0].Name)
+	assert.Equal(t, 75.0, pools[0].Weight)
+	assert.Equal(t, []types.ProxyStat{{Addr: "primary:8080"}}, pools[0].Backends)
+	assert.Equal(t, "canary", pools[1].Name)
+	assert.Equal(t, 25.0, pools[1].Weight)
+	assert.Equal(t, uint64(2), pools[1].Requests)
+	assert.Equal(t, uint64(2), pools[1].Responses["5xx"])
+	assert.Equal(t, uint64(0), pools[1].Responses["2xx"])
+}
@@ -10,9 +10,17 @@ import (
 
 	"github.com/aaydin-tr/divisor/core"
 	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/internal/access"
+	"github.com/aaydin-tr/divisor/internal/auth"
+	"github.com/aaydin-tr/divisor/internal/cache"
+	"github.com/aaydin-tr/divisor/internal/concurrency"
+	"github.com/aaydin-tr/divisor/internal/events"
+	"github.com/aaydin-tr/divisor/internal/mirror"
 	"github.com/aaydin-tr/divisor/internal/monitoring"
 	"github.com/aaydin-tr/divisor/internal/proxy"
+	"github.com/aaydin-tr/divisor/internal/ratelimit"
 	"github.com/aaydin-tr/divisor/internal/server"
+	"github.com/aaydin-tr/divisor/internal/split"
 	cfg "github.com/aaydin-tr/divisor/pkg/config"
 	"github.com/aaydin-tr/divisor/pkg/logger"
 	"github.com/aaydin-tr/divisor/pkg/middleware"
@@ -54,14 +62,100 @@ func main() {
 		zap.S().Fatal(err)
 	}
 
+	for _, hook := range config.Webhooks {
+		events.NewWebhook(hook).Start()
+	}
+
+	proxy.SetNoBackendsRetryAfter(config.Server.NoBackendsRetryAfter)
+
 	zap.S().Info("Proxies are being prepared.")
 	proxies := core.NewBalancer(config, middlewareExecutor, proxy.NewProxyClient)
 
+	// Only an empty backends list makes a balancer nil, and PrepareConfig
+	// rejects one; dead backends no longer do.
 	if proxies == nil {
-		zap.S().Fatal("No available servers")
+		zap.S().Fatal("No backends configured")
 	}
 	zap.S().Infof("All proxies are ready, divisor will use `%s` algorithm health checker func will trigger every %v", config.Type, config.HealthCheckerTime)
 
+	var balancer types.IBalancer = proxies
+	var trafficSplit *split.Split
+	if config.Split.Enabled {
+		pools := make([]types.IBalancer, 0, len(config.Split.Pools))
+		for _, pool := range config.Split.Pools {
+			pools = append(pools, core.NewBalancer(config.ForBackends(pool.Name, pool.Backends), middlewareExecutor, proxy.NewProxyClient))
+		}
+		trafficSplit = split.New(config.Split, proxies, pools)
+		balancer = trafficSplit
+	}
+
+	var trafficMirror *mirror.Mirror
+	if config.Mirror.Enabled {
+		// The shadow pool is balanced round-robin and never retried or
+		// hedged: a copy gets one attempt.
+		shadowConfig := config.ForBackends(cfg.MirrorPool, config.Mirror.Backends)
+		shadowConfig.Type = "round-robin"
+		shadowConfig.Retry, shadowConfig.Hedge = cfg.Retry{}, cfg.Hedge{}
+		trafficMirror = mirror.New(config.Mirror, balancer, core.NewBalancer(shadowConfig, nil, proxy.NewProxyClient))
+		balancer = trafficMirror
+	}
+
+	// The concurrency limit counts what reaches Backends, mirror copies
+	// aside: cache hits never take a place in it.
+	var concurrencyLimiter *concurrency.Limiter
+	if config.Concurrency.Enabled {
+		concurrencyLimiter = concurrency.New(config.Concurrency, balancer)
+		balancer = concurrencyLimiter
+	}
+
+	// The cache sits in front of the balancer: a hit never reaches it, nor
+	// the mirror. It keeps each split pool's responses apart, and leaves out
+	// whatever an auth policy covered, whose response may be the client's
+	// own.
+	var responseCache *cache.Cache
+	if config.Cache.Enabled {
+		responseCache = cache.New(config.Cache, balancer)
+		if trafficSplit != nil {
+			responseCache.Partition(trafficSplit.Choose)
+		}
+		if len(config.Auth) > 0 {
+			responseCache.Bypass(auth.Covered)
+		}
+		balancer = responseCache
+	}
+
+	// Auth goes in front of the cache, so a hit is never served to a client
+	// without credentials, and behind rate limits, which keep a client
+	// guessing passwords from spending bcrypt's cost unbounded.
+	var authPolicies *auth.Auth
+	if len(config.Auth) > 0 {
+		authPolicies, err = auth.New(config.Auth, balancer)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		balancer = authPolicies
+	}
+
+	// Rate limits come first of all: a denied request costs no cache lookup
+	// and no Backend.
+	var rateLimiter *ratelimit.RateLimiter
+	if len(config.RateLimits) > 0 {
+		rateLimiter = ratelimit.New(config.RateLimits, config.ClientIP, balancer)
+		balancer = rateLimiter
+	}
+
+	// Access control goes before even rate limits: a denied client spends
+	// no one's budget.
+	var accessControl *access.Access
+	if config.AccessControl.Enabled() {
+		accessControl, err = access.New(config.AccessControl, config.ClientIP, balancer)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		balancer = accessControl
+		go reloadAccessOnHangup(accessControl)
+	}
+
 	ln, err := reuseport.Listen("tcp4", config.GetAddr())
 	if err != nil {
 		zap.S().Fatalf("Error while starting divisor server %s", err)
@@ -70,12 +164,24 @@ func main() {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
-	srv, serveErr, err := server.Start(config, proxies, ln)
+	srv, serveErr, err := server.Start(config, balancer, ln)
 	if err != nil {
 		zap.S().Fatalf("Error while starting divisor server %s", err)
 	}
 
-	go monitoring.StartMonitoringServer(srv, proxies, config.GetMonitoringAddr())
+	go monitoring.StartMonitoringServer(monitoring.Options{
+		Server:      srv,
+		Balancer:    balancer,
+		Cache:       responseCache,
+		Mirror:      trafficMirror,
+		Split:       trafficSplit,
+		RateLimiter: rateLimiter,
+		Concurrency: concurrencyLimiter,
+		Access:      accessControl,
+		Auth:        authPolicies,
+		Addr:        config.GetMonitoringAddr(),
+		AdminToken:  config.Monitoring.AdminToken,
+	})
 
 	select {
 	case <-shutdown:
@@ -85,7 +191,7 @@ func main() {
 		zap.S().Fatalf("Divisor server stopped serving: %s", err)
 	}
 
-	if err := performGracefulShutdown(srv, proxies); err != nil {
+	if err := performGracefulShutdown(srv, balancer); err != nil {
 		zap.S().Errorf("Error during graceful shutdown: %s", err)
 		os.Exit(1)
 	}
@@ -93,6 +199,19 @@ func main() {
 	zap.S().Info("Divisor server shutdown completed successfully")
 }
 
+// reloadAccessOnHangup reads the access control files again on every SIGHUP.
+func reloadAccessOnHangup(accessControl *access.Access) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := accessControl.Reload(); err != nil {
+			zap.S().Errorf("Access control lists not reloaded, keeping the current ones: %s", err)
+			continue
+		}
+		zap.S().Info("Access control lists reloaded")
+	}
+}
+
 func performGracefulShutdown(srv server.Server, balancer types.IBalancer) error {
 	const timeout = 30 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -1,15 +1,20 @@
 package middleware
 
 import (
+	"net"
+
 	"github.com/valyala/fasthttp"
 )
 
 type Context struct {
 	*fasthttp.RequestCtx
+	// ClientIP is the client's address as client_ip resolves it, which is
+	// RemoteIP() unless a trusted proxy is in front of divisor.
+	ClientIP net.IP
 }
 
 func NewContext(ctx *fasthttp.RequestCtx) *Context {
-	return &Context{ctx}
+	return &Context{RequestCtx: ctx, ClientIP: ctx.RemoteIP()}
 }
 
 type Middleware interface {
@@ -15,6 +15,7 @@ type MockProxy struct {
 	Addr               string
 	ResTime            float64
 	Pending            int
+	Busy               bool
 	IsCalled           bool
 	CloseCalled        bool
 	middlewareExecutor *middleware.Executor
@@ -44,6 +45,10 @@ func (m *MockProxy) PendingRequests() int {
 	return 0
 }
 
+func (m *MockProxy) Saturated() bool {
+	return m.Busy
+}
+
 func (m *MockProxy) AvgResponseTime() float64 {
 	if m.Addr == "localhost:7070" {
 		return 1
@@ -61,6 +66,10 @@ func (m *MockProxy) RecentResponseTime() float64 {
 	return m.AvgResponseTime()
 }
 
+func (m *MockProxy) ResponseTimePercentile(float64) (time.Duration, bool) {
+	return 0, false
+}
+
 func (m *MockProxy) ResetRecentResponseTime() {
 	m.resTimeMu.Lock()
 	m.ResTime = 0
@@ -100,8 +109,8 @@ var TestCases = []testCaseStruct{
 				},
 			},
 			HealthCheckerTime: time.Second * 5,
-			HealthCheckerFunc: func(string) bool {
-				return true
+			HealthCheckerFunc: func(string) types.HealthState {
+				return types.Alive
 			},
 			HashFunc: func(b []byte) uint32 {
 				return uint32(len(b))
@@ -121,8 +130,8 @@ var TestCases = []testCaseStruct{
 				},
 			},
 			HealthCheckerTime: time.Second * 5,
-			HealthCheckerFunc: func(string) bool {
-				return true
+			HealthCheckerFunc: func(string) types.HealthState {
+				return types.Alive
 			},
 			HashFunc: func(b []byte) uint32 {
 				return uint32(len(b))
@@ -146,8 +155,8 @@ var TestCases = []testCaseStruct{
 				},
 			},
 			HealthCheckerTime: time.Second * 5,
-			HealthCheckerFunc: func(string) bool {
-				return false
+			HealthCheckerFunc: func(string) types.HealthState {
+				return types.Down
 			},
 			HashFunc: func(b []byte) uint32 {
 				return uint32(len(b))
@@ -162,8 +171,8 @@ var TestCases = []testCaseStruct{
 			Port:              "8000",
 			Backends:          []config.Backend{},
 			HealthCheckerTime: time.Second * 5,
-			HealthCheckerFunc: func(s string) bool {
-				return false
+			HealthCheckerFunc: func(s string) types.HealthState {
+				return types.Down
 
 			},
 			HashFunc: func(b []byte) uint32 {
@@ -188,8 +197,8 @@ var TestCases = []testCaseStruct{
 				},
 			},
 			HealthCheckerTime: time.Second * 5,
-			HealthCheckerFunc: func(string) bool {
-				return true
+			HealthCheckerFunc: func(string) types.HealthState {
+				return types.Alive
 			},
 			HashFunc: func(b []byte) uint32 {
 				return uint32(len(b))
@@ -207,3 +216,11 @@ type MockBalancer struct{}
 func (m *MockBalancer) Serve() func(ctx *fasthttp.RequestCtx) { return func(*fasthttp.RequestCtx) {} }
 func (m *MockBalancer) Stats() []types.ProxyStat              { return nil }
 func (m *MockBalancer) Shutdown() error                       { return nil }
+
+// AliveIf turns a bool liveness into the HealthState a Probe reports.
+func AliveIf(alive bool) types.HealthState {
+	if alive {
+		return types.Alive
+	}
+	return types.Down
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrAccessRange  = errors.New("access_control allow and deny entries must be IPs or CIDR ranges")
+	ErrAccessFile   = errors.New("access_control allow_files and deny_files must be readable lists of IPs and CIDR ranges, one per line")
+	ErrAccessStatus = errors.New("access_control.deny_status must be between 400 and 599")
+	ErrAccessPath   = errors.New("access_control.paths entries need a prefix starting with /")
+)
+
+const (
+	DefaultAccessDenyStatus = fasthttp.StatusForbidden
+)
+
+// IPRanges is a list of networks an address can be checked against.
+type IPRanges []netip.Prefix
+
+// Contains reports whether ip falls in one of the ranges.
+func (r IPRanges) Contains(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range r {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPRanges parses CIDR ranges and bare IPs, which become a /32 or /128.
+func parseIPRanges(entries []string) (IPRanges, error) {
+	ranges := make(IPRanges, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q", entry)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		ranges = append(ranges, prefix.Masked())
+	}
+	return ranges, nil
+}
+
+// AccessControl turns requests away by client IP. The top-level lists apply
+// to every request, and each Paths entry's lists to the requests under its
+// prefix as well. See docs/adr/0020-access-control.md.
+type AccessControl struct {
+	AccessList `yaml:",inline"`
+	DenyStatus int          `yaml:"deny_status"`
+	Paths      []AccessPath `yaml:"paths"`
+}
+
+// AccessList denies a client IP in a deny range, or outside every allow
+// range when there are any. Files hold one IP or CIDR range per line, with
+// # comments, and are read again on every Load.
+type AccessList struct {
+	Allow      []string `yaml:"allow"`
+	AllowFiles []string `yaml:"allow_files"`
+	Deny       []string `yaml:"deny"`
+	DenyFiles  []string `yaml:"deny_files"`
+}
+
+type AccessPath struct {
+	Prefix     string `yaml:"prefix"`
+	AccessList `yaml:",inline"`
+}
+
+func (a *AccessControl) Enabled() bool {
+	return !a.AccessList.empty() || len(a.Paths) > 0
+}
+
+func (l *AccessList) empty() bool {
+	return len(l.Allow) == 0 && len(l.AllowFiles) == 0 && len(l.Deny) == 0 && len(l.DenyFiles) == 0
+}
+
+// Load parses l's allow and deny ranges, reading its files afresh.
+func (l *AccessList) Load() (allow IPRanges, deny IPRanges, err error) {
+	if allow, err = loadIPRanges(l.Allow, l.AllowFiles); err != nil {
+		return nil, nil, err
+	}
+	if deny, err = loadIPRanges(l.Deny, l.DenyFiles); err != nil {
+		return nil, nil, err
+	}
+	return allow, deny, nil
+}
+
+func loadIPRanges(entries []string, files []string) (IPRanges, error) {
+	ranges, err := parseIPRanges(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAccessRange, err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAccessFile, err)
+		}
+		var lines []string
+		for line := range strings.Lines(string(data)) {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		fileRanges, err := parseIPRanges(lines)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrAccessFile, file, err)
+		}
+		ranges = append(ranges, fileRanges...)
+	}
+	return ranges, nil
+}
+
+func (a *AccessControl) prepare() error {
+	if !a.Enabled() {
+		return nil
+	}
+	if a.DenyStatus == 0 {
+		a.DenyStatus = DefaultAccessDenyStatus
+	}
+	if a.DenyStatus < 400 || a.DenyStatus > 599 {
+		return ErrAccessStatus
+	}
+	if _, _, err := a.Load(); err != nil {
+		return err
+	}
+	for _, path := range a.Paths {
+		if !strings.HasPrefix(path.Prefix, "/") {
+			return fmt.Errorf("%w: %q", ErrAccessPath, path.Prefix)
+		}
+		if _, _, err := path.Load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
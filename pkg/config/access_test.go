@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareAccessControl(t *testing.T) {
+	a := AccessControl{}
+	assert.False(t, a.Enabled())
+	assert.Nil(t, a.prepare())
+
+	file := filepath.Join(t.TempDir(), "allow.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("# offices\n10.0.0.0/8\n\n2001:db8::1 # vpn\n"), 0o600))
+	a = AccessControl{AccessList: AccessList{AllowFiles: []string{file}, Deny: []string{"10.6.6.6"}}}
+	assert.Nil(t, a.prepare())
+	assert.Equal(t, DefaultAccessDenyStatus, a.DenyStatus)
+	allow, deny, err := a.Load()
+	assert.Nil(t, err)
+	assert.Len(t, allow, 2)
+	assert.Equal(t, "10.6.6.6/32", deny[0].String())
+
+	invalid := []struct {
+		access AccessControl
+		err    error
+	}{
+		{AccessControl{AccessList: AccessList{Allow: []string{"10.0.0.0/33"}}}, ErrAccessRange},
+		{AccessControl{AccessList: AccessList{DenyFiles: []string{filepath.Join(t.TempDir(), "missing.txt")}}}, ErrAccessFile},
+		{AccessControl{AccessList: AccessList{Deny: []string{"10.0.0.1"}}, DenyStatus: 200}, ErrAccessStatus},
+		{AccessControl{Paths: []AccessPath{{Prefix: "admin", AccessList: AccessList{Allow: []string{"10.0.0.1"}}}}}, ErrAccessPath},
+		{AccessControl{Paths: []AccessPath{{Prefix: "/admin/", AccessList: AccessList{Allow: []string{"office"}}}}}, ErrAccessRange},
+	}
+	for _, tt := range invalid {
+		assert.ErrorIs(t, tt.access.prepare(), tt.err)
+	}
+}
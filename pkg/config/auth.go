@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrAuthName        = errors.New("auth names must be unique")
+	ErrAuthKind        = errors.New("auth entries need exactly one of basic, api_key or jwt")
+	ErrAuthPath        = errors.New("auth paths entries must be paths starting with /")
+	ErrAuthBasic       = errors.New("auth basic needs an htpasswd_file")
+	ErrAuthAPIKey      = errors.New("auth api_key needs keys or a keys_file, and a valid header name or a query parameter to read the key from")
+	ErrAuthJWT         = errors.New("auth jwt needs a jwks_file, and a leeway that is not negative")
+	ErrAuthClaimHeader = errors.New("auth jwt claim_headers values must be valid header names")
+)
+
+var ValidJWTAlgorithms = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+const (
+	AuthBasic           = "basic"
+	AuthAPIKey          = "api_key"
+	AuthJWT             = "jwt"
+	DefaultAuthRealm    = "divisor"
+	DefaultAPIKeyHeader = "X-Api-Key"
+)
+
+// AuthPolicy lets the requests under Paths through only with valid
+// credentials of one kind, Basic, APIKey or JWT, and answers the rest 401 or
+// 403 before a Backend is picked. See docs/adr/0021-built-in-auth.md.
+type AuthPolicy struct {
+	Name  string `yaml:"name"`
+	Realm string `yaml:"realm"`
+	// Paths (prefixes) narrow what is protected; empty matches everything.
+	Paths  []string    `yaml:"paths"`
+	Basic  *BasicAuth  `yaml:"basic"`
+	APIKey *APIKeyAuth `yaml:"api_key"`
+	JWT    *JWTAuth    `yaml:"jwt"`
+}
+
+// BasicAuth checks users against an htpasswd file of bcrypt hashes.
+type BasicAuth struct {
+	HtpasswdFile string `yaml:"htpasswd_file"`
+}
+
+// APIKeyAuth takes the key from Header, or from the Query parameter when the
+// header is absent. KeysFile holds one key per line, with # comments.
+type APIKeyAuth struct {
+	Header   string   `yaml:"header"`
+	Query    string   `yaml:"query"`
+	Keys     []string `yaml:"keys"`
+	KeysFile string   `yaml:"keys_file"`
+}
+
+// JWTAuth validates a bearer token against the keys of a local JWKS file.
+// Issuer and Audience are only checked when set, and Scopes are all required
+// of the token's scope claim. ClaimHeaders sends claims to Backends, by claim
+// name to header name.
+type JWTAuth struct {
+	JWKSFile     string            `yaml:"jwks_file"`
+	Algorithms   []string          `yaml:"algorithms"`
+	Issuer       string            `yaml:"issuer"`
+	Audience     []string          `yaml:"audience"`
+	Scopes       []string          `yaml:"scopes"`
+	Leeway       time.Duration     `yaml:"leeway"`
+	ClaimHeaders map[string]string `yaml:"claim_headers"`
+}
+
+// Kind names which of Basic, APIKey and JWT p is, or "" when it is not
+// exactly one of them.
+func (p *AuthPolicy) Kind() string {
+	kinds := make([]string, 0, 1)
+	if p.Basic != nil {
+		kinds = append(kinds, AuthBasic)
+	}
+	if p.APIKey != nil {
+		kinds = append(kinds, AuthAPIKey)
+	}
+	if p.JWT != nil {
+		kinds = append(kinds, AuthJWT)
+	}
+	if len(kinds) != 1 {
+		return ""
+	}
+	return kinds[0]
+}
+
+func (c *Config) prepareAuth() error {
+	names := make([]string, 0, len(c.Auth))
+	for i := range c.Auth {
+		p := &c.Auth[i]
+		kind := p.Kind()
+		if kind == "" {
+			return ErrAuthKind
+		}
+		if p.Name == "" {
+			p.Name = kind
+		}
+		if slices.Contains(names, p.Name) {
+			return fmt.Errorf("%w: %q", ErrAuthName, p.Name)
+		}
+		names = append(names, p.Name)
+		if p.Realm == "" {
+			p.Realm = DefaultAuthRealm
+		}
+		for _, path := range p.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("%w: %q", ErrAuthPath, path)
+			}
+		}
+
+		switch kind {
+		case AuthBasic:
+			if p.Basic.HtpasswdFile == "" {
+				return ErrAuthBasic
+			}
+		case AuthAPIKey:
+			if p.APIKey.Header == "" && p.APIKey.Query == "" {
+				p.APIKey.Header = DefaultAPIKeyHeader
+			}
+			if len(p.APIKey.Keys) == 0 && p.APIKey.KeysFile == "" {
+				return ErrAuthAPIKey
+			}
+			if p.APIKey.Header != "" && !httpguts.ValidHeaderFieldName(p.APIKey.Header) {
+				return fmt.Errorf("%w: %q", ErrAuthAPIKey, p.APIKey.Header)
+			}
+		case AuthJWT:
+			if p.JWT.JWKSFile == "" || p.JWT.Leeway < 0 {
+				return ErrAuthJWT
+			}
+			if len(p.JWT.Algorithms) == 0 {
+				p.JWT.Algorithms = slices.Clone(ValidJWTAlgorithms)
+			}
+			for _, alg := range p.JWT.Algorithms {
+				if !helper.Contains(ValidJWTAlgorithms, alg) {
+					return fmt.Errorf("Please choose valid auth jwt algorithms, e.g %v", ValidJWTAlgorithms)
+				}
+			}
+			for _, header := range p.JWT.ClaimHeaders {
+				if !httpguts.ValidHeaderFieldName(header) {
+					return fmt.Errorf("%w: %q", ErrAuthClaimHeader, header)
+				}
+			}
+		}
+	}
+	return nil
+}
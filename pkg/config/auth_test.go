@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareAuth(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Auth: []AuthPolicy{
+		{Basic: &BasicAuth{HtpasswdFile: "/etc/divisor/htpasswd"}},
+		{Name: "partners", Paths: []string{"/partners/"}, APIKey: &APIKeyAuth{Keys: []string{"key"}}},
+		{Realm: "api", JWT: &JWTAuth{JWKSFile: "/etc/divisor/jwks.json"}},
+	}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, AuthBasic, config.Auth[0].Name)
+	assert.Equal(t, DefaultAuthRealm, config.Auth[0].Realm)
+	assert.Equal(t, DefaultAPIKeyHeader, config.Auth[1].APIKey.Header)
+	assert.Equal(t, "api", config.Auth[2].Realm)
+	assert.Equal(t, ValidJWTAlgorithms, config.Auth[2].JWT.Algorithms)
+
+	jwks := "/etc/divisor/jwks.json"
+	invalid := []struct {
+		policies []AuthPolicy
+		err      error
+	}{
+		{[]AuthPolicy{{Name: "none"}}, ErrAuthKind},
+		{[]AuthPolicy{{Basic: &BasicAuth{HtpasswdFile: "htpasswd"}, JWT: &JWTAuth{JWKSFile: jwks}}}, ErrAuthKind},
+		{[]AuthPolicy{{JWT: &JWTAuth{JWKSFile: jwks}}, {JWT: &JWTAuth{JWKSFile: jwks}}}, ErrAuthName},
+		{[]AuthPolicy{{Paths: []string{"admin"}, Basic: &BasicAuth{HtpasswdFile: "htpasswd"}}}, ErrAuthPath},
+		{[]AuthPolicy{{Basic: &BasicAuth{}}}, ErrAuthBasic},
+		{[]AuthPolicy{{APIKey: &APIKeyAuth{Query: "key"}}}, ErrAuthAPIKey},
+		{[]AuthPolicy{{APIKey: &APIKeyAuth{Header: "X Api Key", Keys: []string{"key"}}}}, ErrAuthAPIKey},
+		{[]AuthPolicy{{JWT: &JWTAuth{}}}, ErrAuthJWT},
+		{[]AuthPolicy{{JWT: &JWTAuth{JWKSFile: jwks, Leeway: -time.Second}}}, ErrAuthJWT},
+		{[]AuthPolicy{{JWT: &JWTAuth{JWKSFile: jwks, ClaimHeaders: map[string]string{"sub": "X User"}}}}, ErrAuthClaimHeader},
+	}
+	for _, tt := range invalid {
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Auth: tt.policies}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Auth: []AuthPolicy{
+		{JWT: &JWTAuth{JWKSFile: jwks, Algorithms: []string{"none"}}},
+	}}
+	assert.NotNil(t, config.PrepareConfig())
+}
@@ -0,0 +1,423 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/aaydin-tr/divisor/pkg/http"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrBackendUrlEmpty       = errors.New("Backend url must not be empty")
+	ErrBackendUrlInvalid     = errors.New("Backend url is not valid")
+	ErrBackendUrlPlainTLS    = errors.New("Backend url must not use http:// when tls.enabled is set")
+	ErrBackendUrlScheme      = errors.New("Backend url has an unsupported scheme")
+	ErrBackendUrlUserinfo    = errors.New("Backend url must not contain userinfo")
+	ErrBackendUrlNotHostPort = errors.New("Backend url must be host:port only; forward under a path with rewrite.add_prefix")
+	ErrBackendUrlNoHost      = errors.New("Backend url has no host")
+	ErrHealthCheckService    = errors.New("health_check.service is only valid for grpc health checks")
+	ErrDegradedStatus        = errors.New("health_check.degraded_status is only valid for http health checks")
+	ErrDegradedWeight        = errors.New("health_check.degraded_weight must be between 1 and 100")
+	ErrBackendTLSDisabled    = errors.New("Backend tls options require tls.enabled or an https:// url")
+	ErrBackendTLSKeyPair     = errors.New("Backend tls.cert_file and tls.key_file must be set together")
+	ErrBackendTLSCAFile      = errors.New("Backend tls.ca_file holds no PEM certificates")
+	ErrBackendH2CWithTLS     = errors.New("Backend protocol h2c is cleartext HTTP/2 and cannot be combined with tls")
+	ErrBackendH2CProxyProto  = errors.New("Backend proxy_protocol cannot be combined with protocol h2c, whose connections carry many clients' requests")
+	ErrHostHeader            = errors.New("Backend host_header must be preserve, backend or a valid host")
+)
+
+var ValidHealthCheckTypes = []string{HealthCheckHTTP, HealthCheckTCP, HealthCheckGRPC}
+
+var ValidBackendProtocols = []string{BackendProtocolHTTP1, BackendProtocolH2C}
+
+var ValidBackendProxyProtocols = []string{ProxyProtocolV1, ProxyProtocolV2}
+
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckGRPC = "grpc"
+
+	BackendProtocolHTTP1 = "http1"
+	BackendProtocolH2C   = "h2c"
+
+	HostHeaderPreserve = "preserve"
+	HostHeaderBackend  = "backend"
+
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+
+	DefaultDegradedWeight = 50
+)
+
+// HealthCheck selects how the Backend is probed: an HTTP GET of
+// health_check_path expecting 200, a bare TCP connect, or
+// grpc.health.v1.Health/Check over h2c expecting SERVING.
+type HealthCheck struct {
+	Type string `yaml:"type"`
+	// Service is the grpc health service name; empty asks about the server as a whole.
+	Service string `yaml:"service"`
+	// DegradedStatus lists http Probe statuses that mark the Backend
+	// Degraded instead of Down, e.g. 429 from a Backend shedding load.
+	DegradedStatus []int `yaml:"degraded_status"`
+	// DegradedWeight is the percentage of its normal share a Degraded
+	// Backend keeps.
+	DegradedWeight int `yaml:"degraded_weight"`
+}
+
+// BackendTLS makes divisor speak TLS to a Backend; see
+// docs/adr/0006-tls-to-backends.md.
+type BackendTLS struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the Backend against these roots instead of the
+	// system pool.
+	CAFile string `yaml:"ca_file"`
+	// ServerName is sent as SNI and verified against the certificate;
+	// empty means the url's host.
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// CertFile and KeyFile are the client certificate for mTLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Built by PrepareConfig from the fields above when Enabled.
+	Config *tls.Config `yaml:"-"`
+}
+
+type Backend struct {
+	Url                       string        `yaml:"url"`
+	HealthCheckPath           string        `yaml:"health_check_path"`
+	HealthCheck               HealthCheck   `yaml:"health_check"`
+	TLS                       BackendTLS    `yaml:"tls"`
+	Weight                    uint          `yaml:"weight,omitempty"`
+	MaxConnection             int           `yaml:"max_conn"`
+	MaxConnWaitTimeout        time.Duration `yaml:"max_conn_timeout"`
+	MaxConnDuration           time.Duration `yaml:"max_conn_duration"`
+	MaxIdleConnDuration       time.Duration `yaml:"max_idle_conn_duration"`
+	MaxIdemponentCallAttempts int           `yaml:"max_idemponent_call_attempts"`
+	// Protocol is what divisor speaks to the Backend: http1 (HTTP/1.1) or
+	// h2c, HTTP/2 without TLS, which gRPC needs for its trailers.
+	Protocol string `yaml:"protocol"`
+	// ProxyProtocol opens every connection to the Backend with a PROXY
+	// header of this version, v1 or v2, naming the client; empty sends none.
+	ProxyProtocol string `yaml:"proxy_protocol"`
+	// HostHeader is the Host the Backend is sent: preserve keeps the
+	// client's, backend (the default) is the Backend's own address, and
+	// anything else is sent as is, in Probes too.
+	HostHeader string `yaml:"host_header"`
+	// Copied from the global server.proxy_timeout by PrepareConfig.
+	ProxyTimeout time.Duration `yaml:"-"`
+	// Copied from the global server.websocket_idle_timeout by PrepareConfig.
+	WebSocketIdleTimeout time.Duration `yaml:"-"`
+	// Copied from the global server.max_request_body_size by PrepareConfig.
+	MaxRequestBodySize int `yaml:"-"`
+	// Copied from the global server.stream_bodies by PrepareConfig.
+	StreamBodies bool `yaml:"-"`
+	// Copied from the global forwarded_headers by PrepareConfig.
+	ForwardedHeaders ForwardedHeaders `yaml:"-"`
+	// Copied from the global client_ip by PrepareConfig.
+	ClientIP ClientIP `yaml:"-"`
+	// Copied from the global compression by PrepareConfig.
+	Compression Compression `yaml:"-"`
+	// RequestHeaders and ResponseHeaders apply after the global ones of the
+	// same name, which PrepareConfig merges in: a header named here is only
+	// edited by these.
+	RequestHeaders  HeaderRules `yaml:"request_headers"`
+	ResponseHeaders HeaderRules `yaml:"response_headers"`
+	Rewrite         Rewrite     `yaml:"rewrite"`
+}
+
+// GetHealthCheckURL returns the Probe target; the scheme tells
+// HttpClient.IsHostAlive which probe type to run.
+func (b *Backend) GetHealthCheckURL() string {
+	switch b.HealthCheck.Type {
+	case HealthCheckTCP:
+		return http.ProbeSchemeTCP + b.Url
+	case HealthCheckGRPC:
+		return http.ProbeSchemeGRPC + b.Url + "/" + b.HealthCheck.Service
+	}
+	if b.TLS.Enabled {
+		return "https://" + b.Url + b.HealthCheckPath
+	}
+	return "http://" + b.Url + b.HealthCheckPath
+}
+
+// ProbeHost returns the Host http and grpc Probes send when it is not the
+// Backend's address, empty otherwise. A Probe has no client Host to preserve.
+func (b *Backend) ProbeHost() string {
+	if b.HostHeader == HostHeaderPreserve || b.HostHeader == HostHeaderBackend {
+		return ""
+	}
+	return b.HostHeader
+}
+
+// ProxyProtocolVersion returns the PROXY header version the Backend takes,
+// 0 for none.
+func (b *Backend) ProxyProtocolVersion() int {
+	switch b.ProxyProtocol {
+	case ProxyProtocolV1:
+		return 1
+	case ProxyProtocolV2:
+		return 2
+	}
+	return 0
+}
+
+func (c *Config) prepareBackends() error {
+	weighted := c.Type == "w-round-robin"
+	for i := 0; i < len(c.Backends); i++ {
+		if err := c.prepareBackend(&c.Backends[i], weighted); err != nil {
+			return err
+		}
+	}
+	for i := range c.Split.Pools {
+		for j := range c.Split.Pools[i].Backends {
+			if err := c.prepareBackend(&c.Split.Pools[i].Backends[j], weighted); err != nil {
+				return err
+			}
+		}
+	}
+	// Mirror Backends are always balanced round-robin, so they need no
+	// weight.
+	for i := range c.Mirror.Backends {
+		if err := c.prepareBackend(&c.Mirror.Backends[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allBackends lists every Backend divisor probes: backends, the split's
+// pools and the mirror's.
+func (c *Config) allBackends() []Backend {
+	backends := slices.Concat(c.Backends, c.Mirror.Backends)
+	for _, pool := range c.Split.Pools {
+		backends = append(backends, pool.Backends...)
+	}
+	return backends
+}
+
+// prepareBackend validates b and fills in its defaults and the global
+// settings it carries; weighted Backends need a weight.
+func (c *Config) prepareBackend(b *Backend, weighted bool) error {
+	if weighted && b.Weight <= 0 {
+		return ErrInvalidWeight
+	}
+
+	addr, https, err := normalizeBackendAddress(b.Url, b.TLS.Enabled)
+	if err != nil {
+		return err
+	}
+	b.Url = addr
+	b.TLS.Enabled = https
+
+	if err := b.TLS.prepare(addr); err != nil {
+		return err
+	}
+
+	if b.Protocol == "" {
+		b.Protocol = BackendProtocolHTTP1
+	}
+
+	if !helper.Contains(ValidBackendProtocols, b.Protocol) {
+		return fmt.Errorf("Please choose valid backend protocol, e.g %v", ValidBackendProtocols)
+	}
+
+	if b.Protocol == BackendProtocolH2C && b.TLS.Enabled {
+		return ErrBackendH2CWithTLS
+	}
+
+	if b.ProxyProtocol != "" && !helper.Contains(ValidBackendProxyProtocols, b.ProxyProtocol) {
+		return fmt.Errorf("Please choose valid backend proxy_protocol, e.g %v", ValidBackendProxyProtocols)
+	}
+
+	if b.ProxyProtocol != "" && b.Protocol == BackendProtocolH2C {
+		return ErrBackendH2CProxyProto
+	}
+
+	if b.HostHeader == "" {
+		b.HostHeader = HostHeaderBackend
+	}
+
+	if !httpguts.ValidHostHeader(b.HostHeader) || strings.ContainsAny(b.HostHeader, "/?#@") {
+		return fmt.Errorf("%w: %q", ErrHostHeader, b.HostHeader)
+	}
+
+	if b.HealthCheckPath == "" {
+		b.HealthCheckPath = "/"
+	}
+
+	if b.HealthCheck.Type == "" {
+		b.HealthCheck.Type = HealthCheckHTTP
+	}
+
+	if !helper.Contains(ValidHealthCheckTypes, b.HealthCheck.Type) {
+		return fmt.Errorf("Please choose valid health check type, e.g %v", ValidHealthCheckTypes)
+	}
+
+	if b.HealthCheck.Service != "" && b.HealthCheck.Type != HealthCheckGRPC {
+		return ErrHealthCheckService
+	}
+
+	if len(b.HealthCheck.DegradedStatus) > 0 && b.HealthCheck.Type != HealthCheckHTTP {
+		return ErrDegradedStatus
+	}
+
+	if b.HealthCheck.DegradedWeight == 0 {
+		b.HealthCheck.DegradedWeight = DefaultDegradedWeight
+	}
+
+	if b.HealthCheck.DegradedWeight < 0 || b.HealthCheck.DegradedWeight > 100 {
+		return ErrDegradedWeight
+	}
+
+	if b.MaxConnection <= 0 {
+		b.MaxConnection = DefaultMaxConnection
+	}
+
+	if b.MaxConnWaitTimeout <= 0 {
+		b.MaxConnWaitTimeout = DefaultMaxConnWaitTimeout
+	}
+
+	if b.MaxConnDuration <= 0 {
+		b.MaxConnDuration = DefaultMaxConnDuration
+	}
+
+	if b.MaxIdleConnDuration <= 0 {
+		b.MaxIdleConnDuration = DefaultMaxIdleConnDuration
+	}
+
+	if b.MaxIdemponentCallAttempts <= 0 {
+		b.MaxIdemponentCallAttempts = DefaultMaxIdemponentCallAttempts
+	}
+
+	b.WebSocketIdleTimeout = c.Server.WebSocketIdleTimeout
+	b.MaxRequestBodySize = c.Server.MaxRequestBodySize
+	b.StreamBodies = c.Server.StreamBodies
+	b.ForwardedHeaders = c.ForwardedHeaders
+	b.ClientIP = c.ClientIP
+	b.Compression = c.Compression
+	b.RequestHeaders = c.RequestHeaders.merge(b.RequestHeaders)
+	if err := b.RequestHeaders.prepare(); err != nil {
+		return err
+	}
+	b.ResponseHeaders = c.ResponseHeaders.merge(b.ResponseHeaders)
+	if err := b.ResponseHeaders.prepare(); err != nil {
+		return err
+	}
+	if err := b.Rewrite.prepare(); err != nil {
+		return err
+	}
+	b.ProxyTimeout = c.Server.ProxyTimeout
+	if c.Retry.Enabled() && c.Retry.PerTryTimeout > 0 {
+		b.ProxyTimeout = c.Retry.PerTryTimeout
+	}
+
+	return nil
+}
+
+// normalizeBackendAddress derives the dialable Backend address (host:port)
+// from the config url and reports whether the Backend speaks TLS: an
+// https:// url does, a bare host:port does when useTLS (tls.enabled) is set.
+// A bare trailing slash is stripped and a missing port defaults to 443 or
+// 80. Anything divisor cannot honor (path, query, userinfo) is rejected, as
+// is an http:// url contradicting tls.enabled.
+func normalizeBackendAddress(raw string, useTLS bool) (string, bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return "", false, ErrBackendUrlEmpty
+	}
+
+	withScheme := raw
+	if !strings.Contains(raw, "://") {
+		withScheme = "http://" + raw
+		if useTLS {
+			withScheme = "https://" + raw
+		}
+	}
+
+	u, err := url.Parse(withScheme)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %q: %v", ErrBackendUrlInvalid, raw, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false, fmt.Errorf("%w: %q", ErrBackendUrlScheme, raw)
+	}
+	if u.Scheme == "http" && useTLS {
+		return "", false, fmt.Errorf("%w: %q", ErrBackendUrlPlainTLS, raw)
+	}
+	if u.User != nil {
+		return "", false, fmt.Errorf("%w: %q", ErrBackendUrlUserinfo, raw)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", false, fmt.Errorf("%w: %q", ErrBackendUrlNotHostPort, raw)
+	}
+	if u.Hostname() == "" {
+		return "", false, fmt.Errorf("%w: %q", ErrBackendUrlNoHost, raw)
+	}
+
+	https := u.Scheme == "https"
+	if u.Port() != "" {
+		return u.Host, https, nil
+	}
+	if https {
+		return net.JoinHostPort(u.Hostname(), "443"), https, nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), https, nil
+}
+
+// prepare validates the tls section of the Backend at addr and builds the
+// tls.Config both proxying and Probes use. Files are loaded now so a bad
+// path fails startup instead of every handshake.
+func (t *BackendTLS) prepare(addr string) error {
+	if !t.Enabled {
+		if t.CAFile != "" || t.ServerName != "" || t.InsecureSkipVerify || t.CertFile != "" || t.KeyFile != "" {
+			return ErrBackendTLSDisabled
+		}
+		return nil
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return ErrBackendTLSKeyPair
+	}
+
+	serverName := t.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: t.InsecureSkipVerify, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: %s", ErrBackendTLSCAFile, t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTLSKeyPair, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	t.Config = cfg
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"errors"
+)
+
+var (
+	ErrCacheSize = errors.New("cache.max_size and cache.max_object_size must not be negative")
+)
+
+const (
+	DefaultCacheMaxSize       = 64 << 20
+	DefaultCacheMaxObjectSize = 1 << 20
+)
+
+// Cache keeps responses a Backend marks cacheable in memory, in front of the
+// balancer; see internal/cache.
+type Cache struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxSize bounds the bytes held across all entries; the least recently
+	// used go first.
+	MaxSize int `yaml:"max_size"`
+	// MaxObjectSize is the largest single response, in bytes, worth keeping.
+	MaxObjectSize int `yaml:"max_object_size"`
+}
+
+func (c *Cache) prepare() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxSize < 0 || c.MaxObjectSize < 0 {
+		return ErrCacheSize
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = DefaultCacheMaxSize
+	}
+	if c.MaxObjectSize == 0 {
+		c.MaxObjectSize = DefaultCacheMaxObjectSize
+	}
+	c.MaxObjectSize = min(c.MaxObjectSize, c.MaxSize)
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareCache(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Cache: Cache{Enabled: true}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, DefaultCacheMaxSize, config.Cache.MaxSize)
+	assert.Equal(t, DefaultCacheMaxObjectSize, config.Cache.MaxObjectSize)
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Cache: Cache{Enabled: true, MaxSize: 4096}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, 4096, config.Cache.MaxObjectSize)
+
+	for _, cache := range []Cache{{Enabled: true, MaxSize: -1}, {Enabled: true, MaxObjectSize: -1}} {
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Cache: cache}
+		assert.ErrorIs(t, config.PrepareConfig(), ErrCacheSize)
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+)
+
+var (
+	ErrCompressionLevel       = errors.New("compression.levels must be 1-9 for gzip, 0-11 for br and 1-4 for zstd")
+	ErrCompressionContentType = errors.New("compression.content_types entries must be media types such as application/json or text/*")
+	ErrCompressionMinSize     = errors.New("compression.min_size must not be negative")
+	ErrCompressionMaxSize     = errors.New("compression.max_size must not be below min_size")
+)
+
+var ValidCompressionAlgorithms = []string{CompressionZstd, CompressionBrotli, CompressionGzip}
+
+const (
+	CompressionGzip   = "gzip"
+	CompressionBrotli = "br"
+	CompressionZstd   = "zstd"
+
+	DefaultCompressionMinSize = 1024
+	DefaultCompressionMaxSize = 4 << 20
+)
+
+// Compression compresses buffered responses whose client accepts one of
+// Algorithms, tried in the client's order of preference and then in this
+// list's. Responses a Backend already encoded are left alone.
+type Compression struct {
+	Enabled    bool     `yaml:"enabled"`
+	Algorithms []string `yaml:"algorithms"`
+	// ContentTypes are media types, matched without parameters; * stands
+	// for any run of characters other than /, as in text/* or
+	// application/*+json.
+	ContentTypes []string `yaml:"content_types"`
+	// MinSize is the smallest body, in bytes, worth compressing.
+	MinSize int `yaml:"min_size"`
+	// MaxSize is the largest body, in bytes, compressed: compression runs
+	// on the request path, and its time grows with the body.
+	MaxSize int            `yaml:"max_size"`
+	Levels  map[string]int `yaml:"levels"`
+}
+
+var DefaultCompressionContentTypes = []string{
+	"text/*", "application/json", "application/*+json", "application/javascript",
+	"application/xml", "application/*+xml", "image/svg+xml",
+}
+
+// Default and allowed compression levels, by algorithm. zstd's are fasthttp's
+// four speeds, fastest first.
+var compressionLevels = map[string]struct{ def, min, max int }{
+	CompressionGzip:   {6, 1, 9},
+	CompressionBrotli: {4, 0, 11},
+	CompressionZstd:   {2, 1, 4},
+}
+
+func (c *Compression) prepare() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = slices.Clone(ValidCompressionAlgorithms)
+	}
+	for _, algorithm := range c.Algorithms {
+		if !helper.Contains(ValidCompressionAlgorithms, algorithm) {
+			return fmt.Errorf("Please choose valid compression algorithm, e.g %v", ValidCompressionAlgorithms)
+		}
+	}
+
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = slices.Clone(DefaultCompressionContentTypes)
+	}
+	for i, contentType := range c.ContentTypes {
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		if _, err := path.Match(contentType, ""); err != nil || strings.Count(contentType, "/") != 1 {
+			return fmt.Errorf("%w: %q", ErrCompressionContentType, c.ContentTypes[i])
+		}
+		c.ContentTypes[i] = contentType
+	}
+
+	if c.MinSize < 0 {
+		return ErrCompressionMinSize
+	}
+	if c.MinSize == 0 {
+		c.MinSize = DefaultCompressionMinSize
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = max(DefaultCompressionMaxSize, c.MinSize)
+	}
+	if c.MaxSize < c.MinSize {
+		return fmt.Errorf("%w: %d", ErrCompressionMaxSize, c.MaxSize)
+	}
+
+	levels := make(map[string]int, len(compressionLevels))
+	for algorithm, level := range compressionLevels {
+		levels[algorithm] = level.def
+	}
+	for algorithm, level := range c.Levels {
+		bounds, ok := compressionLevels[algorithm]
+		if !ok {
+			return fmt.Errorf("Please choose valid compression algorithm, e.g %v", ValidCompressionAlgorithms)
+		}
+		if level < bounds.min || level > bounds.max {
+			return fmt.Errorf("%w: %s %d", ErrCompressionLevel, algorithm, level)
+		}
+		levels[algorithm] = level
+	}
+	c.Levels = levels
+	return nil
+}
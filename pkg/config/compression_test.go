@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareCompression(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}},
+		Compression: Compression{Enabled: true, ContentTypes: []string{" Application/JSON "}, Levels: map[string]int{"br": 11}}}
+	assert.Nil(t, config.PrepareConfig())
+	c := config.Backends[0].Compression
+	assert.True(t, c.Enabled)
+	assert.Equal(t, ValidCompressionAlgorithms, c.Algorithms)
+	assert.Equal(t, []string{"application/json"}, c.ContentTypes)
+	assert.Equal(t, DefaultCompressionMinSize, c.MinSize)
+	assert.Equal(t, DefaultCompressionMaxSize, c.MaxSize)
+	assert.Equal(t, map[string]int{"gzip": 6, "br": 11, "zstd": 2}, c.Levels)
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Compression: Compression{Enabled: true}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, DefaultCompressionContentTypes, config.Compression.ContentTypes)
+
+	invalid := []struct {
+		compression Compression
+		err         error
+	}{
+		{Compression{Levels: map[string]int{"gzip": 10}}, ErrCompressionLevel},
+		{Compression{Levels: map[string]int{"zstd": 0}}, ErrCompressionLevel},
+		{Compression{ContentTypes: []string{"json"}}, ErrCompressionContentType},
+		{Compression{ContentTypes: []string{"text/[html"}}, ErrCompressionContentType},
+		{Compression{MinSize: -1}, ErrCompressionMinSize},
+		{Compression{MaxSize: 512}, ErrCompressionMaxSize},
+		{Compression{MinSize: 4096, MaxSize: 2048}, ErrCompressionMaxSize},
+	}
+	for _, tt := range invalid {
+		tt.compression.Enabled = true
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Compression: tt.compression}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	for _, compression := range []Compression{
+		{Enabled: true, Algorithms: []string{"deflate"}},
+		{Enabled: true, Levels: map[string]int{"deflate": 1}},
+	} {
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Compression: compression}
+		assert.ErrorContains(t, config.PrepareConfig(), "compression algorithm")
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrConcurrencyLimit    = errors.New("concurrency limits must be above 0, with min_limit <= initial_limit <= max_limit")
+	ErrConcurrencyLatency  = errors.New("concurrency.latency_threshold must not be negative")
+	ErrConcurrencyCPU      = errors.New("concurrency.cpu_percent must be between 0 and 100")
+	ErrConcurrencyPriority = errors.New("concurrency.priority needs a valid header name to read high and low values from")
+)
+
+var ValidConcurrencyAlgorithms = []string{ConcurrencyGradient, ConcurrencyAIMD}
+
+const (
+	ConcurrencyGradient                = "gradient"
+	ConcurrencyAIMD                    = "aimd"
+	DefaultConcurrencyInitialLimit     = 20
+	DefaultConcurrencyMinLimit         = 4
+	DefaultConcurrencyMaxLimit         = 1000
+	DefaultConcurrencyLatencyThreshold = time.Second
+)
+
+// Concurrency bounds the requests in flight to Backends by a limit it adapts
+// to their latency, and answers the excess 503 at once instead of queueing it.
+type Concurrency struct {
+	Enabled   bool   `yaml:"enabled"`
+	Algorithm string `yaml:"algorithm"`
+	// InitialLimit is the limit before any response was measured.
+	InitialLimit int `yaml:"initial_limit"`
+	MinLimit     int `yaml:"min_limit"`
+	MaxLimit     int `yaml:"max_limit"`
+	// LatencyThreshold is how slow a response may be before aimd takes it,
+	// as it takes a 502, 503 or 504, as a sign of overload.
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	// CPUPercent sheds all but high priority requests while the host's CPU
+	// usage is above it; zero turns it off.
+	CPUPercent float64             `yaml:"cpu_percent"`
+	Priority   ConcurrencyPriority `yaml:"priority"`
+}
+
+// ConcurrencyPriority classes requests by a header's value: High ones are
+// shed last and Low ones first.
+type ConcurrencyPriority struct {
+	Header string   `yaml:"header"`
+	High   []string `yaml:"high"`
+	Low    []string `yaml:"low"`
+}
+
+func (c *Concurrency) prepare() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = ConcurrencyGradient
+	}
+	if !helper.Contains(ValidConcurrencyAlgorithms, c.Algorithm) {
+		return fmt.Errorf("Please choose valid concurrency algorithm, e.g %v", ValidConcurrencyAlgorithms)
+	}
+	if c.InitialLimit == 0 {
+		c.InitialLimit = DefaultConcurrencyInitialLimit
+	}
+	if c.MinLimit == 0 {
+		c.MinLimit = min(DefaultConcurrencyMinLimit, c.InitialLimit)
+	}
+	if c.MaxLimit == 0 {
+		c.MaxLimit = max(DefaultConcurrencyMaxLimit, c.InitialLimit)
+	}
+	if c.MinLimit < 1 || c.MinLimit > c.InitialLimit || c.InitialLimit > c.MaxLimit {
+		return ErrConcurrencyLimit
+	}
+	if c.LatencyThreshold == 0 {
+		c.LatencyThreshold = DefaultConcurrencyLatencyThreshold
+	}
+	if c.LatencyThreshold < 0 {
+		return ErrConcurrencyLatency
+	}
+	if c.CPUPercent < 0 || c.CPUPercent > 100 {
+		return ErrConcurrencyCPU
+	}
+	if (len(c.Priority.High) > 0 || len(c.Priority.Low) > 0) && !httpguts.ValidHeaderFieldName(c.Priority.Header) {
+		return fmt.Errorf("%w: %q", ErrConcurrencyPriority, c.Priority.Header)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareConcurrency(t *testing.T) {
+	c := Concurrency{Enabled: true}
+	assert.Nil(t, c.prepare())
+	assert.Equal(t, Concurrency{
+		Enabled:          true,
+		Algorithm:        ConcurrencyGradient,
+		InitialLimit:     DefaultConcurrencyInitialLimit,
+		MinLimit:         DefaultConcurrencyMinLimit,
+		MaxLimit:         DefaultConcurrencyMaxLimit,
+		LatencyThreshold: DefaultConcurrencyLatencyThreshold,
+	}, c)
+
+	c = Concurrency{Enabled: true, InitialLimit: 2}
+	assert.Nil(t, c.prepare())
+	assert.Equal(t, 2, c.MinLimit)
+
+	invalid := []struct {
+		concurrency Concurrency
+		err         error
+	}{
+		{Concurrency{MinLimit: 30}, ErrConcurrencyLimit},
+		{Concurrency{InitialLimit: 50, MaxLimit: 40}, ErrConcurrencyLimit},
+		{Concurrency{InitialLimit: -1}, ErrConcurrencyLimit},
+		{Concurrency{LatencyThreshold: -time.Second}, ErrConcurrencyLatency},
+		{Concurrency{CPUPercent: 120}, ErrConcurrencyCPU},
+		{Concurrency{Priority: ConcurrencyPriority{High: []string{"health"}}}, ErrConcurrencyPriority},
+	}
+	for _, tt := range invalid {
+		tt.concurrency.Enabled = true
+		assert.ErrorIs(t, tt.concurrency.prepare(), tt.err)
+	}
+
+	c = Concurrency{Enabled: true, Algorithm: "vegas"}
+	assert.NotNil(t, c.prepare())
+}
@@ -4,10 +4,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"net"
-	"net/url"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/aaydin-tr/divisor/core/types"
@@ -18,23 +15,20 @@ import (
 )
 
 var (
-	ErrAtLeastOneBackend     = errors.New("At least one backend must be set")
-	ErrInvalidPort           = errors.New("Please choose valid port")
-	ErrInvalidWeight         = errors.New("When using the weighted-round-robin algorithm, a weight must be specified for each backend")
-	ErrHttp2WithoutTls       = errors.New("The HTTP/2 connection can be only established if the server is using TLS. Please provide cert and key file")
-	ErrInvalidTLSKeyPair     = errors.New("cert_file/key_file could not be loaded as a TLS key pair")
-	ErrBackendUrlEmpty       = errors.New("Backend url must not be empty")
-	ErrBackendUrlInvalid     = errors.New("Backend url is not valid")
-	ErrBackendUrlHttps       = errors.New("Backend url must not use https, divisor terminates TLS and always speaks plain HTTP to backends")
-	ErrBackendUrlScheme      = errors.New("Backend url has an unsupported scheme")
-	ErrBackendUrlUserinfo    = errors.New("Backend url must not contain userinfo")
-	ErrBackendUrlNotHostPort = errors.New("Backend url must be host:port only, divisor cannot forward to a path")
-	ErrBackendUrlNoHost      = errors.New("Backend url has no host")
+	ErrAtLeastOneBackend    = errors.New("At least one backend must be set")
+	ErrInvalidPort          = errors.New("Please choose valid port")
+	ErrInvalidWeight        = errors.New("When using the weighted-round-robin algorithm, a weight must be specified for each backend")
+	ErrHttp2WithoutTls      = errors.New("The HTTP/2 connection can be only established if the server is using TLS. Please provide cert and key file")
+	ErrInvalidTLSKeyPair    = errors.New("cert_file/key_file could not be loaded as a TLS key pair")
+	ErrNoBackendsRetryAfter = errors.New("server.no_backends_retry_after must not be negative")
 )
 
 var ValidTypes = []string{"round-robin", "w-round-robin", "ip-hash", "random", "least-connection", "least-response-time"}
+
 var ValidCustomHeaders = []string{"$remote_addr", "$time", "$uuid", "$incremental"}
 
+var ValidProxyProtocolModes = []string{ProxyProtocolAccept, ProxyProtocolRequire}
+
 const (
 	DefaultMaxConnection             = 512
 	DefaultMaxConnWaitTimeout        = time.Second * 30
@@ -44,11 +38,16 @@ const (
 
 	DefaultHealthCheckerTime = time.Second * 30
 
+	ProxyProtocolAccept  = "accept"
+	ProxyProtocolRequire = "require"
+
 	// No "unlimited" setting exists; see docs/adr/0003-bounded-proxy-timeout.md.
 	DefaultProxyTimeout = time.Second * 60
 
 	DefaultMaxRequestBodySize = fasthttp.DefaultMaxRequestBodySize
 
+	DefaultWebSocketIdleTimeout = 5 * time.Minute
+
 	Http1 = "http1.1"
 	Http2 = "http2"
 
@@ -63,26 +62,12 @@ type Middleware struct {
 	Config   map[string]any `yaml:"config,omitempty"`
 }
 
-type Backend struct {
-	Url                       string        `yaml:"url"`
-	HealthCheckPath           string        `yaml:"health_check_path"`
-	Weight                    uint          `yaml:"weight,omitempty"`
-	MaxConnection             int           `yaml:"max_conn"`
-	MaxConnWaitTimeout        time.Duration `yaml:"max_conn_timeout"`
-	MaxConnDuration           time.Duration `yaml:"max_conn_duration"`
-	MaxIdleConnDuration       time.Duration `yaml:"max_idle_conn_duration"`
-	MaxIdemponentCallAttempts int           `yaml:"max_idemponent_call_attempts"`
-	// Copied from the global server.proxy_timeout by PrepareConfig.
-	ProxyTimeout time.Duration `yaml:"-"`
-}
-
-func (b *Backend) GetHealthCheckURL() string {
-	return "http://" + b.Url + b.HealthCheckPath
-}
-
 type Monitoring struct {
 	Host string `yaml:"host"`
 	Port string `yaml:"port"`
+	// AdminToken is the bearer token the endpoints that change divisor's
+	// state require; they are refused while it is empty.
+	AdminToken string `yaml:"admin_token"`
 }
 
 type Server struct {
@@ -97,21 +82,68 @@ type Server struct {
 	IdleTimeout           time.Duration `yaml:"idle_timeout"`
 	ProxyTimeout          time.Duration `yaml:"proxy_timeout"`
 	MaxRequestBodySize    int           `yaml:"max_request_body_size"`
-	DisableKeepalive      bool          `yaml:"disable_keepalive"`
+	// NoBackendsRetryAfter is the Retry-After sent with the 503 served while
+	// no Backend is Alive; zero omits the header.
+	NoBackendsRetryAfter time.Duration `yaml:"no_backends_retry_after"`
+	// WebSocketIdleTimeout closes a WebSocket tunnel that carried no data in
+	// either direction for this long.
+	WebSocketIdleTimeout time.Duration `yaml:"websocket_idle_timeout"`
+	DisableKeepalive     bool          `yaml:"disable_keepalive"`
+	// StreamBodies forwards request and response bodies as they arrive
+	// instead of buffering them whole; max_request_body_size is then
+	// enforced as the body streams, and proxy_timeout bounds how long a
+	// Backend may go quiet rather than the whole exchange.
+	StreamBodies bool `yaml:"stream_bodies"`
+	// ProxyProtocol reads a PROXY header, v1 or v2, from the start of every
+	// client connection, ahead of TLS: accept takes connections with or
+	// without one, require drops those without. Empty reads none.
+	ProxyProtocol string `yaml:"proxy_protocol"`
 }
 
 type Config struct {
 	CustomHeaders     map[string]string `yaml:"custom_headers"`
 	HealthCheckerFunc types.IsHostAlive
 	HashFunc          types.HashFunc
-	Monitoring        Monitoring    `yaml:"monitoring"`
-	Type              string        `yaml:"type"`
-	Host              string        `yaml:"host"`
-	Port              string        `yaml:"port"`
-	Backends          []Backend     `yaml:"backends"`
-	Server            Server        `yaml:"server"`
-	Middlewares       []Middleware  `yaml:"middlewares"`
-	HealthCheckerTime time.Duration `yaml:"health_checker_time"`
+	// Pool names the pool a balancer built from the config serves, for the
+	// events it publishes; empty for backends.
+	Pool              string           `yaml:"-"`
+	Monitoring        Monitoring       `yaml:"monitoring"`
+	Type              string           `yaml:"type"`
+	Host              string           `yaml:"host"`
+	Port              string           `yaml:"port"`
+	Backends          []Backend        `yaml:"backends"`
+	Server            Server           `yaml:"server"`
+	Middlewares       []Middleware     `yaml:"middlewares"`
+	Retry             Retry            `yaml:"retry"`
+	Webhooks          []Webhook        `yaml:"webhooks"`
+	HealthCheckerTime time.Duration    `yaml:"health_checker_time"`
+	ForwardedHeaders  ForwardedHeaders `yaml:"forwarded_headers"`
+	ClientIP          ClientIP         `yaml:"client_ip"`
+	RequestHeaders    HeaderRules      `yaml:"request_headers"`
+	ResponseHeaders   HeaderRules      `yaml:"response_headers"`
+	Compression       Compression      `yaml:"compression"`
+	Cache             Cache            `yaml:"cache"`
+	Hedge             Hedge            `yaml:"hedge"`
+	Mirror            Mirror           `yaml:"mirror"`
+	Split             Split            `yaml:"split"`
+	RateLimits        []RateLimit      `yaml:"rate_limits"`
+	Concurrency       Concurrency      `yaml:"concurrency"`
+	Queue             Queue            `yaml:"queue"`
+	AccessControl     AccessControl    `yaml:"access_control"`
+	Auth              []AuthPolicy     `yaml:"auth"`
+}
+
+// ForBackends copies c to balance backends instead, as the pool named name,
+// as the mirror's and the split's pools are. w-round-robin over a single
+// Backend becomes round-robin, as PrepareConfig makes it for backends.
+func (c *Config) ForBackends(name string, backends []Backend) *Config {
+	pool := *c
+	pool.Pool = name
+	pool.Backends = backends
+	if pool.Type == "w-round-robin" && len(backends) == 1 {
+		pool.Type = "round-robin"
+	}
+	return &pool
 }
 
 func (c *Config) GetAddr() string {
@@ -193,103 +225,93 @@ func (c *Config) PrepareConfig() error {
 		return err
 	}
 
-	// Default funcs
-	// TODO make more flexible
-	c.HashFunc = helper.HashFunc
-	c.HealthCheckerFunc = http.NewHttpClient().IsHostAlive
-
-	err := c.Server.prepareServer()
-	if err != nil {
+	if err := c.Retry.prepareRetry(); err != nil {
 		return err
 	}
 
-	return c.prepareBackends()
-}
-
-func (c *Config) prepareBackends() error {
-	for i := 0; i < len(c.Backends); i++ {
-		b := &c.Backends[i]
-
-		addr, err := normalizeBackendAddress(b.Url)
-		if err != nil {
-			return err
-		}
-		b.Url = addr
-
-		if c.Type == "w-round-robin" && b.Weight <= 0 {
-			return ErrInvalidWeight
-		}
-
-		if b.HealthCheckPath == "" {
-			b.HealthCheckPath = "/"
-		}
-
-		if b.MaxConnection <= 0 {
-			b.MaxConnection = DefaultMaxConnection
-		}
-
-		if b.MaxConnWaitTimeout <= 0 {
-			b.MaxConnWaitTimeout = DefaultMaxConnWaitTimeout
-		}
+	if err := c.prepareWebhooks(); err != nil {
+		return err
+	}
 
-		if b.MaxConnDuration <= 0 {
-			b.MaxConnDuration = DefaultMaxConnDuration
-		}
+	if err := c.ForwardedHeaders.prepare(); err != nil {
+		return err
+	}
 
-		if b.MaxIdleConnDuration <= 0 {
-			b.MaxIdleConnDuration = DefaultMaxIdleConnDuration
-		}
+	if err := c.prepareClientIP(); err != nil {
+		return err
+	}
 
-		if b.MaxIdemponentCallAttempts <= 0 {
-			b.MaxIdemponentCallAttempts = DefaultMaxIdemponentCallAttempts
-		}
+	if err := c.Compression.prepare(); err != nil {
+		return err
+	}
 
-		b.ProxyTimeout = c.Server.ProxyTimeout
+	if err := c.Cache.prepare(); err != nil {
+		return err
 	}
 
-	return nil
-}
+	err := c.Server.prepareServer()
+	if err != nil {
+		return err
+	}
 
-// normalizeBackendAddress derives the dialable Backend address (host:port)
-// from the config url: an optional http:// scheme and a bare trailing slash
-// are stripped, a missing port defaults to 80. Anything divisor cannot honor
-// (path, query, userinfo) is rejected, as is https://
-// (docs/adr/0004-plaintext-only-backends.md).
-func normalizeBackendAddress(raw string) (string, error) {
-	if strings.TrimSpace(raw) == "" {
-		return "", ErrBackendUrlEmpty
+	if err := c.Hedge.prepare(); err != nil {
+		return err
+	}
+	if c.Hedge.Enabled && c.Server.StreamBodies {
+		return ErrHedgeStreamBodies
 	}
 
-	withScheme := raw
-	if !strings.Contains(raw, "://") {
-		withScheme = "http://" + raw
+	if err := c.Mirror.prepare(); err != nil {
+		return err
+	}
+	if c.Mirror.Enabled && c.Server.StreamBodies {
+		return ErrMirrorStreamBodies
 	}
 
-	u, err := url.Parse(withScheme)
-	if err != nil {
-		return "", fmt.Errorf("%w: %q: %v", ErrBackendUrlInvalid, raw, err)
+	if err := c.Split.prepare(); err != nil {
+		return err
 	}
 
-	if u.Scheme == "https" {
-		return "", fmt.Errorf("%w: %q", ErrBackendUrlHttps, raw)
+	if err := c.prepareRateLimits(); err != nil {
+		return err
 	}
-	if u.Scheme != "http" {
-		return "", fmt.Errorf("%w: %q", ErrBackendUrlScheme, raw)
+
+	if err := c.Concurrency.prepare(); err != nil {
+		return err
 	}
-	if u.User != nil {
-		return "", fmt.Errorf("%w: %q", ErrBackendUrlUserinfo, raw)
+
+	if err := c.Queue.prepare(); err != nil {
+		return err
 	}
-	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
-		return "", fmt.Errorf("%w: %q", ErrBackendUrlNotHostPort, raw)
+
+	if err := c.AccessControl.prepare(); err != nil {
+		return err
 	}
-	if u.Hostname() == "" {
-		return "", fmt.Errorf("%w: %q", ErrBackendUrlNoHost, raw)
+
+	if err := c.prepareAuth(); err != nil {
+		return err
 	}
 
-	if u.Port() == "" {
-		return net.JoinHostPort(u.Hostname(), "80"), nil
+	if err := c.prepareBackends(); err != nil {
+		return err
 	}
-	return u.Host, nil
+
+	// Default funcs
+	// TODO make more flexible
+	c.HashFunc = helper.HashFunc
+	probeClient := http.NewHttpClient()
+	for _, b := range c.allBackends() {
+		probeClient.SetProbeOptions(b.GetHealthCheckURL(), http.ProbeOptions{
+			DegradedStatus: b.HealthCheck.DegradedStatus,
+			TLSConfig:      b.TLS.Config,
+			H2C:            b.Protocol == BackendProtocolH2C,
+			ProxyProtocol:  b.ProxyProtocolVersion(),
+			Host:           b.ProbeHost(),
+		})
+	}
+	c.HealthCheckerFunc = probeClient.IsHostAlive
+
+	return nil
 }
 
 func (s *Server) prepareServer() error {
@@ -301,6 +323,10 @@ func (s *Server) prepareServer() error {
 		return ErrHttp2WithoutTls
 	}
 
+	if s.ProxyProtocol != "" && !helper.Contains(ValidProxyProtocolModes, s.ProxyProtocol) {
+		return fmt.Errorf("Please choose valid server proxy_protocol, e.g %v", ValidProxyProtocolModes)
+	}
+
 	if err := helper.IsFileExist(s.CertFile); err != nil && s.CertFile != "" {
 		return err
 	}
@@ -334,6 +360,14 @@ func (s *Server) prepareServer() error {
 		s.MaxRequestBodySize = DefaultMaxRequestBodySize
 	}
 
+	if s.WebSocketIdleTimeout <= 0 {
+		s.WebSocketIdleTimeout = DefaultWebSocketIdleTimeout
+	}
+
+	if s.NoBackendsRetryAfter < 0 {
+		return ErrNoBackendsRetryAfter
+	}
+
 	return nil
 }
 
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+)
+
+var (
+	ErrTrustedProxy      = errors.New("forwarded_headers.trusted_proxies entries must be IP addresses or CIDR ranges")
+	ErrClientIPUntrusted = errors.New("client_ip.source reads a header, which needs forwarded_headers.trusted_proxies to say whose to believe")
+)
+
+var ValidClientIPSources = []string{ClientIPRemoteAddr, ClientIPXForwardedFor, ClientIPXRealIP, ClientIPCFConnectingIP}
+
+const (
+	ClientIPRemoteAddr     = "remote_addr"
+	ClientIPXForwardedFor  = "x-forwarded-for"
+	ClientIPXRealIP        = "x-real-ip"
+	ClientIPCFConnectingIP = "cf-connecting-ip"
+)
+
+// ForwardedHeaders decides whose X-Forwarded-* and Forwarded values are
+// passed on: a peer in trusted_proxies has its values kept and extended,
+// anyone else has them replaced. See docs/adr/0007-forwarded-headers.md.
+type ForwardedHeaders struct {
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Forwarded adds the RFC 7239 Forwarded header next to X-Forwarded-*.
+	Forwarded bool `yaml:"forwarded"`
+
+	// Parsed from TrustedProxies by PrepareConfig.
+	Trusted IPRanges `yaml:"-"`
+}
+
+// Trusts reports whether ip, a connecting peer, is a trusted proxy.
+func (f *ForwardedHeaders) Trusts(ip net.IP) bool {
+	return f.Trusted.Contains(ip)
+}
+
+func (f *ForwardedHeaders) prepare() error {
+	trusted, err := parseIPRanges(f.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTrustedProxy, err)
+	}
+	f.Trusted = trusted
+	return nil
+}
+
+// ClientIP decides which address is the client's, for ip-hash,
+// $remote_addr and logs: the connecting peer, unless the peer is a trusted
+// proxy and Source names a header that carries the client's address.
+type ClientIP struct {
+	Source string `yaml:"source"`
+
+	// Copied from forwarded_headers.trusted_proxies by PrepareConfig: only
+	// a trusted proxy's header is believed.
+	Trusted IPRanges `yaml:"-"`
+}
+
+func (c *Config) prepareClientIP() error {
+	if c.ClientIP.Source == "" {
+		c.ClientIP.Source = ClientIPRemoteAddr
+	}
+
+	if !helper.Contains(ValidClientIPSources, c.ClientIP.Source) {
+		return fmt.Errorf("Please choose valid client_ip source, e.g %v", ValidClientIPSources)
+	}
+
+	if c.ClientIP.Source != ClientIPRemoteAddr && len(c.ForwardedHeaders.Trusted) == 0 {
+		return ErrClientIPUntrusted
+	}
+
+	c.ClientIP.Trusted = c.ForwardedHeaders.Trusted
+	return nil
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrHeaderName     = errors.New("request_headers and response_headers names must be valid header names")
+	ErrHeaderTemplate = errors.New("request_headers and response_headers values must only use known variables")
+)
+
+var ValidHeaderVariables = append(slices.Clone(ValidCustomHeaders),
+	"$host", "$method", "$path", "$query", "$scheme", "$backend_addr", "$request_id", "$tls_version")
+
+const (
+	HeaderRemove = "remove"
+	HeaderSet    = "set"
+	HeaderAdd    = "add"
+)
+
+// HeaderRules edit the headers of requests on their way to a Backend, or of
+// responses on their way back: Remove goes first, then Set replaces any
+// value, then Add goes next to any value. Values are templates; see
+// ParseHeaderTemplate.
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+
+	// Built by PrepareConfig, in the order they apply.
+	Ops []HeaderOp `yaml:"-"`
+}
+
+// HeaderOp is one header rule: Action is HeaderRemove, HeaderSet or
+// HeaderAdd, and Value is unused for HeaderRemove.
+type HeaderOp struct {
+	Action string
+	Name   string
+	Value  HeaderTemplate
+}
+
+// HeaderTemplate is a header value split into literal text and variables.
+type HeaderTemplate []TemplatePart
+
+// TemplatePart is literal text, or with Var set the name of a variable from
+// ValidHeaderVariables, "$host" for one.
+type TemplatePart struct {
+	Text string
+	Var  bool
+}
+
+// ParseHeaderTemplate splits s into text and variables. A variable is $name
+// or ${name}, the braces for one followed by more letters; $$ is a literal $.
+// $env:NAME is replaced by the environment variable NAME here, once.
+func ParseHeaderTemplate(s string) (HeaderTemplate, error) {
+	var t HeaderTemplate
+	var text strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			text.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		rest := s[i+1:]
+		var name string
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			text.WriteByte('$')
+			i += 2
+			continue
+		case strings.HasPrefix(rest, "{"):
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed ${ in %q", ErrHeaderTemplate, s)
+			}
+			name = rest[1:end]
+			i += end + 2
+		default:
+			n := 0
+			env := strings.HasPrefix(rest, "env:")
+			if env {
+				n = len("env:")
+			}
+			for n < len(rest) && isVariableChar(rest[n], env) {
+				n++
+			}
+			name = rest[:n]
+			i += n + 1
+		}
+
+		if env, ok := strings.CutPrefix(name, "env:"); ok && env != "" {
+			text.WriteString(os.Getenv(env))
+			continue
+		}
+		if !helper.Contains(ValidHeaderVariables, "$"+name) {
+			return nil, fmt.Errorf("%w: unknown variable %q in %q, e.g %v or $env:NAME", ErrHeaderTemplate, "$"+name, s, ValidHeaderVariables)
+		}
+		if text.Len() > 0 {
+			t = append(t, TemplatePart{Text: text.String()})
+			text.Reset()
+		}
+		t = append(t, TemplatePart{Text: "$" + name, Var: true})
+	}
+	if text.Len() > 0 || len(t) == 0 {
+		t = append(t, TemplatePart{Text: text.String()})
+	}
+	return t, nil
+}
+
+// isVariableChar reports whether c may continue a variable name; an
+// environment variable's name may also hold digits and capitals.
+func isVariableChar(c byte, env bool) bool {
+	return c >= 'a' && c <= 'z' || c == '_' || env && (c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+}
+
+// merge returns r with a Backend's own rules on top: a header the Backend
+// removes, sets or adds is no longer touched by r.
+func (r HeaderRules) merge(backend HeaderRules) HeaderRules {
+	own := make(map[string]bool)
+	for _, name := range backend.Remove {
+		own[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	for name := range backend.Set {
+		own[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	for name := range backend.Add {
+		own[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+
+	merged := HeaderRules{Set: map[string]string{}, Add: map[string]string{}}
+	for _, name := range r.Remove {
+		if !own[textproto.CanonicalMIMEHeaderKey(name)] {
+			merged.Remove = append(merged.Remove, name)
+		}
+	}
+	for name, value := range r.Set {
+		if !own[textproto.CanonicalMIMEHeaderKey(name)] {
+			merged.Set[name] = value
+		}
+	}
+	for name, value := range r.Add {
+		if !own[textproto.CanonicalMIMEHeaderKey(name)] {
+			merged.Add[name] = value
+		}
+	}
+	merged.Remove = append(merged.Remove, backend.Remove...)
+	maps.Copy(merged.Set, backend.Set)
+	maps.Copy(merged.Add, backend.Add)
+	return merged
+}
+
+func (r *HeaderRules) prepare() error {
+	r.Ops = nil
+	for _, name := range r.Remove {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("%w: %q", ErrHeaderName, name)
+		}
+		r.Ops = append(r.Ops, HeaderOp{Action: HeaderRemove, Name: name})
+	}
+	for _, action := range []string{HeaderSet, HeaderAdd} {
+		values := r.Set
+		if action == HeaderAdd {
+			values = r.Add
+		}
+		// Sorted, so the same config always produces the same headers.
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			if !httpguts.ValidHeaderFieldName(name) {
+				return fmt.Errorf("%w: %q", ErrHeaderName, name)
+			}
+			value, err := ParseHeaderTemplate(values[name])
+			if err != nil {
+				return err
+			}
+			r.Ops = append(r.Ops, HeaderOp{Action: action, Name: name, Value: value})
+		}
+	}
+	return nil
+}
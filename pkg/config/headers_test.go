@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeaderTemplate(t *testing.T) {
+	t.Setenv("DIVISOR_REGION", "eu-west-1")
+
+	valid := []struct {
+		in   string
+		want HeaderTemplate
+	}{
+		{"static", HeaderTemplate{{Text: "static"}}},
+		{"", HeaderTemplate{{Text: ""}}},
+		{"$host", HeaderTemplate{{Text: "$host", Var: true}}},
+		{"$scheme://$host$path", HeaderTemplate{{Text: "$scheme", Var: true}, {Text: "://"}, {Text: "$host", Var: true}, {Text: "$path", Var: true}}},
+		{"${path}s", HeaderTemplate{{Text: "$path", Var: true}, {Text: "s"}}},
+		{"$$5", HeaderTemplate{{Text: "$5"}}},
+		{"region=$env:DIVISOR_REGION;", HeaderTemplate{{Text: "region=eu-west-1;"}}},
+		{"${env:DIVISOR_REGION}a", HeaderTemplate{{Text: "eu-west-1a"}}},
+	}
+	for _, tt := range valid {
+		got, err := ParseHeaderTemplate(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, in := range []string{"$hostname", "$client_cert_subject", "$", "${path", "$env:", "cost: 5$"} {
+		_, err := ParseHeaderTemplate(in)
+		assert.ErrorIs(t, err, ErrHeaderTemplate, in)
+	}
+}
+
+func TestPrepareHeaderRules(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000",
+		RequestHeaders: HeaderRules{
+			Set:    map[string]string{"X-Env": "prod", "X-Origin": "$scheme://$host"},
+			Add:    map[string]string{"Via": "divisor"},
+			Remove: []string{"Cookie"},
+		},
+		ResponseHeaders: HeaderRules{Remove: []string{"Server"}},
+		Backends: []Backend{
+			{Url: "localhost:8080"},
+			{Url: "localhost:8081", RequestHeaders: HeaderRules{Set: map[string]string{"x-env": "canary", "Cookie": "none"}}},
+		},
+	}
+	assert.Nil(t, config.PrepareConfig())
+
+	ops := func(rules HeaderRules) []string {
+		var out []string
+		for _, op := range rules.Ops {
+			out = append(out, op.Action+" "+op.Name)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"remove Cookie", "set X-Env", "set X-Origin", "add Via"}, ops(config.Backends[0].RequestHeaders))
+	assert.Equal(t, []string{"set Cookie", "set X-Origin", "set x-env", "add Via"}, ops(config.Backends[1].RequestHeaders),
+		"a header the backend names is left to the backend's rules")
+	assert.Equal(t, []string{"remove Server"}, ops(config.Backends[1].ResponseHeaders))
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}},
+		ResponseHeaders: HeaderRules{Set: map[string]string{"X-Time": "$now"}}}
+	assert.ErrorIs(t, config.PrepareConfig(), ErrHeaderTemplate)
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080",
+		RequestHeaders: HeaderRules{Remove: []string{"Bad Header"}}}}}
+	assert.ErrorIs(t, config.PrepareConfig(), ErrHeaderName)
+}
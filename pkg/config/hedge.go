@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrHedgeDelay        = errors.New("hedge.delay must not be negative")
+	ErrHedgePercentile   = errors.New("hedge.percentile must be above 0 and below 100")
+	ErrHedgeBudget       = errors.New("hedge.budget_percent must be between 1 and 100")
+	ErrHedgeStreamBodies = errors.New("hedge cannot be combined with server.stream_bodies: a streamed response cannot be held back while another attempt runs")
+)
+
+const (
+	DefaultHedgeDelay      = 100 * time.Millisecond
+	DefaultHedgePercentile = 95
+	DefaultHedgeBudget     = 10
+)
+
+// Hedge sends a GET or HEAD to a second Backend when the first has not
+// answered within a delay, and keeps whichever answer comes first.
+type Hedge struct {
+	Enabled bool `yaml:"enabled"`
+	// Delay is how long the first Backend gets, or, with Percentile set,
+	// how long it gets while too few of its responses were measured.
+	Delay time.Duration `yaml:"delay"`
+	// Percentile derives the delay from the first Backend's recent
+	// response times: 95 hedges the slowest 5% of its requests.
+	Percentile    float64 `yaml:"percentile"`
+	BudgetPercent int     `yaml:"budget_percent"`
+}
+
+func (h *Hedge) prepare() error {
+	if !h.Enabled {
+		return nil
+	}
+	if h.Delay < 0 {
+		return ErrHedgeDelay
+	}
+	if h.Percentile < 0 || h.Percentile >= 100 {
+		return ErrHedgePercentile
+	}
+	if h.Delay == 0 && h.Percentile == 0 {
+		h.Percentile = DefaultHedgePercentile
+	}
+	if h.Delay == 0 {
+		h.Delay = DefaultHedgeDelay
+	}
+
+	if h.BudgetPercent == 0 {
+		h.BudgetPercent = DefaultHedgeBudget
+	}
+	if h.BudgetPercent < 0 || h.BudgetPercent > 100 {
+		return ErrHedgeBudget
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareHedge(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Hedge: Hedge{Enabled: true}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, Hedge{Enabled: true, Delay: DefaultHedgeDelay, Percentile: DefaultHedgePercentile, BudgetPercent: DefaultHedgeBudget}, config.Hedge)
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Hedge: Hedge{Enabled: true, Delay: 50 * time.Millisecond}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Zero(t, config.Hedge.Percentile)
+
+	invalid := []struct {
+		hedge Hedge
+		err   error
+	}{
+		{Hedge{Delay: -time.Second}, ErrHedgeDelay},
+		{Hedge{Percentile: 100}, ErrHedgePercentile},
+		{Hedge{Percentile: -1}, ErrHedgePercentile},
+		{Hedge{BudgetPercent: 101}, ErrHedgeBudget},
+	}
+	for _, tt := range invalid {
+		tt.hedge.Enabled = true
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Hedge: tt.hedge}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}},
+		Hedge: Hedge{Enabled: true}, Server: Server{StreamBodies: true}}
+	assert.ErrorIs(t, config.PrepareConfig(), ErrHedgeStreamBodies)
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrMirrorBackends     = errors.New("mirror needs at least one backend to send copies to")
+	ErrMirrorPercent      = errors.New("mirror.percent must be above 0 and at most 100")
+	ErrMirrorPath         = errors.New("mirror.paths entries must be paths starting with /")
+	ErrMirrorStreamBodies = errors.New("mirror cannot be combined with server.stream_bodies: a streamed request body can only be read once")
+)
+
+const (
+	DefaultMirrorPercent = 100
+
+	// MirrorPool names the shadow pool in the events about its Backends.
+	MirrorPool = "mirror"
+)
+
+// Mirror sends a copy of matching requests to a shadow pool of Backends and
+// drops their responses.
+type Mirror struct {
+	Enabled  bool      `yaml:"enabled"`
+	Backends []Backend `yaml:"backends"`
+	// Percent of the matching requests copied, picked at random.
+	Percent float64 `yaml:"percent"`
+	// Methods and Paths (prefixes) narrow what is copied; empty matches
+	// everything.
+	Methods []string `yaml:"methods"`
+	Paths   []string `yaml:"paths"`
+}
+
+func (m *Mirror) prepare() error {
+	if !m.Enabled {
+		return nil
+	}
+	if len(m.Backends) == 0 {
+		return ErrMirrorBackends
+	}
+	if m.Percent == 0 {
+		m.Percent = DefaultMirrorPercent
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return ErrMirrorPercent
+	}
+	for i, method := range m.Methods {
+		m.Methods[i] = strings.ToUpper(method)
+	}
+	for _, path := range m.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%w: %q", ErrMirrorPath, path)
+		}
+	}
+	return nil
+}
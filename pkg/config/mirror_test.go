@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareMirror(t *testing.T) {
+	config := Config{Type: "w-round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080", Weight: 1}, {Url: "localhost:8081", Weight: 1}},
+		Mirror: Mirror{Enabled: true, Methods: []string{"get"}, Backends: []Backend{{Url: "https://shadow.internal"}}}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, float64(DefaultMirrorPercent), config.Mirror.Percent)
+	assert.Equal(t, []string{"GET"}, config.Mirror.Methods)
+	// Prepared like any Backend, without the weight w-round-robin needs.
+	shadow := config.Mirror.Backends[0]
+	assert.Equal(t, "shadow.internal:443", shadow.Url)
+	assert.True(t, shadow.TLS.Enabled)
+	assert.Equal(t, DefaultMaxConnection, shadow.MaxConnection)
+
+	invalid := []struct {
+		mirror Mirror
+		err    error
+	}{
+		{Mirror{}, ErrMirrorBackends},
+		{Mirror{Backends: []Backend{{Url: "localhost:9000"}}, Percent: 101}, ErrMirrorPercent},
+		{Mirror{Backends: []Backend{{Url: "localhost:9000"}}, Percent: -1}, ErrMirrorPercent},
+		{Mirror{Backends: []Backend{{Url: "localhost:9000"}}, Paths: []string{"api"}}, ErrMirrorPath},
+		{Mirror{Backends: []Backend{{Url: "localhost:9000/v2"}}}, ErrBackendUrlNotHostPort},
+	}
+	for _, tt := range invalid {
+		tt.mirror.Enabled = true
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Mirror: tt.mirror}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}},
+		Mirror: Mirror{Enabled: true, Backends: []Backend{{Url: "localhost:9000"}}}, Server: Server{StreamBodies: true}}
+	assert.ErrorIs(t, config.PrepareConfig(), ErrMirrorStreamBodies)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+)
+
+var (
+	ErrQueueDepth = errors.New("queue.max_depth must be above 0")
+	ErrQueueWait  = errors.New("queue.max_wait must be above 0")
+)
+
+var ValidQueueOrders = []string{QueueFIFO, QueueLIFO}
+
+const (
+	QueueFIFO            = "fifo"
+	QueueLIFO            = "lifo"
+	DefaultQueueMaxDepth = 1000
+	DefaultQueueMaxWait  = 5 * time.Second
+)
+
+// Queue holds requests while every Alive Backend is at max_conn, and hands
+// each to the first Backend with a free connection, whichever it is.
+type Queue struct {
+	Enabled  bool          `yaml:"enabled"`
+	MaxDepth int           `yaml:"max_depth"`
+	MaxWait  time.Duration `yaml:"max_wait"`
+	// Order is fifo, oldest first, or lifo, newest first: under a lasting
+	// overload the newest request is the one whose client still waits.
+	Order string `yaml:"order"`
+}
+
+func (q *Queue) prepare() error {
+	if !q.Enabled {
+		return nil
+	}
+	if q.MaxDepth == 0 {
+		q.MaxDepth = DefaultQueueMaxDepth
+	}
+	if q.MaxDepth < 0 {
+		return ErrQueueDepth
+	}
+	if q.MaxWait == 0 {
+		q.MaxWait = DefaultQueueMaxWait
+	}
+	if q.MaxWait < 0 {
+		return ErrQueueWait
+	}
+	if q.Order == "" {
+		q.Order = QueueFIFO
+	}
+	if !helper.Contains(ValidQueueOrders, q.Order) {
+		return fmt.Errorf("Please choose valid queue order, e.g %v", ValidQueueOrders)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareQueue(t *testing.T) {
+	q := Queue{Enabled: true}
+	assert.Nil(t, q.prepare())
+	assert.Equal(t, Queue{Enabled: true, MaxDepth: DefaultQueueMaxDepth, MaxWait: DefaultQueueMaxWait, Order: QueueFIFO}, q)
+
+	q = Queue{Enabled: true, Order: QueueLIFO}
+	assert.Nil(t, q.prepare())
+	assert.Equal(t, QueueLIFO, q.Order)
+
+	q = Queue{Enabled: true, MaxDepth: -1}
+	assert.ErrorIs(t, q.prepare(), ErrQueueDepth)
+	q = Queue{Enabled: true, MaxWait: -time.Second}
+	assert.ErrorIs(t, q.prepare(), ErrQueueWait)
+	q = Queue{Enabled: true, Order: "random"}
+	assert.NotNil(t, q.prepare())
+
+	q = Queue{MaxDepth: -1}
+	assert.Nil(t, q.prepare())
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrRateLimitName = errors.New("rate_limits names must be unique")
+	ErrRateLimitRate = errors.New("rate_limits entries need a rate above 0, and a period and burst that are not negative")
+	ErrRateLimitKey  = errors.New("rate_limits key must be client_ip, path or header:NAME")
+	ErrRateLimitPath = errors.New("rate_limits paths entries must be paths starting with /")
+)
+
+var ValidRateLimitAlgorithms = []string{RateLimitTokenBucket, RateLimitSlidingWindow}
+
+const (
+	RateLimitTokenBucket   = "token_bucket"
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitKeyClientIP   = "client_ip"
+	RateLimitKeyPath       = "path"
+	RateLimitKeyHeader     = "header:"
+
+	DefaultRateLimitPeriod = time.Second
+)
+
+// RateLimit allows Rate requests per Period for each key, answering the
+// rest with 429 before a Backend is picked.
+type RateLimit struct {
+	Name      string `yaml:"name"`
+	Algorithm string `yaml:"algorithm"`
+	// Key is what requests are counted by: client_ip, path (the entry of
+	// Paths a request matched) or header:NAME.
+	Key    string        `yaml:"key"`
+	Rate   int           `yaml:"rate"`
+	Period time.Duration `yaml:"period"`
+	// Burst is how many requests a token bucket lets through at once.
+	Burst int `yaml:"burst"`
+	// Paths (prefixes) narrow what is limited; empty matches everything.
+	Paths []string `yaml:"paths"`
+}
+
+func (c *Config) prepareRateLimits() error {
+	names := make([]string, 0, len(c.RateLimits))
+	for i := range c.RateLimits {
+		l := &c.RateLimits[i]
+		if l.Key == "" {
+			l.Key = RateLimitKeyClientIP
+		}
+		if l.Key != RateLimitKeyClientIP && l.Key != RateLimitKeyPath &&
+			!(strings.HasPrefix(l.Key, RateLimitKeyHeader) && httpguts.ValidHeaderFieldName(strings.TrimPrefix(l.Key, RateLimitKeyHeader))) {
+			return fmt.Errorf("%w: %q", ErrRateLimitKey, l.Key)
+		}
+		if l.Name == "" {
+			l.Name = l.Key
+		}
+		if slices.Contains(names, l.Name) {
+			return fmt.Errorf("%w: %q", ErrRateLimitName, l.Name)
+		}
+		names = append(names, l.Name)
+
+		if l.Algorithm == "" {
+			l.Algorithm = RateLimitTokenBucket
+		}
+		if !helper.Contains(ValidRateLimitAlgorithms, l.Algorithm) {
+			return fmt.Errorf("Please choose valid rate_limits algorithm, e.g %v", ValidRateLimitAlgorithms)
+		}
+		if l.Rate <= 0 || l.Period < 0 || l.Burst < 0 {
+			return ErrRateLimitRate
+		}
+		if l.Period == 0 {
+			l.Period = DefaultRateLimitPeriod
+		}
+		if l.Burst == 0 {
+			l.Burst = l.Rate
+		}
+		for _, path := range l.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("%w: %q", ErrRateLimitPath, path)
+			}
+		}
+	}
+	return nil
+}
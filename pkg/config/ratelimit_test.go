@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareRateLimits(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, RateLimits: []RateLimit{
+		{Rate: 10},
+		{Name: "api", Key: "header:X-Api-Key", Algorithm: RateLimitSlidingWindow, Rate: 100, Period: time.Minute, Paths: []string{"/api/"}},
+	}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, RateLimit{Name: RateLimitKeyClientIP, Key: RateLimitKeyClientIP, Algorithm: RateLimitTokenBucket, Rate: 10, Period: DefaultRateLimitPeriod, Burst: 10}, config.RateLimits[0])
+	assert.Equal(t, "api", config.RateLimits[1].Name)
+
+	invalid := []struct {
+		limits []RateLimit
+		err    error
+	}{
+		{[]RateLimit{{Rate: 0}}, ErrRateLimitRate},
+		{[]RateLimit{{Rate: 1, Period: -time.Second}}, ErrRateLimitRate},
+		{[]RateLimit{{Rate: 1, Burst: -1}}, ErrRateLimitRate},
+		{[]RateLimit{{Rate: 1, Key: "cookie:session"}}, ErrRateLimitKey},
+		{[]RateLimit{{Rate: 1, Key: "header:"}}, ErrRateLimitKey},
+		{[]RateLimit{{Rate: 1}, {Rate: 2}}, ErrRateLimitName},
+		{[]RateLimit{{Rate: 1, Paths: []string{"api"}}}, ErrRateLimitPath},
+	}
+	for _, tt := range invalid {
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, RateLimits: tt.limits}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	config = Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, RateLimits: []RateLimit{{Rate: 1, Algorithm: "leaky_bucket"}}}
+	assert.NotNil(t, config.PrepareConfig())
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrInvalidRetryBudget = errors.New("retry.budget_percent must be between 1 and 100")
+)
+
+var ValidRetryOn = []string{RetryOnConnectError, RetryOnTimeout, "502", "503", "504"}
+
+// Safe to send twice: RFC 9110 §9.2.2 idempotent methods minus OPTIONS and
+// TRACE, which nobody load balances.
+var DefaultRetryMethods = []string{fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodPut, fasthttp.MethodDelete}
+
+var DefaultRetryOn = []string{RetryOnConnectError}
+
+const (
+	RetryOnConnectError = "connect_error"
+	RetryOnTimeout      = "timeout"
+	DefaultRetryBudget  = 20
+)
+
+// Retry re-sends a failed request to a different Alive Backend. Disabled
+// unless max_attempts is above 1; see docs/adr/0005-opt-in-retry-on-another-backend.md.
+type Retry struct {
+	Methods       []string      `yaml:"methods"`
+	On            []string      `yaml:"on"`
+	MaxAttempts   int           `yaml:"max_attempts"`
+	PerTryTimeout time.Duration `yaml:"per_try_timeout"`
+	BudgetPercent int           `yaml:"budget_percent"`
+}
+
+func (r *Retry) Enabled() bool {
+	return r.MaxAttempts > 1
+}
+
+func (r *Retry) prepareRetry() error {
+	if !r.Enabled() {
+		return nil
+	}
+
+	if len(r.Methods) == 0 {
+		r.Methods = append([]string(nil), DefaultRetryMethods...)
+	}
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+
+	if len(r.On) == 0 {
+		r.On = append([]string(nil), DefaultRetryOn...)
+	}
+	for _, on := range r.On {
+		if !helper.Contains(ValidRetryOn, on) {
+			return fmt.Errorf("Please choose valid retry condition, e.g %v", ValidRetryOn)
+		}
+	}
+
+	if r.BudgetPercent == 0 {
+		r.BudgetPercent = DefaultRetryBudget
+	}
+	if r.BudgetPercent < 0 || r.BudgetPercent > 100 {
+		return ErrInvalidRetryBudget
+	}
+
+	return nil
+}
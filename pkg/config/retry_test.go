@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled by default", func(t *testing.T) {
+		config := Config{Backends: []Backend{{Url: "localhost:8080"}}, Port: "8000"}
+		err := config.PrepareConfig()
+
+		assert.Nil(t, err)
+		assert.False(t, config.Retry.Enabled())
+		assert.Empty(t, config.Retry.Methods)
+		assert.Equal(t, DefaultProxyTimeout, config.Backends[0].ProxyTimeout)
+	})
+
+	t.Run("default values", func(t *testing.T) {
+		retry := Retry{MaxAttempts: 2}
+		err := retry.prepareRetry()
+
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultRetryMethods, retry.Methods)
+		assert.Equal(t, DefaultRetryOn, retry.On)
+		assert.Equal(t, DefaultRetryBudget, retry.BudgetPercent)
+	})
+
+	t.Run("methods are upper-cased", func(t *testing.T) {
+		retry := Retry{MaxAttempts: 2, Methods: []string{"get", "Options"}}
+		assert.Nil(t, retry.prepareRetry())
+		assert.Equal(t, []string{"GET", "OPTIONS"}, retry.Methods)
+	})
+
+	t.Run("invalid condition", func(t *testing.T) {
+		retry := Retry{MaxAttempts: 2, On: []string{"500"}}
+		err := retry.prepareRetry()
+		assert.EqualError(t, err, fmt.Sprintf("Please choose valid retry condition, e.g %v", ValidRetryOn))
+	})
+
+	t.Run("invalid budget", func(t *testing.T) {
+		retry := Retry{MaxAttempts: 2, BudgetPercent: 101}
+		assert.ErrorIs(t, retry.prepareRetry(), ErrInvalidRetryBudget)
+	})
+
+	t.Run("per_try_timeout replaces proxy_timeout on every backend", func(t *testing.T) {
+		config := Config{
+			Backends: []Backend{{Url: "localhost:2000"}, {Url: "localhost:3000"}},
+			Port:     "8000",
+			Retry:    Retry{MaxAttempts: 3, PerTryTimeout: 2 * time.Second},
+		}
+		err := config.PrepareConfig()
+
+		assert.Nil(t, err)
+		for _, b := range config.Backends {
+			assert.Equal(t, 2*time.Second, b.ProxyTimeout)
+		}
+	})
+}
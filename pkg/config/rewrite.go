@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+var (
+	ErrRewritePrefix = errors.New("rewrite.strip_prefix and rewrite.add_prefix must be paths starting with /")
+	ErrRewriteRegex  = errors.New("rewrite.regex entries need a match that compiles as a regular expression")
+	ErrRewriteHeader = errors.New("rewrite.original_uri_header must be a valid header name")
+)
+
+// Rewrite maps the path a client asked for to the one the Backend serves:
+// StripPrefix comes off first, then the first Regex that matches replaces
+// the path, then AddPrefix goes in front. The query is left alone.
+type Rewrite struct {
+	StripPrefix string         `yaml:"strip_prefix"`
+	AddPrefix   string         `yaml:"add_prefix"`
+	Regex       []RewriteRegex `yaml:"regex"`
+	// RewriteLocation and RewriteCookiePath undo the prefixes on paths the
+	// Backend sends back in Location and in Set-Cookie's Path; regex
+	// rewrites cannot be undone.
+	RewriteLocation   bool `yaml:"rewrite_location"`
+	RewriteCookiePath bool `yaml:"rewrite_cookie_path"`
+	// OriginalURIHeader, when set, names the header that carries the path
+	// and query as the client sent them.
+	OriginalURIHeader string `yaml:"original_uri_header"`
+}
+
+// RewriteRegex replaces a path matching Match with Replace, in which $1 or
+// ${name} stand for Match's groups.
+type RewriteRegex struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+	// Compiled from Match by PrepareConfig.
+	Pattern *regexp.Regexp `yaml:"-"`
+}
+
+// Enabled reports whether the Backend has any rewrite setting.
+func (r *Rewrite) Enabled() bool {
+	return r.StripPrefix != "" || r.AddPrefix != "" || len(r.Regex) > 0 ||
+		r.RewriteLocation || r.RewriteCookiePath || r.OriginalURIHeader != ""
+}
+
+func (r *Rewrite) prepare() error {
+	for _, prefix := range []*string{&r.StripPrefix, &r.AddPrefix} {
+		if *prefix == "" {
+			continue
+		}
+		if !strings.HasPrefix(*prefix, "/") || strings.ContainsAny(*prefix, "?#") {
+			return fmt.Errorf("%w: %q", ErrRewritePrefix, *prefix)
+		}
+		// "/billing/" and "/billing" are the same mount point; "/" alone
+		// strips or adds nothing.
+		*prefix = strings.TrimRight(*prefix, "/")
+	}
+
+	for i := range r.Regex {
+		re := &r.Regex[i]
+		if re.Match == "" {
+			return fmt.Errorf("%w: empty match", ErrRewriteRegex)
+		}
+		pattern, err := regexp.Compile(re.Match)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRewriteRegex, err)
+		}
+		re.Pattern = pattern
+	}
+
+	if r.OriginalURIHeader != "" && !httpguts.ValidHeaderFieldName(r.OriginalURIHeader) {
+		return fmt.Errorf("%w: %q", ErrRewriteHeader, r.OriginalURIHeader)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareRewrite(t *testing.T) {
+	config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080",
+		Rewrite: Rewrite{
+			StripPrefix: "/billing/",
+			AddPrefix:   "/",
+			Regex:       []RewriteRegex{{Match: `^/invoices/(\d+)$`, Replace: "/invoice/$1"}},
+		}}}}
+	assert.Nil(t, config.PrepareConfig())
+	r := config.Backends[0].Rewrite
+	assert.Equal(t, "/billing", r.StripPrefix)
+	assert.Equal(t, "", r.AddPrefix, "a bare / adds nothing")
+	assert.True(t, r.Regex[0].Pattern.MatchString("/invoices/7"))
+	assert.True(t, r.Enabled())
+	assert.False(t, (&Rewrite{}).Enabled())
+
+	invalid := []struct {
+		rewrite Rewrite
+		err     error
+	}{
+		{Rewrite{StripPrefix: "billing"}, ErrRewritePrefix},
+		{Rewrite{AddPrefix: "/api?v=1"}, ErrRewritePrefix},
+		{Rewrite{Regex: []RewriteRegex{{Match: "(unclosed"}}}, ErrRewriteRegex},
+		{Rewrite{Regex: []RewriteRegex{{Replace: "/"}}}, ErrRewriteRegex},
+		{Rewrite{OriginalURIHeader: "X Original"}, ErrRewriteHeader},
+	}
+	for _, tt := range invalid {
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080", Rewrite: tt.rewrite}}}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrSplitPools        = errors.New("split needs at least one pool besides the primary one under backends")
+	ErrSplitPoolName     = errors.New("split.pools names must be unique, not empty and neither primary nor mirror")
+	ErrSplitPoolBackends = errors.New("split.pools entries need at least one backend")
+	ErrSplitWeight       = errors.New("split.pools weights must be between 0 and 100 and add up to at most 100")
+	ErrSplitMatch        = errors.New("split.match entries need one of header or cookie, a value and a known pool")
+	ErrSplitSticky       = errors.New("split.sticky takes a header or a cookie, not both")
+)
+
+const (
+	// SplitPrimaryPool names the pool of the Backends under backends.
+	SplitPrimaryPool = "primary"
+)
+
+// Split divides traffic between the primary pool, the Backends under
+// backends, and further named pools: by a header or cookie match, then by a
+// sticky hash of a user key, then at random by weight.
+type Split struct {
+	Enabled bool         `yaml:"enabled"`
+	Pools   []SplitPool  `yaml:"pools"`
+	Match   []SplitMatch `yaml:"match"`
+	Sticky  SplitKey     `yaml:"sticky"`
+}
+
+// SplitPool is balanced like backends. Weight is its percentage of the
+// traffic no match claims; the primary pool gets what the pools leave.
+type SplitPool struct {
+	Name     string    `yaml:"name"`
+	Weight   float64   `yaml:"weight"`
+	Backends []Backend `yaml:"backends"`
+}
+
+// SplitMatch sends every request whose header or cookie equals Value to
+// Pool.
+type SplitMatch struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+	Value  string `yaml:"value"`
+	Pool   string `yaml:"pool"`
+}
+
+// SplitKey names the header or cookie a request's user key is read from.
+type SplitKey struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+}
+
+func (s *Split) prepare() error {
+	if !s.Enabled {
+		return nil
+	}
+	if len(s.Pools) == 0 {
+		return ErrSplitPools
+	}
+	names := []string{SplitPrimaryPool}
+	weights := make([]float64, 0, len(s.Pools))
+	for _, pool := range s.Pools {
+		if pool.Name == "" || pool.Name == MirrorPool || slices.Contains(names, pool.Name) {
+			return fmt.Errorf("%w: %q", ErrSplitPoolName, pool.Name)
+		}
+		names = append(names, pool.Name)
+		if len(pool.Backends) == 0 {
+			return fmt.Errorf("%w: %q", ErrSplitPoolBackends, pool.Name)
+		}
+		weights = append(weights, pool.Weight)
+	}
+	if !ValidSplitWeights(weights) {
+		return ErrSplitWeight
+	}
+	for _, match := range s.Match {
+		if (match.Header == "") == (match.Cookie == "") || match.Value == "" || !slices.Contains(names, match.Pool) {
+			return ErrSplitMatch
+		}
+	}
+	if s.Sticky.Header != "" && s.Sticky.Cookie != "" {
+		return ErrSplitSticky
+	}
+	return nil
+}
+
+// ValidSplitWeights reports whether weights fit in 100%, leaving the rest to
+// the primary pool.
+func ValidSplitWeights(weights []float64) bool {
+	var total float64
+	for _, weight := range weights {
+		if weight < 0 || weight > 100 {
+			return false
+		}
+		total += weight
+	}
+	return total <= 100
+}
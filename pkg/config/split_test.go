@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareSplit(t *testing.T) {
+	config := Config{Type: "w-round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080", Weight: 1}, {Url: "localhost:8081", Weight: 1}},
+		Split: Split{Enabled: true, Pools: []SplitPool{{Name: "canary", Weight: 10, Backends: []Backend{{Url: "localhost:9000", Weight: 1}}}},
+			Match: []SplitMatch{{Header: "X-Canary", Value: "1", Pool: "canary"}, {Cookie: "release", Value: "stable", Pool: SplitPrimaryPool}}}}
+	assert.Nil(t, config.PrepareConfig())
+	assert.Equal(t, DefaultMaxConnection, config.Split.Pools[0].Backends[0].MaxConnection)
+	// A pool of one is balanced round-robin, as backends would be.
+	pool := config.ForBackends("canary", config.Split.Pools[0].Backends)
+	assert.Equal(t, "round-robin", pool.Type)
+	assert.Equal(t, "canary", pool.Pool)
+	assert.Empty(t, config.Pool)
+	assert.Equal(t, "w-round-robin", config.Type)
+
+	canary := []Backend{{Url: "localhost:9000"}}
+	invalid := []struct {
+		split Split
+		err   error
+	}{
+		{Split{}, ErrSplitPools},
+		{Split{Pools: []SplitPool{{Name: "primary", Backends: canary}}}, ErrSplitPoolName},
+		{Split{Pools: []SplitPool{{Name: "mirror", Backends: canary}}}, ErrSplitPoolName},
+		{Split{Pools: []SplitPool{{Name: "", Backends: canary}}}, ErrSplitPoolName},
+		{Split{Pools: []SplitPool{{Name: "canary", Backends: canary}, {Name: "canary", Backends: canary}}}, ErrSplitPoolName},
+		{Split{Pools: []SplitPool{{Name: "canary"}}}, ErrSplitPoolBackends},
+		{Split{Pools: []SplitPool{{Name: "canary", Weight: 60, Backends: canary}, {Name: "beta", Weight: 50, Backends: canary}}}, ErrSplitWeight},
+		{Split{Pools: []SplitPool{{Name: "canary", Weight: -1, Backends: canary}}}, ErrSplitWeight},
+		{Split{Pools: []SplitPool{{Name: "canary", Backends: canary}}, Match: []SplitMatch{{Header: "X-Canary", Value: "1", Pool: "beta"}}}, ErrSplitMatch},
+		{Split{Pools: []SplitPool{{Name: "canary", Backends: canary}}, Match: []SplitMatch{{Header: "X-Canary", Cookie: "canary", Value: "1", Pool: "canary"}}}, ErrSplitMatch},
+		{Split{Pools: []SplitPool{{Name: "canary", Backends: canary}}, Match: []SplitMatch{{Header: "X-Canary", Pool: "canary"}}}, ErrSplitMatch},
+		{Split{Pools: []SplitPool{{Name: "canary", Backends: canary}}, Sticky: SplitKey{Header: "X-User-Id", Cookie: "uid"}}, ErrSplitSticky},
+	}
+	for _, tt := range invalid {
+		tt.split.Enabled = true
+		config := Config{Type: "round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080"}}, Split: tt.split}
+		assert.ErrorIs(t, config.PrepareConfig(), tt.err)
+	}
+
+	config = Config{Type: "w-round-robin", Port: "8000", Backends: []Backend{{Url: "localhost:8080", Weight: 1}, {Url: "localhost:8081", Weight: 1}},
+		Split: Split{Enabled: true, Pools: []SplitPool{{Name: "canary", Backends: canary}}}}
+	assert.ErrorIs(t, config.PrepareConfig(), ErrInvalidWeight)
+}
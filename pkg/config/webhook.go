@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aaydin-tr/divisor/pkg/helper"
+)
+
+var (
+	ErrWebhookUrl = errors.New("Webhook url must be an absolute http or https url")
+)
+
+var ValidWebhookEvents = []string{"BackendDown", "BackendRejoin", "BackendDegraded", "AllBackendsDown", "ConfigReloaded"}
+
+const (
+	DefaultWebhookMaxAttempts = 3
+	DefaultWebhookTimeout     = 5 * time.Second
+)
+
+// Webhook POSTs health events to url as JSON; see internal/events.
+type Webhook struct {
+	Url string `yaml:"url"`
+	// Events filters what is delivered; empty means every event.
+	Events      []string      `yaml:"events"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+func (c *Config) prepareWebhooks() error {
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+
+		u, err := url.Parse(w.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q", ErrWebhookUrl, w.Url)
+		}
+
+		for _, t := range w.Events {
+			if !helper.Contains(ValidWebhookEvents, t) {
+				return fmt.Errorf("Please choose valid webhook event, e.g %v", ValidWebhookEvents)
+			}
+		}
+
+		if w.MaxAttempts <= 0 {
+			w.MaxAttempts = DefaultWebhookMaxAttempts
+		}
+
+		if w.Timeout <= 0 {
+			w.Timeout = DefaultWebhookTimeout
+		}
+	}
+
+	return nil
+}
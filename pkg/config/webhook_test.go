@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareWebhooks(t *testing.T) {
+	config := Config{Webhooks: []Webhook{
+		{Url: "https://oncall.example.com/hooks/divisor"},
+		{Url: "http://localhost:9000/events", Events: []string{"BackendDown"}, MaxAttempts: 5, Timeout: time.Second},
+	}}
+	assert.Nil(t, config.prepareWebhooks())
+	assert.Equal(t, DefaultWebhookMaxAttempts, config.Webhooks[0].MaxAttempts)
+	assert.Equal(t, DefaultWebhookTimeout, config.Webhooks[0].Timeout)
+	assert.Equal(t, 5, config.Webhooks[1].MaxAttempts)
+	assert.Equal(t, time.Second, config.Webhooks[1].Timeout)
+
+	for _, raw := range []string{"", "oncall.example.com/hooks", "ftp://oncall.example.com", "http://"} {
+		config := Config{Webhooks: []Webhook{{Url: raw}}}
+		assert.ErrorIs(t, config.prepareWebhooks(), ErrWebhookUrl, raw)
+	}
+
+	config = Config{Webhooks: []Webhook{{Url: "http://localhost:9000", Events: []string{"BackendUp"}}}}
+	assert.NotNil(t, config.prepareWebhooks())
+}
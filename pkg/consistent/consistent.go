@@ -1,6 +1,7 @@
 package consistent
 
 import (
+	"slices"
 	"sort"
 	"strconv"
 	"sync/atomic"
@@ -96,15 +97,29 @@ func virtualNodeKey(node *Node, i int) []byte {
 }
 
 func (c *ConsistentHash) GetNode(hash uint32) *Node {
+	return c.GetNodeExcluding(hash, nil)
+}
+
+// GetNodeExcluding walks the ring clockwise from hash like GetNode but passes
+// over the virtual nodes of the excluded proxies, so a retried client lands
+// where it would fail over to if those Backends went Down. It returns nil
+// when every Node is excluded.
+func (c *ConsistentHash) GetNodeExcluding(hash uint32, excluded []proxy.IProxyClient) *Node {
 	ring := c.ring.Load()
 	if ring.numbers.Len() == 0 {
 		return nil
 	}
 
 	i := sort.Search(ring.numbers.Len(), func(i int) bool { return ring.numbers[i] >= hash })
-	if i == ring.numbers.Len() {
-		i = 0
+	for range ring.numbers.Len() {
+		if i == ring.numbers.Len() {
+			i = 0
+		}
+		if node := ring.nodes[ring.numbers[i]]; !slices.Contains(excluded, node.Proxy) {
+			return node
+		}
+		i++
 	}
 
-	return ring.nodes[ring.numbers[i]]
+	return nil
 }
@@ -202,3 +202,28 @@ func TestNodesWithSameAddrKeepSeparateVirtualNodes(t *testing.T) {
 		}
 	}
 }
+
+func TestGetNodeExcluding(t *testing.T) {
+	ch := NewConsistentHash(4, helper.HashFunc)
+	nodeA := &Node{Proxy: &proxy.ProxyClient{Addr: "localhost:8080"}, Id: 0, Addr: "localhost:8080"}
+	nodeB := &Node{Proxy: &proxy.ProxyClient{Addr: "localhost:80"}, Id: 1, Addr: "localhost:80"}
+	ch.AddNode(nodeA)
+	ch.AddNode(nodeB)
+
+	const samples, sampleStride = 1000, math.MaxUint32 / 1000
+	for i := 0; i < samples; i++ {
+		hash := uint32(i * sampleStride)
+		first := ch.GetNode(hash)
+		if got := ch.GetNodeExcluding(hash, nil); got != first {
+			t.Fatalf("GetNodeExcluding(%d, nil) = %v, want GetNode's %v", hash, got, first)
+		}
+		got := ch.GetNodeExcluding(hash, []proxy.IProxyClient{first.Proxy})
+		if got == nil || got == first {
+			t.Fatalf("GetNodeExcluding(%d) = %v, want the other node", hash, got)
+		}
+	}
+
+	if got := ch.GetNodeExcluding(0, []proxy.IProxyClient{nodeA.Proxy, nodeB.Proxy}); got != nil {
+		t.Errorf("Expected nil with every node excluded, got %v", got)
+	}
+}
@@ -1,40 +1,411 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
 	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+// Probe targets without an http:// scheme select the other probe types.
+const (
+	ProbeSchemeTCP  = "tcp://"
+	ProbeSchemeGRPC = "grpc://"
+)
+
+const probeSchemeHTTPS = "https://"
+
+const probeTimeout = 5 * time.Second
+
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	// HealthCheckResponse_SERVING in grpc/health/v1/health.proto.
+	grpcServing = 1
+	// A HealthCheckResponse is a single enum; anything longer is not one.
+	maxGRPCHealthResponse = 64
+)
+
+// ProbeOptions tunes how a Probe is sent and how its result maps to a
+// HealthState.
+type ProbeOptions struct {
+	// DegradedStatus lists the status codes that mean Degraded rather than Down.
+	DegradedStatus []int
+	// TLSConfig probes over TLS with the Backend's own settings; nil probes
+	// in plaintext.
+	TLSConfig *tls.Config
+	// H2C sends http Probes over cleartext HTTP/2, for Backends that may not
+	// speak HTTP/1.1 at all.
+	H2C bool
+	// ProxyProtocol opens every Probe connection with a PROXY header of
+	// this version, LOCAL since a Probe has no client; 0 sends none.
+	ProxyProtocol int
+	// Host is sent as the Host of http and grpc Probes; empty sends the
+	// Backend's address.
+	Host string
+}
+
+// probeDoer sends http Probes; a fasthttp.Client, a Backend's own
+// HostClient, or an h2cProbe.
+type probeDoer interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+}
+
 type HttpClient struct {
 	client *fasthttp.Client
+	// HTTP/2 without TLS, for grpc Probes and Backends with protocol h2c.
+	h2c *http.Client
+	// Written by SetProbeOptions before the first Probe, read-only after.
+	probes map[string]ProbeOptions
+	// Clients for Probes sent with a Backend's own TLS settings, over h2c
+	// or after a PROXY header, by url.
+	clients     map[string]probeDoer
+	grpcClients map[string]*http.Client
+	// PROXY headers for tcp Probes, by url.
+	proxyHeaders map[string][]byte
 }
 
 func NewHttpClient() *HttpClient {
-	return &HttpClient{client: &fasthttp.Client{
-		ReadTimeout:         5 * time.Second,
-		WriteTimeout:        5 * time.Second,
-		MaxIdleConnDuration: 5 * time.Second,
-		MaxConnWaitTimeout:  30 * time.Second,
-		Dial: (&fasthttp.TCPDialer{
-			Concurrency:      4096,
-			DNSCacheDuration: time.Hour,
-		}).Dial,
-	}}
-}
-
-func (h *HttpClient) IsHostAlive(url string) bool {
+	return &HttpClient{
+		client: &fasthttp.Client{
+			ReadTimeout:         probeTimeout,
+			WriteTimeout:        probeTimeout,
+			MaxIdleConnDuration: 5 * time.Second,
+			MaxConnWaitTimeout:  30 * time.Second,
+			Dial: (&fasthttp.TCPDialer{
+				Concurrency:      4096,
+				DNSCacheDuration: time.Hour,
+			}).Dial,
+		},
+		h2c:          newH2CClient(nil),
+		probes:       make(map[string]ProbeOptions),
+		clients:      make(map[string]probeDoer),
+		grpcClients:  make(map[string]*http.Client),
+		proxyHeaders: make(map[string][]byte),
+	}
+}
+
+// SetProbeOptions registers options for the Probes sent to url. It must be
+// called before the health checkers start.
+func (h *HttpClient) SetProbeOptions(url string, opts ProbeOptions) {
+	h.probes[url] = opts
+	var proxyHeader []byte
+	if opts.ProxyProtocol != 0 {
+		proxyHeader = (&proxyproto.Header{Local: true}).Format(opts.ProxyProtocol)
+	}
+
+	switch {
+	case strings.HasPrefix(url, ProbeSchemeTCP):
+		// A tcp Probe only connects; it has no use for TLS settings.
+		if proxyHeader != nil {
+			h.proxyHeaders[url] = proxyHeader
+		}
+	case strings.HasPrefix(url, ProbeSchemeGRPC):
+		if opts.TLSConfig != nil {
+			h.grpcClients[url] = newGRPCTLSClient(opts.TLSConfig, proxyHeader)
+		} else if proxyHeader != nil {
+			h.grpcClients[url] = newH2CClient(proxyHeader)
+		}
+	case opts.H2C && strings.HasPrefix(url, "http://"):
+		client := h.h2c
+		if proxyHeader != nil {
+			client = newH2CClient(proxyHeader)
+		}
+		h.clients[url] = h2cProbe{client: client}
+	case opts.TLSConfig != nil || proxyHeader != nil:
+		https := strings.HasPrefix(url, probeSchemeHTTPS)
+		addr, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(url, probeSchemeHTTPS), "http://"), "/")
+		client := &fasthttp.HostClient{
+			Addr:                addr,
+			IsTLS:               https,
+			TLSConfig:           opts.TLSConfig,
+			ReadTimeout:         probeTimeout,
+			WriteTimeout:        probeTimeout,
+			MaxIdleConnDuration: 5 * time.Second,
+		}
+		if proxyHeader != nil {
+			dial := dialProxied(proxyHeader)
+			client.Dial = func(addr string) (net.Conn, error) {
+				return dial(context.Background(), "tcp", addr)
+			}
+		}
+		h.clients[url] = client
+	}
+}
+
+// dialProxied opens connections that start with header. Every Probe sends
+// the same LOCAL header, so a kept-alive connection may serve the next one.
+func dialProxied(header []byte) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := net.Dialer{Timeout: probeTimeout}
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			if _, err := conn.Write(header); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
+
+// newH2CClient speaks HTTP/2 over plaintext TCP, as gRPC Backends expect,
+// after proxyHeader if it is set. Redirects are returned, not followed, as
+// the fasthttp client does.
+func newH2CClient(proxyHeader []byte) *http.Client {
+	dial := dialProxied(proxyHeader)
+	return &http.Client{
+		Timeout: probeTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// newGRPCTLSClient speaks HTTP/2 over TLS with a Backend's own settings,
+// after proxyHeader if it is set.
+func newGRPCTLSClient(cfg *tls.Config, proxyHeader []byte) *http.Client {
+	transport := &http2.Transport{TLSClientConfig: cfg}
+	if proxyHeader != nil {
+		dial := dialProxied(proxyHeader)
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	return &http.Client{Timeout: probeTimeout, Transport: transport}
+}
+
+// IsHostAlive runs one Probe against url. tcp://host:port is Alive on
+// connect, grpc://host:port/service on a SERVING health check, and any other
+// url on an HTTP 200 — Degraded instead when the status is one of the url's
+// DegradedStatus or the body is JSON with "status":"degraded".
+func (h *HttpClient) IsHostAlive(url string) types.HealthState {
+	if addr, ok := strings.CutPrefix(url, ProbeSchemeTCP); ok {
+		return probeTCP(addr, h.proxyHeaders[url])
+	}
+	if target, ok := strings.CutPrefix(url, ProbeSchemeGRPC); ok {
+		addr, service, _ := strings.Cut(target, "/")
+		if client, ok := h.grpcClients[url]; ok {
+			origin := "http://" + addr
+			if h.probes[url].TLSConfig != nil {
+				origin = probeSchemeHTTPS + addr
+			}
+			return probeGRPC(client, origin, service, h.probes[url].Host)
+		}
+		return probeGRPC(h.h2c, "http://"+addr, service, h.probes[url].Host)
+	}
+
 	req := fasthttp.AcquireRequest()
 	req.SetRequestURI(url)
 	req.Header.SetMethod(fasthttp.MethodGet)
+	if host := h.probes[url].Host; host != "" {
+		req.Header.SetHost(host)
+		req.UseHostHeader = true
+	}
 	resp := fasthttp.AcquireResponse()
-	err := h.client.Do(req, resp)
+	var client probeDoer = h.client
+	if own, ok := h.clients[url]; ok {
+		client = own
+	}
+	err := client.Do(req, resp)
 
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 
 	if err != nil {
+		return types.Down
+	}
+
+	status := resp.StatusCode()
+	if slices.Contains(h.probes[url].DegradedStatus, status) {
+		return types.Degraded
+	}
+	if status != fasthttp.StatusOK {
+		return types.Down
+	}
+	if isDegradedBody(resp) {
+		return types.Degraded
+	}
+	return types.Alive
+}
+
+// isDegradedBody recognizes the common health-endpoint convention of a JSON
+// body whose top-level "status" is "degraded".
+func isDegradedBody(resp *fasthttp.Response) bool {
+	if !bytes.HasPrefix(resp.Header.ContentType(), []byte("application/json")) {
 		return false
 	}
-	return resp.StatusCode() == fasthttp.StatusOK
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(resp.Body(), &body); err != nil {
+		return false
+	}
+	return strings.EqualFold(body.Status, "degraded")
+}
+
+// h2cProbe sends an http Probe over h2c and hands back what IsHostAlive
+// looks at: the status, the content type and the body.
+type h2cProbe struct {
+	client *http.Client
+}
+
+func (p h2cProbe) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	r, err := http.NewRequest(http.MethodGet, req.URI().String(), nil)
+	if err != nil {
+		return err
+	}
+	if req.UseHostHeader {
+		r.Host = string(req.Header.Host())
+	}
+
+	res, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	resp.SetStatusCode(res.StatusCode)
+	resp.Header.SetContentType(res.Header.Get("Content-Type"))
+	resp.SetBody(body)
+	return nil
+}
+
+func probeTCP(addr string, proxyHeader []byte) types.HealthState {
+	conn, err := dialProxied(proxyHeader)(context.Background(), "tcp", addr)
+	if err != nil {
+		return types.Down
+	}
+	conn.Close()
+	return types.Alive
+}
+
+func probeGRPC(client *http.Client, origin, service, host string) types.HealthState {
+	req, err := http.NewRequest(http.MethodPost, origin+grpcHealthCheckPath, bytes.NewReader(grpcHealthCheckRequest(service)))
+	if err != nil {
+		return types.Down
+	}
+	req.Host = host
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return types.Down
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return types.Down
+	}
+
+	// Trailers are only populated once the body has been read to EOF.
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxGRPCHealthResponse))
+	if err != nil {
+		return types.Down
+	}
+
+	// A trailers-only response (e.g. NOT_FOUND for an unknown service)
+	// carries grpc-status in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return types.Down
+	}
+
+	if grpcHealthStatus(payload) != grpcServing {
+		return types.Down
+	}
+	return types.Alive
+}
+
+// grpcHealthCheckRequest frames a HealthCheckRequest{service} as an
+// uncompressed gRPC message.
+func grpcHealthCheckRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, service)
+	}
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcHealthStatus extracts the status enum from a framed
+// HealthCheckResponse, or returns 0 (UNKNOWN) for anything malformed.
+func grpcHealthStatus(frame []byte) uint64 {
+	// Compressed flag must be unset; the request never offered an encoding.
+	if len(frame) < 5 || frame[0] != 0 {
+		return 0
+	}
+	msg := frame[5:]
+	if uint32(len(msg)) != binary.BigEndian.Uint32(frame[1:5]) {
+		return 0
+	}
+
+	var status uint64
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return 0
+			}
+			status = v
+			msg = msg[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return 0
+		}
+		msg = msg[n:]
+	}
+
+	return status
 }
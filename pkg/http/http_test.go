@@ -1,12 +1,25 @@
 package http
 
 import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/aaydin-tr/divisor/core/types"
+	"github.com/aaydin-tr/divisor/pkg/proxyproto"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type mockServer struct {
@@ -26,6 +39,7 @@ func TestNewHttpClient(t *testing.T) {
 	client := NewHttpClient()
 	assert.IsType(t, client, &HttpClient{})
 	assert.IsType(t, client.client, &fasthttp.Client{})
+	assert.IsType(t, client.h2c, &http.Client{})
 }
 
 func TestIsHostAlive(t *testing.T) {
@@ -36,16 +50,296 @@ func TestIsHostAlive(t *testing.T) {
 
 	t.Run("200", func(t *testing.T) {
 		status := client.IsHostAlive(server.URL)
-		assert.True(t, status)
+		assert.Equal(t, types.Alive, status)
 	})
 
 	t.Run("400", func(t *testing.T) {
 		status := client.IsHostAlive(server.URL)
-		assert.False(t, status)
+		assert.Equal(t, types.Down, status)
 	})
 
 	t.Run("error", func(t *testing.T) {
 		status := client.IsHostAlive("")
-		assert.False(t, status)
+		assert.Equal(t, types.Down, status)
+	})
+}
+
+func TestIsHostAliveDegraded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/shedding":
+			res.WriteHeader(http.StatusTooManyRequests)
+		case "/json-degraded":
+			res.Header().Set("Content-Type", "application/json; charset=utf-8")
+			res.Write([]byte(`{"status":"Degraded","checks":{"db":"slow"}}`))
+		case "/json-ok":
+			res.Header().Set("Content-Type", "application/json")
+			res.Write([]byte(`{"status":"ok"}`))
+		case "/text-degraded":
+			res.Write([]byte(`{"status":"degraded"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewHttpClient()
+	client.SetProbeOptions(server.URL+"/shedding", ProbeOptions{DegradedStatus: []int{http.StatusTooManyRequests}})
+
+	assert.Equal(t, types.Degraded, client.IsHostAlive(server.URL+"/shedding"))
+	assert.Equal(t, types.Degraded, client.IsHostAlive(server.URL+"/json-degraded"))
+	assert.Equal(t, types.Alive, client.IsHostAlive(server.URL+"/json-ok"))
+	assert.Equal(t, types.Alive, client.IsHostAlive(server.URL+"/text-degraded"), "only a JSON body is inspected")
+
+	other := NewHttpClient()
+	assert.Equal(t, types.Down, other.IsHostAlive(server.URL+"/shedding"), "429 is Down unless listed in degraded_status")
+}
+
+func TestIsHostAliveTCP(t *testing.T) {
+	client := NewHttpClient()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+
+	assert.Equal(t, types.Alive, client.IsHostAlive(ProbeSchemeTCP+addr))
+
+	assert.NoError(t, ln.Close())
+	assert.Equal(t, types.Down, client.IsHostAlive(ProbeSchemeTCP+addr))
+}
+
+// grpcHealthServer answers grpc.health.v1.Health/Check over h2c with the
+// status registered for the requested service, and NOT_FOUND otherwise.
+func grpcHealthServer(t *testing.T, statuses map[string]uint64) string {
+	t.Helper()
+	server := httptest.NewServer(h2c.NewHandler(grpcHealthHandler(t, statuses), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func grpcHealthHandler(t *testing.T, statuses map[string]uint64) http.Handler {
+	t.Helper()
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, grpcHealthCheckPath, req.URL.Path)
+		body, _ := io.ReadAll(req.Body)
+
+		var service string
+		if msg := body[5:]; len(msg) > 0 {
+			_, _, n := protowire.ConsumeTag(msg)
+			service, _ = protowire.ConsumeString(msg[n:])
+		}
+
+		res.Header().Set("Content-Type", "application/grpc")
+		status, ok := statuses[service]
+		if !ok {
+			res.Header().Set("Grpc-Status", "5")
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+
+		res.Header().Set("Trailer", "Grpc-Status")
+		msg := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), status)
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		res.Write(append(frame, msg...))
+		res.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func TestIsHostAliveGRPC(t *testing.T) {
+	client := NewHttpClient()
+	addr := grpcHealthServer(t, map[string]uint64{
+		"":             grpcServing,
+		"orders":       grpcServing,
+		"payments":     2, // NOT_SERVING
+		"inventory.v1": 0, // UNKNOWN
+	})
+
+	t.Run("server as a whole", func(t *testing.T) {
+		assert.Equal(t, types.Alive, client.IsHostAlive(ProbeSchemeGRPC+addr+"/"))
+	})
+
+	t.Run("serving service", func(t *testing.T) {
+		assert.Equal(t, types.Alive, client.IsHostAlive(ProbeSchemeGRPC+addr+"/orders"))
+	})
+
+	t.Run("not serving service", func(t *testing.T) {
+		assert.Equal(t, types.Down, client.IsHostAlive(ProbeSchemeGRPC+addr+"/payments"))
+		assert.Equal(t, types.Down, client.IsHostAlive(ProbeSchemeGRPC+addr+"/inventory.v1"))
+	})
+
+	t.Run("unknown service", func(t *testing.T) {
+		assert.Equal(t, types.Down, client.IsHostAlive(ProbeSchemeGRPC+addr+"/missing"))
+	})
+
+	t.Run("plain HTTP backend", func(t *testing.T) {
+		server := httptest.NewServer(&mockServer{})
+		defer server.Close()
+		assert.Equal(t, types.Down, client.IsHostAlive(ProbeSchemeGRPC+server.Listener.Addr().String()+"/"))
+	})
+}
+
+func TestIsHostAliveH2C(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			res.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.Write([]byte(`{"status":"degraded"}`))
+	}), &http2.Server{}))
+	defer server.Close()
+
+	url := server.URL + "/health"
+	assert.Equal(t, types.Down, NewHttpClient().IsHostAlive(url), "an h2c-only Backend refuses HTTP/1.1 Probes")
+
+	client := NewHttpClient()
+	client.SetProbeOptions(url, ProbeOptions{H2C: true})
+	assert.Equal(t, types.Degraded, client.IsHostAlive(url), "the body is read like any http Probe's")
+}
+
+func TestIsHostAliveTLS(t *testing.T) {
+	trusting := func(server *httptest.Server) *tls.Config {
+		roots := x509.NewCertPool()
+		roots.AddCert(server.Certificate())
+		return &tls.Config{RootCAs: roots, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12}
+	}
+
+	t.Run("http", func(t *testing.T) {
+		server := httptest.NewTLSServer(&mockServer{})
+		defer server.Close()
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+		untrusting := NewHttpClient()
+		untrusting.SetProbeOptions(server.URL, ProbeOptions{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}})
+		assert.Equal(t, types.Down, untrusting.IsHostAlive(server.URL), "an unverifiable certificate fails the Probe")
+
+		client := NewHttpClient()
+		client.SetProbeOptions(server.URL, ProbeOptions{TLSConfig: trusting(server)})
+		assert.Equal(t, types.Alive, client.IsHostAlive(server.URL))
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(grpcHealthHandler(t, map[string]uint64{"": grpcServing}))
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		defer server.Close()
+
+		url := ProbeSchemeGRPC + server.Listener.Addr().String() + "/"
+		client := NewHttpClient()
+		client.SetProbeOptions(url, ProbeOptions{TLSConfig: trusting(server)})
+		assert.Equal(t, types.Alive, client.IsHostAlive(url))
+
+		plaintext := NewHttpClient()
+		assert.Equal(t, types.Down, plaintext.IsHostAlive(url), "h2c cannot reach a TLS-only Backend")
+	})
+}
+
+// proxyProtocolServer starts server behind a listener that requires a PROXY
+// header, and returns its address.
+func proxyProtocolServer(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	server.Listener = proxyproto.NewListener(server.Listener, true, time.Second)
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func TestIsHostAliveProxyProtocol(t *testing.T) {
+	t.Run("http", func(t *testing.T) {
+		url := "http://" + proxyProtocolServer(t, httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))) + "/"
+		assert.Equal(t, types.Down, NewHttpClient().IsHostAlive(url), "a Backend requiring the header refuses Probes without")
+
+		for _, version := range []int{1, 2} {
+			client := NewHttpClient()
+			client.SetProbeOptions(url, ProbeOptions{ProxyProtocol: version})
+			assert.Equal(t, types.Alive, client.IsHostAlive(url))
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(h2c.NewHandler(grpcHealthHandler(t, map[string]uint64{"": grpcServing}), &http2.Server{}))
+		url := ProbeSchemeGRPC + proxyProtocolServer(t, server) + "/"
+		client := NewHttpClient()
+		client.SetProbeOptions(url, ProbeOptions{ProxyProtocol: 2})
+		assert.Equal(t, types.Alive, client.IsHostAlive(url))
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer ln.Close()
+		headers := make(chan *proxyproto.Header, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			h, _ := proxyproto.Read(bufio.NewReader(conn))
+			headers <- h
+		}()
+
+		url := ProbeSchemeTCP + ln.Addr().String()
+		client := NewHttpClient()
+		client.SetProbeOptions(url, ProbeOptions{ProxyProtocol: 2})
+		assert.Equal(t, types.Alive, client.IsHostAlive(url))
+		select {
+		case h := <-headers:
+			assert.True(t, h != nil && h.Local, "a Probe has no client to name")
+		case <-time.After(time.Second):
+			t.Fatal("no PROXY header arrived")
+		}
+	})
+}
+
+func TestGRPCHealthStatus(t *testing.T) {
+	assert.Equal(t, uint64(grpcServing), grpcHealthStatus([]byte{0, 0, 0, 0, 2, 0x08, grpcServing}))
+	assert.Equal(t, uint64(0), grpcHealthStatus(grpcHealthCheckRequest("")), "empty message is UNKNOWN")
+
+	assert.Equal(t, uint64(0), grpcHealthStatus(nil))
+	assert.Equal(t, uint64(0), grpcHealthStatus([]byte{1, 0, 0, 0, 2, 0x08, grpcServing}), "compressed")
+	assert.Equal(t, uint64(0), grpcHealthStatus([]byte{0, 0, 0, 0, 5, 0x08, grpcServing}), "truncated")
+}
+
+func TestIsHostAliveHost(t *testing.T) {
+	// hostOnly answers 200 only to requests for host.
+	hostOnly := func(host string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Host != host {
+				w.WriteHeader(http.StatusMisdirectedRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	t.Run("http", func(t *testing.T) {
+		server := httptest.NewServer(hostOnly("api.example.com", ok))
+		defer server.Close()
+		url := server.URL + "/"
+		assert.Equal(t, types.Down, NewHttpClient().IsHostAlive(url))
+
+		client := NewHttpClient()
+		client.SetProbeOptions(url, ProbeOptions{Host: "api.example.com"})
+		assert.Equal(t, types.Alive, client.IsHostAlive(url))
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		server := httptest.NewServer(h2c.NewHandler(hostOnly("api.example.com", ok), &http2.Server{}))
+		defer server.Close()
+		client := NewHttpClient()
+		client.SetProbeOptions(server.URL+"/", ProbeOptions{H2C: true, Host: "api.example.com"})
+		assert.Equal(t, types.Alive, client.IsHostAlive(server.URL+"/"))
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		server := httptest.NewServer(h2c.NewHandler(hostOnly("api.example.com", grpcHealthHandler(t, map[string]uint64{"": grpcServing})), &http2.Server{}))
+		defer server.Close()
+		url := ProbeSchemeGRPC + server.Listener.Addr().String() + "/"
+		assert.Equal(t, types.Down, NewHttpClient().IsHostAlive(url))
+
+		client := NewHttpClient()
+		client.SetProbeOptions(url, ProbeOptions{Host: "api.example.com"})
+		assert.Equal(t, types.Alive, client.IsHostAlive(url))
 	})
 }
@@ -0,0 +1,126 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its
+// header, so a client that connects and stays silent cannot hold the read.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener reads a PROXY header from the start of every connection it
+// accepts. Reading happens on the connection's first Read, Write, RemoteAddr
+// or LocalAddr, not in Accept, so one slow client cannot stall the others.
+type Listener struct {
+	net.Listener
+	// Required rejects connections that do not start with a header;
+	// otherwise they are served with their own addresses.
+	Required      bool
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps ln. A zero timeout means DefaultHeaderTimeout.
+func NewListener(ln net.Listener, required bool, timeout time.Duration) *Listener {
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: ln, Required: required, HeaderTimeout: timeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:     c,
+		reader:   bufio.NewReaderSize(c, 256),
+		required: l.Required,
+		timeout:  l.HeaderTimeout,
+	}, nil
+}
+
+// Conn is an accepted connection whose addresses are the ones its PROXY
+// header carries.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// Header returns the connection's header, nil when it sent none. The error
+// is what reading it failed with; the connection is then unusable.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	c.header, c.err = Read(c.reader)
+	c.mu.Lock()
+	c.Conn.SetReadDeadline(c.readDeadline) //nolint:errcheck
+	c.mu.Unlock()
+
+	if errors.Is(c.err, ErrNoHeader) && !c.required {
+		c.err = nil
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// Write fails on a connection whose header could not be read, so nothing,
+// not even an error response, is sent to a client that is not a proxy.
+func (c *Conn) Write(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && !h.Local {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && !h.Local {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline and SetReadDeadline remember the read deadline, which reading
+// the header overrides and then puts back.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,273 @@
+// Package proxyproto reads and writes PROXY protocol headers, which carry a
+// client's address across a TCP proxy ahead of the connection's own bytes:
+// the v1 text form and the v2 binary form with its TLVs. See
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoHeader = errors.New("PROXY protocol header missing")
+	ErrInvalid  = errors.New("PROXY protocol header malformed")
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// Longest v1 line, CRLF included.
+	maxV1Length = 107
+
+	v2Version    = 0x20
+	v2CmdLocal   = 0x00
+	v2CmdProxy   = 0x01
+	v2FamUnspec  = 0x00
+	v2FamInet    = 0x10
+	v2FamInet6   = 0x20
+	v2TransTCP   = 0x01
+	v2HeaderSize = 16
+	v2Inet4Size  = 12
+	v2Inet6Size  = 36
+)
+
+// Header is one decoded PROXY header.
+type Header struct {
+	Version int
+	// Local marks a connection the proxy opened on its own behalf, such as
+	// a health check: it carries no client, and the connection's own
+	// addresses stand (v2 LOCAL, v1 UNKNOWN, or an address family this
+	// package does not decode).
+	Local       bool
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+	// TLVs are the v2 extensions, undecoded.
+	TLVs []TLV
+}
+
+// TLV is one v2 type-length-value extension.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Read decodes the header at the start of r. When r does not start with one
+// it returns ErrNoHeader and has consumed nothing.
+func Read(r *bufio.Reader) (*Header, error) {
+	version, err := detect(r)
+	if err != nil {
+		return nil, err
+	}
+	switch version {
+	case 1:
+		return readV1(r)
+	case 2:
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// detect matches the signatures a byte at a time: a client that is not a
+// proxy may send fewer bytes than a signature and then wait for an answer.
+func detect(r *bufio.Reader) (int, error) {
+	for n := 1; n <= len(v2Signature); n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		v1 := n <= len(v1Prefix) && bytes.Equal(b, v1Prefix[:n])
+		v2 := bytes.Equal(b, v2Signature[:n])
+		switch {
+		case v1 && n == len(v1Prefix):
+			return 1, nil
+		case !v1 && !v2:
+			return 0, nil
+		}
+	}
+	return 2, nil
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for n := len(v1Prefix); ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if b[n-1] == '\n' {
+			line = b
+			break
+		}
+		if n == maxV1Length {
+			return nil, fmt.Errorf("%w: v1 line too long", ErrInvalid)
+		}
+	}
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	r.Discard(len(line)) //nolint:errcheck
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) || len(fields) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalid, line)
+	}
+	h := &Header{Version: 1}
+	if fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalid, line)
+	}
+
+	var err error
+	if h.Source, err = parseV1Addr(fields[2], fields[4], fields[1]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = parseV1Addr(fields[3], fields[5], fields[1]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseV1Addr(host, port, family string) (*net.TCPAddr, error) {
+	// A TCP6 address may be IPv4-mapped, but it is written as IPv6.
+	ip := net.ParseIP(host)
+	if ip == nil || (family == "TCP6") != strings.Contains(host, ":") {
+		return nil, fmt.Errorf("%w: address %q", ErrInvalid, host)
+	}
+	// Ports are decimal without leading zeros, 0 to 65535.
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: port %q", ErrInvalid, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderSize)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]&0xF0 != v2Version {
+		return nil, fmt.Errorf("%w: version %#x", ErrInvalid, fixed[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	var addrLen int
+	switch fixed[13] & 0xF0 {
+	case v2FamInet:
+		addrLen = v2Inet4Size
+	case v2FamInet6:
+		addrLen = v2Inet6Size
+	}
+	switch cmd := fixed[12] & 0x0F; {
+	case cmd == v2CmdLocal:
+		h.Local = true
+	case cmd != v2CmdProxy:
+		return nil, fmt.Errorf("%w: command %#x", ErrInvalid, cmd)
+	case addrLen == 0:
+		// AF_UNSPEC or AF_UNIX: nothing a TCP address can hold.
+		h.Local = true
+	}
+	if len(body) < addrLen {
+		return nil, fmt.Errorf("%w: %d address bytes", ErrInvalid, len(body))
+	}
+
+	if !h.Local {
+		ipLen := (addrLen - 4) / 2
+		h.Source = &net.TCPAddr{
+			IP:   net.IP(bytes.Clone(body[:ipLen])),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+		}
+		h.Destination = &net.TCPAddr{
+			IP:   net.IP(bytes.Clone(body[ipLen : 2*ipLen])),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+		}
+	}
+
+	// TLVs follow the address block of the declared family, LOCAL or not.
+	tlvs := body[addrLen:]
+	if fixed[13]&0xF0 != v2FamInet && fixed[13]&0xF0 != v2FamInet6 {
+		tlvs = nil
+	}
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalid)
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalid)
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return h, nil
+}
+
+// Format encodes h in the given version, 1 or 2. An address pair of mixed
+// families goes out as IPv6, the IPv4 side mapped. TLVs are only sent in v2.
+func (h *Header) Format(version int) []byte {
+	local := h.Local || h.Source == nil || h.Destination == nil
+	src, dst := net.IP(nil), net.IP(nil)
+	ipv4 := false
+	if !local {
+		src, dst = h.Source.IP.To16(), h.Destination.IP.To16()
+		ipv4 = h.Source.IP.To4() != nil && h.Destination.IP.To4() != nil
+		if ipv4 {
+			src, dst = src.To4(), dst.To4()
+		}
+	}
+
+	if version == 1 {
+		if local {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		if ipv4 {
+			return fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", src, dst, h.Source.Port, h.Destination.Port)
+		}
+		return fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", v1IPv6(src), v1IPv6(dst), h.Source.Port, h.Destination.Port)
+	}
+
+	out := append([]byte(nil), v2Signature...)
+	if local {
+		return append(out, v2Version|v2CmdLocal, v2FamUnspec, 0, 0)
+	}
+	family := byte(v2FamInet6)
+	if ipv4 {
+		family = v2FamInet
+	}
+	out = append(out, v2Version|v2CmdProxy, family|v2TransTCP, 0, 0)
+	out = append(out, src...)
+	out = append(out, dst...)
+	out = binary.BigEndian.AppendUint16(out, uint16(h.Source.Port))
+	out = binary.BigEndian.AppendUint16(out, uint16(h.Destination.Port))
+	for _, tlv := range h.TLVs {
+		out = append(out, tlv.Type)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(tlv.Value)))
+		out = append(out, tlv.Value...)
+	}
+	binary.BigEndian.PutUint16(out[14:], uint16(len(out)-v2HeaderSize))
+	return out
+}
+
+// v1IPv6 writes ip in IPv6 notation, which net.IP does not for a mapped
+// IPv4 address.
+func v1IPv6(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
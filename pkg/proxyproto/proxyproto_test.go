@@ -0,0 +1,235 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestReadV1(t *testing.T) {
+	r := reader("PROXY TCP4 203.0.113.9 10.0.0.1 40000 443\r\nGET / HTTP/1.1\r\n")
+	h, err := Read(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.False(t, h.Local)
+	assert.Equal(t, "203.0.113.9:40000", h.Source.String())
+	assert.Equal(t, "10.0.0.1:443", h.Destination.String())
+
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest), "the header is consumed, nothing more")
+
+	h, err = Read(reader("PROXY TCP6 2001:db8::9 2001:db8::1 40000 443\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::9]:40000", h.Source.String())
+
+	h, err = Read(reader("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	assert.NoError(t, err)
+	assert.True(t, h.Local)
+}
+
+func TestReadV1Invalid(t *testing.T) {
+	for _, line := range []string{
+		"PROXY TCP4 203.0.113.9 10.0.0.1 40000\r\n",
+		"PROXY TCP4 2001:db8::9 10.0.0.1 40000 443\r\n",
+		"PROXY TCP4 203.0.113.9 10.0.0.1 040000 443\r\n",
+		"PROXY TCP4 203.0.113.9 10.0.0.1 70000 443\r\n",
+		"PROXY UDP4 203.0.113.9 10.0.0.1 40000 443\r\n",
+		"PROXY TCP4 203.0.113.9 10.0.0.1 40000 443\n",
+		"PROXY " + strings.Repeat("x", 120) + "\r\n",
+	} {
+		_, err := Read(reader(line))
+		assert.ErrorIs(t, err, ErrInvalid, line)
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	in := &Header{
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+		TLVs:        []TLV{{Type: 0x01, Value: []byte("h2")}, {Type: 0x04}},
+	}
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(in.Format(2)), strings.NewReader("rest")))
+	h, err := Read(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, "203.0.113.9:40000", h.Source.String())
+	assert.Equal(t, "10.0.0.1:443", h.Destination.String())
+	assert.Equal(t, []TLV{{Type: 0x01, Value: []byte("h2")}, {Type: 0x04, Value: []byte{}}}, h.TLVs)
+
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, "rest", string(rest))
+}
+
+func TestReadV2IPv6AndMixed(t *testing.T) {
+	in := &Header{
+		Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::9"), Port: 40000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+	}
+	h, err := Read(reader(string(in.Format(2))))
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::9]:40000", h.Source.String())
+	assert.Equal(t, "10.0.0.1:443", h.Destination.String(), "the IPv4 side goes out mapped")
+
+	h, err = Read(reader(string(in.Format(1))))
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::9]:40000", h.Source.String())
+}
+
+func TestReadV2Local(t *testing.T) {
+	h, err := Read(reader(string((&Header{Local: true}).Format(2))))
+	assert.NoError(t, err)
+	assert.True(t, h.Local)
+	assert.Nil(t, h.Source)
+
+	h, err = Read(reader(string((&Header{Local: true}).Format(1))))
+	assert.NoError(t, err)
+	assert.True(t, h.Local)
+}
+
+func TestReadV2Invalid(t *testing.T) {
+	valid := (&Header{
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40000},
+		Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+	}).Format(2)
+
+	badVersion := bytes.Clone(valid)
+	badVersion[12] = 0x11
+	_, err := Read(reader(string(badVersion)))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	shortAddr := bytes.Clone(valid[:v2HeaderSize+4])
+	shortAddr[15] = 4
+	_, err = Read(reader(string(shortAddr)))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	truncatedTLV := append(bytes.Clone(valid), 0x01, 0x00, 0x05, 'h')
+	truncatedTLV[15] += 4
+	_, err = Read(reader(string(truncatedTLV)))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestReadNoHeader(t *testing.T) {
+	for _, in := range []string{"GET / HTTP/1.1\r\n", "PROXX", "\r\n\r\nx", "\x16\x03\x01"} {
+		r := reader(in)
+		_, err := Read(r)
+		assert.ErrorIs(t, err, ErrNoHeader, in)
+
+		rest, _ := io.ReadAll(r)
+		assert.Equal(t, in, string(rest), "nothing is consumed")
+	}
+}
+
+func TestReadShortClientDoesNotBlock(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// A TLS ClientHello prefix is shorter than either signature; the
+	// client then waits for the server, so Read must decide on it alone.
+	go client.Write([]byte{0x16, 0x03}) //nolint:errcheck
+	done := make(chan error, 1)
+	go func() {
+		_, err := Read(bufio.NewReader(server))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrNoHeader)
+	case <-time.After(time.Second):
+		t.Fatal("Read waited for more bytes than the client sent")
+	}
+}
+
+func listen(t *testing.T, required bool) *Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	return NewListener(ln, required, time.Second)
+}
+
+func dialAndSend(t *testing.T, ln net.Listener, data string) net.Conn {
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	_, err = c.Write([]byte(data))
+	assert.NoError(t, err)
+
+	conn, err := ln.Accept()
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestListener(t *testing.T) {
+	t.Run("header addresses replace the connection's", func(t *testing.T) {
+		ln := listen(t, false)
+		conn := dialAndSend(t, ln, "PROXY TCP4 203.0.113.9 10.0.0.1 40000 443\r\nping")
+
+		assert.Equal(t, "203.0.113.9:40000", conn.RemoteAddr().String())
+		assert.Equal(t, "10.0.0.1:443", conn.LocalAddr().String())
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	})
+
+	t.Run("accept serves a connection without a header", func(t *testing.T) {
+		ln := listen(t, false)
+		conn := dialAndSend(t, ln, "ping")
+
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	})
+
+	t.Run("require rejects a connection without a header", func(t *testing.T) {
+		ln := listen(t, true)
+		conn := dialAndSend(t, ln, "ping")
+
+		_, err := conn.Read(make([]byte, 4))
+		assert.ErrorIs(t, err, ErrNoHeader)
+		_, err = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		assert.ErrorIs(t, err, ErrNoHeader, "nothing goes back to a client that is not a proxy")
+	})
+
+	t.Run("LOCAL keeps the connection's addresses", func(t *testing.T) {
+		ln := listen(t, true)
+		conn := dialAndSend(t, ln, string((&Header{Local: true}).Format(2)))
+
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	})
+
+	t.Run("silent client times out", func(t *testing.T) {
+		ln := listen(t, false)
+		ln.HeaderTimeout = 50 * time.Millisecond
+		conn := dialAndSend(t, ln, "")
+
+		_, err := conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNoHeader)
+	})
+
+	t.Run("caller's read deadline is restored", func(t *testing.T) {
+		ln := listen(t, false)
+		conn := dialAndSend(t, ln, "PROXY UNKNOWN\r\n")
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+
+		start := time.Now()
+		_, err := conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}